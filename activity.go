@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// Actions recorded in the activity log.
+const (
+	activityUsersDeleted             = "users_deleted"
+	activityUsersEnabled             = "users_enabled"
+	activityUsersDisabled            = "users_disabled"
+	activityExpiryExtended           = "expiry_extended"
+	activityInviteCreated            = "invite_created"
+	activityConfigModified           = "config_modified"
+	activitySettingsApplied          = "settings_applied"
+	activityAccountsAdminGranted     = "accounts_admin_granted"
+	activityAccountsAdminRevoked     = "accounts_admin_revoked"
+	activityUserExpired              = "user_expired"
+	activityAPIKeyCreated            = "api_key_created"
+	activityAPIKeyRevoked            = "api_key_revoked"
+	activityRoleModified             = "role_modified"
+	activityRoleDeleted              = "role_deleted"
+	activityUserRolesSet             = "user_roles_set"
+	activityTOTPEnabled              = "totp_enabled"
+	activityTOTPDisabled             = "totp_disabled"
+	activitySecretRotated            = "secret_rotated"
+	activityBansLifted               = "bans_lifted"
+	activityRenewalCodeCreated       = "renewal_code_created"
+	activityRenewalCodeDeleted       = "renewal_code_deleted"
+	activityAccountRenewed           = "account_renewed"
+	activityUserInactive             = "user_inactive"
+	activityActionScheduled          = "action_scheduled"
+	activityScheduledActionCancelled = "scheduled_action_cancelled"
+	activityUsersImported            = "users_imported"
+	activityUsersCreated             = "users_created"
+	activityReferralCreated          = "referral_created"
+)
+
+// Types of actor. Admins are identified by their token, API keys by their ID, and daemons act on their own.
+// Users act on their own account, and are identified by their Jellyfin ID.
+const (
+	actorAdmin  = "admin"
+	actorAPIKey = "api_key"
+	actorDaemon = "daemon"
+	actorUser   = "user"
+)
+
+// Most entries GET /activity returns in one page.
+const maxActivityPage = 500
+
+// Activity is an entry in the activity log. Entries are only ever added, or removed once past retention.
+type Activity struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	ActorType string    `json:"actor_type"`
+	ActorID   string    `json:"actor_id"` // Jellyfin ID if logged in through Jellyfin, otherwise the jfa-go user ID.
+	ActorName string    `json:"actor_name"`
+	Targets   []string  `json:"targets"`
+	Details   string    `json:"details,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// logActivity appends an entry to the activity log. Entries past retention are removed by the user daemon, see pruneActivity.
+func (app *appContext) logActivity(activity Activity) {
+	activity.ID = shortuuid.New()
+	activity.Time = time.Now()
+	if activity.Targets == nil {
+		activity.Targets = []string{}
+	}
+	if err := app.storage.appendActivity(activity); err != nil {
+		app.err.Printf("Failed to store activity log: %v", err)
+	}
+}
+
+// adminActivity logs an action taken by the admin who made the request in gc.
+func (app *appContext) adminActivity(gc *gin.Context, action string, targets []string, details string) {
+	app.authActivity(requestAuth(gc), action, targets, details)
+}
+
+// authActivity logs an action taken by the admin or API key identified by auth, see requestAuth.
+func (app *appContext) authActivity(auth map[string]string, action string, targets []string, details string) {
+	activity := Activity{
+		Action:    action,
+		ActorType: actorAdmin,
+		Targets:   targets,
+		Details:   details,
+	}
+	activity.ActorID, activity.ActorName = app.authActor(auth)
+	if auth["apiKeyId"] != "" {
+		activity.ActorType = actorAPIKey
+	}
+	app.logActivity(activity)
+}
+
+// daemonActivity logs an action taken automatically by the named daemon.
+func (app *appContext) daemonActivity(daemon, action string, targets []string, details string) {
+	app.logActivity(Activity{
+		Action:    action,
+		ActorType: actorDaemon,
+		ActorID:   daemon,
+		ActorName: daemon,
+		Targets:   targets,
+		Details:   details,
+	})
+}
+
+// userActivity logs an action a user took on their own account.
+func (app *appContext) userActivity(jfID, username, action, details string) {
+	app.logActivity(Activity{
+		Action:    action,
+		ActorType: actorUser,
+		ActorID:   jfID,
+		ActorName: username,
+		Targets:   []string{jfID},
+		Details:   details,
+	})
+}
+
+// requestAuth returns the values webAuth set in gc to identify whoever made the request, keyed by "apiKeyId", "jfId" or "userId".
+func requestAuth(gc *gin.Context) map[string]string {
+	auth := map[string]string{}
+	for _, key := range []string{"apiKeyId", "jfId", "userId"} {
+		if v := gc.GetString(key); v != "" {
+			auth[key] = v
+		}
+	}
+	return auth
+}
+
+// requestActor returns the ID and name of whoever authenticated the request in gc.
+func (app *appContext) requestActor(gc *gin.Context) (id, name string) {
+	return app.authActor(requestAuth(gc))
+}
+
+// authActor returns the ID and name of the admin or API key identified by auth, see requestAuth.
+func (app *appContext) authActor(auth map[string]string) (id, name string) {
+	if keyID := auth["apiKeyId"]; keyID != "" {
+		key, _ := app.storage.GetAPIKeysKey(keyID)
+		return keyID, key.Name
+	}
+	if jfID := auth["jfId"]; jfID != "" {
+		id = jfID
+		if user, status, err := app.getJFUserByID(jfID); status == 200 && err == nil {
+			name = user.Name
+		}
+		return
+	}
+	id = auth["userId"]
+	if user, ok := app.findUser(func(user User) bool { return user.UserID == id }); ok {
+		name = user.Username
+	}
+	return
+}
+
+// getActivity returns the activity log, newest first.
+func (app *appContext) getActivity() []Activity {
+	log := []Activity{}
+	for _, activity := range app.storage.GetActivity() {
+		log = append(log, activity)
+	}
+	sort.Slice(log, func(i, j int) bool { return log[i].Time.After(log[j].Time) })
+	return log
+}
+
+// pruneActivity removes entries older than keep_n_days, or past the newest keep_n_records.
+// The log is then stored whole, so entries appended since last time don't pile up.
+func (app *appContext) pruneActivity() {
+	days := app.config.Section("activity_log").Key("keep_n_days").MustInt(90)
+	records := app.config.Section("activity_log").Key("keep_n_records").MustInt(10000)
+	log := app.getActivity()
+	cutoff := time.Now().AddDate(0, 0, -days)
+	pruned := 0
+	for i, activity := range log {
+		if (records > 0 && i >= records) || (days > 0 && activity.Time.Before(cutoff)) {
+			app.storage.DeleteActivityKey(activity.ID)
+			pruned++
+		}
+	}
+	if pruned == 0 && app.storage.ActivityAppended() == 0 {
+		return
+	}
+	if pruned != 0 {
+		app.debug.Printf("Daemon: Removed %d old activity log entries", pruned)
+	}
+	if err := app.storage.storeActivity(); err != nil {
+		app.err.Printf("Failed to store activity log: %v", err)
+	}
+}
+
+// activityFilter matches entries against the query parameters of GET /activity.
+type activityFilter struct {
+	Action, Actor, Target string
+	Since, Until          time.Time
+}
+
+func (f activityFilter) match(activity Activity) bool {
+	if f.Action != "" && activity.Action != f.Action {
+		return false
+	}
+	if f.Actor != "" && activity.ActorID != f.Actor {
+		return false
+	}
+	if !f.Since.IsZero() && activity.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && activity.Time.After(f.Until) {
+		return false
+	}
+	if f.Target == "" {
+		return true
+	}
+	for _, target := range activity.Targets {
+		if target == f.Target {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -109,7 +113,7 @@ func (app *appContext) checkInvites() {
 	currentTime := time.Now()
 	app.storage.loadInvites()
 	changed := false
-	for code, data := range app.storage.invites {
+	for code, data := range app.storage.GetInvites() {
 		expiry := data.ValidTill
 		if !currentTime.After(expiry) {
 			continue
@@ -147,7 +151,8 @@ func (app *appContext) checkInvites() {
 			wait.Wait()
 		}
 		changed = true
-		delete(app.storage.invites, code)
+		app.storage.DeleteInvitesKey(code)
+		app.inviteExpiredWebhook(code, data)
 	}
 	if changed {
 		app.storage.storeInvites()
@@ -158,7 +163,7 @@ func (app *appContext) checkInvite(code string, used bool, username string) bool
 	currentTime := time.Now()
 	app.storage.loadInvites()
 	changed := false
-	inv, match := app.storage.invites[code]
+	inv, match := app.storage.GetInvitesKey(code)
 	if !match {
 		return false
 	}
@@ -198,22 +203,11 @@ func (app *appContext) checkInvite(code string, used bool, username string) bool
 		}
 		changed = true
 		match = false
-		delete(app.storage.invites, code)
+		app.storage.DeleteInvitesKey(code)
+		app.inviteExpiredWebhook(code, inv)
 	} else if used {
 		changed = true
-		del := false
-		newInv := inv
-		if newInv.RemainingUses == 1 {
-			del = true
-			delete(app.storage.invites, code)
-		} else if newInv.RemainingUses != 0 {
-			// 0 means infinite i guess?
-			newInv.RemainingUses--
-		}
-		newInv.UsedBy = append(newInv.UsedBy, []string{username, strconv.FormatInt(currentTime.Unix(), 10)})
-		if !del {
-			app.storage.invites[code] = newInv
-		}
+		app.useInvite(code, username)
 	}
 	if changed {
 		app.storage.storeInvites()
@@ -221,18 +215,53 @@ func (app *appContext) checkInvite(code string, used bool, username string) bool
 	return match
 }
 
+func (app *appContext) inviteExpiredWebhook(code string, inv Invite) {
+	app.triggerWebhook(webhookInviteExpired, map[string]interface{}{
+		"code":    code,
+		"label":   inv.Label,
+		"created": inv.Created.Unix(),
+		"expiry":  inv.ValidTill.Unix(),
+		"used_by": len(inv.UsedBy),
+	})
+}
+
+// useInvite records a use of the given invite in memory, deleting it if it has no uses left.
+// The caller is responsible for storing invites afterwards.
+func (app *appContext) useInvite(code string, username string) {
+	// Held throughout so simultaneous uses can't both take the same remaining use.
+	app.storage.invitesLock.Lock()
+	defer app.storage.invitesLock.Unlock()
+	newInv, ok := app.storage.invites[code]
+	if !ok {
+		return
+	}
+	del := false
+	if newInv.RemainingUses == 1 {
+		del = true
+		delete(app.storage.invites, code)
+	} else if newInv.RemainingUses != 0 {
+		// 0 means infinite i guess?
+		newInv.RemainingUses--
+	}
+	newInv.UsedBy = append(newInv.UsedBy, []string{username, strconv.FormatInt(time.Now().Unix(), 10)})
+	metrics.inc(metricInviteUses)
+	if !del {
+		app.storage.invites[code] = newInv
+	}
+}
+
 func (app *appContext) getOmbiUser(jfID string) (map[string]interface{}, int, error) {
 	ombiUsers, code, err := app.ombi.GetUsers()
 	if err != nil || code != 200 {
 		return nil, code, err
 	}
-	jfUser, code, err := app.jf.UserByID(jfID, false)
+	jfUser, code, err := app.getJFUserByID(jfID)
 	if err != nil || code != 200 {
 		return nil, code, err
 	}
 	username := jfUser.Name
 	email := ""
-	if e, ok := app.storage.emails[jfID]; ok {
+	if e, ok := app.storage.GetEmailsKey(jfID); ok {
 		email = e.Addr
 	}
 	for _, ombiUser := range ombiUsers {
@@ -247,6 +276,32 @@ func (app *appContext) getOmbiUser(jfID string) (map[string]interface{}, int, er
 	return nil, 400, fmt.Errorf("Couldn't find user")
 }
 
+// The getJF* functions and expireJFCache serialize access to the Jellyfin user cache,
+// as it's shared between request handlers and the daemons.
+func (app *appContext) getJFUsers() ([]mediabrowser.User, int, error) {
+	app.jfCacheLock.Lock()
+	defer app.jfCacheLock.Unlock()
+	return app.jf.GetUsers(false)
+}
+
+func (app *appContext) getJFUserByID(id string) (mediabrowser.User, int, error) {
+	app.jfCacheLock.Lock()
+	defer app.jfCacheLock.Unlock()
+	return app.jf.UserByID(id, false)
+}
+
+func (app *appContext) getJFUserByName(username string) (mediabrowser.User, int, error) {
+	app.jfCacheLock.Lock()
+	defer app.jfCacheLock.Unlock()
+	return app.jf.UserByName(username, false)
+}
+
+func (app *appContext) expireJFCache() {
+	app.jfCacheLock.Lock()
+	defer app.jfCacheLock.Unlock()
+	app.jf.CacheExpiry = time.Now()
+}
+
 // Routes from now on!
 
 // @Summary Creates a new Jellyfin user without an invite.
@@ -268,7 +323,7 @@ func (app *appContext) NewUserAdmin(gc *gin.Context) {
 	}
 	var req newUserDTO
 	gc.BindJSON(&req)
-	existingUser, _, _ := app.jf.UserByName(req.Username, false)
+	existingUser, _, _ := app.getJFUserByName(req.Username)
 	if existingUser.Name != "" {
 		msg := fmt.Sprintf("User already exists named %s", req.Username)
 		app.info.Printf("%s New user failed: %s", req.Username, msg)
@@ -297,9 +352,9 @@ func (app *appContext) NewUserAdmin(gc *gin.Context) {
 			app.err.Printf("%s: Failed to set configuration template (%d): %v", req.Username, status, err)
 		}
 	}
-	app.jf.CacheExpiry = time.Now()
+	app.expireJFCache()
 	if emailEnabled {
-		app.storage.emails[id] = EmailAddress{Addr: req.Email, Contact: true}
+		app.storage.SetEmailsKey(id, EmailAddress{Addr: req.Email, Contact: true})
 		app.storage.storeEmails()
 	}
 	if app.config.Section("ombi").Key("enabled").MustBool(false) {
@@ -316,7 +371,7 @@ func (app *appContext) NewUserAdmin(gc *gin.Context) {
 	}
 	if emailEnabled && app.config.Section("welcome_email").Key("enabled").MustBool(false) && req.Email != "" {
 		app.debug.Printf("%s: Sending welcome email to %s", req.Username, req.Email)
-		msg, err := app.email.constructWelcome(req.Username, time.Time{}, app, false)
+		msg, err := app.email.constructWelcome(req.Username, time.Time{}, "", app, false)
 		if err != nil {
 			app.err.Printf("%s: Failed to construct welcome email: %v", req.Username, err)
 			respondUser(500, true, false, err.Error(), gc)
@@ -336,7 +391,7 @@ type errorFunc func(gc *gin.Context)
 
 // Used on the form & when a users email has been confirmed.
 func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, success bool) {
-	existingUser, _, _ := app.jf.UserByName(req.Username, false)
+	existingUser, _, _ := app.getJFUserByName(req.Username)
 	if existingUser.Name != "" {
 		f = func(gc *gin.Context) {
 			msg := fmt.Sprintf("User %s already exists", req.Username)
@@ -346,94 +401,36 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 		success = false
 		return
 	}
-	var discordUser DiscordUser
-	discordVerified := false
-	if discordEnabled {
-		if req.DiscordPIN == "" {
-			if app.config.Section("discord").Key("required").MustBool(false) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Discord verification not completed", req.Code)
-					respond(401, "errorDiscordVerification", gc)
-				}
-				success = false
-				return
-			}
-		} else {
-			discordUser, discordVerified = app.discord.verifiedTokens[req.DiscordPIN]
-			if !discordVerified {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Discord PIN was invalid", req.Code)
-					respond(401, "errorInvalidPIN", gc)
-				}
-				success = false
-				return
-			}
-			err := app.discord.ApplyRole(discordUser.ID)
-			if err != nil {
-				f = func(gc *gin.Context) {
-					app.err.Printf("%s: New user failed: Failed to set member role: %v", req.Code, err)
-					respond(401, "error", gc)
-				}
-				success = false
-				return
-			}
+	// Contact methods with a verified PIN, linked once the user's been created.
+	linking := []PINVerifier{}
+	for _, method := range app.contactMethods {
+		verifier, ok := method.(PINVerifier)
+		if !ok || !method.Enabled() {
+			continue
 		}
-	}
-	var matrixUser MatrixUser
-	matrixVerified := false
-	if matrixEnabled {
-		if req.MatrixPIN == "" {
-			if app.config.Section("matrix").Key("required").MustBool(false) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Matrix verification not completed", req.Code)
-					respond(401, "errorMatrixVerification", gc)
-				}
-				success = false
-				return
-			}
-		} else {
-			user, ok := app.matrix.tokens[req.MatrixPIN]
-			if !ok || !user.Verified {
-				matrixVerified = false
+		name := method.Name()
+		pin := req.ContactMethods[contactKey(method)].PIN
+		if pin == "" {
+			if verifier.Required() {
 				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Matrix PIN was invalid", req.Code)
-					respond(401, "errorInvalidPIN", gc)
+					app.debug.Printf("%s: New user failed: %s verification not completed", req.Code, name)
+					respond(401, "error"+name+"Verification", gc)
 				}
 				success = false
 				return
 			}
-			matrixVerified = user.Verified
-			matrixUser = *user.User
-
+			continue
 		}
-	}
-	telegramTokenIndex := -1
-	if telegramEnabled {
-		if req.TelegramPIN == "" {
-			if app.config.Section("telegram").Key("required").MustBool(false) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Telegram verification not completed", req.Code)
-					respond(401, "errorTelegramVerification", gc)
-				}
-				success = false
-				return
-			}
-		} else {
-			for i, v := range app.telegram.verifiedTokens {
-				if v.Token == req.TelegramPIN {
-					telegramTokenIndex = i
-					break
-				}
-			}
-			if telegramTokenIndex == -1 {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Telegram PIN was invalid", req.Code)
-					respond(401, "errorInvalidPIN", gc)
-				}
-				success = false
-				return
+		if !verifier.Verified(pin) {
+			f = func(gc *gin.Context) {
+				app.debug.Printf("%s: New user failed: %s PIN was invalid", req.Code, name)
+				app.failedAttempt(gc, "")
+				respond(401, "errorInvalidPIN", gc)
 			}
+			success = false
+			return
 		}
+		linking = append(linking, verifier)
 	}
 	if emailEnabled && app.config.Section("email_confirmation").Key("enabled").MustBool(false) && !confirmed {
 		claims := jwt.MapClaims{
@@ -442,7 +439,7 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 			"email":       req.Email,
 			"username":    req.Username,
 			"password":    req.Password,
-			"telegramPIN": req.TelegramPIN,
+			"telegramPIN": req.ContactMethods["telegram"].PIN,
 			"exp":         time.Now().Add(time.Hour * 12).Unix(),
 			"type":        "confirmation",
 		}
@@ -456,14 +453,14 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 			success = false
 			return
 		}
-		inv := app.storage.invites[req.Code]
+		inv, _ := app.storage.GetInvitesKey(req.Code)
 		inv.Keys = append(inv.Keys, key)
-		app.storage.invites[req.Code] = inv
+		app.storage.SetInvitesKey(req.Code, inv)
 		app.storage.storeInvites()
 		f = func(gc *gin.Context) {
 			app.debug.Printf("%s: Email confirmation required", req.Code)
 			respond(401, "confirmEmail", gc)
-			msg, err := app.email.constructConfirmation(req.Code, req.Username, key, app, false)
+			msg, err := app.email.constructConfirmation(req.Username, app.confirmationLink(req.Code, key), app, false)
 			if err != nil {
 				app.err.Printf("%s: Failed to construct confirmation email: %v", req.Code, err)
 			} else if err := app.email.send(msg, req.Email); err != nil {
@@ -486,8 +483,11 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 		return
 	}
 	app.storage.loadProfiles()
-	invite := app.storage.invites[req.Code]
-	app.checkInvite(req.Code, true, req.Username)
+	app.storage.loadInvites()
+	invite, _ := app.storage.GetInvitesKey(req.Code)
+	app.useInvite(req.Code, req.Username)
+	// Everything changed below is written in one go at the end, so a failure can't leave a half-stored user.
+	changedStores := []string{"invites"}
 	if emailEnabled && app.config.Section("notifications").Key("enabled").MustBool(false) {
 		for address, settings := range invite.Notify {
 			if settings["notify-creation"] {
@@ -513,13 +513,22 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 		}
 	}
 	id := user.ID
+	app.storage.SetUserInvitesKey(id, UserInvite{
+		Code:     req.Code,
+		Label:    invite.Label,
+		Profile:  invite.Profile,
+		Inviter:  invite.CreatedBy,
+		Referrer: invite.ReferrerID,
+		Created:  time.Now(),
+	})
+	changedStores = append(changedStores, "user_invites")
 	var profile Profile
 	if invite.Profile != "" {
 		app.debug.Printf("Applying settings from profile \"%s\"", invite.Profile)
 		var ok bool
-		profile, ok = app.storage.profiles[invite.Profile]
+		profile, ok = app.storage.GetProfilesKey(invite.Profile)
 		if !ok {
-			profile = app.storage.profiles["Default"]
+			profile, _ = app.storage.GetProfilesKey("Default")
 		}
 		if profile.Policy.BlockedTags != nil {
 			app.debug.Printf("Applying policy from profile \"%s\"", invite.Profile)
@@ -540,52 +549,24 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 		}
 	}
 	// if app.config.Section("password_resets").Key("enabled").MustBool(false) {
-	if req.Email != "" {
-		app.storage.emails[id] = EmailAddress{Addr: req.Email, Contact: true}
-		app.storage.storeEmails()
+	if req.Email != "" || invite.Profile != "" {
+		app.storage.SetEmailsKey(id, EmailAddress{Addr: req.Email, Contact: req.Email != "", Profile: invite.Profile})
+		changedStores = append(changedStores, "emails")
 	}
 	expiry := time.Time{}
 	if invite.UserExpiry {
-		app.storage.usersLock.Lock()
-		defer app.storage.usersLock.Unlock()
 		expiry = time.Now().AddDate(0, invite.UserMonths, invite.UserDays).Add(time.Duration((60*invite.UserHours)+invite.UserMinutes) * time.Minute)
-		app.storage.users[id] = expiry
-		if err := app.storage.storeUsers(); err != nil {
-			app.err.Printf("Failed to store user duration: %v", err)
-		}
-	}
-	if discordEnabled && discordVerified {
-		discordUser.Contact = req.DiscordContact
-		if app.storage.discord == nil {
-			app.storage.discord = map[string]DiscordUser{}
-		}
-		app.storage.discord[user.ID] = discordUser
-		if err := app.storage.storeDiscordUsers(); err != nil {
-			app.err.Printf("Failed to store Discord users: %v", err)
-		} else {
-			delete(app.discord.verifiedTokens, req.DiscordPIN)
-		}
+		app.storage.SetUsersKey(id, expiry)
+		changedStores = append(changedStores, "users")
 	}
-	if telegramEnabled && telegramTokenIndex != -1 {
-		tgToken := app.telegram.verifiedTokens[telegramTokenIndex]
-		tgUser := TelegramUser{
-			ChatID:   tgToken.ChatID,
-			Username: tgToken.Username,
-			Contact:  req.TelegramContact,
-		}
-		if lang, ok := app.telegram.languages[tgToken.ChatID]; ok {
-			tgUser.Lang = lang
-		}
-		if app.storage.telegram == nil {
-			app.storage.telegram = map[string]TelegramUser{}
-		}
-		app.storage.telegram[user.ID] = tgUser
-		if err := app.storage.storeTelegramUsers(); err != nil {
-			app.err.Printf("Failed to store Telegram users: %v", err)
-		} else {
-			app.telegram.verifiedTokens[len(app.telegram.verifiedTokens)-1], app.telegram.verifiedTokens[telegramTokenIndex] = app.telegram.verifiedTokens[telegramTokenIndex], app.telegram.verifiedTokens[len(app.telegram.verifiedTokens)-1]
-			app.telegram.verifiedTokens = app.telegram.verifiedTokens[:len(app.telegram.verifiedTokens)-1]
+	for _, method := range linking {
+		choice := req.ContactMethods[contactKey(method)]
+		if err := method.Link(id, choice.PIN, choice.Contact); err != nil {
+			app.err.Printf("%s: Failed to link %s account: %v", req.Code, method.Name(), err)
+			continue
 		}
+		changedStores = append(changedStores, method.StorageKey())
+		app.contactLinkedWebhook(method, id)
 	}
 	if invite.Profile != "" && app.config.Section("ombi").Key("enabled").MustBool(false) {
 		if profile.Ombi != nil && len(profile.Ombi) != 0 {
@@ -596,19 +577,19 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 				app.debug.Printf("Errors reported by Ombi: %s", strings.Join(errors, ", "))
 			} else {
 				app.info.Println("Created Ombi user")
-				if (discordEnabled && discordVerified) || (telegramEnabled && telegramTokenIndex != -1) {
+				dID := ""
+				tUser := ""
+				if dcUser, ok := app.storage.GetDiscordKey(id); ok && discordEnabled {
+					dID = dcUser.ID
+				}
+				if tgUser, ok := app.storage.GetTelegramKey(id); ok && telegramEnabled {
+					tUser = tgUser.Username
+				}
+				if dID != "" || tUser != "" {
 					ombiUser, status, err := app.getOmbiUser(id)
 					if status != 200 || err != nil {
 						app.err.Printf("Failed to get Ombi user (%d): %v", status, err)
 					} else {
-						dID := ""
-						tUser := ""
-						if discordEnabled && discordVerified {
-							dID = discordUser.ID
-						}
-						if telegramEnabled && telegramTokenIndex != -1 {
-							tUser = app.storage.telegram[user.ID].Username
-						}
 						resp, status, err := app.ombi.SetNotificationPrefs(ombiUser, dID, tUser)
 						if !(status == 200 || status == 204) || err != nil {
 							app.err.Printf("Failed to link Telegram/Discord to Ombi (%d): %v", status, err)
@@ -621,21 +602,25 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 			app.debug.Printf("Skipping Ombi: Profile \"%s\" was empty", invite.Profile)
 		}
 	}
-	if matrixVerified {
-		matrixUser.Contact = req.MatrixContact
-		delete(app.matrix.tokens, req.MatrixPIN)
-		if app.storage.matrix == nil {
-			app.storage.matrix = map[string]MatrixUser{}
-		}
-		app.storage.matrix[user.ID] = matrixUser
-		if err := app.storage.storeMatrixUsers(); err != nil {
-			app.err.Printf("Failed to store Matrix users: %v", err)
-		}
+	if err := app.storage.storeTogether(changedStores...); err != nil {
+		app.err.Printf("%s: Failed to store new user: %v", req.Code, err)
 	}
-	if (emailEnabled && app.config.Section("welcome_email").Key("enabled").MustBool(false) && req.Email != "") || telegramTokenIndex != -1 || discordVerified {
+	webhookData := map[string]interface{}{
+		"id":       id,
+		"username": req.Username,
+		"email":    req.Email,
+		"invite":   req.Code,
+		"label":    invite.Label,
+		"profile":  invite.Profile,
+	}
+	if !expiry.IsZero() {
+		webhookData["expiry"] = expiry.Unix()
+	}
+	app.triggerWebhook(webhookUserCreated, webhookData)
+	if (emailEnabled && app.config.Section("welcome_email").Key("enabled").MustBool(false) && req.Email != "") || len(linking) != 0 {
 		name := app.getAddressOrName(user.ID)
 		app.debug.Printf("%s: Sending welcome message to %s", req.Username, name)
-		msg, err := app.email.constructWelcome(req.Username, expiry, app, false)
+		msg, err := app.email.constructWelcome(req.Username, expiry, "", app, false)
 		if err != nil {
 			app.err.Printf("%s: Failed to construct welcome message: %v", req.Username, err)
 		} else if err := app.sendByID(msg, user.ID); err != nil {
@@ -644,7 +629,7 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool) (f errorFunc, suc
 			app.info.Printf("%s: Sent welcome message to \"%s\"", req.Username, name)
 		}
 	}
-	app.jf.CacheExpiry = time.Now()
+	app.expireJFCache()
 	success = true
 	return
 }
@@ -662,11 +647,13 @@ func (app *appContext) NewUser(gc *gin.Context) {
 	app.debug.Printf("%s: New user attempt", req.Code)
 	if app.config.Section("captcha").Key("enabled").MustBool(false) && !app.verifyCaptcha(req.Code, req.CaptchaID, req.CaptchaText) {
 		app.info.Printf("%s: New user failed: Captcha Incorrect", req.Code)
+		app.failedAttempt(gc, "")
 		respond(400, "errorCaptcha", gc)
 		return
 	}
 	if !app.checkInvite(req.Code, false, "") {
 		app.info.Printf("%s New user failed: invalid code", req.Code)
+		app.failedAttempt(gc, "")
 		respond(401, "errorInvalidCode", gc)
 		return
 	}
@@ -702,22 +689,21 @@ func (app *appContext) NewUser(gc *gin.Context) {
 	gc.JSON(code, validation)
 }
 
-// @Summary Enable/Disable a list of users, optionally notifying them why.
-// @Produce json
-// @Param enableDisableUserDTO body enableDisableUserDTO true "User enable/disable request object"
-// @Success 200 {object} boolResponse
-// @Failure 400 {object} stringResponse
-// @Failure 500 {object} errorListDTO "List of errors"
-// @Router /users/enable [post]
-// @Security Bearer
-// @tags Users
-func (app *appContext) EnableDisableUsers(gc *gin.Context) {
-	var req enableDisableUserDTO
-	gc.BindJSON(&req)
+var (
+	// errNoDuration is returned by extendExpiry when the request doesn't extend by anything.
+	errNoDuration = errors.New("no duration given")
+	// errMessagesDisabled is returned by announce when there's no way to send messages.
+	errMessagesDisabled = errors.New("messages are disabled")
+)
+
+// enableDisableUsers enables or disables the users in req, logging it as done by whoever auth identifies (see requestAuth).
+// Returns the users it failed to get or change, if any.
+func (app *appContext) enableDisableUsers(req enableDisableUserDTO, auth map[string]string) errorListDTO {
 	errors := errorListDTO{
 		"GetUser":   map[string]string{},
 		"SetPolicy": map[string]string{},
 	}
+	changed := []string{}
 	sendMail := messagesEnabled
 	var msg *Message
 	var err error
@@ -733,7 +719,7 @@ func (app *appContext) EnableDisableUsers(gc *gin.Context) {
 		}
 	}
 	for _, userID := range req.Users {
-		user, status, err := app.jf.UserByID(userID, false)
+		user, status, err := app.getJFUserByID(userID)
 		if status != 200 || err != nil {
 			errors["GetUser"][userID] = fmt.Sprintf("%d %v", status, err)
 			app.err.Printf("Failed to get user \"%s\" (%d): %v", userID, status, err)
@@ -746,6 +732,16 @@ func (app *appContext) EnableDisableUsers(gc *gin.Context) {
 			app.err.Printf("Failed to set policy for user \"%s\" (%d): %v", userID, status, err)
 			continue
 		}
+		changed = append(changed, userID)
+		// An admin has taken over, so renewing shouldn't undo their choice.
+		app.storage.DeleteExpiredUsersKey(userID)
+		if !req.Enabled {
+			app.triggerWebhook(webhookUserDisabled, map[string]interface{}{
+				"id":       userID,
+				"username": user.Name,
+				"reason":   req.Reason,
+			})
+		}
 		if sendMail && req.Notify {
 			if err := app.sendByID(msg, userID); err != nil {
 				app.err.Printf("Failed to send account enabled/disabled email: %v", err)
@@ -753,27 +749,45 @@ func (app *appContext) EnableDisableUsers(gc *gin.Context) {
 			}
 		}
 	}
-	app.jf.CacheExpiry = time.Now()
-	if len(errors["GetUser"]) != 0 || len(errors["SetPolicy"]) != 0 {
-		gc.JSON(500, errors)
-		return
+	app.expireJFCache()
+	if err := app.storage.storeExpiredUsers(); err != nil {
+		app.err.Printf("Failed to store expired users: %v", err)
 	}
-	respondBool(200, true, gc)
+	if len(changed) != 0 {
+		action := activityUsersDisabled
+		if req.Enabled {
+			action = activityUsersEnabled
+		}
+		app.authActivity(auth, action, changed, req.Reason)
+	}
+	return errors
 }
 
-// @Summary Delete a list of users, optionally notifying them why.
+// @Summary Enable/Disable a list of users, optionally notifying them why.
 // @Produce json
-// @Param deleteUserDTO body deleteUserDTO true "User deletion request object"
+// @Param enableDisableUserDTO body enableDisableUserDTO true "User enable/disable request object"
 // @Success 200 {object} boolResponse
 // @Failure 400 {object} stringResponse
 // @Failure 500 {object} errorListDTO "List of errors"
-// @Router /users [delete]
+// @Router /users/enable [post]
 // @Security Bearer
 // @tags Users
-func (app *appContext) DeleteUsers(gc *gin.Context) {
-	var req deleteUserDTO
+func (app *appContext) EnableDisableUsers(gc *gin.Context) {
+	var req enableDisableUserDTO
 	gc.BindJSON(&req)
+	errors := app.enableDisableUsers(req, requestAuth(gc))
+	if len(errors["GetUser"]) != 0 || len(errors["SetPolicy"]) != 0 {
+		gc.JSON(500, errors)
+		return
+	}
+	respondBool(200, true, gc)
+}
+
+// deleteUsers deletes the users in req, logging it as done by whoever auth identifies (see requestAuth).
+// Returns errors for the users it failed to delete, keyed by their ID.
+func (app *appContext) deleteUsers(req deleteUserDTO, auth map[string]string) map[string]string {
 	errors := map[string]string{}
+	deleted := []string{}
 	ombiEnabled := app.config.Section("ombi").Key("enabled").MustBool(false)
 	sendMail := messagesEnabled
 	var msg *Message
@@ -806,6 +820,12 @@ func (app *appContext) DeleteUsers(gc *gin.Context) {
 			} else {
 				errors[userID] += msg
 			}
+		} else {
+			deleted = append(deleted, userID)
+			app.triggerWebhook(webhookUserDeleted, map[string]interface{}{
+				"id":     userID,
+				"reason": req.Reason,
+			})
 		}
 		if sendMail && req.Notify {
 			if err := app.sendByID(msg, userID); err != nil {
@@ -813,7 +833,30 @@ func (app *appContext) DeleteUsers(gc *gin.Context) {
 			}
 		}
 	}
-	app.jf.CacheExpiry = time.Now()
+	// Done after notifying, as the messages are sent through the contact methods being removed.
+	if err := app.unlinkContactMethods(deleted...); err != nil {
+		app.err.Printf("Failed to remove contact methods of deleted users: %v", err)
+	}
+	if len(deleted) != 0 {
+		app.authActivity(auth, activityUsersDeleted, deleted, req.Reason)
+	}
+	app.expireJFCache()
+	return errors
+}
+
+// @Summary Delete a list of users, optionally notifying them why.
+// @Produce json
+// @Param deleteUserDTO body deleteUserDTO true "User deletion request object"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} errorListDTO "List of errors"
+// @Router /users [delete]
+// @Security Bearer
+// @tags Users
+func (app *appContext) DeleteUsers(gc *gin.Context) {
+	var req deleteUserDTO
+	gc.BindJSON(&req)
+	errors := app.deleteUsers(req, requestAuth(gc))
 	if len(errors) == len(req.Users) {
 		respondBool(500, false, gc)
 		app.err.Printf("Account deletion failed: %s", errors[req.Users[0]])
@@ -825,90 +868,103 @@ func (app *appContext) DeleteUsers(gc *gin.Context) {
 	respondBool(200, true, gc)
 }
 
-// @Summary Extend time before the user(s) expiry, or create and expiry if it doesn't exist.
-// @Produce json
-// @Param extendExpiryDTO body extendExpiryDTO true "Extend expiry object"
-// @Success 200 {object} boolResponse
-// @Failure 400 {object} boolResponse
-// @Failure 500 {object} boolResponse
-// @Router /users/extend [post]
-// @tags Users
-func (app *appContext) ExtendExpiry(gc *gin.Context) {
-	var req extendExpiryDTO
-	gc.BindJSON(&req)
+// extendExpiry extends the expiry of the users in req, or gives them one from now, logging it as done by whoever auth identifies (see requestAuth).
+func (app *appContext) extendExpiry(req extendExpiryDTO, auth map[string]string) error {
 	app.info.Printf("Expiry extension requested for %d user(s)", len(req.Users))
 	if req.Months <= 0 && req.Days <= 0 && req.Hours <= 0 && req.Minutes <= 0 {
-		respondBool(400, false, gc)
-		return
+		return errNoDuration
 	}
-	app.storage.usersLock.Lock()
-	defer app.storage.usersLock.Unlock()
 	for _, id := range req.Users {
-		if expiry, ok := app.storage.users[id]; ok {
-			app.storage.users[id] = expiry.AddDate(0, req.Months, req.Days).Add(time.Duration(((60 * req.Hours) + req.Minutes)) * time.Minute)
+		if expiry, ok := app.storage.GetUsersKey(id); ok {
+			app.storage.SetUsersKey(id, expiry.AddDate(0, req.Months, req.Days).Add(time.Duration(((60*req.Hours)+req.Minutes))*time.Minute))
 			app.debug.Printf("Expiry extended for \"%s\"", id)
 		} else {
-			app.storage.users[id] = time.Now().AddDate(0, req.Months, req.Days).Add(time.Duration(((60 * req.Hours) + req.Minutes)) * time.Minute)
+			app.storage.SetUsersKey(id, time.Now().AddDate(0, req.Months, req.Days).Add(time.Duration(((60*req.Hours)+req.Minutes))*time.Minute))
 			app.debug.Printf("Created expiry for \"%s\"", id)
 		}
 	}
 	if err := app.storage.storeUsers(); err != nil {
-		app.err.Printf("Failed to store user duration: %v", err)
-		respondBool(500, false, gc)
-		return
+		return err
 	}
-	respondBool(204, true, gc)
+	app.authActivity(auth, activityExpiryExtended, req.Users, fmt.Sprintf("%d months, %d days, %d hours, %d minutes", req.Months, req.Days, req.Hours, req.Minutes))
+	return nil
 }
 
-// @Summary Send an announcement via email to a given list of users.
+// @Summary Extend time before the user(s) expiry, or create and expiry if it doesn't exist.
 // @Produce json
-// @Param announcementDTO body announcementDTO true "Announcement request object"
+// @Param extendExpiryDTO body extendExpiryDTO true "Extend expiry object"
 // @Success 200 {object} boolResponse
 // @Failure 400 {object} boolResponse
 // @Failure 500 {object} boolResponse
-// @Router /users/announce [post]
-// @Security Bearer
+// @Router /users/extend [post]
 // @tags Users
-func (app *appContext) Announce(gc *gin.Context) {
-	var req announcementDTO
+func (app *appContext) ExtendExpiry(gc *gin.Context) {
+	var req extendExpiryDTO
 	gc.BindJSON(&req)
-	if !messagesEnabled {
+	if err := app.extendExpiry(req, requestAuth(gc)); err == errNoDuration {
 		respondBool(400, false, gc)
 		return
+	} else if err != nil {
+		app.err.Printf("Failed to store user duration: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	respondBool(204, true, gc)
+}
+
+// announce sends the announcement in req to its users, constructing it for each if it includes their username.
+func (app *appContext) announce(req announcementDTO) error {
+	if !messagesEnabled {
+		return errMessagesDisabled
 	}
 	// Generally, we only need to construct once. If {username} is included, however, this needs to be done for each user.
 	unique := strings.Contains(req.Message, "{username}")
 	if unique {
 		for _, userID := range req.Users {
-			user, status, err := app.jf.UserByID(userID, false)
+			user, status, err := app.getJFUserByID(userID)
 			if status != 200 || err != nil {
 				app.err.Printf("Failed to get user with ID \"%s\" (%d): %v", userID, status, err)
 				continue
 			}
 			msg, err := app.email.constructTemplate(req.Subject, req.Message, app, user.Name)
 			if err != nil {
-				app.err.Printf("Failed to construct announcement message: %v", err)
-				respondBool(500, false, gc)
-				return
+				return fmt.Errorf("failed to construct announcement message: %v", err)
 			} else if err := app.sendByID(msg, userID); err != nil {
-				app.err.Printf("Failed to send announcement message: %v", err)
-				respondBool(500, false, gc)
-				return
+				return fmt.Errorf("failed to send announcement message: %v", err)
 			}
 		}
 	} else {
 		msg, err := app.email.constructTemplate(req.Subject, req.Message, app)
 		if err != nil {
-			app.err.Printf("Failed to construct announcement messages: %v", err)
-			respondBool(500, false, gc)
-			return
+			return fmt.Errorf("failed to construct announcement messages: %v", err)
 		} else if err := app.sendByID(msg, req.Users...); err != nil {
-			app.err.Printf("Failed to send announcement messages: %v", err)
-			respondBool(500, false, gc)
-			return
+			return fmt.Errorf("failed to send announcement messages: %v", err)
 		}
 	}
 	app.info.Println("Sent announcement messages")
+	return nil
+}
+
+// @Summary Send an announcement via email to a given list of users.
+// @Produce json
+// @Param announcementDTO body announcementDTO true "Announcement request object"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /users/announce [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) Announce(gc *gin.Context) {
+	var req announcementDTO
+	gc.BindJSON(&req)
+	if err := app.announce(req); err == errMessagesDisabled {
+		respondBool(400, false, gc)
+		return
+	} else if err != nil {
+		app.err.Printf("Announcement failed: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
 	respondBool(200, true, gc)
 }
 
@@ -927,7 +983,7 @@ func (app *appContext) SaveAnnounceTemplate(gc *gin.Context) {
 		respondBool(400, false, gc)
 		return
 	}
-	app.storage.announcements[req.Name] = req
+	app.storage.SetAnnouncementsKey(req.Name, req)
 	if err := app.storage.storeAnnouncements(); err != nil {
 		respondBool(500, false, gc)
 		app.err.Printf("Failed to store announcement templates: %v", err)
@@ -943,9 +999,9 @@ func (app *appContext) SaveAnnounceTemplate(gc *gin.Context) {
 // @Security Bearer
 // @tags Users
 func (app *appContext) GetAnnounceTemplates(gc *gin.Context) {
-	resp := &getAnnouncementsDTO{make([]string, len(app.storage.announcements))}
+	resp := &getAnnouncementsDTO{make([]string, len(app.storage.GetAnnouncements()))}
 	i := 0
-	for name := range app.storage.announcements {
+	for name := range app.storage.GetAnnouncements() {
 		resp.Announcements[i] = name
 		i++
 	}
@@ -962,7 +1018,7 @@ func (app *appContext) GetAnnounceTemplates(gc *gin.Context) {
 // @tags Users
 func (app *appContext) GetAnnounceTemplate(gc *gin.Context) {
 	name := gc.Param("name")
-	if announcement, ok := app.storage.announcements[name]; ok {
+	if announcement, ok := app.storage.GetAnnouncementsKey(name); ok {
 		gc.JSON(200, announcement)
 		return
 	}
@@ -979,7 +1035,7 @@ func (app *appContext) GetAnnounceTemplate(gc *gin.Context) {
 // @tags Users
 func (app *appContext) DeleteAnnounceTemplate(gc *gin.Context) {
 	name := gc.Param("name")
-	delete(app.storage.announcements, name)
+	app.storage.DeleteAnnouncementsKey(name)
 	if err := app.storage.storeAnnouncements(); err != nil {
 		respondBool(500, false, gc)
 		app.err.Printf("Failed to store announcement templates: %v", err)
@@ -1068,6 +1124,16 @@ func (app *appContext) GenerateInvite(gc *gin.Context) {
 	app.debug.Println("Generating new invite")
 	app.storage.loadInvites()
 	gc.BindJSON(&req)
+	inviteCode, invite := app.newInvite(req)
+	_, invite.CreatedBy = app.requestActor(gc)
+	app.storage.SetInvitesKey(inviteCode, invite)
+	app.storage.storeInvites()
+	app.adminActivity(gc, activityInviteCreated, []string{inviteCode}, invite.Label)
+	respondBool(200, true, gc)
+}
+
+// newInvite builds an invite from the request, sending it on if asked. It isn't stored.
+func (app *appContext) newInvite(req generateInviteDTO) (string, Invite) {
 	currentTime := time.Now()
 	validTill := currentTime.AddDate(0, req.Months, req.Days)
 	validTill = validTill.Add(time.Hour*time.Duration(req.Hours) + time.Minute*time.Duration(req.Minutes))
@@ -1141,15 +1207,27 @@ func (app *appContext) GenerateInvite(gc *gin.Context) {
 		}
 	}
 	if req.Profile != "" {
-		if _, ok := app.storage.profiles[req.Profile]; ok {
+		if _, ok := app.storage.GetProfilesKey(req.Profile); ok {
 			invite.Profile = req.Profile
 		} else {
 			invite.Profile = "Default"
 		}
 	}
-	app.storage.invites[inviteCode] = invite
-	app.storage.storeInvites()
-	respondBool(200, true, gc)
+	return inviteCode, invite
+}
+
+// parseUsedBy parses the time from an invite's UsedBy entry.
+func (app *appContext) parseUsedBy(t string) time.Time {
+	// These used to be stored formatted instead of as a unix timestamp.
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		date, err := timefmt.Parse(t, app.datePattern+" "+app.timePattern)
+		if err != nil {
+			app.err.Printf("Failed to parse usedBy time: %v", err)
+		}
+		return date
+	}
+	return time.Unix(unix, 0)
 }
 
 // @Summary Get invites.
@@ -1164,7 +1242,7 @@ func (app *appContext) GetInvites(gc *gin.Context) {
 	app.storage.loadInvites()
 	app.checkInvites()
 	var invites []inviteDTO
-	for code, inv := range app.storage.invites {
+	for code, inv := range app.storage.GetInvites() {
 		_, months, days, hours, minutes, _ := timeDiff(inv.ValidTill, currentTime)
 		invite := inviteDTO{
 			Code:        code,
@@ -1185,16 +1263,7 @@ func (app *appContext) GetInvites(gc *gin.Context) {
 		if len(inv.UsedBy) != 0 {
 			invite.UsedBy = map[string]int64{}
 			for _, pair := range inv.UsedBy {
-				// These used to be stored formatted instead of as a unix timestamp.
-				unix, err := strconv.ParseInt(pair[1], 10, 64)
-				if err != nil {
-					date, err := timefmt.Parse(pair[1], app.datePattern+" "+app.timePattern)
-					if err != nil {
-						app.err.Printf("Failed to parse usedBy time: %v", err)
-					}
-					unix = date.Unix()
-				}
-				invite.UsedBy[pair[0]] = unix
+				invite.UsedBy[pair[0]] = app.parseUsedBy(pair[1]).Unix()
 			}
 		}
 		invite.RemainingUses = 1
@@ -1208,7 +1277,7 @@ func (app *appContext) GetInvites(gc *gin.Context) {
 			var address string
 			if app.config.Section("ui").Key("jellyfin_login").MustBool(false) {
 				app.storage.loadEmails()
-				if addr, ok := app.storage.emails[gc.GetString("jfId")]; ok && addr.Addr != "" {
+				if addr, ok := app.storage.GetEmailsKey(gc.GetString("jfId")); ok && addr.Addr != "" {
 					address = addr.Addr
 				}
 			} else {
@@ -1225,13 +1294,15 @@ func (app *appContext) GetInvites(gc *gin.Context) {
 		}
 		invites = append(invites, invite)
 	}
-	profiles := make([]string, len(app.storage.profiles))
-	if len(app.storage.profiles) != 0 {
-		profiles[0] = app.storage.defaultProfile
+	storedProfiles := app.storage.GetProfiles()
+	defaultProfile := app.storage.GetDefaultProfile()
+	profiles := make([]string, len(storedProfiles))
+	if len(storedProfiles) != 0 {
+		profiles[0] = defaultProfile
 		i := 1
-		if len(app.storage.profiles) > 1 {
-			for p := range app.storage.profiles {
-				if p != app.storage.defaultProfile {
+		if len(storedProfiles) > 1 {
+			for p := range storedProfiles {
+				if p != defaultProfile {
 					profiles[i] = p
 					i++
 				}
@@ -1258,14 +1329,14 @@ func (app *appContext) SetProfile(gc *gin.Context) {
 	gc.BindJSON(&req)
 	app.debug.Printf("%s: Setting profile to \"%s\"", req.Invite, req.Profile)
 	// "" means "Don't apply profile"
-	if _, ok := app.storage.profiles[req.Profile]; !ok && req.Profile != "" {
+	if _, ok := app.storage.GetProfilesKey(req.Profile); !ok && req.Profile != "" {
 		app.err.Printf("%s: Profile \"%s\" not found", req.Invite, req.Profile)
 		respond(500, "Profile not found", gc)
 		return
 	}
-	inv := app.storage.invites[req.Invite]
+	inv, _ := app.storage.GetInvitesKey(req.Invite)
 	inv.Profile = req.Profile
-	app.storage.invites[req.Invite] = inv
+	app.storage.SetInvitesKey(req.Invite, inv)
 	app.storage.storeInvites()
 	respondBool(200, true, gc)
 }
@@ -1280,10 +1351,10 @@ func (app *appContext) GetProfiles(gc *gin.Context) {
 	app.storage.loadProfiles()
 	app.debug.Println("Profiles requested")
 	out := getProfilesDTO{
-		DefaultProfile: app.storage.defaultProfile,
+		DefaultProfile: app.storage.GetDefaultProfile(),
 		Profiles:       map[string]profileDTO{},
 	}
-	for name, p := range app.storage.profiles {
+	for name, p := range app.storage.GetProfiles() {
 		out.Profiles[name] = profileDTO{
 			Admin:         p.Admin,
 			LibraryAccess: p.LibraryAccess,
@@ -1306,20 +1377,20 @@ func (app *appContext) SetDefaultProfile(gc *gin.Context) {
 	req := profileChangeDTO{}
 	gc.BindJSON(&req)
 	app.info.Printf("Setting default profile to \"%s\"", req.Name)
-	if _, ok := app.storage.profiles[req.Name]; !ok {
+	if _, ok := app.storage.GetProfilesKey(req.Name); !ok {
 		app.err.Printf("Profile not found: \"%s\"", req.Name)
 		respond(500, "Profile not found", gc)
 		return
 	}
-	for name, profile := range app.storage.profiles {
+	for name, profile := range app.storage.GetProfiles() {
 		if name == req.Name {
 			profile.Admin = true
-			app.storage.profiles[name] = profile
+			app.storage.SetProfilesKey(name, profile)
 		} else {
 			profile.Admin = false
 		}
 	}
-	app.storage.defaultProfile = req.Name
+	app.storage.SetDefaultProfile(req.Name)
 	respondBool(200, true, gc)
 }
 
@@ -1335,8 +1406,8 @@ func (app *appContext) CreateProfile(gc *gin.Context) {
 	app.info.Println("Profile creation requested")
 	var req newProfileDTO
 	gc.BindJSON(&req)
-	app.jf.CacheExpiry = time.Now()
-	user, status, err := app.jf.UserByID(req.ID, false)
+	app.expireJFCache()
+	user, status, err := app.getJFUserByID(req.ID)
 	if !(status == 200 || status == 204) || err != nil {
 		app.err.Printf("Failed to get user from Jellyfin (%d): %v", status, err)
 		respond(500, "Couldn't get user", gc)
@@ -1357,7 +1428,7 @@ func (app *appContext) CreateProfile(gc *gin.Context) {
 		}
 	}
 	app.storage.loadProfiles()
-	app.storage.profiles[req.Name] = profile
+	app.storage.SetProfilesKey(req.Name, profile)
 	app.storage.storeProfiles()
 	app.storage.loadProfiles()
 	respondBool(200, true, gc)
@@ -1374,11 +1445,11 @@ func (app *appContext) DeleteProfile(gc *gin.Context) {
 	req := profileChangeDTO{}
 	gc.BindJSON(&req)
 	name := req.Name
-	if _, ok := app.storage.profiles[name]; ok {
-		if app.storage.defaultProfile == name {
-			app.storage.defaultProfile = ""
+	if _, ok := app.storage.GetProfilesKey(name); ok {
+		if app.storage.GetDefaultProfile() == name {
+			app.storage.SetDefaultProfile("")
 		}
-		delete(app.storage.profiles, name)
+		app.storage.DeleteProfilesKey(name)
 	}
 	app.storage.storeProfiles()
 	respondBool(200, true, gc)
@@ -1401,7 +1472,7 @@ func (app *appContext) SetNotify(gc *gin.Context) {
 		app.debug.Printf("%s: Notification settings change requested", code)
 		app.storage.loadInvites()
 		app.storage.loadEmails()
-		invite, ok := app.storage.invites[code]
+		invite, ok := app.storage.GetInvitesKey(code)
 		if !ok {
 			app.err.Printf("%s Notification setting change failed: Invalid code", code)
 			respond(400, "Invalid invite code", gc)
@@ -1440,7 +1511,7 @@ func (app *appContext) SetNotify(gc *gin.Context) {
 			changed = true
 		}
 		if changed {
-			app.storage.invites[code] = invite
+			app.storage.SetInvitesKey(code, invite)
 		}
 	}
 	if changed {
@@ -1461,9 +1532,9 @@ func (app *appContext) DeleteInvite(gc *gin.Context) {
 	gc.BindJSON(&req)
 	app.debug.Printf("%s: Deletion requested", req.Code)
 	var ok bool
-	_, ok = app.storage.invites[req.Code]
+	_, ok = app.storage.GetInvitesKey(req.Code)
 	if ok {
-		delete(app.storage.invites, req.Code)
+		app.storage.DeleteInvitesKey(req.Code)
 		app.storage.storeInvites()
 		app.info.Printf("%s: Invite deleted", req.Code)
 		respondBool(200, true, gc)
@@ -1473,8 +1544,9 @@ func (app *appContext) DeleteInvite(gc *gin.Context) {
 	respond(400, "Code doesn't exist", gc)
 }
 
-// @Summary Get a list of Jellyfin users.
+// @Summary Get a list of Jellyfin users, optionally filtered with the same search as the accounts tab.
 // @Produce json
+// @Param q query string false "Search, e.g. \"disabled:true\", \"invite:<code or label>\", \"inviter:<name>\" or part of a username or email address."
 // @Success 200 {object} getUsersDTO
 // @Failure 500 {object} stringResponse
 // @Router /users [get]
@@ -1483,57 +1555,66 @@ func (app *appContext) DeleteInvite(gc *gin.Context) {
 func (app *appContext) GetUsers(gc *gin.Context) {
 	app.debug.Println("Users requested")
 	var resp getUsersDTO
-	users, status, err := app.jf.GetUsers(false)
-	resp.UserList = make([]respUser, len(users))
+	users, status, err := app.getJFUsers()
+	resp.UserList = make([]respUser, 0, len(users))
 	if !(status == 200 || status == 204) || err != nil {
 		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
 		respond(500, "Couldn't get users", gc)
 		return
 	}
-	adminOnly := app.config.Section("ui").Key("admin_only").MustBool(true)
-	allowAll := app.config.Section("ui").Key("allow_all").MustBool(false)
-	i := 0
-	app.storage.usersLock.Lock()
-	defer app.storage.usersLock.Unlock()
+	query := gc.Query("q")
 	for _, jfUser := range users {
-		user := respUser{
-			ID:       jfUser.ID,
-			Name:     jfUser.Name,
-			Admin:    jfUser.Policy.IsAdministrator,
-			Disabled: jfUser.Policy.IsDisabled,
-		}
-		if !jfUser.LastActivityDate.IsZero() {
-			user.LastActive = jfUser.LastActivityDate.Unix()
-		}
-		if email, ok := app.storage.emails[jfUser.ID]; ok {
-			user.Email = email.Addr
-			user.NotifyThroughEmail = email.Contact
-			user.Label = email.Label
-			user.AccountsAdmin = (app.jellyfinLogin) && (email.Admin || (adminOnly && jfUser.Policy.IsAdministrator) || allowAll)
-		}
-		expiry, ok := app.storage.users[jfUser.ID]
-		if ok {
-			user.Expiry = expiry.Unix()
-		}
-		if tgUser, ok := app.storage.telegram[jfUser.ID]; ok {
-			user.Telegram = tgUser.Username
-			user.NotifyThroughTelegram = tgUser.Contact
-		}
-		if mxUser, ok := app.storage.matrix[jfUser.ID]; ok {
-			user.Matrix = mxUser.UserID
-			user.NotifyThroughMatrix = mxUser.Contact
-		}
-		if dcUser, ok := app.storage.discord[jfUser.ID]; ok {
-			user.Discord = dcUser.Username + "#" + dcUser.Discriminator
-			user.DiscordID = dcUser.ID
-			user.NotifyThroughDiscord = dcUser.Contact
-		}
-		resp.UserList[i] = user
-		i++
+		if user := app.newRespUser(jfUser); accountsQueryMatches(user, query) {
+			resp.UserList = append(resp.UserList, user)
+		}
 	}
 	gc.JSON(200, resp)
 }
 
+// newRespUser fills in a user's details for the accounts tab.
+func (app *appContext) newRespUser(jfUser mediabrowser.User) respUser {
+	adminOnly := app.config.Section("ui").Key("admin_only").MustBool(true)
+	allowAll := app.config.Section("ui").Key("allow_all").MustBool(false)
+	user := respUser{
+		ID:       jfUser.ID,
+		Name:     jfUser.Name,
+		Admin:    jfUser.Policy.IsAdministrator,
+		Disabled: jfUser.Policy.IsDisabled,
+	}
+	if !jfUser.LastActivityDate.IsZero() {
+		user.LastActive = jfUser.LastActivityDate.Unix()
+	}
+	if email, ok := app.storage.GetEmailsKey(jfUser.ID); ok {
+		user.Label = email.Label
+		user.AccountsAdmin = (app.jellyfinLogin) && (email.Admin || (adminOnly && jfUser.Policy.IsAdministrator) || allowAll)
+		user.Roles = email.Roles
+	}
+	user.TOTP = app.totpEnabled(jfUser.ID)
+	if inv, ok := app.storage.GetUserInvitesKey(jfUser.ID); ok {
+		user.Invite = &userInviteDTO{
+			Code:     inv.Code,
+			Label:    inv.Label,
+			Profile:  inv.Profile,
+			Inviter:  inv.Inviter,
+			Referrer: inv.Referrer,
+			Created:  inv.Created.Unix(),
+		}
+	}
+	expiry, ok := app.storage.GetUsersKey(jfUser.ID)
+	if ok {
+		user.Expiry = expiry.Unix()
+	}
+	user.ContactMethods = map[string]contactAccountDTO{}
+	for _, method := range app.contactMethods {
+		account := contactAccountDTO{}
+		if method.Linked(jfUser.ID) {
+			account = method.Account(jfUser.ID)
+		}
+		user.ContactMethods[contactKey(method)] = account
+	}
+	return user
+}
+
 // @Summary Get a list of Ombi users.
 // @Produce json
 // @Success 200 {object} ombiUsersDTO
@@ -1573,7 +1654,7 @@ func (app *appContext) SetOmbiProfile(gc *gin.Context) {
 	var req ombiUser
 	gc.BindJSON(&req)
 	profileName := gc.Param("profile")
-	profile, ok := app.storage.profiles[profileName]
+	profile, ok := app.storage.GetProfilesKey(profileName)
 	if !ok {
 		respondBool(400, false, gc)
 		return
@@ -1585,7 +1666,7 @@ func (app *appContext) SetOmbiProfile(gc *gin.Context) {
 		return
 	}
 	profile.Ombi = template
-	app.storage.profiles[profileName] = profile
+	app.storage.SetProfilesKey(profileName, profile)
 	if err := app.storage.storeProfiles(); err != nil {
 		respond(500, "Failed to store profile", gc)
 		app.err.Printf("Failed to store profiles: %v", err)
@@ -1605,13 +1686,13 @@ func (app *appContext) SetOmbiProfile(gc *gin.Context) {
 // @tags Ombi
 func (app *appContext) DeleteOmbiProfile(gc *gin.Context) {
 	profileName := gc.Param("profile")
-	profile, ok := app.storage.profiles[profileName]
+	profile, ok := app.storage.GetProfilesKey(profileName)
 	if !ok {
 		respondBool(400, false, gc)
 		return
 	}
 	profile.Ombi = nil
-	app.storage.profiles[profileName] = profile
+	app.storage.SetProfilesKey(profileName, profile)
 	if err := app.storage.storeProfiles(); err != nil {
 		respond(500, "Failed to store profile", gc)
 		app.err.Printf("Failed to store profiles: %v", err)
@@ -1632,7 +1713,8 @@ func (app *appContext) SetAccountsAdmin(gc *gin.Context) {
 	var req setAccountsAdminDTO
 	gc.BindJSON(&req)
 	app.debug.Println("Admin modification requested")
-	users, status, err := app.jf.GetUsers(false)
+	granted, revoked := []string{}, []string{}
+	users, status, err := app.getJFUsers()
 	if !(status == 200 || status == 204) || err != nil {
 		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
 		respond(500, "Couldn't get users", gc)
@@ -1642,21 +1724,171 @@ func (app *appContext) SetAccountsAdmin(gc *gin.Context) {
 		id := jfUser.ID
 		if admin, ok := req[id]; ok {
 			var emailStore = EmailAddress{}
-			if oldEmail, ok := app.storage.emails[id]; ok {
+			if oldEmail, ok := app.storage.GetEmailsKey(id); ok {
 				emailStore = oldEmail
 			}
+			if emailStore.Admin != admin {
+				if admin {
+					granted = append(granted, id)
+				} else {
+					revoked = append(revoked, id)
+				}
+			}
 			emailStore.Admin = admin
-			app.storage.emails[id] = emailStore
+			app.storage.SetEmailsKey(id, emailStore)
 		}
 	}
 	if err := app.storage.storeEmails(); err != nil {
 		app.err.Printf("Failed to store email list: %v", err)
 		respondBool(500, false, gc)
 	}
+	if len(granted) != 0 {
+		app.adminActivity(gc, activityAccountsAdminGranted, granted, "")
+	}
+	if len(revoked) != 0 {
+		app.adminActivity(gc, activityAccountsAdminRevoked, revoked, "")
+	}
 	app.info.Println("Email list modified")
 	respondBool(204, true, gc)
 }
 
+// @Summary Returns the list of roles, and the permissions they can be given.
+// @Produce json
+// @Success 200 {object} getRolesDTO
+// @Router /roles [get]
+// @Security Bearer
+// @tags Roles
+func (app *appContext) GetRoles(gc *gin.Context) {
+	resp := getRolesDTO{Roles: []Role{}, Permissions: permissions}
+	for _, role := range app.storage.GetRoles() {
+		resp.Roles = append(resp.Roles, role)
+	}
+	sort.Slice(resp.Roles, func(i, j int) bool { return resp.Roles[i].Name < resp.Roles[j].Name })
+	gc.JSON(200, resp)
+}
+
+// @Summary Creates a role, or replaces the permissions of an existing one.
+// @Produce json
+// @Param Role body Role true "Name and permissions of the role."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /roles [post]
+// @Security Bearer
+// @tags Roles
+func (app *appContext) SetRole(gc *gin.Context) {
+	var req Role
+	gc.BindJSON(&req)
+	// Names are used in URLs, so can't contain slashes.
+	if req.Name == "" || strings.Contains(req.Name, "/") {
+		respond(400, "Invalid name", gc)
+		return
+	}
+	if req.Permissions == nil {
+		req.Permissions = []string{}
+	}
+	for _, perm := range req.Permissions {
+		if !validPermission(perm) {
+			respond(400, "Invalid permission \""+perm+"\"", gc)
+			return
+		}
+	}
+	app.storage.SetRolesKey(req.Name, req)
+	if err := app.storage.storeRoles(); err != nil {
+		app.err.Printf("Failed to store roles: %v", err)
+		respond(500, "Couldn't store role", gc)
+		return
+	}
+	app.info.Printf("Role \"%s\" set with permissions %s", req.Name, strings.Join(req.Permissions, ", "))
+	app.adminActivity(gc, activityRoleModified, []string{req.Name}, strings.Join(req.Permissions, ", "))
+	respondBool(200, true, gc)
+}
+
+// @Summary Deletes a role, removing it from any users who have it.
+// @Produce json
+// @Param name path string true "Name of the role."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /roles/{name} [delete]
+// @Security Bearer
+// @tags Roles
+func (app *appContext) DeleteRole(gc *gin.Context) {
+	name := gc.Param("name")
+	if _, ok := app.storage.GetRolesKey(name); !ok {
+		respondBool(404, false, gc)
+		return
+	}
+	app.storage.DeleteRolesKey(name)
+	changed := []string{}
+	for id, emailStore := range app.storage.GetEmails() {
+		roles := []string{}
+		for _, role := range emailStore.Roles {
+			if role != name {
+				roles = append(roles, role)
+			}
+		}
+		if len(roles) != len(emailStore.Roles) {
+			emailStore.Roles = roles
+			app.storage.SetEmailsKey(id, emailStore)
+			changed = append(changed, id)
+		}
+	}
+	if err := app.storage.storeTogether("roles", "emails"); err != nil {
+		app.err.Printf("Failed to store roles: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	// Logged in users had their access decided by the role, so they have to log in again.
+	if err := app.revokeUserSessions(changed...); err != nil {
+		app.err.Printf("Failed to revoke sessions: %v", err)
+	}
+	app.info.Printf("Deleted role \"%s\"", name)
+	app.adminActivity(gc, activityRoleDeleted, []string{name}, "")
+	respondBool(200, true, gc)
+}
+
+// @Summary Sets the roles of the given Jellyfin users, logging them out. Users with roles can log in, limited to what their roles allow, unless they're an accounts admin.
+// @Produce json
+// @Param setUserRolesDTO body setUserRolesDTO true "Map of Jellyfin user IDs to role names."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /users/roles [post]
+// @Security Bearer
+// @tags Roles
+func (app *appContext) SetUserRoles(gc *gin.Context) {
+	var req setUserRolesDTO
+	gc.BindJSON(&req)
+	for _, roles := range req {
+		for _, role := range roles {
+			if _, ok := app.storage.GetRolesKey(role); !ok {
+				respond(400, "Role \""+role+"\" doesn't exist", gc)
+				return
+			}
+		}
+	}
+	changed := []string{}
+	for id, roles := range req {
+		emailStore, _ := app.storage.GetEmailsKey(id)
+		emailStore.Roles = roles
+		app.storage.SetEmailsKey(id, emailStore)
+		changed = append(changed, id)
+	}
+	if err := app.storage.storeEmails(); err != nil {
+		app.err.Printf("Failed to store email list: %v", err)
+		respond(500, "Couldn't store roles", gc)
+		return
+	}
+	// Logged in users had their access decided by their old roles, so they have to log in again.
+	if err := app.revokeUserSessions(changed...); err != nil {
+		app.err.Printf("Failed to revoke sessions: %v", err)
+	}
+	app.info.Printf("Roles set for %d user(s)", len(changed))
+	app.adminActivity(gc, activityUserRolesSet, changed, "")
+	respondBool(200, true, gc)
+}
+
 // @Summary Modify user's labels, which show next to their name in the accounts tab.
 // @Produce json
 // @Param modifyEmailsDTO body modifyEmailsDTO true "Map of userIDs to labels"
@@ -1669,7 +1901,7 @@ func (app *appContext) ModifyLabels(gc *gin.Context) {
 	var req modifyEmailsDTO
 	gc.BindJSON(&req)
 	app.debug.Println("Label modification requested")
-	users, status, err := app.jf.GetUsers(false)
+	users, status, err := app.getJFUsers()
 	if !(status == 200 || status == 204) || err != nil {
 		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
 		respond(500, "Couldn't get users", gc)
@@ -1679,11 +1911,11 @@ func (app *appContext) ModifyLabels(gc *gin.Context) {
 		id := jfUser.ID
 		if label, ok := req[id]; ok {
 			var emailStore = EmailAddress{}
-			if oldEmail, ok := app.storage.emails[id]; ok {
+			if oldEmail, ok := app.storage.GetEmailsKey(id); ok {
 				emailStore = oldEmail
 			}
 			emailStore.Label = label
-			app.storage.emails[id] = emailStore
+			app.storage.SetEmailsKey(id, emailStore)
 		}
 	}
 	if err := app.storage.storeEmails(); err != nil {
@@ -1706,7 +1938,7 @@ func (app *appContext) ModifyEmails(gc *gin.Context) {
 	var req modifyEmailsDTO
 	gc.BindJSON(&req)
 	app.debug.Println("Email modification requested")
-	users, status, err := app.jf.GetUsers(false)
+	users, status, err := app.getJFUsers()
 	if !(status == 200 || status == 204) || err != nil {
 		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
 		respond(500, "Couldn't get users", gc)
@@ -1717,20 +1949,13 @@ func (app *appContext) ModifyEmails(gc *gin.Context) {
 		id := jfUser.ID
 		if address, ok := req[id]; ok {
 			var emailStore = EmailAddress{}
-			if oldEmail, ok := app.storage.emails[id]; ok {
+			if oldEmail, ok := app.storage.GetEmailsKey(id); ok {
 				emailStore = oldEmail
 			}
 			emailStore.Addr = address
-			app.storage.emails[id] = emailStore
+			app.storage.SetEmailsKey(id, emailStore)
 			if ombiEnabled {
-				ombiUser, code, err := app.getOmbiUser(id)
-				if code == 200 && err == nil {
-					ombiUser["emailAddress"] = address
-					code, err = app.ombi.ModifyUser(ombiUser)
-					if code != 200 || err != nil {
-						app.err.Printf("%s: Failed to change ombi email address (%d): %v", ombiUser["userName"].(string), code, err)
-					}
-				}
+				app.setOmbiEmail(id, address)
 			}
 		}
 	}
@@ -1739,6 +1964,19 @@ func (app *appContext) ModifyEmails(gc *gin.Context) {
 	respondBool(200, true, gc)
 }
 
+// setOmbiEmail changes the email address of the user's Ombi account, if they have one.
+func (app *appContext) setOmbiEmail(id, address string) {
+	ombiUser, code, err := app.getOmbiUser(id)
+	if code != 200 || err != nil {
+		return
+	}
+	ombiUser["emailAddress"] = address
+	code, err = app.ombi.ModifyUser(ombiUser)
+	if code != 200 || err != nil {
+		app.err.Printf("%s: Failed to change ombi email address (%d): %v", ombiUser["userName"].(string), code, err)
+	}
+}
+
 // @Summary Resets a user's password with a PIN, and optionally set a new password if given.
 // @Produce json
 // @Success 200 {object} boolResponse
@@ -1798,9 +2036,9 @@ func (app *appContext) ResetSetPassword(gc *gin.Context) {
 	var status int
 	var err error
 	if isInternal {
-		user, status, err = app.jf.UserByID(userID, false)
+		user, status, err = app.getJFUserByID(userID)
 	} else {
-		user, status, err = app.jf.UserByName(username, false)
+		user, status, err = app.getJFUserByName(username)
 	}
 	if status != 200 || err != nil {
 		app.err.Printf("Failed to get user \"%s\" (%d): %v", username, status, err)
@@ -1862,23 +2100,23 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 	if req.From == "profile" {
 		app.storage.loadProfiles()
 		// Check profile exists & isn't empty
-		if _, ok := app.storage.profiles[req.Profile]; !ok || app.storage.profiles[req.Profile].Policy.BlockedTags == nil {
+		profile, ok := app.storage.GetProfilesKey(req.Profile)
+		if !ok || profile.Policy.BlockedTags == nil {
 			app.err.Printf("Couldn't find profile \"%s\" or profile was empty", req.Profile)
 			respond(500, "Couldn't find profile", gc)
 			return
 		}
 		if req.Homescreen {
-			if app.storage.profiles[req.Profile].Configuration.GroupedFolders == nil || len(app.storage.profiles[req.Profile].Displayprefs) == 0 {
+			if profile.Configuration.GroupedFolders == nil || len(profile.Displayprefs) == 0 {
 				app.err.Printf("No homescreen saved in profile \"%s\"", req.Profile)
 				respond(500, "No homescreen template available", gc)
 				return
 			}
-			configuration = app.storage.profiles[req.Profile].Configuration
-			displayprefs = app.storage.profiles[req.Profile].Displayprefs
+			configuration = profile.Configuration
+			displayprefs = profile.Displayprefs
 		}
-		policy = app.storage.profiles[req.Profile].Policy
+		policy = profile.Policy
 		if app.config.Section("ombi").Key("enabled").MustBool(false) {
-			profile := app.storage.profiles[req.Profile]
 			if profile.Ombi != nil && len(profile.Ombi) != 0 {
 				ombi = profile.Ombi
 			}
@@ -1886,8 +2124,8 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 
 	} else if req.From == "user" {
 		applyingFrom = "user"
-		app.jf.CacheExpiry = time.Now()
-		user, status, err := app.jf.UserByID(req.ID, false)
+		app.expireJFCache()
+		user, status, err := app.getJFUserByID(req.ID)
 		if !(status == 200 || status == 204) || err != nil {
 			app.err.Printf("Failed to get user from Jellyfin (%d): %v", status, err)
 			respond(500, "Couldn't get user", gc)
@@ -1976,10 +2214,24 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 			time.Sleep(250 * time.Millisecond)
 		}
 	}
+	if req.From == "profile" {
+		for _, id := range req.ApplyTo {
+			if _, failed := errors["policy"][id]; failed {
+				continue
+			}
+			emailStore, _ := app.storage.GetEmailsKey(id)
+			emailStore.Profile = req.Profile
+			app.storage.SetEmailsKey(id, emailStore)
+		}
+		if err := app.storage.storeEmails(); err != nil {
+			app.err.Printf("Failed to store applied profiles: %v", err)
+		}
+	}
 	code := 200
 	if len(errors["policy"]) == len(req.ApplyTo) || len(errors["homescreen"]) == len(req.ApplyTo) {
 		code = 500
 	}
+	app.adminActivity(gc, activitySettingsApplied, req.ApplyTo, "From "+applyingFrom)
 	gc.JSON(code, errors)
 }
 
@@ -2103,6 +2355,7 @@ func (app *appContext) ModifyConfig(gc *gin.Context) {
 	app.info.Println("Config modification requested")
 	var req configDTO
 	gc.BindJSON(&req)
+	changed := []string{}
 	// Load a new config, as we set various default values in app.config that shouldn't be stored.
 	tempConfig, _ := ini.Load(app.configPath)
 	for section, settings := range req {
@@ -2119,6 +2372,8 @@ func (app *appContext) ModifyConfig(gc *gin.Context) {
 					tempConfig.Section("telegram").Key("language").SetValue(value.(string))
 				} else if value.(string) != app.config.Section(section).Key(setting).MustString("") {
 					tempConfig.Section(section).Key(setting).SetValue(value.(string))
+					// Values aren't logged, as some are secrets.
+					changed = append(changed, section+"."+setting)
 				}
 			}
 		}
@@ -2130,6 +2385,7 @@ func (app *appContext) ModifyConfig(gc *gin.Context) {
 		return
 	}
 	app.debug.Println("Config saved")
+	app.adminActivity(gc, activityConfigModified, changed, "")
 	gc.JSON(200, map[string]bool{"success": true})
 	if req["restart-program"] != nil && req["restart-program"].(bool) {
 		app.info.Println("Restarting...")
@@ -2182,6 +2438,9 @@ func (app *appContext) GetCustomEmails(gc *gin.Context) {
 		"WelcomeEmail":      {Name: app.storage.lang.Email[lang].WelcomeEmail["name"], Enabled: app.storage.customEmails.WelcomeEmail.Enabled},
 		"EmailConfirmation": {Name: app.storage.lang.Email[lang].EmailConfirmation["name"], Enabled: app.storage.customEmails.EmailConfirmation.Enabled},
 		"UserExpired":       {Name: app.storage.lang.Email[lang].UserExpired["name"], Enabled: app.storage.customEmails.UserExpired.Enabled},
+		"ExpiryReminder":    {Name: app.storage.lang.Email[lang].ExpiryReminder["name"], Enabled: app.storage.customEmails.ExpiryReminder.Enabled},
+		"DeletionNotice":    {Name: app.storage.lang.Email[lang].DeletionNotice["name"], Enabled: app.storage.customEmails.DeletionNotice.Enabled},
+		"InactivityWarning": {Name: app.storage.lang.Email[lang].InactivityWarning["name"], Enabled: app.storage.customEmails.InactivityWarning.Enabled},
 	})
 }
 
@@ -2209,6 +2468,12 @@ func (app *appContext) getCustomEmail(id string) *customEmail {
 		return &app.storage.customEmails.EmailConfirmation
 	case "UserExpired":
 		return &app.storage.customEmails.UserExpired
+	case "ExpiryReminder":
+		return &app.storage.customEmails.ExpiryReminder
+	case "DeletionNotice":
+		return &app.storage.customEmails.DeletionNotice
+	case "InactivityWarning":
+		return &app.storage.customEmails.InactivityWarning
 	}
 	return nil
 }
@@ -2304,7 +2569,7 @@ func (app *appContext) GetCustomEmailTemplate(gc *gin.Context) {
 		return
 	}
 	if id == "WelcomeEmail" {
-		conditionals = []string{"{yourAccountWillExpire}"}
+		conditionals = []string{"{yourAccountWillExpire}", "{setPasswordLink}"}
 		email.Conditionals = conditionals
 	}
 	content = email.Content
@@ -2353,19 +2618,35 @@ func (app *appContext) GetCustomEmailTemplate(gc *gin.Context) {
 		values = app.email.inviteValues("xxxxxx", Invite{}, app, false)
 	case "WelcomeEmail":
 		if noContent {
-			msg, err = app.email.constructWelcome("", time.Time{}, app, true)
+			msg, err = app.email.constructWelcome("", time.Time{}, "", app, true)
 		}
-		values = app.email.welcomeValues(username, time.Now(), app, false, true)
+		link, _ := app.GenResetLink("xxxxxx")
+		values = app.email.welcomeValues(username, time.Now(), link, app, false, true)
 	case "EmailConfirmation":
 		if noContent {
-			msg, err = app.email.constructConfirmation("", "", "", app, true)
+			msg, err = app.email.constructConfirmation("", "", app, true)
 		}
-		values = app.email.confirmationValues("xxxxxx", username, "xxxxxx", app, false)
+		values = app.email.confirmationValues(username, app.confirmationLink("xxxxxx", "xxxxxx"), app, false)
 	case "UserExpired":
 		if noContent {
 			msg, err = app.email.constructUserExpired(app, true)
 		}
 		values = app.email.userExpiredValues(app, false)
+	case "ExpiryReminder":
+		if noContent {
+			msg, err = app.email.constructExpiryReminder("", time.Time{}, app, true)
+		}
+		values = app.email.expiryReminderValues(username, time.Now(), app, false)
+	case "DeletionNotice":
+		if noContent {
+			msg, err = app.email.constructDeletionNotice(time.Time{}, app, true)
+		}
+		values = app.email.deletionNoticeValues(time.Now(), app, false)
+	case "InactivityWarning":
+		if noContent {
+			msg, err = app.email.constructInactivityWarning("", time.Time{}, time.Time{}, app, true)
+		}
+		values = app.email.inactivityWarningValues(username, time.Now(), time.Now(), app, false)
 	}
 	if err != nil {
 		respondBool(500, false, gc)
@@ -2461,7 +2742,14 @@ func (app *appContext) Logout(gc *gin.Context) {
 		respond(500, "Couldn't fetch cookies", gc)
 		return
 	}
-	app.invalidTokens = append(app.invalidTokens, cookie)
+	if token, err := jwt.Parse(cookie, checkToken); err == nil {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			sessionID, _ := claims["sid"].(string)
+			if err := app.revokeSessions(sessionID); err != nil {
+				app.err.Printf("Failed to store sessions: %v", err)
+			}
+		}
+	}
 	gc.SetCookie("refresh", "invalid", -1, "/", gc.Request.URL.Hostname(), true, true)
 	respondBool(200, true, gc)
 }
@@ -2555,37 +2843,14 @@ func (app *appContext) TelegramAddUser(gc *gin.Context) {
 		respondBool(400, false, gc)
 		return
 	}
-	tokenIndex := -1
-	for i, v := range app.telegram.verifiedTokens {
-		if v.Token == req.Token {
-			tokenIndex = i
-			break
-		}
-	}
-	if tokenIndex == -1 {
+	if err := app.telegram.Link(req.ID, req.Token, true); err != nil {
 		respondBool(500, false, gc)
 		return
 	}
-	tgToken := app.telegram.verifiedTokens[tokenIndex]
-	tgUser := TelegramUser{
-		ChatID:   tgToken.ChatID,
-		Username: tgToken.Username,
-		Contact:  true,
-	}
-	if lang, ok := app.telegram.languages[tgToken.ChatID]; ok {
-		tgUser.Lang = lang
-	}
-	if app.storage.telegram == nil {
-		app.storage.telegram = map[string]TelegramUser{}
-	}
-	app.storage.telegram[req.ID] = tgUser
-	err := app.storage.storeTelegramUsers()
-	if err != nil {
+	if err := app.storage.storeTelegramUsers(); err != nil {
 		app.err.Printf("Failed to store Telegram users: %v", err)
-	} else {
-		app.telegram.verifiedTokens[len(app.telegram.verifiedTokens)-1], app.telegram.verifiedTokens[tokenIndex] = app.telegram.verifiedTokens[tokenIndex], app.telegram.verifiedTokens[len(app.telegram.verifiedTokens)-1]
-		app.telegram.verifiedTokens = app.telegram.verifiedTokens[:len(app.telegram.verifiedTokens)-1]
 	}
+	app.contactLinkedWebhook(app.telegram, req.ID)
 	linkExistingOmbiDiscordTelegram(app)
 	respondBool(200, true, gc)
 }
@@ -2606,72 +2871,24 @@ func (app *appContext) SetContactMethods(gc *gin.Context) {
 		respondBool(400, false, gc)
 		return
 	}
-	if tgUser, ok := app.storage.telegram[req.ID]; ok {
-		change := tgUser.Contact != req.Telegram
-		tgUser.Contact = req.Telegram
-		app.storage.telegram[req.ID] = tgUser
-		if err := app.storage.storeTelegramUsers(); err != nil {
-			respondBool(500, false, gc)
-			app.err.Printf("Telegram: Failed to store users: %v", err)
-			return
-		}
-		if change {
-			msg := ""
-			if !req.Telegram {
-				msg = " not"
-			}
-			app.debug.Printf("Telegram: User \"%s\" will%s be notified through Telegram.", tgUser.Username, msg)
-		}
-	}
-	if dcUser, ok := app.storage.discord[req.ID]; ok {
-		change := dcUser.Contact != req.Discord
-		dcUser.Contact = req.Discord
-		app.storage.discord[req.ID] = dcUser
-		if err := app.storage.storeDiscordUsers(); err != nil {
-			respondBool(500, false, gc)
-			app.err.Printf("Discord: Failed to store users: %v", err)
-			return
-		}
-		if change {
-			msg := ""
-			if !req.Discord {
-				msg = " not"
-			}
-			app.debug.Printf("Discord: User \"%s\" will%s be notified through Discord.", dcUser.Username, msg)
-		}
-	}
-	if mxUser, ok := app.storage.matrix[req.ID]; ok {
-		change := mxUser.Contact != req.Matrix
-		mxUser.Contact = req.Matrix
-		app.storage.matrix[req.ID] = mxUser
-		if err := app.storage.storeMatrixUsers(); err != nil {
-			respondBool(500, false, gc)
-			app.err.Printf("Matrix: Failed to store users: %v", err)
-			return
-		}
-		if change {
-			msg := ""
-			if !req.Matrix {
-				msg = " not"
-			}
-			app.debug.Printf("Matrix: User \"%s\" will%s be notified through Matrix.", mxUser.UserID, msg)
+	for _, method := range app.contactMethods {
+		if !method.Linked(req.ID) {
+			continue
 		}
-	}
-	if email, ok := app.storage.emails[req.ID]; ok {
-		change := email.Contact != req.Email
-		email.Contact = req.Email
-		app.storage.emails[req.ID] = email
-		if err := app.storage.storeEmails(); err != nil {
+		contact := req.Contact[contactKey(method)]
+		change := method.Contact(req.ID) != contact
+		method.SetContact(req.ID, contact)
+		if err := app.storage.store(method.StorageKey()); err != nil {
 			respondBool(500, false, gc)
-			app.err.Printf("Failed to store emails: %v", err)
+			app.err.Printf("%s: Failed to store users: %v", method.Name(), err)
 			return
 		}
 		if change {
 			msg := ""
-			if !req.Email {
+			if !contact {
 				msg = " not"
 			}
-			app.debug.Printf("\"%s\" will%s be notified via Email.", email.Addr, msg)
+			app.debug.Printf("%s: \"%s\" will%s be notified through %s.", method.Name(), method.DisplayName(req.ID), msg, method.Name())
 		}
 	}
 	respondBool(200, true, gc)
@@ -2685,20 +2902,7 @@ func (app *appContext) SetContactMethods(gc *gin.Context) {
 // @Security Bearer
 // @tags Other
 func (app *appContext) TelegramVerified(gc *gin.Context) {
-	pin := gc.Param("pin")
-	tokenIndex := -1
-	for i, v := range app.telegram.verifiedTokens {
-		if v.Token == pin {
-			tokenIndex = i
-			break
-		}
-	}
-	// if tokenIndex != -1 {
-	// 	length := len(app.telegram.verifiedTokens)
-	// 	app.telegram.verifiedTokens[length-1], app.telegram.verifiedTokens[tokenIndex] = app.telegram.verifiedTokens[tokenIndex], app.telegram.verifiedTokens[length-1]
-	// 	app.telegram.verifiedTokens = app.telegram.verifiedTokens[:length-1]
-	// }
-	respondBool(200, tokenIndex != -1, gc)
+	app.pinStatus(app.telegram, gc)
 }
 
 // @Summary Returns true/false on whether or not a telegram PIN was verified. Requires invite code.
@@ -2711,24 +2915,12 @@ func (app *appContext) TelegramVerified(gc *gin.Context) {
 // @tags Other
 func (app *appContext) TelegramVerifiedInvite(gc *gin.Context) {
 	code := gc.Param("invCode")
-	if _, ok := app.storage.invites[code]; !ok {
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
+		app.failedAttempt(gc, "")
 		respondBool(401, false, gc)
 		return
 	}
-	pin := gc.Param("pin")
-	tokenIndex := -1
-	for i, v := range app.telegram.verifiedTokens {
-		if v.Token == pin {
-			tokenIndex = i
-			break
-		}
-	}
-	// if tokenIndex != -1 {
-	// 	length := len(app.telegram.verifiedTokens)
-	// 	app.telegram.verifiedTokens[length-1], app.telegram.verifiedTokens[tokenIndex] = app.telegram.verifiedTokens[tokenIndex], app.telegram.verifiedTokens[length-1]
-	// 	app.telegram.verifiedTokens = app.telegram.verifiedTokens[:length-1]
-	// }
-	respondBool(200, tokenIndex != -1, gc)
+	app.pinStatus(app.telegram, gc)
 }
 
 // @Summary Returns true/false on whether or not a discord PIN was verified. Requires invite code.
@@ -2741,13 +2933,12 @@ func (app *appContext) TelegramVerifiedInvite(gc *gin.Context) {
 // @tags Other
 func (app *appContext) DiscordVerifiedInvite(gc *gin.Context) {
 	code := gc.Param("invCode")
-	if _, ok := app.storage.invites[code]; !ok {
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
+		app.failedAttempt(gc, "")
 		respondBool(401, false, gc)
 		return
 	}
-	pin := gc.Param("pin")
-	_, ok := app.discord.verifiedTokens[pin]
-	respondBool(200, ok, gc)
+	app.pinStatus(app.discord, gc)
 }
 
 // @Summary Returns a 10-minute, one-use Discord server invite
@@ -2765,7 +2956,7 @@ func (app *appContext) DiscordServerInvite(gc *gin.Context) {
 		return
 	}
 	code := gc.Param("invCode")
-	if _, ok := app.storage.invites[code]; !ok {
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
 		respondBool(401, false, gc)
 		return
 	}
@@ -2789,7 +2980,8 @@ func (app *appContext) DiscordServerInvite(gc *gin.Context) {
 // @tags Other
 func (app *appContext) MatrixSendPIN(gc *gin.Context) {
 	code := gc.Param("invCode")
-	if _, ok := app.storage.invites[code]; !ok {
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
+		app.failedAttempt(gc, "")
 		respondBool(401, false, gc)
 		return
 	}
@@ -2818,26 +3010,17 @@ func (app *appContext) MatrixSendPIN(gc *gin.Context) {
 // @tags Other
 func (app *appContext) MatrixCheckPIN(gc *gin.Context) {
 	code := gc.Param("invCode")
-	if _, ok := app.storage.invites[code]; !ok {
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
 		app.debug.Println("Matrix: Invite code was invalid")
+		app.failedAttempt(gc, "")
 		respondBool(401, false, gc)
 		return
 	}
-	userID := gc.Param("userID")
-	pin := gc.Param("pin")
-	user, ok := app.matrix.tokens[pin]
-	if !ok {
-		app.debug.Println("Matrix: PIN not found")
-		respondBool(200, false, gc)
-		return
-	}
-	if user.User.UserID != userID {
-		app.debug.Println("Matrix: User ID of PIN didn't match")
+	if !app.matrix.checkPIN(gc.Param("userID"), gc.Param("pin")) {
+		app.failedAttempt(gc, "")
 		respondBool(200, false, gc)
 		return
 	}
-	user.Verified = true
-	app.matrix.tokens[pin] = user
 	respondBool(200, true, gc)
 }
 
@@ -2888,28 +3071,26 @@ func (app *appContext) MatrixLogin(gc *gin.Context) {
 func (app *appContext) MatrixConnect(gc *gin.Context) {
 	var req MatrixConnectUserDTO
 	gc.BindJSON(&req)
-	if app.storage.matrix == nil {
-		app.storage.matrix = map[string]MatrixUser{}
-	}
 	roomID, encrypted, err := app.matrix.CreateRoom(req.UserID)
 	if err != nil {
 		app.err.Printf("Matrix: Failed to create room: %v", err)
 		respondBool(500, false, gc)
 		return
 	}
-	app.storage.matrix[req.JellyfinID] = MatrixUser{
+	app.storage.SetMatrixKey(req.JellyfinID, MatrixUser{
 		UserID:    req.UserID,
 		RoomID:    string(roomID),
 		Lang:      "en-us",
 		Contact:   true,
 		Encrypted: encrypted,
-	}
+	})
 	app.matrix.isEncrypted[roomID] = encrypted
 	if err := app.storage.storeMatrixUsers(); err != nil {
 		app.err.Printf("Failed to store Matrix users: %v", err)
 		respondBool(500, false, gc)
 		return
 	}
+	app.contactLinkedWebhook(app.matrix, req.JellyfinID)
 	respondBool(200, true, gc)
 }
 
@@ -2959,12 +3140,13 @@ func (app *appContext) DiscordConnect(gc *gin.Context) {
 		respondBool(500, false, gc)
 		return
 	}
-	app.storage.discord[req.JellyfinID] = user
+	app.storage.SetDiscordKey(req.JellyfinID, user)
 	if err := app.storage.storeDiscordUsers(); err != nil {
 		app.err.Printf("Failed to store Discord users: %v", err)
 		respondBool(500, false, gc)
 		return
 	}
+	app.contactLinkedWebhook(app.discord, req.JellyfinID)
 	linkExistingOmbiDiscordTelegram(app)
 	respondBool(200, true, gc)
 }
@@ -2990,6 +3172,351 @@ func (app *appContext) GetLog(gc *gin.Context) {
 	gc.JSON(200, LogDTO{lineCache.String()})
 }
 
+// @Summary Returns a list of backups, newest first.
+// @Produce json
+// @Success 200 {object} getBackupsDTO
+// @Failure 500 {object} stringResponse
+// @Router /backups [get]
+// @Security Bearer
+// @tags Backups
+func (app *appContext) GetBackups(gc *gin.Context) {
+	backups, err := app.getBackups()
+	if err != nil {
+		app.err.Printf("Failed to list backups: %v", err)
+		respond(500, "Couldn't list backups", gc)
+		return
+	}
+	resp := getBackupsDTO{Backups: make([]BackupDTO, len(backups))}
+	for i, b := range backups {
+		resp.Backups[i] = BackupDTO{Name: b.Name, Date: b.Date.Unix(), Size: b.Size}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Makes a backup now.
+// @Produce json
+// @Success 200 {object} BackupDTO
+// @Failure 500 {object} stringResponse
+// @Router /backups [post]
+// @Security Bearer
+// @tags Backups
+func (app *appContext) CreateBackup(gc *gin.Context) {
+	name, err := app.makeBackup("")
+	if err != nil {
+		app.err.Printf("Failed to make backup: %v", err)
+		respond(500, "Couldn't make backup", gc)
+		return
+	}
+	app.info.Printf("Backed up to \"%s\"", name)
+	info, err := os.Stat(filepath.Join(app.backupPath(), name))
+	if err != nil {
+		respond(500, "Couldn't make backup", gc)
+		return
+	}
+	gc.JSON(200, BackupDTO{Name: name, Date: info.ModTime().Unix(), Size: info.Size()})
+}
+
+// @Summary Download a backup archive.
+// @Produce application/zip
+// @Param fname path string true "backup file name"
+// @Success 200 {string} string
+// @Failure 400 {object} boolResponse
+// @Router /backups/{fname} [get]
+// @Security Bearer
+// @tags Backups
+func (app *appContext) GetBackup(gc *gin.Context) {
+	name := gc.Param("fname")
+	if !validBackupName(name) {
+		respondBool(400, false, gc)
+		return
+	}
+	path := filepath.Join(app.backupPath(), name)
+	if _, err := os.Stat(path); err != nil {
+		respondBool(404, false, gc)
+		return
+	}
+	gc.FileAttachment(path, name)
+}
+
+// @Summary Upload a backup archive, so it can then be restored.
+// @Accept multipart/form-data
+// @Produce json
+// @Param backups.zip formData file true "backup archive"
+// @Success 200 {object} BackupDTO
+// @Failure 400 {object} stringResponse
+// @Router /backups/upload [post]
+// @Security Bearer
+// @tags Backups
+func (app *appContext) UploadBackup(gc *gin.Context) {
+	file, err := gc.FormFile("backups.zip")
+	if err != nil {
+		respond(400, "No file given", gc)
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		respond(500, "Couldn't read file", gc)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		respond(500, "Couldn't read file", gc)
+		return
+	}
+	if _, _, err := readBackup(data); err != nil {
+		app.info.Printf("Rejected uploaded backup: %v", err)
+		respond(400, "Invalid backup: "+err.Error(), gc)
+		return
+	}
+	if err := os.MkdirAll(app.backupPath(), 0700); err != nil {
+		respond(500, "Couldn't store backup", gc)
+		return
+	}
+	name := backupPrefix + time.Now().Format(backupTimeLayout) + "-upload" + backupExtension
+	path := filepath.Join(app.backupPath(), name)
+	if err := writeFileAtomic(path, data, 0); err != nil {
+		app.err.Printf("Failed to store uploaded backup: %v", err)
+		respond(500, "Couldn't store backup", gc)
+		return
+	}
+	app.info.Printf("Stored uploaded backup as \"%s\"", name)
+	gc.JSON(200, BackupDTO{Name: name, Date: time.Now().Unix(), Size: int64(len(data))})
+}
+
+// @Summary Restore storage and config.ini from a backup. Everything is reloaded without a restart, although some settings may still require one.
+// @Produce json
+// @Param fname path string true "backup file name"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Failure 500 {object} stringResponse
+// @Router /backups/{fname}/restore [post]
+// @Security Bearer
+// @tags Backups
+func (app *appContext) RestoreBackup(gc *gin.Context) {
+	name := gc.Param("fname")
+	if !validBackupName(name) {
+		respondBool(400, false, gc)
+		return
+	}
+	if err := app.restoreBackup(name); err != nil {
+		app.err.Printf("Failed to restore backup \"%s\": %v", name, err)
+		respond(500, "Couldn't restore backup: "+err.Error(), gc)
+		return
+	}
+	app.info.Printf("Restored backup \"%s\"", name)
+	respondBool(200, true, gc)
+}
+
+// @Summary Returns the webhook delivery log, newest first.
+// @Produce json
+// @Success 200 {object} getWebhookDeliveriesDTO
+// @Router /webhooks/deliveries [get]
+// @Security Bearer
+// @tags Webhooks
+func (app *appContext) GetWebhookDeliveries(gc *gin.Context) {
+	deliveries := app.getWebhookDeliveries()
+	resp := getWebhookDeliveriesDTO{Deliveries: make([]WebhookDeliveryDTO, len(deliveries))}
+	for i, d := range deliveries {
+		resp.Deliveries[i] = WebhookDeliveryDTO{
+			ID:          d.ID,
+			Event:       d.Event,
+			URL:         d.URL,
+			Payload:     d.Payload,
+			Created:     d.Created.Unix(),
+			Attempts:    d.Attempts,
+			LastAttempt: d.LastAttempt.Unix(),
+			Status:      d.Status,
+			Error:       d.Error,
+			Delivered:   d.Delivered,
+		}
+		if !d.NextAttempt.IsZero() {
+			resp.Deliveries[i].NextAttempt = d.NextAttempt.Unix()
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Returns a page of the activity log, newest first, optionally filtered.
+// @Produce json
+// @Param page query int false "Page number, starting at 0."
+// @Param limit query int false "Entries per page, 20 by default and at most 500."
+// @Param action query string false "Only include this action, e.g. users_deleted."
+// @Param actor query string false "Only include actions by this actor ID."
+// @Param target query string false "Only include actions targeting this ID, e.g. a Jellyfin user ID or invite code."
+// @Param since query int false "Only include actions after this Unix timestamp."
+// @Param until query int false "Only include actions before this Unix timestamp."
+// @Success 200 {object} activityLogDTO
+// @Failure 400 {object} stringResponse
+// @Router /activity [get]
+// @Security Bearer
+// @tags Activity
+func (app *appContext) GetActivity(gc *gin.Context) {
+	page, err := strconv.Atoi(gc.DefaultQuery("page", "0"))
+	if err != nil || page < 0 {
+		respond(400, "Invalid page", gc)
+		return
+	}
+	limit, err := strconv.Atoi(gc.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		respond(400, "Invalid limit", gc)
+		return
+	}
+	if limit > maxActivityPage {
+		limit = maxActivityPage
+	}
+	filter := activityFilter{
+		Action: gc.Query("action"),
+		Actor:  gc.Query("actor"),
+		Target: gc.Query("target"),
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := gc.Query(param); v != "" {
+			unix, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				respond(400, "Invalid "+param, gc)
+				return
+			}
+			*t = time.Unix(unix, 0)
+		}
+	}
+	matches := []Activity{}
+	for _, activity := range app.getActivity() {
+		if filter.match(activity) {
+			matches = append(matches, activity)
+		}
+	}
+	resp := activityLogDTO{
+		Activity: []ActivityDTO{},
+		Total:    len(matches),
+		Page:     page,
+		Pages:    (len(matches) + limit - 1) / limit,
+	}
+	// Checked first, so a huge page number can't overflow.
+	if page >= resp.Pages {
+		gc.JSON(200, resp)
+		return
+	}
+	for i := page * limit; i < len(matches) && i < (page+1)*limit; i++ {
+		a := matches[i]
+		resp.Activity = append(resp.Activity, ActivityDTO{
+			ID:        a.ID,
+			Action:    a.Action,
+			ActorType: a.ActorType,
+			ActorID:   a.ActorID,
+			ActorName: a.ActorName,
+			Targets:   a.Targets,
+			Details:   a.Details,
+			Time:      a.Time.Unix(),
+		})
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Returns a list of API keys. Secrets aren't included, as they're only shown on creation.
+// @Produce json
+// @Success 200 {object} getAPIKeysDTO
+// @Router /api-keys [get]
+// @Security Bearer
+// @tags API Keys
+func (app *appContext) GetAPIKeys(gc *gin.Context) {
+	keys := app.storage.GetAPIKeys()
+	resp := getAPIKeysDTO{Keys: make([]APIKeyDTO, 0, len(keys))}
+	for _, key := range keys {
+		dto := APIKeyDTO{
+			ID:        key.ID,
+			Name:      key.Name,
+			Scopes:    key.Scopes,
+			Created:   key.Created.Unix(),
+			CreatedBy: key.CreatedBy,
+		}
+		if !key.Expiry.IsZero() {
+			dto.Expiry = key.Expiry.Unix()
+		}
+		if !key.LastUsed.IsZero() {
+			dto.LastUsed = key.LastUsed.Unix()
+		}
+		resp.Keys = append(resp.Keys, dto)
+	}
+	sort.Slice(resp.Keys, func(i, j int) bool { return resp.Keys[i].Created < resp.Keys[j].Created })
+	gc.JSON(200, resp)
+}
+
+// @Summary Creates an API key with the given scopes. The key is only returned here, so should be noted down.
+// @Produce json
+// @Param newAPIKeyDTO body newAPIKeyDTO true "Name, scopes and expiry of the key."
+// @Success 200 {object} createdAPIKeyDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /api-keys [post]
+// @Security Bearer
+// @tags API Keys
+func (app *appContext) CreateAPIKey(gc *gin.Context) {
+	var req newAPIKeyDTO
+	gc.BindJSON(&req)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		respond(400, "Name and scopes required", gc)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			respond(400, "Invalid scope \""+scope+"\"", gc)
+			return
+		}
+	}
+	var expiry time.Time
+	if req.Expiry != 0 {
+		expiry = time.Unix(req.Expiry, 0)
+		if expiry.Before(time.Now()) {
+			respond(400, "Expiry is in the past", gc)
+			return
+		}
+	}
+	key, token, err := newAPIKey(req.Name, req.Scopes, expiry)
+	if err != nil {
+		app.err.Printf("Failed to generate API key: %v", err)
+		respond(500, "Couldn't generate key", gc)
+		return
+	}
+	_, key.CreatedBy = app.requestActor(gc)
+	app.storage.SetAPIKeysKey(key.ID, key)
+	if err := app.storage.storeAPIKeys(); err != nil {
+		app.err.Printf("Failed to store API keys: %v", err)
+		respond(500, "Couldn't store key", gc)
+		return
+	}
+	app.info.Printf("Created API key \"%s\" with scopes %s", key.Name, strings.Join(key.Scopes, ", "))
+	app.adminActivity(gc, activityAPIKeyCreated, []string{key.ID}, key.Name)
+	gc.JSON(200, createdAPIKeyDTO{ID: key.ID, Key: token})
+}
+
+// @Summary Revokes an API key.
+// @Produce json
+// @Param id path string true "ID of the key."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /api-keys/{id} [delete]
+// @Security Bearer
+// @tags API Keys
+func (app *appContext) DeleteAPIKey(gc *gin.Context) {
+	id := gc.Param("id")
+	key, ok := app.storage.GetAPIKeysKey(id)
+	if !ok {
+		respondBool(404, false, gc)
+		return
+	}
+	app.storage.DeleteAPIKeysKey(id)
+	if err := app.storage.storeAPIKeys(); err != nil {
+		app.err.Printf("Failed to store API keys: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Printf("Revoked API key \"%s\"", key.Name)
+	app.adminActivity(gc, activityAPIKeyRevoked, []string{key.ID}, key.Name)
+	respondBool(200, true, gc)
+}
+
 // no need to syscall.exec anymore!
 func (app *appContext) Restart() error {
 	if TRAY {
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// API keys are given as "Bearer jfa_<key ID>_<secret>". Only a hash of the secret is stored.
+const apiKeyPrefix = "jfa_"
+
+// Resources API key scopes can be given for, each matching a route group in router.go.
+// Scopes take the form "<resource>:<read|write|*>", where read allows GET requests and write allows everything else.
+// "*" gives access to every resource.
+var apiKeyResources = []string{"users", "invites", "profiles", "config", "backups", "logs"}
+
+// APIKey is a named, long-lived credential for scripts, with a limited set of scopes.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"` // SHA-256 of the secret, hex encoded.
+	Scopes    []string  `json:"scopes"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by"`
+	Expiry    time.Time `json:"expiry"` // Zero if the key doesn't expire.
+	LastUsed  time.Time `json:"last_used"`
+}
+
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// newAPIKey generates a key and returns it, along with the token to give to the user. The key isn't stored.
+func newAPIKey(name string, scopes []string, expiry time.Time) (APIKey, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return APIKey{}, "", err
+	}
+	secret := hex.EncodeToString(raw)
+	key := APIKey{
+		ID:      shortuuid.New(),
+		Name:    name,
+		Hash:    hashAPIKeySecret(secret),
+		Scopes:  scopes,
+		Created: time.Now(),
+		Expiry:  expiry,
+	}
+	return key, apiKeyPrefix + key.ID + "_" + secret, nil
+}
+
+// validScope returns whether a scope is one of those described above apiKeyResources.
+func validScope(scope string) bool {
+	if scope == "*" {
+		return true
+	}
+	parts := strings.SplitN(scope, ":", 2)
+	if len(parts) != 2 || !(parts[1] == "read" || parts[1] == "write" || parts[1] == "*") {
+		return false
+	}
+	for _, resource := range apiKeyResources {
+		if parts[0] == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// allows returns whether the key can make a request with the given method to a route group for the given resource.
+func (key APIKey) allows(resource, method string) bool {
+	action := "write"
+	if method == "GET" || method == "HEAD" {
+		action = "read"
+	}
+	for _, scope := range key.Scopes {
+		if scope == "*" || scope == resource+":*" || scope == resource+":"+action {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey checks a token of the form "jfa_<key ID>_<secret>" is valid, unexpired and has access to the resource.
+func (app *appContext) authenticateAPIKey(token, resource string, gc *gin.Context) (APIKey, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(token, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		app.debug.Println("Auth denied: Malformed API key")
+		return APIKey{}, false
+	}
+	key, ok := app.storage.GetAPIKeysKey(parts[0])
+	if !ok || subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(parts[1])), []byte(key.Hash)) != 1 {
+		app.debug.Println("Auth denied: Invalid API key")
+		return APIKey{}, false
+	}
+	if !key.Expiry.IsZero() && key.Expiry.Before(time.Now()) {
+		app.debug.Printf("Auth denied: API key \"%s\" has expired", key.Name)
+		return APIKey{}, false
+	}
+	// Routes for managing keys are only available to logged in admins, so keys can't be used to make more powerful ones.
+	if resource == "" || !key.allows(resource, gc.Request.Method) {
+		app.debug.Printf("Auth denied: API key \"%s\" doesn't have access to %s %s", key.Name, gc.Request.Method, gc.Request.URL.Path)
+		return APIKey{}, false
+	}
+	app.apiKeyUsed(key)
+	return key, true
+}
+
+// apiKeyUsed updates the key's last used time, only writing it to disk every minute so busy scripts don't cause a write per request.
+// Keys deleted since they were checked are left deleted.
+func (app *appContext) apiKeyUsed(key APIKey) {
+	now := time.Now()
+	previous, ok := app.storage.SetAPIKeyLastUsed(key.ID, now)
+	if !ok || now.Sub(previous) <= time.Minute {
+		return
+	}
+	if err := app.storage.storeAPIKeys(); err != nil {
+		app.err.Printf("Failed to store API keys: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidScope(t *testing.T) {
+	for scope, want := range map[string]bool{
+		"*":             true,
+		"users:read":    true,
+		"users:write":   true,
+		"users:*":       true,
+		"backups:write": true,
+		"users":         false,
+		"users:delete":  false,
+		"roles:read":    false,
+		":read":         false,
+		"":              false,
+	} {
+		if got := validScope(scope); got != want {
+			t.Errorf("validScope(%q) = %t, expected %t", scope, got, want)
+		}
+	}
+}
+
+func TestAPIKeyAllows(t *testing.T) {
+	cases := []struct {
+		scopes           []string
+		resource, method string
+		want             bool
+	}{
+		{[]string{"users:read"}, "users", "GET", true},
+		{[]string{"users:read"}, "users", "HEAD", true},
+		{[]string{"users:read"}, "users", "POST", false},
+		{[]string{"users:read"}, "invites", "GET", false},
+		{[]string{"users:write"}, "users", "DELETE", true},
+		{[]string{"users:write"}, "users", "GET", false},
+		{[]string{"users:*"}, "users", "GET", true},
+		{[]string{"users:*"}, "users", "POST", true},
+		{[]string{"users:*"}, "config", "GET", false},
+		{[]string{"invites:read", "config:write"}, "config", "POST", true},
+		{[]string{"*"}, "backups", "POST", true},
+		{[]string{}, "users", "GET", false},
+	}
+	for _, c := range cases {
+		if got := (APIKey{Scopes: c.scopes}).allows(c.resource, c.method); got != c.want {
+			t.Errorf("%v: allows(%s, %s) = %t, expected %t", c.scopes, c.resource, c.method, got, c.want)
+		}
+	}
+}
+
+// TestAPIKeyRoutes checks keys are only let into the route groups their scopes cover, and never into those only for logged in admins.
+func TestAPIKeyRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newTestApp(t, "http://localhost")
+	router := gin.New()
+	ok := func(gc *gin.Context) { gc.Status(200) }
+	router.GET("/users", app.webAuth("users"), ok)
+	router.POST("/users", app.webAuth("users"), ok)
+	router.GET("/invites", app.webAuth("invites"), ok)
+	router.GET("/api-keys", app.webAuth(""), ok)
+
+	key, token, err := newAPIKey("test", []string{"users:read"}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	app.storage.SetAPIKeysKey(key.ID, key)
+	expired, expiredToken, _ := newAPIKey("expired", []string{"*"}, time.Now().Add(-time.Minute))
+	app.storage.SetAPIKeysKey(expired.ID, expired)
+
+	for _, c := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/users", token, 200},
+		{"POST", "/users", token, 401},
+		{"GET", "/invites", token, 401},
+		{"GET", "/api-keys", token, 401},
+		{"GET", "/users", token + "x", 401},
+		{"GET", "/users", apiKeyPrefix + "unknown_secret", 401},
+		{"GET", "/users", expiredToken, 401},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		router.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s %s returned %d, expected %d", c.method, c.path, w.Code, c.want)
+		}
+	}
+	if key, _ = app.storage.GetAPIKeysKey(key.ID); key.LastUsed.IsZero() {
+		t.Error("Key's last used time wasn't updated")
+	}
+	// A key deleted while a request using it was being handled should stay deleted.
+	app.storage.DeleteAPIKeysKey(key.ID)
+	app.apiKeyUsed(key)
+	if _, ok := app.storage.GetAPIKeysKey(key.ID); ok {
+		t.Error("Deleted key was restored when marked as used")
+	}
+}
@@ -93,6 +93,8 @@ func helpFunc() {
 	stop a daemonized instance of jfa-go.
   systemd
 	generate a systemd .service file.
+  import [-dry-run] [-overwrite] <file>
+	import contact details, labels and expiries of existing users from a CSV or JSON file.
 `)
 	shortHands := []string{"-help", "-data", "-config", "-port"}
 	var b bytes.Buffer
@@ -12,18 +12,24 @@ import (
 	"github.com/lithammer/shortuuid/v3"
 )
 
-func (app *appContext) webAuth() gin.HandlerFunc {
-	return app.authenticate
+// webAuth returns middleware authenticating requests to a route group for the given API key resource (see apiKeyResources).
+// Logged in admins can access everything, API keys only what their scopes allow. An empty resource denies all API keys.
+func (app *appContext) webAuth(resource string) gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		app.authenticate(gc, resource)
+	}
 }
 
 // CreateToken returns a web token as well as a refresh token, which can be used to obtain new tokens.
-func CreateToken(userId, jfId string) (string, string, error) {
+// Both are only accepted while the session they belong to exists, see session.go.
+func CreateToken(userId, jfId, sessionID string) (string, string, error) {
 	var token, refresh string
 	claims := jwt.MapClaims{
 		"valid": true,
 		"id":    userId,
 		"exp":   time.Now().Add(time.Minute * 20).Unix(),
 		"jfid":  jfId,
+		"sid":   sessionID,
 		"type":  "bearer",
 	}
 	tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -31,7 +37,7 @@ func CreateToken(userId, jfId string) (string, string, error) {
 	if err != nil {
 		return "", "", err
 	}
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+	claims["exp"] = time.Now().Add(sessionLength).Unix()
 	claims["type"] = "refresh"
 	tk = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	refresh, err = tk.SignedString([]byte(os.Getenv("JFA_SECRET")))
@@ -41,14 +47,49 @@ func CreateToken(userId, jfId string) (string, string, error) {
 	return token, refresh, nil
 }
 
+// findUser returns the first logged in user match returns true for.
+func (app *appContext) findUser(match func(user User) bool) (User, bool) {
+	app.usersLock.RLock()
+	defer app.usersLock.RUnlock()
+	for _, user := range app.users {
+		if match(user) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// addUser adds a logged in user, unless one with the same ID has already been added.
+func (app *appContext) addUser(user User) {
+	app.usersLock.Lock()
+	defer app.usersLock.Unlock()
+	for _, existing := range app.users {
+		if existing.UserID == user.UserID {
+			return
+		}
+	}
+	app.users = append(app.users, user)
+}
+
 // Check header for token
-func (app *appContext) authenticate(gc *gin.Context) {
+func (app *appContext) authenticate(gc *gin.Context, resource string) {
 	header := strings.SplitN(gc.Request.Header.Get("Authorization"), " ", 2)
-	if header[0] != "Bearer" {
+	if header[0] != "Bearer" || len(header) != 2 {
 		app.debug.Println("Invalid authorization header")
 		respond(401, "Unauthorized", gc)
 		return
 	}
+	if strings.HasPrefix(header[1], apiKeyPrefix) {
+		key, ok := app.authenticateAPIKey(header[1], resource, gc)
+		if !ok {
+			respond(401, "Unauthorized", gc)
+			return
+		}
+		gc.Set("apiKeyId", key.ID)
+		app.debug.Printf("Auth succeeded with API key \"%s\"", key.Name)
+		gc.Next()
+		return
+	}
 	token, err := jwt.Parse(string(header[1]), checkToken)
 	if err != nil {
 		app.debug.Printf("Auth denied: %s", err)
@@ -70,20 +111,25 @@ func (app *appContext) authenticate(gc *gin.Context) {
 	}
 	userID := claims["id"].(string)
 	jfID := claims["jfid"].(string)
-	match := false
-	for _, user := range app.users {
-		if user.UserID == userID {
-			match = true
-			break
-		}
+	sessionID, _ := claims["sid"].(string)
+	if _, ok := app.validSession(sessionID); !ok {
+		app.debug.Println("Auth denied: Session logged out or expired")
+		respond(401, "Unauthorized", gc)
+		return
 	}
-	if !match {
+	if _, ok := app.findUser(func(user User) bool { return user.UserID == userID }); !ok {
 		app.debug.Printf("Couldn't find user ID \"%s\"", userID)
 		respond(401, "Unauthorized", gc)
 		return
 	}
+	if jfID != "" && !app.routeAllowed(jfID, gc) {
+		app.debug.Printf("Auth denied: User \"%s\" doesn't have permission for %s %s", jfID, gc.Request.Method, gc.FullPath())
+		respond(403, "Forbidden", gc)
+		return
+	}
 	gc.Set("jfId", jfID)
 	gc.Set("userId", userID)
+	gc.Set("sessionId", sessionID)
 	app.debug.Println("Auth succeeded")
 	gc.Next()
 }
@@ -96,7 +142,9 @@ func checkToken(token *jwt.Token) (interface{}, error) {
 }
 
 type getTokenDTO struct {
-	Token string `json:"token" example:"kjsdklsfdkljfsjsdfklsdfkldsfjdfskjsdfjklsdf"` // API token for use with everything else.
+	Token        string `json:"token" example:"kjsdklsfdkljfsjsdfklsdfkldsfjdfskjsdfjklsdf"` // API token for use with everything else.
+	TOTPRequired bool   `json:"totp_required,omitempty"`                                     // If true, no token is given, and Challenge should be sent to /token/totp with a 2FA code.
+	Challenge    string `json:"challenge,omitempty"`
 }
 
 // @Summary Grabs an API token using username & password.
@@ -115,20 +163,20 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 	var userID, jfID string
 	if creds[0] == "" || creds[1] == "" {
 		app.debug.Println("Auth denied: blank username/password")
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		app.failedAttempt(gc, "")
 		respond(401, "Unauthorized", gc)
 		return
 	}
-	match := false
-	for _, user := range app.users {
-		if user.Username == creds[0] && user.Password == creds[1] {
-			match = true
-			app.debug.Println("Found existing user")
-			userID = user.UserID
-			break
-		}
+	user, match := app.findUser(func(user User) bool { return user.Username == creds[0] && user.Password == creds[1] })
+	if match {
+		app.debug.Println("Found existing user")
+		userID = user.UserID
 	}
 	if !app.jellyfinLogin && !match {
 		app.info.Println("Auth denied: Invalid username/password")
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		app.failedAttempt(gc, creds[0])
 		respond(401, "Unauthorized", gc)
 		return
 	}
@@ -137,26 +185,23 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 		if status != 200 || err != nil {
 			if status == 401 || status == 400 {
 				app.info.Println("Auth denied: Invalid username/password (Jellyfin)")
+				metrics.inc(metricLogins, "result", metricResultFailure)
+				app.failedAttempt(gc, creds[0])
 				respond(401, "Unauthorized", gc)
 				return
 			}
 			app.err.Printf("Auth failed: Couldn't authenticate with Jellyfin (%d/%s)", status, err)
+			metrics.inc(metricLogins, "result", metricResultFailure)
 			respond(500, "Jellyfin error", gc)
 			return
 		}
 		jfID = user.ID
-		if !app.config.Section("ui").Key("allow_all").MustBool(false) {
-			accountsAdmin := false
-			adminOnly := app.config.Section("ui").Key("admin_only").MustBool(true)
-			if emailStore, ok := app.storage.emails[jfID]; ok {
-				accountsAdmin = emailStore.Admin
-			}
-			accountsAdmin = accountsAdmin || (adminOnly && user.Policy.IsAdministrator)
-			if !accountsAdmin {
-				app.debug.Printf("Auth denied: Users \"%s\" isn't admin", creds[0])
-				respond(401, "Unauthorized", gc)
-				return
-			}
+		if !app.accountsAdmin(user) && !app.hasRoles(jfID) {
+			app.debug.Printf("Auth denied: Users \"%s\" isn't admin", creds[0])
+			metrics.inc(metricLogins, "result", metricResultFailure)
+			app.failedAttempt(gc, creds[0])
+			respond(401, "Unauthorized", gc)
+			return
 		}
 		// New users are only added when using jellyfinLogin.
 		userID = shortuuid.New()
@@ -164,16 +209,35 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 			UserID: userID,
 		}
 		app.debug.Printf("Token generated for user \"%s\"", creds[0])
-		app.users = append(app.users, newUser)
+		app.addUser(newUser)
+	}
+	if key, _ := app.totpAccount(userID, jfID); app.totpEnabled(key) {
+		challenge, err := createTOTPChallenge(userID, jfID)
+		if err != nil {
+			app.err.Printf("getToken failed: Couldn't generate 2FA challenge (%s)", err)
+			respond(500, "Couldn't generate token", gc)
+			return
+		}
+		app.debug.Printf("2FA code required for user \"%s\"", creds[0])
+		gc.JSON(200, getTokenDTO{TOTPRequired: true, Challenge: challenge})
+		return
 	}
-	token, refresh, err := CreateToken(userID, jfID)
+	// With 2FA, failures are only cleared once the code is given, so a known password can't be used to reset them.
+	app.succeededAttempt(gc, creds[0])
+	app.issueTokens(userID, jfID, gc)
+}
+
+// issueTokens responds with an API token and sets the refresh cookie, once the user's credentials have been checked.
+func (app *appContext) issueTokens(userID, jfID string, gc *gin.Context) {
+	token, refresh, err := app.createSession(userID, jfID, gc)
 	if err != nil {
 		app.err.Printf("getToken failed: Couldn't generate token (%s)", err)
 		respond(500, "Couldn't generate token", gc)
 		return
 	}
-	gc.SetCookie("refresh", refresh, (3600 * 24), "/", gc.Request.URL.Hostname(), true, true)
-	gc.JSON(200, getTokenDTO{token})
+	metrics.inc(metricLogins, "result", metricResultSuccess)
+	gc.SetCookie("refresh", refresh, int(sessionLength.Seconds()), "/", gc.Request.URL.Hostname(), true, true)
+	gc.JSON(200, getTokenDTO{Token: token})
 }
 
 // @Summary Grabs an API token using a refresh token from cookies.
@@ -190,13 +254,6 @@ func (app *appContext) getTokenRefresh(gc *gin.Context) {
 		respond(400, "Couldn't get token", gc)
 		return
 	}
-	for _, token := range app.invalidTokens {
-		if cookie == token {
-			app.debug.Println("getTokenRefresh: Invalid token")
-			respond(401, "Invalid token", gc)
-			return
-		}
-	}
 	token, err := jwt.Parse(cookie, checkToken)
 	if err != nil {
 		app.debug.Println("getTokenRefresh: Invalid token")
@@ -216,14 +273,19 @@ func (app *appContext) getTokenRefresh(gc *gin.Context) {
 		respond(401, "Invalid token", gc)
 		return
 	}
-	userID := claims["id"].(string)
-	jfID := claims["jfid"].(string)
-	jwt, refresh, err := CreateToken(userID, jfID)
+	sessionID, _ := claims["sid"].(string)
+	session, ok := app.validSession(sessionID)
+	if !ok {
+		app.debug.Println("getTokenRefresh: Session logged out or expired")
+		respond(401, "Invalid token", gc)
+		return
+	}
+	jwt, refresh, err := app.refreshSession(session, gc)
 	if err != nil {
 		app.err.Printf("getTokenRefresh failed: Couldn't generate token (%s)", err)
 		respond(500, "Couldn't generate token", gc)
 		return
 	}
-	gc.SetCookie("refresh", refresh, (3600 * 24), "/", gc.Request.URL.Hostname(), true, true)
-	gc.JSON(200, getTokenDTO{jwt})
+	gc.SetCookie("refresh", refresh, int(sessionLength.Seconds()), "/", gc.Request.URL.Hostname(), true, true)
+	gc.JSON(200, getTokenDTO{Token: jwt})
 }
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Each store in Storage is saved under a key, which is also the name of its path in the "files" config section.
+var storageKeys = []string{"invites", "emails", "users", "telegram_users", "discord_users", "matrix_users", "announcements", "user_profiles", "custom_emails", "ombi_template", "user_template", "user_configuration", "user_displayprefs", "webhook_deliveries", "activity", "api_keys", "roles", "totp", "sessions", "renewal_codes", "expired_users", "expiry_reminders", "inactive_users", "scheduled_actions", "user_invites", "referrers"}
+
+// StorageBackend saves raw (JSON encoded) stores by key.
+type StorageBackend interface {
+	// Get returns the value for key, or nil if it hasn't been stored.
+	Get(key string) ([]byte, error)
+	// Put stores a single value, clearing anything appended to it.
+	Put(key string, value []byte) error
+	// PutAll stores all the given values in one transaction, so either all or none are written. Anything appended to them is cleared.
+	PutAll(values map[string][]byte) error
+	// Append adds a record to the end of key's log without rewriting its value, for stores which grow on every change (e.g. activity).
+	Append(key string, record []byte) error
+	// Appended returns the records appended to key since its value was last stored, oldest first.
+	Appended(key string) ([][]byte, error)
+	Name() string
+	Close() error
+}
+
+// JSONBackend stores each key as a separate JSON file, as jfa-go always has.
+// Multi-key writes are journaled, and an incomplete journal is replayed on the next start.
+// Appended records are kept one per line next to the key's file, see appendPath.
+type JSONBackend struct {
+	path        func(key string) string
+	journalPath string
+}
+
+func newJSONBackend(dataPath string, path func(key string) string) (*JSONBackend, error) {
+	b := &JSONBackend{
+		path:        path,
+		journalPath: filepath.Join(dataPath, "storage-journal.json"),
+	}
+	return b, b.replayJournal()
+}
+
+func (b *JSONBackend) Name() string { return "json" }
+
+func (b *JSONBackend) Get(key string) ([]byte, error) {
+	return readJSONFile(b.path(key))
+}
+
+func (b *JSONBackend) Put(key string, value []byte) error {
+	return writeValue(b.path(key), value)
+}
+
+// writeValue replaces the file at path, along with anything appended to it.
+func writeValue(path string, value []byte) error {
+	if err := writeFileAtomic(path, value, jsonGenerations); err != nil {
+		return err
+	}
+	if err := os.Remove(appendPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// appendPath returns the file records appended to the file at path are kept in, e.g. activity.jsonl for activity.json.
+func appendPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".jsonl"
+}
+
+func (b *JSONBackend) Append(key string, record []byte) error {
+	f, err := os.OpenFile(appendPath(b.path(key)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// Written in one go, so a crash can only cut off the last line.
+	if _, err := f.Write(append(append([]byte{}, record...), '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *JSONBackend) Appended(key string) ([][]byte, error) {
+	data, err := os.ReadFile(appendPath(b.path(key)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	records := [][]byte{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		// Skips the trailing newline, and a last line cut off by a crash.
+		if json.Valid(line) {
+			records = append(records, line)
+		}
+	}
+	return records, nil
+}
+
+func (b *JSONBackend) PutAll(values map[string][]byte) error {
+	journal := map[string]json.RawMessage{}
+	for key, value := range values {
+		journal[b.path(key)] = value
+	}
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(b.journalPath, data, 0); err != nil {
+		return err
+	}
+	if err := b.applyJournal(journal); err != nil {
+		return err
+	}
+	return os.Remove(b.journalPath)
+}
+
+func (b *JSONBackend) applyJournal(journal map[string]json.RawMessage) error {
+	for path, value := range journal {
+		if err := writeValue(path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayJournal finishes a multi-key write which was interrupted.
+func (b *JSONBackend) replayJournal() error {
+	data, err := os.ReadFile(b.journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	journal := map[string]json.RawMessage{}
+	// A journal that can't be parsed was never fully written, so none of its writes were applied.
+	if json.Unmarshal(data, &journal) == nil {
+		if err := b.applyJournal(journal); err != nil {
+			return err
+		}
+	}
+	return os.Remove(b.journalPath)
+}
+
+func (b *JSONBackend) Close() error { return nil }
+
+var (
+	boltStorageBucket  = []byte("storage")
+	boltMetaBucket     = []byte("meta")
+	boltAppendedBucket = []byte("appended")
+	boltMigratedKey    = []byte("migrated_from_json")
+)
+
+// BoltBackend stores everything in a single embedded bbolt database.
+// Appended records are kept in a bucket for each key, in the order they were added.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+func newBoltBackend(path string) (*BoltBackend, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltStorageBucket, boltMetaBucket, boltAppendedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltBackend{db: db}, nil
+}
+
+func (b *BoltBackend) Name() string { return "bolt" }
+
+func (b *BoltBackend) Get(key string) (value []byte, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltStorageBucket).Get([]byte(key)); v != nil {
+			// Values are only valid for the life of the transaction.
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return
+}
+
+func (b *BoltBackend) Put(key string, value []byte) error {
+	return b.PutAll(map[string][]byte{key: value})
+}
+
+func (b *BoltBackend) PutAll(values map[string][]byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltStorageBucket)
+		appended := tx.Bucket(boltAppendedBucket)
+		for key, value := range values {
+			if err := bucket.Put([]byte(key), value); err != nil {
+				return err
+			}
+			if appended.Bucket([]byte(key)) != nil {
+				if err := appended.DeleteBucket([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltBackend) Append(key string, record []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return boltAppend(tx, key, record)
+	})
+}
+
+// boltAppend adds a record to key's bucket, keyed by its sequence number so they're kept in order.
+func boltAppend(tx *bolt.Tx, key string, record []byte) error {
+	bucket, err := tx.Bucket(boltAppendedBucket).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return bucket.Put(k, record)
+}
+
+func (b *BoltBackend) Appended(key string) (records [][]byte, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAppendedBucket).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			records = append(records, append([]byte{}, v...))
+			return nil
+		})
+	})
+	return
+}
+
+func (b *BoltBackend) Close() error { return b.db.Close() }
+
+// migrateFromJSON copies the contents of the existing JSON files into the database, once.
+// The files themselves are left alone, so switching back to the JSON backend is possible.
+func (b *BoltBackend) migrateFromJSON(src *JSONBackend) (migrated []string, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if meta.Get(boltMigratedKey) != nil {
+			return nil
+		}
+		bucket := tx.Bucket(boltStorageBucket)
+		for _, key := range storageKeys {
+			if bucket.Get([]byte(key)) != nil {
+				continue
+			}
+			value, err := src.Get(key)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			records, err := src.Appended(key)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			if value == nil && len(records) == 0 {
+				continue
+			}
+			if value != nil {
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+			for _, record := range records {
+				if err := boltAppend(tx, key, record); err != nil {
+					return err
+				}
+			}
+			migrated = append(migrated, key)
+		}
+		sort.Strings(migrated)
+		return meta.Put(boltMigratedKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+	return
+}
+
+// openStorageBackend opens the backend chosen in the "storage" config section.
+func (app *appContext) openStorageBackend() (StorageBackend, error) {
+	jsonBackend, err := newJSONBackend(app.dataPath, func(key string) string {
+		return app.config.Section("files").Key(key).String()
+	})
+	if err != nil {
+		return nil, err
+	}
+	backend := app.config.Section("storage").Key("backend").MustString("json")
+	switch backend {
+	case "json":
+		return jsonBackend, nil
+	case "bolt":
+		path := app.config.Section("storage").Key("database").String()
+		boltBackend, err := newBoltBackend(path)
+		if err != nil {
+			return nil, err
+		}
+		migrated, err := boltBackend.migrateFromJSON(jsonBackend)
+		if err != nil {
+			boltBackend.Close()
+			return nil, fmt.Errorf("failed to migrate from JSON: %v", err)
+		}
+		if len(migrated) != 0 {
+			app.info.Printf("Migrated %v from JSON into \"%s\"", migrated, path)
+		}
+		return boltBackend, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend \"%s\"", backend)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestJSONBackend(t *testing.T, dir string) *JSONBackend {
+	b, err := newJSONBackend(dir, func(key string) string {
+		return filepath.Join(dir, key+".json")
+	})
+	if err != nil {
+		t.Fatalf("Failed to open JSON backend: %v", err)
+	}
+	return b
+}
+
+// testBackendRoundTrip stores values with Put and PutAll, and checks they read back the same.
+func testBackendRoundTrip(t *testing.T, b StorageBackend) {
+	if v, err := b.Get("invites"); err != nil || v != nil {
+		t.Fatalf("Expected nothing for an unstored key, got %q, %v", v, err)
+	}
+	if err := b.Put("invites", []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := b.PutAll(map[string][]byte{"emails": []byte(`{"b":2}`), "users": []byte(`{"c":3}`)}); err != nil {
+		t.Fatalf("PutAll failed: %v", err)
+	}
+	// Overwriting should replace, not merge.
+	if err := b.Put("invites", []byte(`{"d":4}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for key, want := range map[string]string{"invites": `{"d":4}`, "emails": `{"b":2}`, "users": `{"c":3}`} {
+		got, err := b.Get(key)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", key, err)
+		}
+		if string(got) != want {
+			t.Errorf("Expected %s for %s, got %s", want, key, got)
+		}
+	}
+}
+
+// testBackendAppend appends records to a key, and checks they read back in order without changing its value, until it's stored again.
+func testBackendAppend(t *testing.T, b StorageBackend) {
+	if records, err := b.Appended("activity"); err != nil || len(records) != 0 {
+		t.Fatalf("Expected no appended records, got %q, %v", records, err)
+	}
+	b.Put("activity", []byte(`{"a":1}`))
+	for _, record := range []string{`{"b":2}`, `{"c":3}`} {
+		if err := b.Append("activity", []byte(record)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	records, err := b.Appended("activity")
+	if err != nil {
+		t.Fatalf("Appended failed: %v", err)
+	}
+	if got := fmt.Sprintf("%s", records); got != `[{"b":2} {"c":3}]` {
+		t.Errorf("Expected both records in order, got %s", got)
+	}
+	if got, _ := b.Get("activity"); string(got) != `{"a":1}` {
+		t.Errorf("Expected appending to leave the value alone, got %s", got)
+	}
+	if err := b.PutAll(map[string][]byte{"activity": []byte(`{"d":4}`)}); err != nil {
+		t.Fatalf("PutAll failed: %v", err)
+	}
+	if records, _ := b.Appended("activity"); len(records) != 0 {
+		t.Errorf("Expected storing the value to clear appended records, got %q", records)
+	}
+}
+
+func TestJSONBackend(t *testing.T) {
+	b := newTestJSONBackend(t, t.TempDir())
+	testBackendRoundTrip(t, b)
+	testBackendAppend(t, b)
+	if _, err := os.Stat(b.journalPath); !os.IsNotExist(err) {
+		t.Errorf("Journal wasn't removed after PutAll: %v", err)
+	}
+}
+
+func TestBoltBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jfa-go.db")
+	b, err := newBoltBackend(path)
+	if err != nil {
+		t.Fatalf("Failed to open bolt backend: %v", err)
+	}
+	testBackendRoundTrip(t, b)
+	testBackendAppend(t, b)
+	// Values should survive closing and reopening the database.
+	b.Close()
+	b, err = newBoltBackend(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt backend: %v", err)
+	}
+	defer b.Close()
+	if v, _ := b.Get("emails"); string(v) != `{"b":2}` {
+		t.Errorf("Expected emails to persist, got %s", v)
+	}
+}
+
+// TestJSONJournalReplay checks an interrupted PutAll is finished on the next start, and a half-written journal is ignored.
+func TestJSONJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	journal := `{"` + filepath.Join(dir, "invites.json") + `":{"a":1},"` + filepath.Join(dir, "emails.json") + `":{"b":2}}`
+	if err := os.WriteFile(filepath.Join(dir, "storage-journal.json"), []byte(journal), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b := newTestJSONBackend(t, dir)
+	for key, want := range map[string]string{"invites": `{"a":1}`, "emails": `{"b":2}`} {
+		if got, _ := b.Get(key); string(got) != want {
+			t.Errorf("Expected %s for %s after replay, got %s", want, key, got)
+		}
+	}
+	if _, err := os.Stat(b.journalPath); !os.IsNotExist(err) {
+		t.Errorf("Journal wasn't removed after replay: %v", err)
+	}
+
+	dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "storage-journal.json"), []byte(journal[:len(journal)/2]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b = newTestJSONBackend(t, dir)
+	if got, _ := b.Get("invites"); got != nil {
+		t.Errorf("Expected an incomplete journal to be ignored, got invites %s", got)
+	}
+	if _, err := os.Stat(b.journalPath); !os.IsNotExist(err) {
+		t.Errorf("Incomplete journal wasn't removed: %v", err)
+	}
+}
+
+func TestBoltMigrateFromJSON(t *testing.T) {
+	dir := t.TempDir()
+	src := newTestJSONBackend(t, dir)
+	src.Put("invites", []byte(`{"a":1}`))
+	src.Put("emails", []byte(`{"b":2}`))
+	src.Put("users", []byte(`{"c":3}`))
+	b, err := newBoltBackend(filepath.Join(dir, "jfa-go.db"))
+	if err != nil {
+		t.Fatalf("Failed to open bolt backend: %v", err)
+	}
+	defer b.Close()
+	// Keys already in the database aren't overwritten.
+	b.Put("users", []byte(`{"d":4}`))
+
+	migrated, err := b.migrateFromJSON(src)
+	if err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if want := []string{"emails", "invites"}; !reflect.DeepEqual(migrated, want) {
+		t.Errorf("Expected %v to be migrated, got %v", want, migrated)
+	}
+	for key, want := range map[string]string{"invites": `{"a":1}`, "emails": `{"b":2}`, "users": `{"d":4}`} {
+		if got, _ := b.Get(key); string(got) != want {
+			t.Errorf("Expected %s for %s, got %s", want, key, got)
+		}
+	}
+	// Migration only happens once, so later changes to the JSON files are ignored.
+	src.Put("announcements", []byte(`{"e":5}`))
+	if migrated, err = b.migrateFromJSON(src); err != nil || len(migrated) != 0 {
+		t.Errorf("Expected a second migration to do nothing, got %v, %v", migrated, err)
+	}
+	if got, _ := b.Get("announcements"); got != nil {
+		t.Errorf("Expected announcements not to be migrated, got %s", got)
+	}
+}
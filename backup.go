@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	backupPrefix     = "jfa-go-backup-"
+	backupExtension  = ".zip"
+	backupTimeLayout = "2006-01-02T15-04-05"
+	backupConfigName = "config.ini"
+)
+
+type backupDaemon struct {
+	Stopped         bool
+	ShutdownChannel chan string
+	Interval        time.Duration
+	period          time.Duration
+	app             *appContext
+}
+
+func newBackupDaemon(interval time.Duration, app *appContext) *backupDaemon {
+	return &backupDaemon{
+		Stopped:         false,
+		ShutdownChannel: make(chan string),
+		Interval:        interval,
+		period:          interval,
+		app:             app,
+	}
+}
+
+func (rt *backupDaemon) run() {
+	rt.app.info.Println("Backup daemon started")
+	for {
+		select {
+		case <-rt.ShutdownChannel:
+			rt.ShutdownChannel <- "Down"
+			return
+		case <-time.After(rt.period):
+			break
+		}
+		started := time.Now()
+		if name, err := rt.app.makeBackup(""); err != nil {
+			rt.app.err.Printf("Failed to make backup: %v", err)
+		} else {
+			rt.app.info.Printf("Backed up to \"%s\"", name)
+		}
+		finished := time.Now()
+		duration := finished.Sub(started)
+		rt.period = rt.Interval - duration
+	}
+}
+
+func (rt *backupDaemon) shutdown() {
+	rt.Stopped = true
+	rt.ShutdownChannel <- "Down"
+	<-rt.ShutdownChannel
+	close(rt.ShutdownChannel)
+}
+
+type backupFile struct {
+	Name string
+	Date time.Time
+	Size int64
+}
+
+func (app *appContext) backupPath() string {
+	return app.config.Section("backups").Key("path").String()
+}
+
+// validBackupName makes sure a name passed to the API only refers to a backup, and not some other file.
+func validBackupName(name string) bool {
+	return name == filepath.Base(name) && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExtension)
+}
+
+// makeBackup writes every store and config.ini to a new timestamped archive, then removes any archives beyond the retention count.
+// suffix is added to the name to mark backups not made on schedule.
+func (app *appContext) makeBackup(suffix string) (string, error) {
+	path := app.backupPath()
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", err
+	}
+	name := backupPrefix + time.Now().Format(backupTimeLayout) + suffix + backupExtension
+	// Only stored values are backed up, so fold in anything appended to the activity log.
+	if err := app.storage.storeActivity(); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, key := range storageKeys {
+		data, err := app.storage.backend.Get(key)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", key, err)
+		}
+		if data == nil {
+			continue
+		}
+		w, err := archive.Create(key + ".json")
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write(data); err != nil {
+			return "", err
+		}
+	}
+	config, err := os.ReadFile(app.configPath)
+	if err != nil {
+		return "", err
+	}
+	w, err := archive.Create(backupConfigName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(config); err != nil {
+		return "", err
+	}
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(filepath.Join(path, name), buf.Bytes(), 0); err != nil {
+		return "", err
+	}
+	app.cleanBackups()
+	return name, nil
+}
+
+// getBackups returns all archives in the backup directory, newest first.
+func (app *appContext) getBackups() ([]backupFile, error) {
+	entries, err := os.ReadDir(app.backupPath())
+	if os.IsNotExist(err) {
+		return []backupFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	backups := []backupFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !validBackupName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{
+			Name: entry.Name(),
+			Date: info.ModTime(),
+			Size: info.Size(),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Date.After(backups[j].Date) })
+	return backups, nil
+}
+
+func (app *appContext) cleanBackups() {
+	keep := app.config.Section("backups").Key("keep_n_backups").MustInt(20)
+	if keep <= 0 {
+		return
+	}
+	backups, err := app.getBackups()
+	if err != nil {
+		app.err.Printf("Failed to list backups: %v", err)
+		return
+	}
+	for i := keep; i < len(backups); i++ {
+		app.debug.Printf("Removing old backup \"%s\"", backups[i].Name)
+		if err := os.Remove(filepath.Join(app.backupPath(), backups[i].Name)); err != nil {
+			app.err.Printf("Failed to remove old backup \"%s\": %v", backups[i].Name, err)
+		}
+	}
+}
+
+// readBackup returns the stores and config contained in an archive, checking everything in it can be used.
+func readBackup(data []byte) (stores map[string][]byte, config []byte, err error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return
+	}
+	stores = map[string][]byte{}
+	known := map[string]bool{}
+	for _, key := range storageKeys {
+		known[key+".json"] = true
+	}
+	for _, f := range archive.File {
+		if f.Name != backupConfigName && !known[f.Name] {
+			err = fmt.Errorf("unknown file \"%s\" in backup", f.Name)
+			return
+		}
+		var r io.ReadCloser
+		r, err = f.Open()
+		if err != nil {
+			return
+		}
+		var contents []byte
+		contents, err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return
+		}
+		if f.Name == backupConfigName {
+			config = contents
+		} else {
+			stores[strings.TrimSuffix(f.Name, ".json")] = contents
+		}
+	}
+	if len(stores) == 0 && config == nil {
+		err = fmt.Errorf("backup is empty")
+	}
+	return
+}
+
+// restoreBackup replaces storage and config.ini with the contents of the given archive, and reloads them.
+// A backup of the current state is made first.
+func (app *appContext) restoreBackup(name string) error {
+	data, err := os.ReadFile(filepath.Join(app.backupPath(), name))
+	if err != nil {
+		return err
+	}
+	stores, config, err := readBackup(data)
+	if err != nil {
+		return err
+	}
+	// Stores are replaced before they're loaded, so anything unusable has to be caught now.
+	if err := app.storage.checkStores(stores); err != nil {
+		return fmt.Errorf("invalid store in backup: %v", err)
+	}
+	if config != nil {
+		if _, err := ini.Load(config); err != nil {
+			return fmt.Errorf("invalid config in backup: %v", err)
+		}
+	}
+	if _, err := app.makeBackup("-pre-restore"); err != nil {
+		return fmt.Errorf("failed to backup current state: %v", err)
+	}
+	if len(stores) != 0 {
+		if err := app.storage.backend.PutAll(stores); err != nil {
+			return err
+		}
+	}
+	if config != nil {
+		if err := writeFileAtomic(app.configPath, config, 0); err != nil {
+			return err
+		}
+		if err := app.loadConfig(); err != nil {
+			return err
+		}
+	}
+	return app.storage.reload()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBackupRestore backs up stores of each kind, changes them, and checks restoring brings every one back, in memory and when next stored.
+func TestBackupRestore(t *testing.T) {
+	app := newTestApp(t, "http://localhost")
+	app.configPath = filepath.Join(app.dataPath, "config.ini")
+	if err := os.WriteFile(app.configPath, []byte("[backups]\npath = "+filepath.Join(app.dataPath, "backups")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.loadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	app.storage.SetInvitesKey("invite", Invite{Label: "invite"})
+	app.storage.SetActivityKey("activity", Activity{ID: "activity"})
+	app.storage.SetAPIKeysKey("key", APIKey{ID: "key"})
+	app.storage.SetSessionsKey("session", Session{ID: "session"})
+	app.storage.SetRenewalCodesKey("code", RenewalCode{Code: "code"})
+	app.storage.SetReferrersKey("referrer", Referrer{Invites: []string{"invite"}})
+	if err := app.storage.storeTogether(storageKeys...); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	name, err := app.makeBackup("")
+	if err != nil {
+		t.Fatalf("Failed to make backup: %v", err)
+	}
+
+	app.storage.DeleteInvitesKey("invite")
+	app.storage.DeleteSessionsKey("session")
+	app.storage.SetAPIKeysKey("other", APIKey{ID: "other"})
+	if err := app.storage.storeTogether(storageKeys...); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	if err := app.restoreBackup(name); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+
+	check := func(st *Storage) {
+		t.Helper()
+		if _, ok := st.GetInvitesKey("invite"); !ok {
+			t.Error("Invite wasn't restored")
+		}
+		if _, ok := st.GetActivityKey("activity"); !ok {
+			t.Error("Activity wasn't restored")
+		}
+		if _, ok := st.GetAPIKeysKey("key"); !ok {
+			t.Error("API key wasn't restored")
+		}
+		if _, ok := st.GetAPIKeysKey("other"); ok {
+			t.Error("API key created after the backup wasn't removed")
+		}
+		if _, ok := st.GetSessionsKey("session"); !ok {
+			t.Error("Session wasn't restored")
+		}
+		if _, ok := st.GetRenewalCodesKey("code"); !ok {
+			t.Error("Renewal code wasn't restored")
+		}
+		if _, ok := st.GetReferrersKey("referrer"); !ok {
+			t.Error("Referrer wasn't restored")
+		}
+	}
+	check(&app.storage)
+	// Storing everything again shouldn't lose anything that was restored.
+	if err := app.storage.storeTogether(storageKeys...); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	stored := Storage{backend: app.storage.backend}
+	if err := stored.reload(); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	check(&stored)
+}
+
+// TestRestoreInvalidBackup checks a backup with a store that can't be loaded is rejected before anything is replaced.
+func TestRestoreInvalidBackup(t *testing.T) {
+	app := newTestApp(t, "http://localhost")
+	app.config.Section("backups").Key("path").SetValue(filepath.Join(app.dataPath, "backups"))
+	app.storage.SetAPIKeysKey("key", APIKey{ID: "key"})
+	if err := app.storage.storeAPIKeys(); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	before, _ := app.storage.backend.Get("api_keys")
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, contents := range map[string]string{
+		"api_keys.json": `{}`,
+		"invites.json":  `{"invite":"not an invite"}`,
+	} {
+		w, _ := archive.Create(name)
+		w.Write([]byte(contents))
+	}
+	archive.Close()
+	os.MkdirAll(app.backupPath(), 0700)
+	name := backupPrefix + "invalid" + backupExtension
+	if err := os.WriteFile(filepath.Join(app.backupPath(), name), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.restoreBackup(name); err == nil {
+		t.Fatal("Expected a backup with an invalid store to be rejected")
+	}
+	if after, _ := app.storage.backend.Get("api_keys"); string(after) != string(before) {
+		t.Errorf("Expected storage to be left alone, got api_keys %s", after)
+	}
+}
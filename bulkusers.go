@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+)
+
+// newUsersRow is one user to create with NewUsersAdmin, from a CSV row or JSON object. Only Username is required.
+type newUsersRow struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"` // A random one is generated if not given, and the user is sent a link to set their own.
+	Profile  string `json:"profile"`  // Overrides the profile given for the whole request.
+}
+
+// newUsersColumns maps accepted CSV header names to the fields they fill.
+var newUsersColumns = map[string]func(row *newUsersRow, v string){
+	"username": func(row *newUsersRow, v string) { row.Username = v },
+	"user":     func(row *newUsersRow, v string) { row.Username = v },
+	"email":    func(row *newUsersRow, v string) { row.Email = v },
+	"password": func(row *newUsersRow, v string) { row.Password = v },
+	"profile":  func(row *newUsersRow, v string) { row.Profile = v },
+}
+
+// parseNewUsers reads rows from a JSON array, or otherwise CSV with a header row naming the columns.
+func parseNewUsers(data []byte) ([]newUsersRow, error) {
+	data, isJSON := trimRows(data)
+	if isJSON {
+		rows := []newUsersRow{}
+		err := json.Unmarshal(data, &rows)
+		return rows, err
+	}
+	header, records, err := readCSV(data, func(column string) bool {
+		_, ok := newUsersColumns[column]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]newUsersRow, len(records))
+	for n, record := range records {
+		for i, v := range record {
+			newUsersColumns[header[i]](&rows[n], v)
+		}
+	}
+	return rows, nil
+}
+
+// setPasswordLinksEnabled returns whether users can follow a link to set their own password, which needs link resets with "set password" enabled.
+func (app *appContext) setPasswordLinksEnabled() bool {
+	section := app.config.Section("password_resets")
+	return section.Key("link_reset").MustBool(false) && section.Key("set_password").MustBool(false) && section.Key("url_base").String() != ""
+}
+
+// applyNewUserProfile applies a profile's policy, homescreen and Ombi template to a newly created user, or the old-style templates if name is empty.
+func (app *appContext) applyNewUserProfile(id string, row newUsersRow, name string) {
+	profile := Profile{
+		Policy:        app.storage.policy,
+		Configuration: app.storage.configuration,
+		Displayprefs:  app.storage.displayprefs,
+	}
+	if name != "" {
+		profile, _ = app.storage.GetProfilesKey(name)
+	} else if app.config.Section("ombi").Key("enabled").MustBool(false) {
+		app.storage.loadOmbiTemplate()
+		profile.Ombi = app.storage.ombi_template
+	}
+	if profile.Policy.BlockedTags != nil {
+		status, err := app.jf.SetPolicy(id, profile.Policy)
+		if !((status == 200 || status == 204) && err == nil) {
+			app.err.Printf("%s: Failed to set user policy (%d): %v", row.Username, status, err)
+		}
+	}
+	if profile.Configuration.GroupedFolders != nil && len(profile.Displayprefs) != 0 {
+		status, err := app.jf.SetConfiguration(id, profile.Configuration)
+		if (status == 200 || status == 204) && err == nil {
+			status, err = app.jf.SetDisplayPreferences(id, profile.Displayprefs)
+		}
+		if !((status == 200 || status == 204) && err == nil) {
+			app.err.Printf("%s: Failed to set configuration template (%d): %v", row.Username, status, err)
+		}
+	}
+	if app.config.Section("ombi").Key("enabled").MustBool(false) && len(profile.Ombi) != 0 {
+		errors, code, err := app.ombi.NewUser(row.Username, row.Password, row.Email, profile.Ombi)
+		if err != nil || code != 200 {
+			app.err.Printf("%s: Failed to create Ombi user (%d): %v", row.Username, code, err)
+			app.debug.Printf("Errors reported by Ombi: %s", strings.Join(errors, ", "))
+		} else {
+			app.info.Printf("%s: Created Ombi user", row.Username)
+		}
+	}
+}
+
+// newUsers creates a user for each row, applying their profile (or defaultProfile) and sending them a welcome message.
+// Users without a password are given a random one, and a set-password link valid for linkValidity in their welcome message.
+// Rows which fail don't stop the others.
+func (app *appContext) newUsers(rows []newUsersRow, defaultProfile string, linkValidity time.Duration) newUsersRespDTO {
+	resp := newUsersRespDTO{Users: make([]newUsersResultDTO, len(rows))}
+	if defaultProfile == "" {
+		defaultProfile = app.storage.GetDefaultProfile()
+	}
+	links := app.setPasswordLinksEnabled()
+	seen := map[string]bool{}
+	changed := false
+	for i, row := range rows {
+		result := &resp.Users[i]
+		result.Username = row.Username
+		if row.Profile == "" {
+			row.Profile = defaultProfile
+		}
+		if row.Username == "" {
+			result.Error = "No username given"
+			continue
+		} else if seen[strings.ToLower(row.Username)] {
+			result.Error = "Username appears more than once"
+			continue
+		}
+		seen[strings.ToLower(row.Username)] = true
+		if _, ok := app.storage.GetProfilesKey(row.Profile); row.Profile != "" && !ok {
+			result.Error = fmt.Sprintf("Profile \"%s\" not found", row.Profile)
+			continue
+		}
+		generated := row.Password == ""
+		if generated {
+			if !links {
+				result.Error = "No password given, and set-password links aren't enabled in Settings > Password Resets"
+				continue
+			}
+			var err error
+			if row.Password, err = randomString(); err != nil {
+				app.err.Printf("%s: Failed to generate password: %v", row.Username, err)
+				result.Error = "Couldn't generate password"
+				continue
+			}
+		}
+		if existing, _, _ := app.getJFUserByName(row.Username); existing.Name != "" {
+			result.Error = "User already exists"
+			continue
+		}
+		user, status, err := app.jf.NewUser(row.Username, row.Password)
+		if !(status == 200 || status == 204) || err != nil {
+			app.err.Printf("%s: New user failed (%d): %v", row.Username, status, err)
+			result.Error = fmt.Sprintf("Jellyfin returned %d", status)
+			continue
+		}
+		app.info.Printf("%s: Created user", row.Username)
+		result.ID = user.ID
+		result.Created = true
+		changed = true
+		app.applyNewUserProfile(user.ID, row, row.Profile)
+		if row.Email != "" || row.Profile != "" {
+			app.storage.SetEmailsKey(user.ID, EmailAddress{Addr: row.Email, Contact: row.Email != "", Profile: row.Profile})
+		}
+		app.triggerWebhook(webhookUserCreated, map[string]interface{}{
+			"id":       user.ID,
+			"username": row.Username,
+			"email":    row.Email,
+			"profile":  row.Profile,
+		})
+		link := ""
+		if links {
+			link = app.newSetPasswordLink(user, linkValidity)
+		}
+		result.Sent = app.sendNewUserWelcome(row, link)
+		if !result.Sent && generated {
+			result.Link = link
+		}
+	}
+	if changed {
+		app.expireJFCache()
+		if err := app.storage.storeEmails(); err != nil {
+			app.err.Printf("Failed to store emails: %v", err)
+		}
+	}
+	return resp
+}
+
+// newSetPasswordLink stores an internal password reset for the user, returning a link to it.
+// Like resets from AdminPasswordReset, these are only kept in memory, so a restart invalidates them.
+func (app *appContext) newSetPasswordLink(user mediabrowser.User, validity time.Duration) string {
+	pwr := InternalPWR{
+		PIN:      genAuthToken(),
+		Username: user.Name,
+		ID:       user.ID,
+		Expiry:   time.Now().Add(validity),
+	}
+	if app.internalPWRs == nil {
+		app.internalPWRs = map[string]InternalPWR{}
+	}
+	app.internalPWRs[pwr.PIN] = pwr
+	link, err := app.GenResetLink(pwr.PIN)
+	if err != nil {
+		app.err.Printf("%s: Failed to generate set-password link: %v", user.Name, err)
+	}
+	return link
+}
+
+// sendNewUserWelcome emails a welcome message to a user created with NewUsersAdmin, returning whether it was sent.
+func (app *appContext) sendNewUserWelcome(row newUsersRow, link string) bool {
+	if !emailEnabled || row.Email == "" {
+		return false
+	}
+	msg, err := app.email.constructWelcome(row.Username, time.Time{}, link, app, false)
+	if err != nil {
+		app.err.Printf("%s: Failed to construct welcome email: %v", row.Username, err)
+		return false
+	} else if err := app.email.send(msg, row.Email); err != nil {
+		app.err.Printf("%s: Failed to send welcome email: %v", row.Username, err)
+		return false
+	}
+	app.info.Printf("%s: Sent welcome email to %s", row.Username, row.Email)
+	return true
+}
+
+// @Summary Creates Jellyfin users from CSV with a header row or a JSON array of rows, applying a profile and emailing each a welcome message. Users without a password get a random one, and a link to set their own. Results are given for each row, and failed rows don't stop the rest.
+// @Accept json,text/csv
+// @Produce json
+// @Param rows body []newUsersRow true "Users to create. CSV columns are the same as the JSON fields."
+// @Param profile query string false "Profile for rows which don't give one. Defaults to the default profile."
+// @Param link_hours query int false "How long set-password links are valid, in hours. Defaults to 72."
+// @Success 200 {object} newUsersRespDTO
+// @Failure 400 {object} stringResponse
+// @Router /users/bulk [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) NewUsersAdmin(gc *gin.Context) {
+	data, err := ioutil.ReadAll(gc.Request.Body)
+	if err != nil {
+		respond(400, "Couldn't read request", gc)
+		return
+	}
+	rows, err := parseNewUsers(data)
+	if err != nil {
+		respond(400, err.Error(), gc)
+		return
+	}
+	if len(rows) == 0 {
+		respond(400, "No users given", gc)
+		return
+	}
+	profile := gc.Query("profile")
+	if _, ok := app.storage.GetProfilesKey(profile); profile != "" && !ok {
+		respond(400, "Profile not found", gc)
+		return
+	}
+	hours := 72
+	if v := gc.Query("link_hours"); v != "" {
+		if hours, err = strconv.Atoi(v); err != nil || hours <= 0 {
+			respond(400, "Invalid link_hours", gc)
+			return
+		}
+	}
+	resp := app.newUsers(rows, profile, time.Duration(hours)*time.Hour)
+	if ids := resp.createdIDs(); len(ids) != 0 {
+		app.adminActivity(gc, activityUsersCreated, ids, fmt.Sprintf("%d of %d user(s)", len(ids), len(rows)))
+	}
+	gc.JSON(200, resp)
+}
+
+func (resp newUsersRespDTO) createdIDs() []string {
+	ids := []string{}
+	for _, user := range resp.Users {
+		if user.Created {
+			ids = append(ids, user.ID)
+		}
+	}
+	return ids
+}
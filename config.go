@@ -16,6 +16,7 @@ var messagesEnabled = false
 var telegramEnabled = false
 var discordEnabled = false
 var matrixEnabled = false
+var webhooksEnabled = false
 
 func (app *appContext) GetPath(sect, key string) (fs.FS, string) {
 	val := app.config.Section(sect).Key(key).MustString("")
@@ -46,7 +47,7 @@ func (app *appContext) loadConfig() error {
 			key.SetValue(key.MustString(filepath.Join(app.dataPath, (key.Name() + ".json"))))
 		}
 	}
-	for _, key := range []string{"user_configuration", "user_displayprefs", "user_profiles", "ombi_template", "invites", "emails", "user_template", "custom_emails", "users", "telegram_users", "discord_users", "matrix_users", "announcements"} {
+	for _, key := range []string{"user_configuration", "user_displayprefs", "user_profiles", "ombi_template", "invites", "emails", "user_template", "custom_emails", "users", "telegram_users", "discord_users", "matrix_users", "announcements", "referrers", "user_invites", "scheduled_actions", "inactive_users", "expiry_reminders", "expired_users", "renewal_codes", "sessions", "totp", "roles", "api_keys", "activity", "webhook_deliveries"} {
 		app.config.Section("files").Key(key).SetValue(app.config.Section("files").Key(key).MustString(filepath.Join(app.dataPath, (key + ".json"))))
 	}
 	for _, key := range []string{"matrix_sql"} {
@@ -99,6 +100,14 @@ func (app *appContext) loadConfig() error {
 	app.MustSetValue("user_expiry", "behaviour", "disable_user")
 	app.MustSetValue("user_expiry", "email_html", "jfa-go:"+"user-expired.html")
 	app.MustSetValue("user_expiry", "email_text", "jfa-go:"+"user-expired.txt")
+	app.MustSetValue("user_expiry", "final_notice_html", "jfa-go:"+"deletion-notice.html")
+	app.MustSetValue("user_expiry", "final_notice_text", "jfa-go:"+"deletion-notice.txt")
+
+	app.MustSetValue("expiry_reminders", "email_html", "jfa-go:"+"expiry-reminder.html")
+	app.MustSetValue("expiry_reminders", "email_text", "jfa-go:"+"expiry-reminder.txt")
+
+	app.MustSetValue("inactivity", "email_html", "jfa-go:"+"inactivity-warning.html")
+	app.MustSetValue("inactivity", "email_text", "jfa-go:"+"inactivity-warning.txt")
 
 	app.MustSetValue("matrix", "topic", "Jellyfin notifications")
 	app.MustSetValue("matrix", "show_on_reg", "true")
@@ -134,6 +143,7 @@ func (app *appContext) loadConfig() error {
 	if !emailEnabled && !telegramEnabled && !discordEnabled && !matrixEnabled {
 		messagesEnabled = false
 	}
+	webhooksEnabled = app.config.Section("webhooks").Key("enabled").MustBool(false) && len(app.webhookURLs()) != 0
 
 	app.MustSetValue("updates", "enabled", "true")
 	releaseChannel := app.config.Section("updates").Key("channel").String()
@@ -157,8 +167,19 @@ func (app *appContext) loadConfig() error {
 		app.MustSetValue("updates", "channel", releaseChannel)
 	}
 
+	app.MustSetValue("storage", "backend", "json")
+	app.MustSetValue("storage", "database", filepath.Join(app.dataPath, "jfa-go.db"))
+	jsonGenerations = app.config.Section("storage").Key("generations").MustInt(2)
+
+	app.MustSetValue("backups", "path", filepath.Join(app.dataPath, "backups"))
+	app.MustSetValue("backups", "every_n_minutes", "1440")
+	app.MustSetValue("backups", "keep_n_backups", "20")
+
 	app.storage.customEmails_path = app.config.Section("files").Key("custom_emails").String()
-	app.storage.loadCustomEmails()
+	// The backend isn't opened until storage is first loaded in start().
+	if app.storage.backend != nil {
+		app.storage.loadCustomEmails()
+	}
 
 	substituteStrings = app.config.Section("jellyfin").Key("substitute_jellyfin_strings").MustString("")
 
@@ -184,3 +205,14 @@ func (app *appContext) loadConfig() error {
 
 	return nil
 }
+
+// configSet splits a comma-separated setting into a set of its values.
+func configSet(section *ini.Section, key string) map[string]bool {
+	m := map[string]bool{}
+	for _, v := range strings.Split(section.Key(key).MustString(""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			m[v] = true
+		}
+	}
+	return m
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContactMethod is a way of messaging users, e.g. email or one of the chat bots.
+// Each is registered with registerContactMethod once running, and sending, listing
+// and deleting users goes through every registered method.
+type ContactMethod interface {
+	// Name is shown in logs, and in lower case matches the method's fields in requests, e.g. "Telegram" for "telegram_pin".
+	Name() string
+	// Enabled returns whether messages can currently be sent through the method.
+	Enabled() bool
+	// StorageKey is the Storage key the method's users are kept under.
+	StorageKey() string
+	// Linked returns whether the Jellyfin user has an account on this method.
+	Linked(jfID string) bool
+	// DisplayName returns how the user's account should be shown, e.g. "@username" or an address.
+	DisplayName(jfID string) string
+	// Contact returns whether the user wants to be messaged through this method.
+	Contact(jfID string) bool
+	// SetContact changes whether the user wants to be messaged through this method. It doesn't store the change.
+	SetContact(jfID string, contact bool)
+	// SendByID sends a message to the user's account.
+	SendByID(msg *Message, jfID string) error
+	// Account returns the user's account, for their entry in the accounts list.
+	Account(jfID string) contactAccountDTO
+	// Unlink removes the user's account. It doesn't store the change.
+	Unlink(jfID string)
+}
+
+// PINVerifier is implemented by contact methods users link by sending a PIN to a bot.
+type PINVerifier interface {
+	ContactMethod
+	// Required returns whether a verified PIN must be given on the signup form.
+	Required() bool
+	// Verified returns whether the PIN has been verified by someone.
+	Verified(pin string) bool
+	// Issued returns whether the PIN was given out and hasn't been used to link an account yet, verified or not.
+	Issued(pin string) bool
+	// Link gives the Jellyfin user the account which verified the PIN, and consumes it. It doesn't store the change.
+	Link(jfID, pin string, contact bool) error
+}
+
+func (app *appContext) registerContactMethod(method ContactMethod) {
+	app.debug.Printf("Registered contact method \"%s\"", method.Name())
+	app.contactMethods = append(app.contactMethods, method)
+}
+
+// contactKey returns the name used for a method's fields in requests.
+func contactKey(method ContactMethod) string {
+	return strings.ToLower(method.Name())
+}
+
+// contactChoice is what the signup form gives for one contact method.
+type contactChoice struct {
+	PIN     string // Verification PIN, for methods linked through a bot.
+	Contact bool   // Whether the user wants to be messaged through the method.
+}
+
+// UnmarshalJSON reads the fixed fields, then collects "<method>_pin" and "<method>_contact" fields into ContactMethods,
+// so contact methods don't need their own fields.
+func (req *newUserDTO) UnmarshalJSON(data []byte) error {
+	type plain newUserDTO
+	if err := json.Unmarshal(data, (*plain)(req)); err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	req.ContactMethods = map[string]contactChoice{}
+	for field, value := range fields {
+		if method := strings.TrimSuffix(field, "_pin"); method != field {
+			choice := req.ContactMethods[method]
+			if json.Unmarshal(value, &choice.PIN) == nil {
+				req.ContactMethods[method] = choice
+			}
+		} else if method := strings.TrimSuffix(field, "_contact"); method != field {
+			choice := req.ContactMethods[method]
+			if json.Unmarshal(value, &choice.Contact) == nil {
+				req.ContactMethods[method] = choice
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON reads "id", and collects every other boolean field into Contact by contact method.
+func (req *SetContactMethodsDTO) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	req.Contact = map[string]bool{}
+	for field, value := range fields {
+		if field == "id" {
+			if err := json.Unmarshal(value, &req.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		var contact bool
+		if json.Unmarshal(value, &contact) == nil {
+			req.Contact[field] = contact
+		}
+	}
+	return nil
+}
+
+// MarshalJSON adds "<method>", "notify_<method>" and "<method>_id" fields for each contact method to the fixed ones,
+// in the form the accounts tab has always used.
+func (user respUser) MarshalJSON() ([]byte, error) {
+	type plain respUser
+	data, err := json.Marshal(plain(user))
+	if err != nil || len(user.ContactMethods) == 0 {
+		return data, err
+	}
+	fields := map[string]interface{}{}
+	for method, account := range user.ContactMethods {
+		fields[method] = account.Name
+		fields["notify_"+method] = account.Contact
+		if account.ID != "" {
+			fields[method+"_id"] = account.ID
+		}
+	}
+	extra, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	// Both are objects, so join them by replacing the first's closing brace with a comma and the second's opening one.
+	return append(append(data[:len(data)-1], ','), extra[1:]...), nil
+}
+
+// pinStatus responds with whether the PIN in the request's path has been verified.
+// Polling for a PIN that's waiting to be verified is expected, but PINs that were never given out count as failed attempts.
+func (app *appContext) pinStatus(verifier PINVerifier, gc *gin.Context) {
+	pin := gc.Param("pin")
+	if !verifier.Issued(pin) {
+		app.failedAttempt(gc, "")
+		respondBool(200, false, gc)
+		return
+	}
+	respondBool(200, verifier.Verified(pin), gc)
+}
+
+// unlinkContactMethods removes the given users' accounts from every contact method, e.g. once they've been deleted.
+func (app *appContext) unlinkContactMethods(jfIDs ...string) error {
+	changedStores := []string{}
+	for _, method := range app.contactMethods {
+		changed := false
+		for _, id := range jfIDs {
+			if method.Linked(id) {
+				method.Unlink(id)
+				changed = true
+			}
+		}
+		if changed {
+			changedStores = append(changedStores, method.StorageKey())
+		}
+	}
+	if len(changedStores) == 0 {
+		return nil
+	}
+	return app.storage.storeTogether(changedStores...)
+}
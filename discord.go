@@ -48,7 +48,7 @@ func newDiscordDaemon(app *appContext) (*DiscordDaemon, error) {
 	dd.commandHandlers[app.config.Section("discord").Key("start_command").MustString("start")] = dd.cmdStart
 	dd.commandHandlers["lang"] = dd.cmdLang
 	dd.commandHandlers["pin"] = dd.cmdPIN
-	for _, user := range app.storage.discord {
+	for _, user := range app.storage.GetDiscord() {
 		dd.users[user.ID] = user
 	}
 
@@ -442,11 +442,11 @@ func (d *DiscordDaemon) cmdLang(s *dg.Session, i *dg.InteractionCreate, lang str
 	code := i.ApplicationCommandData().Options[0].StringValue()
 	if _, ok := d.app.storage.lang.Telegram[code]; ok {
 		var user DiscordUser
-		for jfID, u := range d.app.storage.discord {
+		for jfID, u := range d.app.storage.GetDiscord() {
 			if u.ID == i.Interaction.Member.User.ID {
 				u.Lang = code
 				lang = code
-				d.app.storage.discord[jfID] = u
+				d.app.storage.SetDiscordKey(jfID, u)
 				if err := d.app.storage.storeDiscordUsers(); err != nil {
 					d.app.err.Printf("Failed to store Discord users: %v", err)
 				}
@@ -552,10 +552,10 @@ func (d *DiscordDaemon) msgLang(s *dg.Session, m *dg.MessageCreate, sects []stri
 	}
 	if _, ok := d.app.storage.lang.Telegram[sects[1]]; ok {
 		var user DiscordUser
-		for jfID, u := range d.app.storage.discord {
+		for jfID, u := range d.app.storage.GetDiscord() {
 			if u.ID == m.Author.ID {
 				u.Lang = sects[1]
-				d.app.storage.discord[jfID] = u
+				d.app.storage.SetDiscordKey(jfID, u)
 				if err := d.app.storage.storeDiscordUsers(); err != nil {
 					d.app.err.Printf("Failed to store Discord users: %v", err)
 				}
@@ -632,33 +632,122 @@ func (d *DiscordDaemon) Send(message *Message, channelID ...string) error {
 		msg = message.Text
 	}
 	for _, id := range channelID {
-		var err error
-		if len(embeds) != 0 {
-			_, err = d.bot.ChannelMessageSendComplex(
-				id,
-				&dg.MessageSend{
-					Content: msg,
-					Embed:   embeds[0],
-				},
-			)
-			if err != nil {
-				return err
-			}
-			for i := 1; i < len(embeds); i++ {
-				_, err := d.bot.ChannelMessageSendEmbed(id, embeds[i])
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			_, err := d.bot.ChannelMessageSend(
-				id,
-				msg,
-			)
-			if err != nil {
-				return err
-			}
+		err := d.sendToChannel(id, msg, embeds)
+		metrics.result(metricMessages, err, "daemon", "discord")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DiscordDaemon) sendToChannel(id, msg string, embeds []*dg.MessageEmbed) error {
+	if len(embeds) == 0 {
+		_, err := d.bot.ChannelMessageSend(id, msg)
+		return err
+	}
+	_, err := d.bot.ChannelMessageSendComplex(
+		id,
+		&dg.MessageSend{
+			Content: msg,
+			Embed:   embeds[0],
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(embeds); i++ {
+		if _, err := d.bot.ChannelMessageSendEmbed(id, embeds[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContactMethod implementation, registered in main once the daemon is running.
+
+func (d *DiscordDaemon) Name() string       { return "Discord" }
+func (d *DiscordDaemon) Enabled() bool      { return discordEnabled }
+func (d *DiscordDaemon) StorageKey() string { return "discord_users" }
+
+func (d *DiscordDaemon) Linked(jfID string) bool {
+	_, ok := d.app.storage.GetDiscordKey(jfID)
+	return ok
+}
+
+func (d *DiscordDaemon) DisplayName(jfID string) string {
+	user, _ := d.app.storage.GetDiscordKey(jfID)
+	return user.Username + "#" + user.Discriminator
+}
+
+func (d *DiscordDaemon) Contact(jfID string) bool {
+	user, ok := d.app.storage.GetDiscordKey(jfID)
+	return ok && user.Contact
+}
+
+func (d *DiscordDaemon) SetContact(jfID string, contact bool) {
+	if user, ok := d.app.storage.GetDiscordKey(jfID); ok {
+		user.Contact = contact
+		d.app.storage.SetDiscordKey(jfID, user)
+	}
+}
+
+func (d *DiscordDaemon) SendByID(msg *Message, jfID string) error {
+	user, ok := d.app.storage.GetDiscordKey(jfID)
+	if !ok {
+		return nil
+	}
+	// Imported users only have an ID until they're first messaged.
+	if user.ChannelID == "" {
+		newUser, ok := d.NewUser(user.ID)
+		if !ok {
+			return fmt.Errorf("failed to create DM channel for \"%s\"", user.ID)
+		}
+		newUser.Contact, newUser.Lang = user.Contact, user.Lang
+		user = newUser
+		d.app.storage.SetDiscordKey(jfID, user)
+		if err := d.app.storage.storeDiscordUsers(); err != nil {
+			d.app.err.Printf("Failed to store Discord users: %v", err)
+		}
+	}
+	return d.Send(msg, user.ChannelID)
+}
+
+func (d *DiscordDaemon) Account(jfID string) contactAccountDTO {
+	dcUser, _ := d.app.storage.GetDiscordKey(jfID)
+	return contactAccountDTO{Name: dcUser.Username + "#" + dcUser.Discriminator, ID: dcUser.ID, Contact: dcUser.Contact}
+}
+
+func (d *DiscordDaemon) Unlink(jfID string) { d.app.storage.DeleteDiscordKey(jfID) }
+
+func (d *DiscordDaemon) Required() bool {
+	return d.app.config.Section("discord").Key("required").MustBool(false)
+}
+
+func (d *DiscordDaemon) Verified(pin string) bool {
+	_, ok := d.verifiedTokens[pin]
+	return ok
+}
+
+func (d *DiscordDaemon) Issued(pin string) bool {
+	for _, token := range d.tokens {
+		if token == pin {
+			return true
 		}
 	}
+	return d.Verified(pin)
+}
+
+func (d *DiscordDaemon) Link(jfID, pin string, contact bool) error {
+	user, ok := d.verifiedTokens[pin]
+	if !ok {
+		return fmt.Errorf("PIN not verified")
+	}
+	if err := d.ApplyRole(user.ID); err != nil {
+		return fmt.Errorf("failed to set member role: %v", err)
+	}
+	user.Contact = contact
+	d.app.storage.SetDiscordKey(jfID, user)
+	delete(d.verifiedTokens, pin)
 	return nil
 }
@@ -287,7 +287,25 @@ func (emailer *Emailer) construct(app *appContext, section, keyFragment string,
 	return
 }
 
-func (emailer *Emailer) confirmationValues(code, username, key string, app *appContext, noSub bool) map[string]interface{} {
+// confirmationLink returns the link in a sign-up confirmation email, which finishes creating the account.
+func (app *appContext) confirmationLink(code, key string) string {
+	inviteLink := app.config.Section("invite_emails").Key("url_base").String()
+	if !strings.HasSuffix(inviteLink, "/invite") {
+		inviteLink += "/invite"
+	}
+	return fmt.Sprintf("%s/%s?key=%s", inviteLink, code, key)
+}
+
+// GenEmailChangeLink generates the link in the confirmation email sent when a user changes their address on the My Account page.
+func (app *appContext) GenEmailChangeLink(key string) (string, error) {
+	url := app.config.Section("password_resets").Key("url_base").String()
+	if url == "" {
+		return "", fmt.Errorf("disabled as no URL Base provided. Set in Settings > Password Resets.")
+	}
+	return fmt.Sprintf("%s/my/confirm/%s", url, key), nil
+}
+
+func (emailer *Emailer) confirmationValues(username, link string, app *appContext, noSub bool) map[string]interface{} {
 	template := map[string]interface{}{
 		"clickBelow":    emailer.lang.EmailConfirmation.get("clickBelow"),
 		"ifItWasNotYou": emailer.lang.Strings.get("ifItWasNotYou"),
@@ -303,24 +321,19 @@ func (emailer *Emailer) confirmationValues(code, username, key string, app *appC
 		}
 	} else {
 		message := app.config.Section("messages").Key("message").String()
-		inviteLink := app.config.Section("invite_emails").Key("url_base").String()
-		if !strings.HasSuffix(inviteLink, "/invite") {
-			inviteLink += "/invite"
-		}
-		inviteLink = fmt.Sprintf("%s/%s?key=%s", inviteLink, code, key)
 		template["helloUser"] = emailer.lang.Strings.template("helloUser", tmpl{"username": username})
-		template["confirmationURL"] = inviteLink
+		template["confirmationURL"] = link
 		template["message"] = message
 	}
 	return template
 }
 
-func (emailer *Emailer) constructConfirmation(code, username, key string, app *appContext, noSub bool) (*Message, error) {
+func (emailer *Emailer) constructConfirmation(username, link string, app *appContext, noSub bool) (*Message, error) {
 	email := &Message{
 		Subject: app.config.Section("email_confirmation").Key("subject").MustString(emailer.lang.EmailConfirmation.get("title")),
 	}
 	var err error
-	template := emailer.confirmationValues(code, username, key, app, noSub)
+	template := emailer.confirmationValues(username, link, app, noSub)
 	if app.storage.customEmails.EmailConfirmation.Enabled {
 		content := templateEmail(
 			app.storage.customEmails.EmailConfirmation.Content,
@@ -565,7 +578,7 @@ func (emailer *Emailer) resetValues(pwr PasswordReset, app *appContext, noSub bo
 				// Only used in html email.
 				template["pin_code"] = pwr.Pin
 			} else {
-				app.info.Println("Couldn't generate PWR link: %v", err)
+				app.info.Printf("Couldn't generate PWR link: %v", err)
 				template["pin"] = pwr.Pin
 			}
 		} else {
@@ -722,17 +735,20 @@ func (emailer *Emailer) constructEnabled(reason string, app *appContext, noSub b
 	return email, nil
 }
 
-func (emailer *Emailer) welcomeValues(username string, expiry time.Time, app *appContext, noSub bool, custom bool) map[string]interface{} {
+// welcomeValues builds the welcome message. setPasswordLink is only given for users created without a password they know, see NewUsersAdmin.
+func (emailer *Emailer) welcomeValues(username string, expiry time.Time, setPasswordLink string, app *appContext, noSub bool, custom bool) map[string]interface{} {
 	template := map[string]interface{}{
 		"welcome":               emailer.lang.WelcomeEmail.get("welcome"),
 		"youCanLoginWith":       emailer.lang.WelcomeEmail.get("youCanLoginWith"),
 		"jellyfinURLString":     emailer.lang.WelcomeEmail.get("jellyfinURL"),
 		"usernameString":        emailer.lang.Strings.get("username"),
+		"setYourPassword":       emailer.lang.WelcomeEmail.get("setYourPassword"),
 		"message":               "",
 		"yourAccountWillExpire": "",
+		"setPasswordLink":       setPasswordLink,
 	}
 	if noSub {
-		empty := []string{"jellyfinURL", "username", "yourAccountWillExpire"}
+		empty := []string{"jellyfinURL", "username", "yourAccountWillExpire", "setPasswordLink"}
 		for _, v := range empty {
 			template[v] = "{" + v + "}"
 		}
@@ -754,16 +770,16 @@ func (emailer *Emailer) welcomeValues(username string, expiry time.Time, app *ap
 	return template
 }
 
-func (emailer *Emailer) constructWelcome(username string, expiry time.Time, app *appContext, noSub bool) (*Message, error) {
+func (emailer *Emailer) constructWelcome(username string, expiry time.Time, setPasswordLink string, app *appContext, noSub bool) (*Message, error) {
 	email := &Message{
 		Subject: app.config.Section("welcome_email").Key("subject").MustString(emailer.lang.WelcomeEmail.get("title")),
 	}
 	var err error
 	var template map[string]interface{}
 	if app.storage.customEmails.WelcomeEmail.Enabled {
-		template = emailer.welcomeValues(username, expiry, app, noSub, true)
+		template = emailer.welcomeValues(username, expiry, setPasswordLink, app, noSub, true)
 	} else {
-		template = emailer.welcomeValues(username, expiry, app, noSub, false)
+		template = emailer.welcomeValues(username, expiry, setPasswordLink, app, noSub, false)
 	}
 	if noSub {
 		template["yourAccountWillExpire"] = emailer.lang.WelcomeEmail.template("yourAccountWillExpire", tmpl{
@@ -822,54 +838,211 @@ func (emailer *Emailer) constructUserExpired(app *appContext, noSub bool) (*Mess
 	return email, nil
 }
 
+func (emailer *Emailer) expiryReminderValues(username string, expiry time.Time, app *appContext, noSub bool) map[string]interface{} {
+	template := map[string]interface{}{
+		"contactTheAdmin": emailer.lang.ExpiryReminder.get("contactTheAdmin"),
+		"message":         "",
+	}
+	if noSub {
+		empty := []string{"username", "date"}
+		for _, v := range empty {
+			template[v] = "{" + v + "}"
+		}
+	} else {
+		template["username"] = username
+		template["date"] = app.formatDatetime(expiry)
+		template["message"] = app.config.Section("messages").Key("message").String()
+	}
+	template["yourAccountWillExpire"] = emailer.lang.ExpiryReminder.template("yourAccountWillExpire", tmpl{
+		"date": template["date"].(string),
+	})
+	return template
+}
+
+func (emailer *Emailer) constructExpiryReminder(username string, expiry time.Time, app *appContext, noSub bool) (*Message, error) {
+	email := &Message{
+		Subject: app.config.Section("expiry_reminders").Key("subject").MustString(emailer.lang.ExpiryReminder.get("title")),
+	}
+	var err error
+	template := emailer.expiryReminderValues(username, expiry, app, noSub)
+	if app.storage.customEmails.ExpiryReminder.Enabled {
+		content := templateEmail(
+			app.storage.customEmails.ExpiryReminder.Content,
+			app.storage.customEmails.ExpiryReminder.Variables,
+			nil,
+			template,
+		)
+		email, err = emailer.constructTemplate(email.Subject, content, app)
+	} else {
+		email.HTML, email.Text, email.Markdown, err = emailer.construct(app, "expiry_reminders", "email_", template)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+func (emailer *Emailer) deletionNoticeValues(deletion time.Time, app *appContext, noSub bool) map[string]interface{} {
+	template := map[string]interface{}{
+		"contactTheAdmin": emailer.lang.DeletionNotice.get("contactTheAdmin"),
+		"message":         "",
+		"date":            "{date}",
+	}
+	if !noSub {
+		template["date"] = app.formatDatetime(deletion)
+		template["message"] = app.config.Section("messages").Key("message").String()
+	}
+	template["yourAccountWillBeDeleted"] = emailer.lang.DeletionNotice.template("yourAccountWillBeDeleted", tmpl{
+		"date": template["date"].(string),
+	})
+	return template
+}
+
+func (emailer *Emailer) constructDeletionNotice(deletion time.Time, app *appContext, noSub bool) (*Message, error) {
+	email := &Message{
+		Subject: app.config.Section("user_expiry").Key("final_notice_subject").MustString(emailer.lang.DeletionNotice.get("title")),
+	}
+	var err error
+	template := emailer.deletionNoticeValues(deletion, app, noSub)
+	if app.storage.customEmails.DeletionNotice.Enabled {
+		content := templateEmail(
+			app.storage.customEmails.DeletionNotice.Content,
+			app.storage.customEmails.DeletionNotice.Variables,
+			nil,
+			template,
+		)
+		email, err = emailer.constructTemplate(email.Subject, content, app)
+	} else {
+		email.HTML, email.Text, email.Markdown, err = emailer.construct(app, "user_expiry", "final_notice_", template)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+func (emailer *Emailer) inactivityWarningValues(username string, lastActive, deadline time.Time, app *appContext, noSub bool) map[string]interface{} {
+	template := map[string]interface{}{
+		"contactTheAdmin": emailer.lang.InactivityWarning.get("contactTheAdmin"),
+		"message":         "",
+	}
+	if noSub {
+		empty := []string{"username", "lastActive", "date"}
+		for _, v := range empty {
+			template[v] = "{" + v + "}"
+		}
+	} else {
+		template["username"] = username
+		template["lastActive"] = app.formatDatetime(lastActive)
+		template["date"] = app.formatDatetime(deadline)
+		template["message"] = app.config.Section("messages").Key("message").String()
+	}
+	template["yourAccountIsInactive"] = emailer.lang.InactivityWarning.template("yourAccountIsInactive", tmpl{
+		"lastActive": template["lastActive"].(string),
+		"date":       template["date"].(string),
+	})
+	return template
+}
+
+func (emailer *Emailer) constructInactivityWarning(username string, lastActive, deadline time.Time, app *appContext, noSub bool) (*Message, error) {
+	email := &Message{
+		Subject: app.config.Section("inactivity").Key("subject").MustString(emailer.lang.InactivityWarning.get("title")),
+	}
+	var err error
+	template := emailer.inactivityWarningValues(username, lastActive, deadline, app, noSub)
+	if app.storage.customEmails.InactivityWarning.Enabled {
+		content := templateEmail(
+			app.storage.customEmails.InactivityWarning.Content,
+			app.storage.customEmails.InactivityWarning.Variables,
+			nil,
+			template,
+		)
+		email, err = emailer.constructTemplate(email.Subject, content, app)
+	} else {
+		email.HTML, email.Text, email.Markdown, err = emailer.construct(app, "inactivity", "email_", template)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
 // calls the send method in the underlying emailClient.
 func (emailer *Emailer) send(email *Message, address ...string) error {
-	return emailer.sender.Send(emailer.fromName, emailer.fromAddr, email, address...)
+	err := emailer.sender.Send(emailer.fromName, emailer.fromAddr, email, address...)
+	metrics.result(metricEmails, err, "backend", emailBackend(emailer.sender))
+	return err
 }
 
 func (app *appContext) sendByID(email *Message, ID ...string) error {
 	for _, id := range ID {
-		var err error
-		if tgChat, ok := app.storage.telegram[id]; ok && tgChat.Contact && telegramEnabled {
-			err = app.telegram.Send(email, tgChat.ChatID)
-			if err != nil {
-				return err
-			}
-		}
-		if dcChat, ok := app.storage.discord[id]; ok && dcChat.Contact && discordEnabled {
-			err = app.discord.Send(email, dcChat.ChannelID)
-			if err != nil {
-				return err
-			}
-		}
-		if mxChat, ok := app.storage.matrix[id]; ok && mxChat.Contact && matrixEnabled {
-			err = app.matrix.Send(email, mxChat)
-			if err != nil {
-				return err
+		for _, method := range app.contactMethods {
+			if !method.Enabled() || !method.Contact(id) {
+				continue
 			}
-		}
-		if address, ok := app.storage.emails[id]; ok && address.Contact && emailEnabled {
-			err = app.email.send(email, address.Addr)
-			if err != nil {
+			if err := method.SendByID(email, id); err != nil {
 				return err
 			}
 		}
-		if err != nil {
-			return err
-		}
 	}
 	return nil
 }
 
+// getAddressOrName returns the first account the user wants to be contacted through, or else their email address.
 func (app *appContext) getAddressOrName(jfID string) string {
-	if dcChat, ok := app.storage.discord[jfID]; ok && dcChat.Contact && discordEnabled {
-		return dcChat.Username + "#" + dcChat.Discriminator
-	}
-	if tgChat, ok := app.storage.telegram[jfID]; ok && tgChat.Contact && telegramEnabled {
-		return "@" + tgChat.Username
+	for _, method := range app.contactMethods {
+		if method.Enabled() && method.Contact(jfID) {
+			return method.DisplayName(jfID)
+		}
 	}
-	if addr, ok := app.storage.emails[jfID]; ok {
+	if addr, ok := app.storage.GetEmailsKey(jfID); ok {
 		return addr.Addr
 	}
 	return ""
 }
+
+// emailContactMethod sends to the addresses in Storage through whichever Emailer is current, as it's replaced when the config is reloaded.
+type emailContactMethod struct {
+	app *appContext
+}
+
+func (e emailContactMethod) Name() string       { return "Email" }
+func (e emailContactMethod) Enabled() bool      { return emailEnabled }
+func (e emailContactMethod) StorageKey() string { return "emails" }
+
+func (e emailContactMethod) Linked(jfID string) bool {
+	_, ok := e.app.storage.GetEmailsKey(jfID)
+	return ok
+}
+
+func (e emailContactMethod) DisplayName(jfID string) string {
+	addr, _ := e.app.storage.GetEmailsKey(jfID)
+	return addr.Addr
+}
+
+func (e emailContactMethod) Contact(jfID string) bool {
+	addr, ok := e.app.storage.GetEmailsKey(jfID)
+	return ok && addr.Contact
+}
+
+func (e emailContactMethod) SetContact(jfID string, contact bool) {
+	if addr, ok := e.app.storage.GetEmailsKey(jfID); ok {
+		addr.Contact = contact
+		e.app.storage.SetEmailsKey(jfID, addr)
+	}
+}
+
+func (e emailContactMethod) SendByID(msg *Message, jfID string) error {
+	addr, ok := e.app.storage.GetEmailsKey(jfID)
+	if !ok {
+		return nil
+	}
+	return e.app.email.send(msg, addr.Addr)
+}
+
+func (e emailContactMethod) Account(jfID string) contactAccountDTO {
+	addr, _ := e.app.storage.GetEmailsKey(jfID)
+	return contactAccountDTO{Name: addr.Addr, Contact: addr.Contact}
+}
+
+func (e emailContactMethod) Unlink(jfID string) { e.app.storage.DeleteEmailsKey(jfID) }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accountsQueryMatches applies a search the same way as the accounts tab: a user matches if any of the "admin:<true/false>", "disabled:<true/false>",
+// "invite:<code/label>" or "inviter:<name>" words apply to them, or the rest of the query appears in their username or email address. An empty query matches everyone.
+func accountsQueryMatches(user respUser, query string) bool {
+	text := []string{}
+	words := strings.Fields(strings.ToLower(query))
+	for _, word := range words {
+		if !strings.Contains(word, ":") {
+			text = append(text, word)
+			continue
+		}
+		split := strings.SplitN(word, ":", 2)
+		state := split[1] == "true" || split[1] == "yes"
+		switch split[0] {
+		case "admin":
+			if user.Admin == state {
+				return true
+			}
+		case "disabled":
+			if user.Disabled == state {
+				return true
+			}
+		case "invite":
+			if user.Invite != nil && (strings.ToLower(user.Invite.Code) == split[1] || strings.ToLower(user.Invite.Label) == split[1]) {
+				return true
+			}
+		case "inviter":
+			if user.Invite != nil && strings.ToLower(user.Invite.Inviter) == split[1] {
+				return true
+			}
+		}
+	}
+	if len(words) == 0 {
+		return true
+	}
+	search := strings.Join(text, " ")
+	return search != "" && (strings.Contains(strings.ToLower(user.Name), search) || strings.Contains(strings.ToLower(user.ContactMethods["email"].Name), search))
+}
+
+// inviteSources maps usernames to the code of the invite they signed up with, for invites which haven't been deleted.
+// Only needed for users created before invites were recorded for each user, see UserInvite.
+func (app *appContext) inviteSources() map[string]string {
+	sources := map[string]string{}
+	for code, inv := range app.storage.GetInvites() {
+		for _, pair := range inv.UsedBy {
+			sources[pair[0]] = code
+		}
+	}
+	return sources
+}
+
+// exportTime formats times for CSV exports, leaving zero times blank.
+func exportTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).Format(time.RFC3339)
+}
+
+// writeExport sends rows as an attachment in the format given by "?format=", either "json" (the default) or "csv".
+func writeExport(gc *gin.Context, name string, data interface{}, header []string, rows [][]string) {
+	filename := fmt.Sprintf("%s-%s", name, time.Now().Format("2006-01-02"))
+	if gc.Query("format") != "csv" {
+		gc.Header("Content-Disposition", "attachment; filename=\""+filename+".json\"")
+		gc.JSON(200, data)
+		return
+	}
+	gc.Header("Content-Disposition", "attachment; filename=\""+filename+".csv\"")
+	gc.Header("Content-Type", "text/csv; charset=utf-8")
+	gc.Status(200)
+	w := csv.NewWriter(gc.Writer)
+	w.Write(header)
+	w.WriteAll(rows)
+}
+
+// @Summary Exports users with their expiry, activity, contact methods, label and the invite they used, as JSON or CSV. Filtered with the same search as the accounts tab.
+// @Produce json,text/csv
+// @Param format query string false "\"json\" (default) or \"csv\"."
+// @Param q query string false "Search, e.g. \"disabled:true\", \"invite:<code or label>\", \"inviter:<name>\" or part of a username or email address."
+// @Success 200 {object} []exportUserDTO
+// @Failure 500 {object} stringResponse
+// @Router /users/export [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) ExportUsers(gc *gin.Context) {
+	users, status, err := app.getJFUsers()
+	if !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
+		respond(500, "Couldn't get users", gc)
+		return
+	}
+	query := gc.Query("q")
+	sources := app.inviteSources()
+	export := []exportUserDTO{}
+	for _, jfUser := range users {
+		user := app.newRespUser(jfUser)
+		if !accountsQueryMatches(user, query) {
+			continue
+		}
+		dto := exportUserDTO{
+			ID:             user.ID,
+			Name:           user.Name,
+			Label:          user.Label,
+			Admin:          user.Admin,
+			AccountsAdmin:  user.AccountsAdmin,
+			Disabled:       user.Disabled,
+			Expiry:         user.Expiry,
+			LastActive:     user.LastActive,
+			Accounts:       map[string]string{},
+			ContactMethods: []string{},
+			Invite:         sources[user.Name],
+		}
+		if user.Invite != nil {
+			dto.Invite = user.Invite.Code
+			dto.InviteLabel = user.Invite.Label
+			dto.Inviter = user.Invite.Inviter
+		}
+		for method, account := range user.ContactMethods {
+			if account.Name != "" {
+				dto.Accounts[method] = account.Name
+			}
+			if account.Contact {
+				dto.ContactMethods = append(dto.ContactMethods, method)
+			}
+		}
+		sort.Strings(dto.ContactMethods)
+		export = append(export, dto)
+	}
+	sort.Slice(export, func(i, j int) bool { return strings.ToLower(export[i].Name) < strings.ToLower(export[j].Name) })
+	// Each contact method gets a column for the user's account on it.
+	methods := make([]string, len(app.contactMethods))
+	for i, method := range app.contactMethods {
+		methods[i] = contactKey(method)
+	}
+	header := append([]string{"id", "name", "label", "admin", "accounts_admin", "disabled", "expiry", "last_active"}, methods...)
+	header = append(header, "contact_methods", "invite", "invite_label", "inviter")
+	rows := make([][]string, len(export))
+	for i, user := range export {
+		rows[i] = []string{
+			user.ID, user.Name, user.Label,
+			strconv.FormatBool(user.Admin), strconv.FormatBool(user.AccountsAdmin), strconv.FormatBool(user.Disabled),
+			exportTime(user.Expiry), exportTime(user.LastActive),
+		}
+		for _, method := range methods {
+			rows[i] = append(rows[i], user.Accounts[method])
+		}
+		rows[i] = append(rows[i], strings.Join(user.ContactMethods, ";"), user.Invite, user.InviteLabel, user.Inviter)
+	}
+	writeExport(gc, "users", export, header, rows)
+}
+
+// @Summary Exports invites with their uses and who used them, as JSON or CSV.
+// @Produce json,text/csv
+// @Param format query string false "\"json\" (default) or \"csv\"."
+// @Success 200 {object} []exportInviteDTO
+// @Router /invites/export [get]
+// @Security Bearer
+// @tags Invites
+func (app *appContext) ExportInvites(gc *gin.Context) {
+	export := []exportInviteDTO{}
+	for code, inv := range app.storage.GetInvites() {
+		dto := exportInviteDTO{
+			Code:          code,
+			Label:         inv.Label,
+			Profile:       inv.Profile,
+			Created:       inv.Created.Unix(),
+			ValidTill:     inv.ValidTill.Unix(),
+			NoLimit:       inv.NoLimit,
+			RemainingUses: inv.RemainingUses,
+			UsedBy:        make([]inviteUseDTO, len(inv.UsedBy)),
+		}
+		for i, pair := range inv.UsedBy {
+			dto.UsedBy[i] = inviteUseDTO{Username: pair[0], Time: app.parseUsedBy(pair[1]).Unix()}
+		}
+		sort.Slice(dto.UsedBy, func(i, j int) bool { return dto.UsedBy[i].Time < dto.UsedBy[j].Time })
+		export = append(export, dto)
+	}
+	sort.Slice(export, func(i, j int) bool { return export[i].Created < export[j].Created })
+	header := []string{"code", "label", "profile", "created", "valid_till", "unlimited", "remaining_uses", "uses", "used_by"}
+	rows := make([][]string, len(export))
+	for i, inv := range export {
+		usedBy := make([]string, len(inv.UsedBy))
+		for j, use := range inv.UsedBy {
+			usedBy[j] = use.Username + " (" + exportTime(use.Time) + ")"
+		}
+		rows[i] = []string{
+			inv.Code, inv.Label, inv.Profile,
+			exportTime(inv.Created), exportTime(inv.ValidTill),
+			strconv.FormatBool(inv.NoLimit), strconv.Itoa(inv.RemainingUses), strconv.Itoa(len(inv.UsedBy)),
+			strings.Join(usedBy, "; "),
+		}
+	}
+	writeExport(gc, "invites", export, header, rows)
+}
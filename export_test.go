@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// TestAccountsQueryMatches checks exports pick the same users as a search on the accounts tab, which matches any of its terms.
+func TestAccountsQueryMatches(t *testing.T) {
+	alice := respUser{Name: "alice", Admin: true, ContactMethods: map[string]contactAccountDTO{"email": {Name: "alice@example.com"}}}
+	bob := respUser{Name: "bob", Disabled: true, Invite: &userInviteDTO{Code: "abc", Label: "Friends", Inviter: "alice"}}
+	for _, c := range []struct {
+		query      string
+		alice, bob bool
+	}{
+		{"", true, true},
+		{"ALICE", true, false},
+		{"example.com", true, false},
+		{"admin:true", true, false},
+		{"admin:true disabled:true", true, true},
+		{"invite:friends", false, true},
+		{"inviter:alice", false, true},
+		{"bob admin:true", true, true},
+		{"carol admin:false", false, true},
+		{"unknown:true", false, false},
+	} {
+		if got := accountsQueryMatches(alice, c.query); got != c.alice {
+			t.Errorf("%q: expected alice to match %t, got %t", c.query, c.alice, got)
+		}
+		if got := accountsQueryMatches(bob, c.query); got != c.bob {
+			t.Errorf("%q: expected bob to match %t, got %t", c.query, c.bob, got)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"maunium.net/go/mautrix/id"
+)
+
+// importRow is one existing user to import details for, from a CSV row or JSON object. Only User is required.
+type importRow struct {
+	User     string `json:"user"` // Jellyfin username or ID.
+	Email    string `json:"email"`
+	Discord  string `json:"discord"`  // Discord user ID.
+	Telegram string `json:"telegram"` // Telegram username.
+	Matrix   string `json:"matrix"`   // Matrix user ID, e.g. "@user:matrix.org".
+	Label    string `json:"label"`
+	Expiry   string `json:"expiry"` // RFC 3339 time, "2006-01-02 15:04" or "2006-01-02", in local time if no zone is given.
+	Admin    bool   `json:"admin"`  // Make them a jfa-go admin. Existing admins aren't demoted.
+}
+
+// importColumns maps accepted CSV header names to the fields they fill.
+var importColumns = map[string]func(row *importRow, v string) error{
+	"user":     func(row *importRow, v string) error { row.User = v; return nil },
+	"username": func(row *importRow, v string) error { row.User = v; return nil },
+	"id":       func(row *importRow, v string) error { row.User = v; return nil },
+	"email":    func(row *importRow, v string) error { row.Email = v; return nil },
+	"discord":  func(row *importRow, v string) error { row.Discord = v; return nil },
+	"telegram": func(row *importRow, v string) error { row.Telegram = v; return nil },
+	"matrix":   func(row *importRow, v string) error { row.Matrix = v; return nil },
+	"label":    func(row *importRow, v string) error { row.Label = v; return nil },
+	"expiry":   func(row *importRow, v string) error { row.Expiry = v; return nil },
+	"admin": func(row *importRow, v string) (err error) {
+		if v == "" {
+			return nil
+		}
+		switch strings.ToLower(v) {
+		case "yes", "y":
+			row.Admin = true
+		case "no", "n":
+			row.Admin = false
+		default:
+			row.Admin, err = strconv.ParseBool(v)
+		}
+		return
+	},
+}
+
+var importTimeFormats = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+func parseImportTime(v string) (time.Time, error) {
+	for _, format := range importTimeFormats {
+		if t, err := time.ParseInLocation(format, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry \"%s\"", v)
+}
+
+// trimRows strips surrounding whitespace and the byte order mark some spreadsheet software adds, returning whether what's left is a JSON array rather than CSV.
+func trimRows(data []byte) ([]byte, bool) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("\xef\xbb\xbf"))
+	return data, bytes.HasPrefix(data, []byte("["))
+}
+
+// readCSV reads CSV with a header row, returning the lowercased column names and trimmed records. Columns known doesn't accept are an error.
+func readCSV(data []byte, known func(column string) bool) ([]string, [][]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if !known(header[i]) {
+			return nil, nil, fmt.Errorf("unknown column \"%s\"", name)
+		}
+	}
+	records := [][]string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if len(record) > len(header) {
+			record = record[:len(header)]
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		records = append(records, record)
+	}
+	return header, records, nil
+}
+
+// parseImport reads rows from a JSON array, or otherwise CSV with a header row naming the columns.
+func parseImport(data []byte) ([]importRow, error) {
+	data, isJSON := trimRows(data)
+	if isJSON {
+		rows := []importRow{}
+		err := json.Unmarshal(data, &rows)
+		return rows, err
+	}
+	header, records, err := readCSV(data, func(column string) bool {
+		_, ok := importColumns[column]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]importRow, 0, len(records))
+	for n, record := range records {
+		row := importRow{}
+		for i, v := range record {
+			if err := importColumns[header[i]](&row, v); err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s \"%s\"", n+1, header[i], v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importedUser is a checked row, ready to be stored.
+type importedUser struct {
+	user   mediabrowser.User
+	data   importRow
+	expiry time.Time
+}
+
+// checkImport matches rows to Jellyfin users and checks them against stored details and each other, without changing anything.
+// Unless overwrite is set, rows which would replace a user's existing details are conflicts, and rows linking a contact method already linked to another user always are.
+func (app *appContext) checkImport(rows []importRow, overwrite bool) ([]importedUser, importUsersDTO, error) {
+	resp := importUsersDTO{
+		Imported:  []importedUserDTO{},
+		Unmatched: []importIssueDTO{},
+		Conflicts: []importIssueDTO{},
+		Invalid:   []importIssueDTO{},
+	}
+	jfUsers, status, err := app.getJFUsers()
+	if err != nil || status != 200 {
+		return nil, resp, fmt.Errorf("failed to get users (%d): %v", status, err)
+	}
+	byID := map[string]mediabrowser.User{}
+	byName := map[string]mediabrowser.User{}
+	for _, user := range jfUsers {
+		byID[user.ID] = user
+		byName[strings.ToLower(user.Name)] = user
+	}
+	// Owners of each contact method, stored or claimed by an earlier row.
+	owners := map[string]map[string]string{"email": {}, "discord": {}, "telegram": {}, "matrix": {}}
+	for id, email := range app.storage.GetEmails() {
+		if email.Addr != "" {
+			owners["email"][strings.ToLower(email.Addr)] = id
+		}
+	}
+	for id, user := range app.storage.GetDiscord() {
+		owners["discord"][user.ID] = id
+	}
+	for id, user := range app.storage.GetTelegram() {
+		owners["telegram"][strings.ToLower(user.Username)] = id
+	}
+	for id, user := range app.storage.GetMatrix() {
+		owners["matrix"][user.UserID] = id
+	}
+	seen := map[string]int{}
+	users := []importedUser{}
+	for i, row := range rows {
+		n := i + 1
+		row.Telegram = strings.TrimPrefix(row.Telegram, "@")
+		issue := importIssueDTO{Row: n, User: row.User}
+		if row.User == "" {
+			issue.Reason = "No user given"
+			resp.Invalid = append(resp.Invalid, issue)
+			continue
+		}
+		imported := importedUser{data: row}
+		if row.Expiry != "" {
+			if imported.expiry, err = parseImportTime(row.Expiry); err != nil {
+				issue.Reason = err.Error()
+				resp.Invalid = append(resp.Invalid, issue)
+				continue
+			}
+		}
+		if row.Matrix != "" && !(strings.HasPrefix(row.Matrix, "@") && strings.Contains(row.Matrix, ":")) {
+			issue.Reason = fmt.Sprintf("invalid Matrix user ID \"%s\"", row.Matrix)
+			resp.Invalid = append(resp.Invalid, issue)
+			continue
+		}
+		user, ok := byID[row.User]
+		if !ok {
+			user, ok = byName[strings.ToLower(row.User)]
+		}
+		if !ok {
+			issue.Reason = "No such Jellyfin user"
+			resp.Unmatched = append(resp.Unmatched, issue)
+			continue
+		}
+		imported.user = user
+		reasons := []string{}
+		if prev, ok := seen[user.ID]; ok {
+			reasons = append(reasons, fmt.Sprintf("same user as row %d", prev))
+		}
+		emailStore, _ := app.storage.GetEmailsKey(user.ID)
+		discordUser, hasDiscord := app.storage.GetDiscordKey(user.ID)
+		telegramUser, hasTelegram := app.storage.GetTelegramKey(user.ID)
+		matrixUser, hasMatrix := app.storage.GetMatrixKey(user.ID)
+		expiry, hasExpiry := app.storage.GetUsersKey(user.ID)
+		for _, field := range []struct {
+			name, key, value, existing string
+			has                        bool
+		}{
+			{"email", "email", row.Email, emailStore.Addr, emailStore.Addr != ""},
+			{"Discord account", "discord", row.Discord, discordUser.ID, hasDiscord},
+			{"Telegram account", "telegram", row.Telegram, telegramUser.Username, hasTelegram},
+			{"Matrix account", "matrix", row.Matrix, matrixUser.UserID, hasMatrix},
+		} {
+			if field.value == "" {
+				continue
+			}
+			key := field.value
+			if field.key == "email" || field.key == "telegram" {
+				key = strings.ToLower(key)
+			}
+			if owner, ok := owners[field.key][key]; ok && owner != user.ID {
+				name := owner
+				if u, ok := byID[owner]; ok {
+					name = u.Name
+				}
+				reasons = append(reasons, fmt.Sprintf("%s \"%s\" belongs to \"%s\"", field.name, field.value, name))
+			} else if field.has && !strings.EqualFold(field.existing, field.value) && !overwrite {
+				reasons = append(reasons, fmt.Sprintf("already has %s \"%s\"", field.name, field.existing))
+			}
+		}
+		if row.Label != "" && emailStore.Label != "" && emailStore.Label != row.Label && !overwrite {
+			reasons = append(reasons, fmt.Sprintf("already has label \"%s\"", emailStore.Label))
+		}
+		if !imported.expiry.IsZero() && hasExpiry && !expiry.Equal(imported.expiry) && !overwrite {
+			reasons = append(reasons, fmt.Sprintf("already expires %s", app.formatDatetime(expiry)))
+		}
+		if len(reasons) != 0 {
+			issue.Reason = strings.Join(reasons, "; ")
+			resp.Conflicts = append(resp.Conflicts, issue)
+			continue
+		}
+		seen[user.ID] = n
+		for key, value := range map[string]string{"email": strings.ToLower(row.Email), "discord": row.Discord, "telegram": strings.ToLower(row.Telegram), "matrix": row.Matrix} {
+			if value != "" {
+				owners[key][value] = user.ID
+			}
+		}
+		users = append(users, imported)
+		resp.Imported = append(resp.Imported, importedUserDTO{Row: n, ID: user.ID, Name: user.Name})
+	}
+	return users, resp, nil
+}
+
+// importUsers checks the rows with checkImport, and unless dryRun is set, stores the details of those without problems.
+// Discord and Matrix accounts are set up straight away if their bots are running, otherwise when they're first messaged.
+// Telegram accounts can only be messaged once the user has started a chat with the bot, so they're linked when they do.
+func (app *appContext) importUsers(rows []importRow, dryRun, overwrite bool) (importUsersDTO, error) {
+	users, resp, err := app.checkImport(rows, overwrite)
+	resp.DryRun = dryRun
+	if err != nil || dryRun || len(users) == 0 {
+		return resp, err
+	}
+	changed := map[string]bool{}
+	for _, imported := range users {
+		id, row := imported.user.ID, imported.data
+		emailStore, ok := app.storage.GetEmailsKey(id)
+		if row.Email != "" {
+			emailStore.Addr = row.Email
+			emailStore.Contact = true
+		}
+		if row.Label != "" {
+			emailStore.Label = row.Label
+		}
+		if row.Admin {
+			emailStore.Admin = true
+		}
+		if ok || row.Email != "" || row.Label != "" || row.Admin {
+			app.storage.SetEmailsKey(id, emailStore)
+			changed["emails"] = true
+		}
+		if dcUser, ok := app.storage.GetDiscordKey(id); row.Discord != "" && !(ok && dcUser.ID == row.Discord) {
+			app.storage.SetDiscordKey(id, app.importDiscordUser(row.Discord))
+			changed["discord_users"] = true
+		}
+		if tgUser, ok := app.storage.GetTelegramKey(id); row.Telegram != "" && !(ok && strings.EqualFold(tgUser.Username, row.Telegram)) {
+			app.storage.SetTelegramKey(id, TelegramUser{Username: row.Telegram, Contact: true})
+			changed["telegram_users"] = true
+		}
+		if mxUser, ok := app.storage.GetMatrixKey(id); row.Matrix != "" && !(ok && mxUser.UserID == row.Matrix) {
+			app.storage.SetMatrixKey(id, app.importMatrixUser(row.Matrix))
+			changed["matrix_users"] = true
+		}
+		if !imported.expiry.IsZero() {
+			app.storage.SetUsersKey(id, imported.expiry)
+			changed["users"] = true
+		}
+	}
+	changedStores := make([]string, 0, len(changed))
+	for key := range changed {
+		changedStores = append(changedStores, key)
+	}
+	if len(changedStores) != 0 {
+		if err := app.storage.storeTogether(changedStores...); err != nil {
+			return resp, fmt.Errorf("failed to store imported details: %v", err)
+		}
+	}
+	app.info.Printf("Imported details for %d user(s)", len(users))
+	return resp, nil
+}
+
+func (app *appContext) importDiscordUser(discordID string) DiscordUser {
+	if discordEnabled && app.discord != nil {
+		if user, ok := app.discord.NewUser(discordID); ok {
+			return user
+		}
+	}
+	return DiscordUser{ID: discordID, Username: discordID, Contact: true}
+}
+
+func (app *appContext) importMatrixUser(userID string) MatrixUser {
+	user := MatrixUser{UserID: userID, Lang: "en-us", Contact: true}
+	if matrixEnabled && app.matrix != nil {
+		roomID, encrypted, err := app.matrix.CreateRoom(userID)
+		if err != nil {
+			app.err.Printf("Matrix: Failed to create room for \"%s\": %v", userID, err)
+			return user
+		}
+		user.RoomID, user.Encrypted = string(roomID), encrypted
+		app.matrix.isEncrypted[id.RoomID(user.RoomID)] = encrypted
+	}
+	return user
+}
+
+// @Summary Imports contact details, labels, expiries and admin status for existing Jellyfin users, from CSV with a header row or a JSON array of rows. Rows which don't match a user, clash with stored details or other rows, or have invalid values are reported and not imported.
+// @Accept json,text/csv
+// @Produce json
+// @Param rows body []importRow true "Rows to import. CSV columns are the same as the JSON fields."
+// @Param dry_run query bool false "Only report what would be imported."
+// @Param overwrite query bool false "Replace existing details instead of treating them as conflicts."
+// @Success 200 {object} importUsersDTO
+// @Failure 400 {object} stringResponse
+// @Failure 403 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /users/import [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) ImportUsers(gc *gin.Context) {
+	data, err := ioutil.ReadAll(gc.Request.Body)
+	if err != nil {
+		respond(400, "Couldn't read request", gc)
+		return
+	}
+	rows, err := parseImport(data)
+	if err != nil {
+		respond(400, err.Error(), gc)
+		return
+	}
+	// Admins have full access, so only full admins can make them.
+	if !app.requestFullAdmin(gc) {
+		for _, row := range rows {
+			if row.Admin {
+				respond(403, "Only full admins can import admins", gc)
+				return
+			}
+		}
+	}
+	dryRun := gc.Query("dry_run") == "true"
+	resp, err := app.importUsers(rows, dryRun, gc.Query("overwrite") == "true")
+	if err != nil {
+		app.err.Printf("Failed to import users: %v", err)
+		respond(500, "Import failed", gc)
+		return
+	}
+	if !dryRun && len(resp.Imported) != 0 {
+		app.adminActivity(gc, activityUsersImported, resp.importedIDs(), fmt.Sprintf("%d user(s)", len(resp.Imported)))
+	}
+	gc.JSON(200, resp)
+}
+
+func (resp importUsersDTO) importedIDs() []string {
+	ids := make([]string, len(resp.Imported))
+	for i, user := range resp.Imported {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+// importCLI runs "jfa-go import [-dry-run] [-overwrite] <file>", printing the report.
+func importCLI(app *appContext) {
+	path := ""
+	dryRun, overwrite := false, false
+	started := false
+	for _, arg := range os.Args[1:] {
+		if !started {
+			started = arg == "import"
+			continue
+		}
+		switch strings.TrimLeft(arg, "-") {
+		case "dry-run":
+			dryRun = true
+		case "overwrite":
+			overwrite = true
+		default:
+			path = arg
+		}
+	}
+	if path == "" {
+		fmt.Println("Usage: jfa-go import [-dry-run] [-overwrite] <file.csv|file.json>")
+		os.Exit(1)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Failed to read \"%s\": %v\n", path, err)
+		os.Exit(1)
+	}
+	rows, err := parseImport(data)
+	if err != nil {
+		fmt.Printf("Failed to parse \"%s\": %v\n", path, err)
+		os.Exit(1)
+	}
+	resp, err := app.importUsers(rows, dryRun, overwrite)
+	if err != nil {
+		fmt.Printf("Import failed: %v\n", err)
+		os.Exit(1)
+	}
+	verb := "Imported"
+	if dryRun {
+		verb = "Would import"
+	} else if len(resp.Imported) != 0 {
+		app.daemonActivity("cli", activityUsersImported, resp.importedIDs(), fmt.Sprintf("%d user(s)", len(resp.Imported)))
+	}
+	fmt.Printf("%s %d of %d row(s)\n", verb, len(resp.Imported), len(rows))
+	for _, list := range []struct {
+		name   string
+		issues []importIssueDTO
+	}{{"Unmatched", resp.Unmatched}, {"Conflicting", resp.Conflicts}, {"Invalid", resp.Invalid}} {
+		if len(list.issues) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s row(s):\n", list.name)
+		for _, issue := range list.issues {
+			fmt.Printf("  %d (%s): %s\n", issue.Row, issue.User, issue.Reason)
+		}
+	}
+}
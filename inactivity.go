@@ -0,0 +1,280 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+)
+
+// Steps of the inactivity policy, in the order they're taken.
+const (
+	inactivityWarn    = "warn"
+	inactivityDisable = "disable"
+	inactivityDelete  = "delete"
+)
+
+// InactiveUser tracks a user's progress through the inactivity policy.
+type InactiveUser struct {
+	// Inactivity is counted from here if it's later than the user's last activity,
+	// i.e. when first seen if they've never been active, or when re-enabled by an admin.
+	Since    time.Time `json:"since"`
+	Warned   bool      `json:"warned"`
+	Disabled bool      `json:"disabled"` // Whether the policy disabled them, as opposed to an admin or expiry.
+}
+
+// inactivityPolicy is the "inactivity" config section. Steps with a zero duration are skipped.
+type inactivityPolicy struct {
+	warn, disable, delete time.Duration
+	exemptAdmins          bool
+	exemptLabels          map[string]bool
+	exemptProfiles        map[string]bool
+}
+
+func (app *appContext) loadInactivityPolicy() inactivityPolicy {
+	section := app.config.Section("inactivity")
+	days := func(key string) time.Duration {
+		return time.Duration(section.Key(key).MustInt(0)) * 24 * time.Hour
+	}
+	return inactivityPolicy{
+		warn:           days("warn_after_days"),
+		disable:        days("disable_after_days"),
+		delete:         days("delete_after_days"),
+		exemptAdmins:   section.Key("exempt_admins").MustBool(true),
+		exemptLabels:   configSet(section, "exempt_labels"),
+		exemptProfiles: configSet(section, "exempt_profiles"),
+	}
+}
+
+// inactivityExempt returns whether the user is excluded from the policy by being an admin, or their label or profile.
+func (app *appContext) inactivityExempt(policy inactivityPolicy, user mediabrowser.User) bool {
+	emailStore, _ := app.storage.GetEmailsKey(user.ID)
+	if policy.exemptAdmins && (user.Policy.IsAdministrator || emailStore.Admin) {
+		return true
+	}
+	return policy.exemptLabels[emailStore.Label] || policy.exemptProfiles[emailStore.Profile]
+}
+
+// since returns when the user's inactivity is counted from, which is zero if they've never been active and haven't been seen before.
+func (policy inactivityPolicy) since(user mediabrowser.User, record InactiveUser) time.Time {
+	since := user.LastActivityDate.Time
+	if record.Since.After(since) {
+		since = record.Since
+	}
+	return since
+}
+
+// step returns the step due for a user who's been inactive for the given time, or "" if there isn't one.
+func (policy inactivityPolicy) step(inactive time.Duration, record InactiveUser) string {
+	switch {
+	case policy.delete != 0 && inactive >= policy.delete:
+		return inactivityDelete
+	case policy.disable != 0 && inactive >= policy.disable:
+		if !record.Disabled {
+			return inactivityDisable
+		}
+	case policy.warn != 0 && inactive >= policy.warn:
+		if !record.Warned {
+			return inactivityWarn
+		}
+	}
+	return ""
+}
+
+// deadline returns when the step after a warning will be taken, for the warning message.
+func (policy inactivityPolicy) deadline(since time.Time) time.Time {
+	if policy.disable != 0 {
+		return since.Add(policy.disable)
+	}
+	return since.Add(policy.delete)
+}
+
+// checkInactivity warns, disables and deletes users who haven't been active for as long as the "inactivity" section says.
+// Run by the user daemon after checkUsers.
+func (app *appContext) checkInactivity() {
+	if !app.config.Section("inactivity").Key("enabled").MustBool(false) {
+		return
+	}
+	policy := app.loadInactivityPolicy()
+	users, status, err := app.getJFUsers()
+	if err != nil || status != 200 {
+		app.err.Printf("Failed to get users (%d): %s", status, err)
+		return
+	}
+	app.info.Println("Daemon: Checking for inactive users")
+	now := time.Now()
+	records := app.storage.GetInactiveUsers()
+	seen := map[string]bool{}
+	for _, user := range users {
+		seen[user.ID] = true
+		record := records[user.ID]
+		if app.inactivityExempt(policy, user) {
+			app.storage.DeleteInactiveUsersKey(user.ID)
+			continue
+		}
+		if record.Disabled && !user.Policy.IsDisabled {
+			// Re-enabled by an admin, so they get a fresh start.
+			record = InactiveUser{Since: now}
+		} else if user.Policy.IsDisabled && !record.Disabled {
+			continue
+		}
+		since := policy.since(user, record)
+		if since.IsZero() {
+			record.Since = now
+			since = now
+		}
+		step := policy.step(now.Sub(since), record)
+		switch step {
+		case inactivityWarn:
+			app.warnInactiveUser(user, since, policy.deadline(since))
+			record.Warned = true
+		case inactivityDisable:
+			if !app.disableInactiveUser(user) {
+				continue
+			}
+			record.Disabled = true
+		case inactivityDelete:
+			if app.deleteInactiveUser(user) {
+				app.storage.DeleteInactiveUsersKey(user.ID)
+			}
+			continue
+		}
+		if record.Warned && policy.warn != 0 && now.Sub(since) < policy.warn {
+			// Active again since being warned.
+			record.Warned = false
+		}
+		// Nothing worth keeping once they've been active since the record started.
+		if !record.Warned && !record.Disabled && !record.Since.After(user.LastActivityDate.Time) {
+			app.storage.DeleteInactiveUsersKey(user.ID)
+		} else {
+			app.storage.SetInactiveUsersKey(user.ID, record)
+		}
+	}
+	for id := range records {
+		if !seen[id] {
+			app.storage.DeleteInactiveUsersKey(id)
+		}
+	}
+	if err := app.storage.storeInactiveUsers(); err != nil {
+		app.err.Printf("Failed to store inactive users: %v", err)
+	}
+}
+
+func (app *appContext) warnInactiveUser(user mediabrowser.User, since, deadline time.Time) {
+	if !messagesEnabled {
+		return
+	}
+	name := app.getAddressOrName(user.ID)
+	msg, err := app.email.constructInactivityWarning(user.Name, since, deadline, app, false)
+	if err != nil {
+		app.err.Printf("Failed to construct inactivity warning for \"%s\": %v", user.Name, err)
+		metrics.inc(metricInactivityProcessed, "action", inactivityWarn, "result", metricResultFailure)
+		return
+	} else if err := app.sendByID(msg, user.ID); err != nil {
+		app.err.Printf("Failed to send inactivity warning to \"%s\": %v", name, err)
+		metrics.inc(metricInactivityProcessed, "action", inactivityWarn, "result", metricResultFailure)
+		return
+	}
+	app.info.Printf("Sent inactivity warning to \"%s\"", name)
+	metrics.inc(metricInactivityProcessed, "action", inactivityWarn, "result", metricResultSuccess)
+	app.daemonActivity("inactivity", activityUserInactive, []string{user.ID}, inactivityWarn)
+}
+
+func (app *appContext) disableInactiveUser(user mediabrowser.User) bool {
+	app.info.Printf("Disabling inactive user \"%s\"", user.Name)
+	user.Policy.IsDisabled = true
+	// Admins can't be disabled
+	user.Policy.IsAdministrator = false
+	status, err := app.jf.SetPolicy(user.ID, user.Policy)
+	if !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to disable \"%s\" (%d): %v", user.Name, status, err)
+		metrics.inc(metricInactivityProcessed, "action", inactivityDisable, "result", metricResultFailure)
+		return false
+	}
+	metrics.inc(metricInactivityProcessed, "action", inactivityDisable, "result", metricResultSuccess)
+	app.expireJFCache()
+	app.triggerWebhook(webhookUserDisabled, map[string]interface{}{
+		"id":       user.ID,
+		"username": user.Name,
+		"reason":   "inactive",
+	})
+	app.daemonActivity("inactivity", activityUserInactive, []string{user.ID}, inactivityDisable)
+	return true
+}
+
+func (app *appContext) deleteInactiveUser(user mediabrowser.User) bool {
+	app.info.Printf("Deleting inactive user \"%s\"", user.Name)
+	status, err := app.jf.DeleteUser(user.ID)
+	if !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to delete \"%s\" (%d): %v", user.Name, status, err)
+		metrics.inc(metricInactivityProcessed, "action", inactivityDelete, "result", metricResultFailure)
+		return false
+	}
+	metrics.inc(metricInactivityProcessed, "action", inactivityDelete, "result", metricResultSuccess)
+	app.expireJFCache()
+	app.storage.DeleteUsersKey(user.ID)
+	if err := app.storage.storeUsers(); err != nil {
+		app.err.Printf("Failed to store user expiries: %v", err)
+	}
+	app.triggerWebhook(webhookUserDeleted, map[string]interface{}{
+		"id":     user.ID,
+		"reason": "inactive",
+	})
+	app.daemonActivity("inactivity", activityUserInactive, []string{user.ID}, inactivityDelete)
+	if err := app.unlinkContactMethods(user.ID); err != nil {
+		app.err.Printf("Failed to remove contact methods of \"%s\": %v", user.Name, err)
+	}
+	return true
+}
+
+// @Summary Lists the users the inactivity policy would warn, disable or delete if it ran now, whether or not it's enabled.
+// @Produce json
+// @Success 200 {object} inactivityPreviewDTO
+// @Failure 500 {object} stringResponse
+// @Router /users/inactivity [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetInactivityPreview(gc *gin.Context) {
+	policy := app.loadInactivityPolicy()
+	users, status, err := app.getJFUsers()
+	if err != nil || status != 200 {
+		app.err.Printf("Failed to get users (%d): %v", status, err)
+		respond(500, "Couldn't get users", gc)
+		return
+	}
+	now := time.Now()
+	records := app.storage.GetInactiveUsers()
+	resp := inactivityPreviewDTO{
+		Enabled: app.config.Section("inactivity").Key("enabled").MustBool(false),
+		Users:   []inactiveUserDTO{},
+	}
+	for _, user := range users {
+		record := records[user.ID]
+		if app.inactivityExempt(policy, user) || (user.Policy.IsDisabled && !record.Disabled) {
+			continue
+		}
+		since := policy.since(user, record)
+		if since.IsZero() {
+			// Never active, so counted from the next run.
+			continue
+		}
+		step := policy.step(now.Sub(since), record)
+		if step == "" {
+			continue
+		}
+		dto := inactiveUserDTO{
+			ID:       user.ID,
+			Name:     user.Name,
+			Since:    since.Unix(),
+			Inactive: int(now.Sub(since).Hours() / 24),
+			Action:   step,
+		}
+		if !user.LastActivityDate.IsZero() {
+			dto.LastActive = user.LastActivityDate.Unix()
+		}
+		resp.Users = append(resp.Users, dto)
+	}
+	sort.Slice(resp.Users, func(i, j int) bool { return resp.Users[i].Since < resp.Users[j].Since })
+	gc.JSON(200, resp)
+}
@@ -101,6 +101,9 @@ type emailLang struct {
 	WelcomeEmail      langSection `json:"welcomeEmail"`
 	EmailConfirmation langSection `json:"emailConfirmation"`
 	UserExpired       langSection `json:"userExpired"`
+	ExpiryReminder    langSection `json:"expiryReminder"`
+	DeletionNotice    langSection `json:"deletionNotice"`
+	InactivityWarning langSection `json:"inactivityWarning"`
 }
 
 type setupLangs map[string]setupLang
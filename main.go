@@ -17,6 +17,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -40,6 +41,7 @@ var (
 	DEBUG              *bool
 	PPROF              *bool
 	TEST               bool
+	IMPORT             bool
 	SWAGGER            *bool
 	QUIT               = false
 	RUNNING            = false
@@ -71,6 +73,7 @@ type User struct {
 	UserID   string `json:"id"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Subject  string `json:"-"` // OpenID Connect subject, for admins who logged in through the provider.
 }
 
 // contains (almost) everything the application needs, essentially. This was a dumb design decision imo.
@@ -85,10 +88,16 @@ type appContext struct {
 	cssClass       string // Default theme, "light"|"dark".
 	jellyfinLogin  bool
 	users          []User
-	invalidTokens  []string
+	usersLock      sync.RWMutex // Guards users, which logins and refreshes add to. See findUser and addUser.
 	// Keeping jf name because I can't think of a better one
 	jf               *mediabrowser.MediaBrowser
+	jfCacheLock      sync.Mutex // mediabrowser doesn't guard its user cache, see getJFUsers.
+	referralLock     sync.Mutex // Held while checking and using a referral quota, see CreateMyReferral.
 	authJf           *mediabrowser.MediaBrowser
+	oidc             *OIDCProvider // nil unless OpenID Connect login is enabled.
+	limiter          rateLimiter
+	renewalLock      sync.Mutex // Held while redeeming a renewal code, so single-use codes can't be used twice.
+	totpLock         sync.Mutex // Held while checking a 2FA code, so codes can't be replayed and recovery codes used twice by simultaneous requests.
 	ombi             *ombi.Ombi
 	datePattern      string
 	timePattern      string
@@ -98,6 +107,7 @@ type appContext struct {
 	telegram         *TelegramDaemon
 	discord          *DiscordDaemon
 	matrix           *MatrixDaemon
+	contactMethods   []ContactMethod // Registered with registerContactMethod, see contact.go.
 	info, debug, err *logger.Logger
 	host             string
 	port             int
@@ -133,7 +143,7 @@ func test(app *appContext) {
 	for n, v := range settings {
 		fmt.Println(n, ":", v)
 	}
-	users, status, err := app.jf.GetUsers(false)
+	users, status, err := app.getJFUsers()
 	fmt.Printf("GetUsers: code %d err %s maplength %d\n", status, err, len(users))
 	fmt.Printf("View output? [y/n]: ")
 	var choice string
@@ -145,7 +155,7 @@ func test(app *appContext) {
 	fmt.Printf("Enter a user to grab: ")
 	var username string
 	fmt.Scanln(&username)
-	user, status, err := app.jf.UserByName(username, false)
+	user, status, err := app.getJFUserByName(username)
 	fmt.Printf("UserByName (%s): code %d err %s", username, status, err)
 	out, _ := json.MarshalIndent(user, "", "  ")
 	fmt.Print(string(out))
@@ -215,6 +225,12 @@ func start(asDaemon, firstCall bool) {
 		tempConfig.SaveTo(app.configPath)
 	}
 
+	unlock, err := lockDataDir(app.dataPath)
+	if err != nil {
+		app.err.Fatalf("Failed to lock data directory \"%s\": %v", app.dataPath, err)
+	}
+	defer unlock()
+
 	var debugMode bool
 	var address string
 	if err := app.loadConfig(); err != nil {
@@ -287,7 +303,6 @@ func start(asDaemon, firstCall bool) {
 	app.storage.lang.TelegramPath = "telegram"
 	app.storage.lang.PasswordResetPath = "pwreset"
 	externalLang := app.config.Section("files").Key("lang_files").MustString("")
-	var err error
 	if externalLang == "" {
 		err = app.storage.loadLang(langFS)
 	} else {
@@ -329,6 +344,16 @@ func start(asDaemon, firstCall bool) {
 
 		app.debug.Println("Loading storage")
 
+		app.storage.backend, err = app.openStorageBackend()
+		if err != nil {
+			app.err.Fatalf("Failed to open storage: %v", err)
+		}
+		defer app.storage.backend.Close()
+		app.debug.Printf("Using %s storage backend", app.storage.backend.Name())
+		if err := app.storage.loadCustomEmails(); err != nil {
+			app.err.Printf("Failed to load custom emails: %v", err)
+		}
+
 		app.storage.invite_path = app.config.Section("files").Key("invites").String()
 		if err := app.storage.loadInvites(); err != nil {
 			app.err.Printf("Failed to load Invites: %v", err)
@@ -373,6 +398,45 @@ func start(asDaemon, firstCall bool) {
 		if err := app.storage.loadAnnouncements(); err != nil {
 			app.err.Printf("Failed to load announcement templates: %v", err)
 		}
+		if err := app.storage.loadReferrers(); err != nil {
+			app.err.Printf("Failed to load referrers: %v", err)
+		}
+		if err := app.storage.loadUserInvites(); err != nil {
+			app.err.Printf("Failed to load invite records: %v", err)
+		}
+		if err := app.storage.loadScheduledActions(); err != nil {
+			app.err.Printf("Failed to load scheduled actions: %v", err)
+		}
+		if err := app.storage.loadInactiveUsers(); err != nil {
+			app.err.Printf("Failed to load inactivity records: %v", err)
+		}
+		if err := app.storage.loadExpiryReminders(); err != nil {
+			app.err.Printf("Failed to load sent expiry reminders: %v", err)
+		}
+		if err := app.storage.loadExpiredUsers(); err != nil {
+			app.err.Printf("Failed to load expired users: %v", err)
+		}
+		if err := app.storage.loadRenewalCodes(); err != nil {
+			app.err.Printf("Failed to load renewal codes: %v", err)
+		}
+		if err := app.storage.loadSessions(); err != nil {
+			app.err.Printf("Failed to load login sessions: %v", err)
+		}
+		if err := app.storage.loadTOTP(); err != nil {
+			app.err.Printf("Failed to load two-factor authentication secrets: %v", err)
+		}
+		if err := app.storage.loadRoles(); err != nil {
+			app.err.Printf("Failed to load roles: %v", err)
+		}
+		if err := app.storage.loadAPIKeys(); err != nil {
+			app.err.Printf("Failed to load API keys: %v", err)
+		}
+		if err := app.storage.loadActivity(); err != nil {
+			app.err.Printf("Failed to load activity log: %v", err)
+		}
+		if err := app.storage.loadWebhookDeliveries(); err != nil {
+			app.err.Printf("Failed to load webhook deliveries: %v", err)
+		}
 
 		app.storage.profiles_path = app.config.Section("files").Key("user_profiles").String()
 		app.storage.loadProfiles()
@@ -394,11 +458,12 @@ func start(asDaemon, firstCall bool) {
 		configBase, _ := fs.ReadFile(localFS, app.configBasePath)
 		json.Unmarshal(configBase, &app.configBase)
 
-		secret, err := generateSecret(16)
+		secret, err := app.loadSecret()
 		if err != nil {
 			app.err.Fatal(err)
 		}
 		os.Setenv("JFA_SECRET", secret)
+		app.pruneSessions()
 
 		// Initialize jellyfin/emby connection
 		server := app.config.Section("jellyfin").Key("server").String()
@@ -414,6 +479,10 @@ func start(asDaemon, firstCall bool) {
 			app.info.Println("Using Jellyfin server type")
 		}
 
+		if app.config.Section("metrics").Key("enabled").MustBool(false) {
+			instrumentJellyfin(server)
+		}
+
 		app.jf, err = mediabrowser.NewServer(
 			serverType,
 			server,
@@ -447,7 +516,7 @@ func start(asDaemon, firstCall bool) {
 			user.UserID = shortuuid.New()
 			user.Username = app.config.Section("ui").Key("username").String()
 			user.Password = app.config.Section("ui").Key("password").String()
-			app.users = append(app.users, user)
+			app.addUser(user)
 		} else {
 			app.debug.Println("Using Jellyfin for authentication")
 			app.authJf, _ = mediabrowser.NewServer(serverType, server, "jfa-go", app.version, "auth", "auth", timeoutHandler, cacheTimeout)
@@ -455,6 +524,15 @@ func start(asDaemon, firstCall bool) {
 				app.authJf.Verbose = true
 			}
 		}
+		if app.config.Section("oidc").Key("enabled").MustBool(false) {
+			// Without it, anyone with an account at the provider would be an admin.
+			if app.config.Section("oidc").Key("admin_value").String() == "" {
+				app.err.Println("OIDC: Not enabled, as no admin value is set")
+			} else {
+				app.debug.Println("Using OpenID Connect for authentication")
+				app.oidc = newOIDCProvider(app)
+			}
+		}
 
 		// Since email depends on language, the email reload in loadConfig won't work first time.
 		app.email = NewEmailer(app)
@@ -481,6 +559,12 @@ func start(asDaemon, firstCall bool) {
 			os.Exit(0)
 		}
 
+		// Import mode for importing existing users' details, accessed with 'jfa-go import <file>'
+		if IMPORT {
+			importCLI(app)
+			os.Exit(0)
+		}
+
 		invDaemon := newInviteDaemon(time.Duration(60*time.Second), app)
 		go invDaemon.run()
 		defer invDaemon.Shutdown()
@@ -489,6 +573,22 @@ func start(asDaemon, firstCall bool) {
 		go userDaemon.run()
 		defer userDaemon.shutdown()
 
+		scheduleDaemon := newScheduleDaemon(time.Duration(60*time.Second), app)
+		go scheduleDaemon.run()
+		defer scheduleDaemon.shutdown()
+
+		if app.config.Section("backups").Key("enabled").MustBool(false) {
+			backupDaemon := newBackupDaemon(time.Duration(app.config.Section("backups").Key("every_n_minutes").MustInt(1440))*time.Minute, app)
+			go backupDaemon.run()
+			defer backupDaemon.shutdown()
+		}
+
+		if app.config.Section("webhooks").Key("enabled").MustBool(false) {
+			webhookDaemon := newWebhookDaemon(webhookRetryInterval, app)
+			go webhookDaemon.run()
+			defer webhookDaemon.shutdown()
+		}
+
 		if app.config.Section("password_resets").Key("enabled").MustBool(false) && serverType == mediabrowser.JellyfinServer {
 			go app.StartPWR()
 		}
@@ -503,6 +603,7 @@ func start(asDaemon, firstCall bool) {
 				app.err.Printf("Failed to authenticate with Telegram: %v", err)
 				telegramEnabled = false
 			} else {
+				app.registerContactMethod(app.telegram)
 				go app.telegram.run()
 				defer app.telegram.Shutdown()
 			}
@@ -513,6 +614,7 @@ func start(asDaemon, firstCall bool) {
 				app.err.Printf("Failed to authenticate with Discord: %v", err)
 				discordEnabled = false
 			} else {
+				app.registerContactMethod(app.discord)
 				go app.discord.run()
 				defer app.discord.Shutdown()
 			}
@@ -523,10 +625,12 @@ func start(asDaemon, firstCall bool) {
 				app.err.Printf("Failed to initialize Matrix daemon: %v", err)
 				matrixEnabled = false
 			} else {
+				app.registerContactMethod(app.matrix)
 				go app.matrix.run()
 				defer app.matrix.Shutdown()
 			}
 		}
+		app.registerContactMethod(emailContactMethod{app})
 	} else {
 		debugMode = false
 		if *PORT != app.port && *PORT > 0 {
@@ -678,6 +782,9 @@ func main() {
 	if flagPassed("test") {
 		TEST = true
 	}
+	if flagPassed("import") {
+		IMPORT = true
+	}
 	loadFilesystems()
 	if flagPassed("start") {
 		args := []string{}
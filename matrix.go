@@ -83,7 +83,7 @@ func newMatrixDaemon(app *appContext) (d *MatrixDaemon, err error) {
 	// 	return
 	// }
 	// d.bot.Store.SaveFilterID(d.userID, resp.FilterID)
-	for _, user := range app.storage.matrix {
+	for _, user := range app.storage.GetMatrix() {
 		if user.Lang != "" {
 			d.languages[id.RoomID(user.RoomID)] = user.Lang
 		}
@@ -176,9 +176,9 @@ func (d *MatrixDaemon) commandLang(evt *event.Event, code, lang string) {
 		return
 	}
 	d.languages[evt.RoomID] = code
-	if u, ok := d.app.storage.matrix[string(evt.RoomID)]; ok {
+	if u, ok := d.app.storage.GetMatrixKey(string(evt.RoomID)); ok {
 		u.Lang = code
-		d.app.storage.matrix[string(evt.RoomID)] = u
+		d.app.storage.SetMatrixKey(string(evt.RoomID), u)
 		if err := d.app.storage.storeMatrixUsers(); err != nil {
 			d.app.err.Printf("Matrix: Failed to store Matrix users: %v", err)
 		}
@@ -234,6 +234,22 @@ func (d *MatrixDaemon) SendStart(userID string) (ok bool) {
 	return
 }
 
+// checkPIN marks the PIN sent by SendStart as verified if it was sent to the given user.
+func (d *MatrixDaemon) checkPIN(userID, pin string) bool {
+	user, ok := d.tokens[pin]
+	if !ok {
+		d.app.debug.Println("Matrix: PIN not found")
+		return false
+	}
+	if user.User.UserID != userID {
+		d.app.debug.Println("Matrix: User ID of PIN didn't match")
+		return false
+	}
+	user.Verified = true
+	d.tokens[pin] = user
+	return true
+}
+
 func (d *MatrixDaemon) sendToRoom(content *event.MessageEventContent, roomID id.RoomID) (err error) {
 	if encrypted, ok := d.isEncrypted[roomID]; ok && encrypted {
 		err = SendEncrypted(d, content, roomID)
@@ -264,6 +280,7 @@ func (d *MatrixDaemon) Send(message *Message, users ...MatrixUser) (err error) {
 	}
 	for _, user := range users {
 		err = d.sendToRoom(content, id.RoomID(user.RoomID))
+		metrics.result(metricMessages, err, "daemon", "matrix")
 		if err != nil {
 			return
 		}
@@ -274,3 +291,85 @@ func (d *MatrixDaemon) Send(message *Message, users ...MatrixUser) (err error) {
 // User enters ID on sign-up, a PIN is sent to them. They enter it on sign-up.
 
 // Message the user first, to avoid E2EE by default
+
+// ContactMethod implementation, registered in main once the daemon is running.
+
+func (d *MatrixDaemon) Name() string       { return "Matrix" }
+func (d *MatrixDaemon) Enabled() bool      { return matrixEnabled }
+func (d *MatrixDaemon) StorageKey() string { return "matrix_users" }
+
+func (d *MatrixDaemon) Linked(jfID string) bool {
+	_, ok := d.app.storage.GetMatrixKey(jfID)
+	return ok
+}
+
+func (d *MatrixDaemon) DisplayName(jfID string) string {
+	user, _ := d.app.storage.GetMatrixKey(jfID)
+	return user.UserID
+}
+
+func (d *MatrixDaemon) Contact(jfID string) bool {
+	user, ok := d.app.storage.GetMatrixKey(jfID)
+	return ok && user.Contact
+}
+
+func (d *MatrixDaemon) SetContact(jfID string, contact bool) {
+	if user, ok := d.app.storage.GetMatrixKey(jfID); ok {
+		user.Contact = contact
+		d.app.storage.SetMatrixKey(jfID, user)
+	}
+}
+
+func (d *MatrixDaemon) SendByID(msg *Message, jfID string) error {
+	user, ok := d.app.storage.GetMatrixKey(jfID)
+	if !ok {
+		return nil
+	}
+	// Imported users don't have a room until they're first messaged.
+	if user.RoomID == "" {
+		roomID, encrypted, err := d.CreateRoom(user.UserID)
+		if err != nil {
+			return err
+		}
+		user.RoomID, user.Encrypted = string(roomID), encrypted
+		d.isEncrypted[roomID] = encrypted
+		d.app.storage.SetMatrixKey(jfID, user)
+		if err := d.app.storage.storeMatrixUsers(); err != nil {
+			d.app.err.Printf("Failed to store Matrix users: %v", err)
+		}
+	}
+	return d.Send(msg, user)
+}
+
+func (d *MatrixDaemon) Account(jfID string) contactAccountDTO {
+	mxUser, _ := d.app.storage.GetMatrixKey(jfID)
+	return contactAccountDTO{Name: mxUser.UserID, Contact: mxUser.Contact}
+}
+
+func (d *MatrixDaemon) Unlink(jfID string) { d.app.storage.DeleteMatrixKey(jfID) }
+
+func (d *MatrixDaemon) Required() bool {
+	return d.app.config.Section("matrix").Key("required").MustBool(false)
+}
+
+func (d *MatrixDaemon) Verified(pin string) bool {
+	user, ok := d.tokens[pin]
+	return ok && user.Verified
+}
+
+func (d *MatrixDaemon) Issued(pin string) bool {
+	_, ok := d.tokens[pin]
+	return ok
+}
+
+func (d *MatrixDaemon) Link(jfID, pin string, contact bool) error {
+	user, ok := d.tokens[pin]
+	if !ok || !user.Verified {
+		return fmt.Errorf("PIN not verified")
+	}
+	mxUser := *user.User
+	mxUser.Contact = contact
+	d.app.storage.SetMatrixKey(jfID, mxUser)
+	delete(d.tokens, pin)
+	return nil
+}
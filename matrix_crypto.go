@@ -88,7 +88,7 @@ func InitMatrixCrypto(d *MatrixDaemon) (err error) {
 	if !d.Encryption {
 		return
 	}
-	for _, user := range d.app.storage.matrix {
+	for _, user := range d.app.storage.GetMatrix() {
 		d.isEncrypted[id.RoomID(user.RoomID)] = user.Encrypted
 	}
 	dbPath := d.app.config.Section("files").Key("matrix_sql").String()
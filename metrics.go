@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metric names, as exported on /metrics.
+const (
+	metricInvitesActive       = "jfa_go_invites_active"
+	metricInviteUses          = "jfa_go_invite_uses_total"
+	metricUsersWithExpiry     = "jfa_go_users_with_expiry"
+	metricExpiriesProcessed   = "jfa_go_expiries_processed_total"
+	metricEmails              = "jfa_go_emails_total"
+	metricMessages            = "jfa_go_messages_total"
+	metricJellyfinDuration    = "jfa_go_jellyfin_request_duration_seconds"
+	metricJellyfinErrors      = "jfa_go_jellyfin_request_errors_total"
+	metricLogins              = "jfa_go_logins_total"
+	metricRateLimited         = "jfa_go_rate_limited_total"
+	metricInactivityProcessed = "jfa_go_inactivity_processed_total"
+)
+
+const (
+	metricsContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	metricResultSuccess = "success"
+	metricResultFailure = "failure"
+)
+
+var metricsHelp = map[string]string{
+	metricInvitesActive:       "Number of invites that haven't expired or been used up.",
+	metricInviteUses:          "Number of accounts created through invites.",
+	metricUsersWithExpiry:     "Number of users with an expiry set.",
+	metricExpiriesProcessed:   "Number of expired users disabled or deleted by the user daemon.",
+	metricEmails:              "Number of emails sent, by backend and result.",
+	metricMessages:            "Number of messages sent, by bot daemon and result.",
+	metricJellyfinDuration:    "Latency of requests to Jellyfin.",
+	metricJellyfinErrors:      "Number of requests to Jellyfin which failed or returned an error status.",
+	metricLogins:              "Number of admin logins through /token/login, by result.",
+	metricRateLimited:         "Number of requests rejected by the rate limiter, by bucket and reason.",
+	metricInactivityProcessed: "Number of inactive users warned, disabled or deleted by the user daemon.",
+}
+
+// Upper bounds of the Jellyfin latency histogram, in seconds.
+var jellyfinLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // Cumulative, one for each bucket.
+	sum     float64
+	count   uint64
+}
+
+// metricsRegistry keeps counters and histograms for /metrics. Gauges are read from storage when scraped.
+type metricsRegistry struct {
+	lock       sync.Mutex
+	counters   map[string]map[string]float64 // metric name -> rendered labels -> value.
+	histograms map[string]map[string]*histogram
+}
+
+// metrics is global so the email and Jellyfin clients, which don't have access to appContext, can record to it.
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// renderLabels formats pairs of label names and values, e.g. ("result", "success") becomes {result="success"}.
+func renderLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// inc adds one to a counter. labels are pairs of label names and values.
+func (m *metricsRegistry) inc(name string, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]float64{}
+	}
+	m.counters[name][renderLabels(labels...)]++
+}
+
+// result increments a counter with a "result" label of success or failure depending on err.
+func (m *metricsRegistry) result(name string, err error, labels ...string) {
+	result := metricResultSuccess
+	if err != nil {
+		result = metricResultFailure
+	}
+	m.inc(name, append(labels, "result", result)...)
+}
+
+func (m *metricsRegistry) observe(name string, buckets []float64, value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.histograms[name] == nil {
+		m.histograms[name] = map[string]*histogram{}
+	}
+	key := renderLabels(labels...)
+	h, ok := m.histograms[name][key]
+	if !ok {
+		h = &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		m.histograms[name][key] = h
+	}
+	for i, bound := range buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type gauge struct {
+	name  string
+	value float64
+}
+
+// write outputs every metric in the Prometheus text format, along with the given gauges.
+func (m *metricsRegistry) write(w io.Writer, gauges []gauge) {
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", g.name, metricsHelp[g.name], g.name, g.name, g.value)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, name := range sortedCounters(m.counters) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, metricsHelp[name], name)
+		series := make([]string, 0, len(m.counters[name]))
+		for labels := range m.counters[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			fmt.Fprintf(w, "%s%s %v\n", name, labels, m.counters[name][labels])
+		}
+	}
+	names := make([]string, 0, len(m.histograms))
+	for name := range m.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, metricsHelp[name], name)
+		series := make([]string, 0, len(m.histograms[name]))
+		for labels := range m.histograms[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			h := m.histograms[name][labels]
+			// Labels are rendered as {...}, so "le" is added inside the braces.
+			withLE := func(le string) string {
+				if labels == "" {
+					return renderLabels("le", le)
+				}
+				return labels[:len(labels)-1] + "," + renderLabels("le", le)[1:]
+			}
+			for i, bound := range h.buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLE(fmt.Sprintf("%v", bound)), h.counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLE("+Inf"), h.count)
+			fmt.Fprintf(w, "%s_sum%s %v\n", name, labels, h.sum)
+			fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+		}
+	}
+}
+
+func sortedCounters(counters map[string]map[string]float64) []string {
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jellyfinMetricsTransport records the latency and errors of requests to Jellyfin.
+// The mediabrowser client uses http.DefaultTransport, so this wraps it and lets other requests through untouched.
+type jellyfinMetricsTransport struct {
+	host string
+	next http.RoundTripper
+}
+
+func (t *jellyfinMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	metrics.observe(metricJellyfinDuration, jellyfinLatencyBuckets, time.Since(start).Seconds(), "method", req.Method)
+	if err != nil || resp.StatusCode >= 400 {
+		metrics.inc(metricJellyfinErrors, "method", req.Method)
+	}
+	return resp, err
+}
+
+// instrumentJellyfin starts recording requests to the given Jellyfin server.
+func instrumentJellyfin(server string) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return
+	}
+	// Avoid wrapping twice when restarting.
+	if t, ok := http.DefaultTransport.(*jellyfinMetricsTransport); ok {
+		t.host = u.Host
+		return
+	}
+	http.DefaultTransport = &jellyfinMetricsTransport{host: u.Host, next: http.DefaultTransport}
+}
+
+// emailBackend returns the name of the EmailClient in use, for labelling metrics.
+func emailBackend(client EmailClient) string {
+	switch client.(type) {
+	case *SMTP:
+		return "smtp"
+	case *Mailgun:
+		return "mailgun"
+	}
+	return "none"
+}
+
+// @Summary Returns metrics in the Prometheus text format. Requires the token set in Settings > Metrics.
+// @Produce plain
+// @Success 200 {string} string
+// @Failure 401 {object} stringResponse
+// @Router /metrics [get]
+// @Security Bearer
+// @tags Metrics
+func (app *appContext) GetMetrics(gc *gin.Context) {
+	token := app.config.Section("metrics").Key("token").String()
+	header := strings.SplitN(gc.Request.Header.Get("Authorization"), " ", 2)
+	if token == "" || len(header) != 2 || header[0] != "Bearer" || subtle.ConstantTimeCompare([]byte(header[1]), []byte(token)) != 1 {
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	activeInvites := 0
+	now := time.Now()
+	for _, invite := range app.storage.GetInvites() {
+		if invite.ValidTill.After(now) && (invite.NoLimit || invite.RemainingUses > 0) {
+			activeInvites++
+		}
+	}
+	gc.Header("Content-Type", metricsContentType)
+	gc.Status(200)
+	metrics.write(gc.Writer, []gauge{
+		{metricInvitesActive, float64(activeInvites)},
+		{metricUsersWithExpiry, float64(len(app.storage.GetUsers()))},
+	})
+}
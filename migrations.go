@@ -86,7 +86,7 @@ func migrateEmailStorage(app *appContext) error {
 		return nil
 	}
 	var emails map[string]interface{}
-	err := loadJSON(app.storage.emails_path, &emails)
+	err := app.storage.load("emails", &emails)
 	if err != nil {
 		return err
 	}
@@ -117,7 +117,10 @@ func migrateEmailStorage(app *appContext) error {
 	if err != nil {
 		return err
 	}
-	err = storeJSON(app.storage.emails_path, newEmails)
+	app.storage.emailsLock.Lock()
+	app.storage.emails = newEmails
+	app.storage.emailsLock.Unlock()
+	err = app.storage.storeEmails()
 	if err != nil {
 		return err
 	}
@@ -131,7 +134,7 @@ func migrateNotificationMethods(app *appContext) error {
 		return nil
 	}
 	changes := false
-	for code, invite := range app.storage.invites {
+	for code, invite := range app.storage.GetInvites() {
 		if invite.Notify == nil {
 			continue
 		}
@@ -139,7 +142,7 @@ func migrateNotificationMethods(app *appContext) error {
 			if !strings.Contains(address, "@") {
 				continue
 			}
-			for id, email := range app.storage.emails {
+			for id, email := range app.storage.GetEmails() {
 				if email.Addr == address {
 					invite.Notify[id] = notifyPrefs
 					delete(invite.Notify, address)
@@ -149,7 +152,7 @@ func migrateNotificationMethods(app *appContext) error {
 			}
 		}
 		if changes {
-			app.storage.invites[code] = invite
+			app.storage.SetInvitesKey(code, invite)
 		}
 	}
 	if changes {
@@ -168,10 +171,10 @@ func linkExistingOmbiDiscordTelegram(app *appContext) error {
 		return nil
 	}
 	idList := map[string][2]string{}
-	for jfID, user := range app.storage.discord {
+	for jfID, user := range app.storage.GetDiscord() {
 		idList[jfID] = [2]string{user.ID, ""}
 	}
-	for jfID, user := range app.storage.telegram {
+	for jfID, user := range app.storage.GetTelegram() {
 		vals, ok := idList[jfID]
 		if !ok {
 			vals = [2]string{"", ""}
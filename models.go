@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type stringResponse struct {
 	Response string `json:"response" example:"message"`
@@ -13,18 +16,14 @@ type boolResponse struct {
 }
 
 type newUserDTO struct {
-	Username        string `json:"username" example:"jeff" binding:"required"`  // User's username
-	Password        string `json:"password" example:"guest" binding:"required"` // User's password
-	Email           string `json:"email" example:"jeff@jellyf.in"`              // User's email address
-	Code            string `json:"code" example:"abc0933jncjkcjj"`              // Invite code (required on /newUser)
-	TelegramPIN     string `json:"telegram_pin" example:"A1-B2-3C"`             // Telegram verification PIN (if used)
-	TelegramContact bool   `json:"telegram_contact"`                            // Whether or not to use telegram for notifications/pwrs
-	DiscordPIN      string `json:"discord_pin" example:"A1-B2-3C"`              // Discord verification PIN (if used)
-	DiscordContact  bool   `json:"discord_contact"`                             // Whether or not to use discord for notifications/pwrs
-	MatrixPIN       string `json:"matrix_pin" example:"A1-B2-3C"`               // Matrix verification PIN (if used)
-	MatrixContact   bool   `json:"matrix_contact"`                              // Whether or not to use matrix for notifications/pwrs
-	CaptchaID       string `json:"captcha_id"`                                  // Captcha ID (if enabled)
-	CaptchaText     string `json:"captcha_text"`                                // Captcha text (if enabled)
+	Username    string `json:"username" example:"jeff" binding:"required"`  // User's username
+	Password    string `json:"password" example:"guest" binding:"required"` // User's password
+	Email       string `json:"email" example:"jeff@jellyf.in"`              // User's email address
+	Code        string `json:"code" example:"abc0933jncjkcjj"`              // Invite code (required on /newUser)
+	CaptchaID   string `json:"captcha_id"`                                  // Captcha ID (if enabled)
+	CaptchaText string `json:"captcha_text"`                                // Captcha text (if enabled)
+	// Verification PINs and contact preferences by contact method, from "<method>_pin" and "<method>_contact" fields, e.g. "telegram_pin". See UnmarshalJSON in contact.go.
+	ContactMethods map[string]contactChoice `json:"-"`
 }
 
 type newUserResponse struct {
@@ -33,6 +32,19 @@ type newUserResponse struct {
 	Error string `json:"error"`                   // Optional error message.
 }
 
+type newUsersResultDTO struct {
+	Username string `json:"username"`
+	ID       string `json:"id,omitempty"`
+	Created  bool   `json:"created"`
+	Sent     bool   `json:"sent"`           // Whether the welcome email was sent.
+	Link     string `json:"link,omitempty"` // Set-password link, given if the user has a generated password and the welcome email couldn't be sent.
+	Error    string `json:"error,omitempty"`
+}
+
+type newUsersRespDTO struct {
+	Users []newUsersResultDTO `json:"users"` // In the same order as the rows given.
+}
+
 type deleteUserDTO struct {
 	Users  []string `json:"users" binding:"required"` // List of usernames to delete
 	Notify bool     `json:"notify"`                   // Whether to notify users of deletion
@@ -132,23 +144,35 @@ type deleteInviteDTO struct {
 }
 
 type respUser struct {
-	ID                    string `json:"id" example:"fdgsdfg45534fa"`              // userID of user
-	Name                  string `json:"name" example:"jeff"`                      // Username of user
-	Email                 string `json:"email,omitempty" example:"jeff@jellyf.in"` // Email address of user (if available)
-	NotifyThroughEmail    bool   `json:"notify_email"`
-	LastActive            int64  `json:"last_active" example:"1617737207510"` // Time of last activity on Jellyfin
-	Admin                 bool   `json:"admin" example:"false"`               // Whether or not the user is Administrator
-	Expiry                int64  `json:"expiry" example:"1617737207510"`      // Expiry time of user as Epoch/Unix time.
-	Disabled              bool   `json:"disabled"`                            // Whether or not the user is disabled.
-	Telegram              string `json:"telegram"`                            // Telegram username (if known)
-	NotifyThroughTelegram bool   `json:"notify_telegram"`
-	Discord               string `json:"discord"`    // Discord username (if known)
-	DiscordID             string `json:"discord_id"` // Discord user ID for creating links.
-	NotifyThroughDiscord  bool   `json:"notify_discord"`
-	Matrix                string `json:"matrix"` // Matrix ID (if known)
-	NotifyThroughMatrix   bool   `json:"notify_matrix"`
-	Label                 string `json:"label"`          // Label of user, shown next to their name.
-	AccountsAdmin         bool   `json:"accounts_admin"` // Whether or not the user is a jfa-go admin.
+	ID            string         `json:"id" example:"fdgsdfg45534fa"`         // userID of user
+	Name          string         `json:"name" example:"jeff"`                 // Username of user
+	LastActive    int64          `json:"last_active" example:"1617737207510"` // Time of last activity on Jellyfin
+	Admin         bool           `json:"admin" example:"false"`               // Whether or not the user is Administrator
+	Expiry        int64          `json:"expiry" example:"1617737207510"`      // Expiry time of user as Epoch/Unix time.
+	Disabled      bool           `json:"disabled"`                            // Whether or not the user is disabled.
+	Label         string         `json:"label"`                               // Label of user, shown next to their name.
+	AccountsAdmin bool           `json:"accounts_admin"`                      // Whether or not the user is a jfa-go admin.
+	Roles         []string       `json:"roles,omitempty"`                     // Roles limiting what the user can do as an admin.
+	TOTP          bool           `json:"totp"`                                // Whether or not the user has 2FA enabled.
+	Invite        *userInviteDTO `json:"invite,omitempty"`                    // Invite the user signed up with, if known.
+	// Accounts by contact method, sent as "<method>", "notify_<method>" and "<method>_id" fields, e.g. "telegram" and "notify_telegram". See MarshalJSON in contact.go.
+	ContactMethods map[string]contactAccountDTO `json:"-"`
+}
+
+// contactAccountDTO is a user's account on a contact method.
+type contactAccountDTO struct {
+	Name    string // Username or address, empty if not linked.
+	ID      string // Platform user ID, if needed for links, e.g. on Discord.
+	Contact bool   // Whether the user is messaged through the method.
+}
+
+type userInviteDTO struct {
+	Code     string `json:"code"`
+	Label    string `json:"label,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	Inviter  string `json:"inviter,omitempty"`  // Name of the admin, API key or user which created the invite.
+	Referrer string `json:"referrer,omitempty"` // Jellyfin ID of the user who created the invite, if it was a referral.
+	Created  int64  `json:"created"`            // When the user was created.
 }
 
 type getUsersDTO struct {
@@ -278,11 +302,9 @@ type telegramSetDTO struct {
 }
 
 type SetContactMethodsDTO struct {
-	ID       string `json:"id"`
-	Email    bool   `json:"email"`
-	Discord  bool   `json:"discord"`
-	Telegram bool   `json:"telegram"`
-	Matrix   bool   `json:"matrix"`
+	ID string `json:"id"`
+	// Whether to contact the user by contact method, from a field named after each, e.g. "telegram": true. See UnmarshalJSON in contact.go.
+	Contact map[string]bool `json:"-"`
 }
 
 type DiscordUserDTO struct {
@@ -350,8 +372,298 @@ type LogDTO struct {
 	Log string `json:"log"`
 }
 
+type BackupDTO struct {
+	Name string `json:"name"` // File name, used to download or restore.
+	Date int64  `json:"date"` // Unix timestamp of when the backup was made.
+	Size int64  `json:"size"` // Size in bytes.
+}
+
+type getBackupsDTO struct {
+	Backups []BackupDTO `json:"backups"`
+}
+
+type WebhookDeliveryDTO struct {
+	ID          string `json:"id"`
+	Event       string `json:"event" example:"user_created"`
+	URL         string `json:"url"`
+	Payload     string `json:"payload"` // JSON body, as sent.
+	Created     int64  `json:"created"`
+	Attempts    int    `json:"attempts"`
+	LastAttempt int64  `json:"last_attempt"`
+	NextAttempt int64  `json:"next_attempt"` // 0 if delivered or given up on.
+	Status      int    `json:"status"`       // HTTP status of the last attempt, 0 if the request failed.
+	Error       string `json:"error,omitempty"`
+	Delivered   bool   `json:"delivered"`
+}
+
+type getWebhookDeliveriesDTO struct {
+	Deliveries []WebhookDeliveryDTO `json:"deliveries"`
+}
+
+type ActivityDTO struct {
+	ID        string   `json:"id"`
+	Action    string   `json:"action" example:"users_deleted"`
+	ActorType string   `json:"actor_type" example:"admin"` // admin, api_key, daemon or user.
+	ActorID   string   `json:"actor_id"`
+	ActorName string   `json:"actor_name"`
+	Targets   []string `json:"targets"` // IDs of the users, invite codes or settings acted on.
+	Details   string   `json:"details,omitempty"`
+	Time      int64    `json:"time"`
+}
+
+type activityLogDTO struct {
+	Activity []ActivityDTO `json:"activity"`
+	Total    int           `json:"total"` // Number of entries matching the filter.
+	Page     int           `json:"page"`
+	Pages    int           `json:"pages"`
+}
+
+type APIKeyDTO struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes" example:"invites:write,users:read"`
+	Created   int64    `json:"created"`
+	CreatedBy string   `json:"created_by"`
+	Expiry    int64    `json:"expiry"`    // 0 if the key doesn't expire.
+	LastUsed  int64    `json:"last_used"` // 0 if never used.
+}
+
+type getAPIKeysDTO struct {
+	Keys []APIKeyDTO `json:"keys"`
+}
+
+type newAPIKeyDTO struct {
+	Name   string   `json:"name" example:"Invite bot"`
+	Scopes []string `json:"scopes" example:"invites:write,users:read"` // "<resource>:<read|write|*>", or "*" for everything. Resources are users, invites, profiles, config, backups and logs.
+	Expiry int64    `json:"expiry"`                                    // Unix timestamp, or 0 for no expiry.
+}
+
+type createdAPIKeyDTO struct {
+	ID  string `json:"id"`
+	Key string `json:"key"` // Give as "Authorization: Bearer <key>". Not shown again.
+}
+
+type RenewalCodeDTO struct {
+	Code      string   `json:"code"`
+	Label     string   `json:"label"`
+	UserID    string   `json:"user_id"` // Jellyfin ID of the user the code is for.
+	Months    int      `json:"months"`
+	Days      int      `json:"days"`
+	Hours     int      `json:"hours"`
+	Minutes   int      `json:"minutes"`
+	MaxUses   int      `json:"max_uses"` // Times the user can redeem the code, 0 for unlimited.
+	UsedBy    []string `json:"used_by"`  // The user's Jellyfin ID, once for each time they've redeemed the code.
+	Valid     bool     `json:"valid"`    // False once the code has expired or run out of uses.
+	Created   int64    `json:"created"`
+	CreatedBy string   `json:"created_by"`
+	ValidTill int64    `json:"valid_till"` // 0 if the code doesn't expire.
+}
+
+type getRenewalCodesDTO struct {
+	Codes []RenewalCodeDTO `json:"codes"`
+}
+
+type newRenewalCodeDTO struct {
+	UserID    string `json:"user_id" example:"ZXhhbXBsZQ"` // Jellyfin ID of the user who can redeem the code.
+	Label     string `json:"label" example:"Supporters"`
+	Months    int    `json:"months" example:"1"`
+	Days      int    `json:"days" example:"0"`
+	Hours     int    `json:"hours" example:"0"`
+	Minutes   int    `json:"minutes" example:"0"`
+	MaxUses   int    `json:"max_uses" example:"1"` // Times the user can redeem the code, 0 for unlimited.
+	ValidTill int64  `json:"valid_till"`           // Unix timestamp after which the code can't be redeemed, or 0 for never.
+}
+
+type inactiveUserDTO struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	LastActive int64  `json:"last_active"` // 0 if the user has never been active.
+	Since      int64  `json:"since"`       // When inactivity is counted from.
+	Inactive   int    `json:"inactive_days"`
+	Action     string `json:"action"` // "warn", "disable" or "delete".
+}
+
+type inactivityPreviewDTO struct {
+	Enabled bool              `json:"enabled"` // Whether the policy is actually being applied.
+	Users   []inactiveUserDTO `json:"users"`
+}
+
+type scheduleActionDTO struct {
+	Action  string          `json:"action" example:"enable_disable"` // "enable_disable", "delete", "extend" or "announce".
+	RunAt   int64           `json:"run_at"`                          // Unix timestamp to run the action at.
+	Request json.RawMessage `json:"request" swaggertype:"object"`    // Body for the action's route, e.g. an enableDisableUserDTO.
+}
+
+type ScheduledActionDTO struct {
+	ID        string          `json:"id"`
+	Action    string          `json:"action"`
+	Request   json.RawMessage `json:"request" swaggertype:"object"`
+	RunAt     int64           `json:"run_at"`
+	Created   int64           `json:"created"`
+	CreatedBy string          `json:"created_by"`
+}
+
+type getScheduledActionsDTO struct {
+	Actions []ScheduledActionDTO `json:"actions"`
+}
+
+type importIssueDTO struct {
+	Row    int    `json:"row"` // Starting from 1, not counting the CSV header.
+	User   string `json:"user"`
+	Reason string `json:"reason"`
+}
+
+type importedUserDTO struct {
+	Row  int    `json:"row"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type importUsersDTO struct {
+	DryRun    bool              `json:"dry_run"`
+	Imported  []importedUserDTO `json:"imported"`  // Rows which were imported, or would be if not a dry run.
+	Unmatched []importIssueDTO  `json:"unmatched"` // Rows which don't match a Jellyfin user.
+	Conflicts []importIssueDTO  `json:"conflicts"` // Rows which clash with stored details or another row.
+	Invalid   []importIssueDTO  `json:"invalid"`   // Rows with missing or invalid values.
+}
+
+type exportUserDTO struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Label          string            `json:"label"`
+	Admin          bool              `json:"admin"`          // Jellyfin administrator.
+	AccountsAdmin  bool              `json:"accounts_admin"` // jfa-go admin.
+	Disabled       bool              `json:"disabled"`
+	Expiry         int64             `json:"expiry"`          // 0 if the user doesn't expire.
+	LastActive     int64             `json:"last_active"`     // 0 if the user has never been active.
+	Accounts       map[string]string `json:"accounts"`        // Linked accounts by contact method, e.g. "email": "jeff@jellyf.in".
+	ContactMethods []string          `json:"contact_methods"` // Methods the user is contacted through.
+	Invite         string            `json:"invite"`          // Code of the invite the user signed up with, if known.
+	InviteLabel    string            `json:"invite_label"`
+	Inviter        string            `json:"inviter"` // Name of the admin or API key which created the invite.
+}
+
+type inviteUseDTO struct {
+	Username string `json:"username"`
+	Time     int64  `json:"time"`
+}
+
+type exportInviteDTO struct {
+	Code          string         `json:"code"`
+	Label         string         `json:"label"`
+	Profile       string         `json:"profile"`
+	Created       int64          `json:"created"`
+	ValidTill     int64          `json:"valid_till"`
+	NoLimit       bool           `json:"no_limit"`
+	RemainingUses int            `json:"remaining_uses"`
+	UsedBy        []inviteUseDTO `json:"used_by"` // Oldest first.
+}
+
 type setAccountsAdminDTO map[string]bool
 
+type getRolesDTO struct {
+	Roles       []Role   `json:"roles"`
+	Permissions []string `json:"permissions"` // Every permission a role can have.
+}
+
+type SessionDTO struct {
+	ID        string `json:"id"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Created   int64  `json:"created"`
+	LastUsed  int64  `json:"last_used"`
+	Expiry    int64  `json:"expiry"`
+	Current   bool   `json:"current"` // Whether this is the session making the request.
+}
+
+type getSessionsDTO struct {
+	Sessions []SessionDTO `json:"sessions"`
+}
+
+type BanDTO struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"` // "ip" or "user".
+	Value    string `json:"value"`
+	Until    int64  `json:"until"`
+	Lockouts int    `json:"lockouts"` // Number of lockouts since the last was lifted or expired a day ago.
+}
+
+type getBansDTO struct {
+	Bans []BanDTO `json:"bans"`
+}
+
+type deleteBansDTO struct {
+	Bans []string `json:"bans"` // IDs of bans to lift, or empty for all.
+}
+
+type totpLoginDTO struct {
+	Challenge string `json:"challenge"`             // Challenge returned by /token/login.
+	Code      string `json:"code" example:"123456"` // TOTP or recovery code.
+}
+
+type totpCodeDTO struct {
+	Code string `json:"code" example:"123456"`
+}
+
+type totpStatusDTO struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recovery_codes"` // Number of unused recovery codes.
+}
+
+type totpEnrollDTO struct {
+	Secret string `json:"secret"` // Base32 secret, for entering manually.
+	URL    string `json:"url" example:"otpauth://totp/jfa-go:admin?issuer=jfa-go&secret=..."`
+	QR     string `json:"qr"` // QR code of URL, as a PNG data URI.
+}
+
+type totpRecoveryCodesDTO struct {
+	RecoveryCodes []string `json:"recovery_codes"` // Each can be used once in place of a TOTP code. Not shown again.
+}
+
+type setUserRolesDTO map[string][]string // Map of Jellyfin user IDs to role names. An empty list removes all roles.
+
 type genCaptchaDTO struct {
 	ID string `json:"id"`
 }
+
+type myContactMethodDTO struct {
+	Method  string `json:"method"` // "email", "telegram", "discord" or "matrix".
+	Linked  bool   `json:"linked"`
+	Value   string `json:"value"` // How the linked account is shown, e.g. an address or "@username".
+	Contact bool   `json:"contact"`
+}
+
+type myDetailsDTO struct {
+	ID             string               `json:"id"`
+	Username       string               `json:"username"`
+	Expiry         int64                `json:"expiry"` // 0 if the account doesn't expire.
+	Email          string               `json:"email"`
+	ContactMethods []myContactMethodDTO `json:"contact_methods"`
+}
+
+type myReferralInviteDTO struct {
+	Code          string `json:"code"`
+	ValidTill     int64  `json:"valid_till"`
+	RemainingUses int    `json:"remaining_uses"`
+	Used          int    `json:"used"` // Number of accounts created with it.
+}
+
+type myReferralsDTO struct {
+	Allowed     bool                  `json:"allowed"`      // Whether the user can create referral invites. Nothing else is given if not.
+	Remaining   int                   `json:"remaining"`    // Invites the user can still create, or -1 for no limit.
+	NextAllowed int64                 `json:"next_allowed"` // When the user can create their next invite, or 0 if they can now.
+	Invites     []myReferralInviteDTO `json:"invites"`      // Invites which can still be used.
+}
+
+type myEmailDTO struct {
+	Email string `json:"email"`
+}
+
+type myLinkDTO struct {
+	PIN string `json:"pin"`
+}
+
+type myPasswordDTO struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+const (
+	// Time given to log in at the provider before the state is forgotten.
+	oidcLoginLength = 10 * time.Minute
+	oidcTimeout     = 10 * time.Second
+	// Prefix of the account key (see totpAccount) of admins who logged in through the provider, followed by their subject.
+	oidcOwnerPrefix = "oidc:"
+)
+
+var oidcClient = &http.Client{Timeout: oidcTimeout}
+
+// oidcDiscovery holds the parts of the provider's /.well-known/openid-configuration we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcPendingLogin struct {
+	nonce, verifier string
+	expiry          time.Time
+}
+
+// OIDCProvider implements the OpenID Connect authorization code flow (with PKCE) for admin login.
+// Discovery and signing keys are fetched when first needed, so an unreachable provider doesn't stop jfa-go starting.
+type OIDCProvider struct {
+	issuer, clientID, clientSecret, redirectURL string
+	scopes                                      []string
+	adminClaim, adminValue, usernameClaim       string
+	lock                                        sync.Mutex
+	discovery                                   *oidcDiscovery
+	keys                                        map[string]interface{}      // Key ID -> *rsa.PublicKey or *ecdsa.PublicKey.
+	pending                                     map[string]oidcPendingLogin // State -> login.
+}
+
+func newOIDCProvider(app *appContext) *OIDCProvider {
+	section := app.config.Section("oidc")
+	return &OIDCProvider{
+		issuer:        strings.TrimSuffix(section.Key("issuer").String(), "/"),
+		clientID:      section.Key("client_id").String(),
+		clientSecret:  section.Key("client_secret").String(),
+		redirectURL:   section.Key("redirect_url").String(),
+		scopes:        strings.Fields(section.Key("scopes").MustString("openid profile email groups")),
+		adminClaim:    section.Key("admin_claim").MustString("groups"),
+		adminValue:    section.Key("admin_value").String(),
+		usernameClaim: section.Key("username_claim").MustString("preferred_username"),
+		keys:          map[string]interface{}{},
+		pending:       map[string]oidcPendingLogin{},
+	}
+}
+
+func oidcGetJSON(url string, target interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got status %d from \"%s\"", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	discovery := &oidcDiscovery{}
+	if err := oidcGetJSON(p.issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("issuer \"%s\" doesn't match configured issuer \"%s\"", discovery.Issuer, p.issuer)
+	}
+	p.discovery = discovery
+	return discovery, nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey converts an RSA or P-256 JWK to a key usable with jwt.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve \"%s\"", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type \"%s\"", k.Kty)
+}
+
+// key returns the provider's signing key with the given ID, refetching the key set if it isn't known, e.g. after rotation.
+func (p *OIDCProvider) key(kid string) (interface{}, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oidcGetJSON(discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = key
+		}
+	}
+	key, ok := p.keys[kid]
+	if !ok {
+		// Providers with a single key don't always give an ID.
+		if key, ok = p.keys[""]; !ok {
+			return nil, fmt.Errorf("unknown signing key \"%s\"", kid)
+		}
+	}
+	return key, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// authURL returns the URL to send the user to, remembering the state, nonce and PKCE verifier for the callback.
+func (p *OIDCProvider) authURL(redirectURL string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	var state, nonce, verifier string
+	for _, s := range []*string{&state, &nonce, &verifier} {
+		if *s, err = randomString(); err != nil {
+			return "", err
+		}
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	p.lock.Lock()
+	now := time.Now()
+	for s, login := range p.pending {
+		if login.expiry.Before(now) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = oidcPendingLogin{nonce: nonce, verifier: verifier, expiry: now.Add(oidcLoginLength)}
+	p.lock.Unlock()
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// exchange swaps the code from the callback for an ID token, and returns its verified claims.
+func (p *OIDCProvider) exchange(state, code, redirectURL string) (jwt.MapClaims, error) {
+	p.lock.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.lock.Unlock()
+	if !ok || login.expiry.Before(time.Now()) {
+		return nil, fmt.Errorf("unknown or expired state")
+	}
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oidcClient.PostForm(discovery.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"code_verifier": {login.verifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token request failed (%d): %s", resp.StatusCode, tokens.Error)
+	}
+	token, err := jwt.Parse(tokens.IDToken, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid ID token")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("ID token from unexpected issuer \"%s\"", iss)
+	}
+	if !claims.VerifyAudience(p.clientID, true) {
+		return nil, fmt.Errorf("ID token not intended for this client")
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.nonce {
+		return nil, fmt.Errorf("ID token nonce doesn't match")
+	}
+	return claims, nil
+}
+
+// isAdmin returns whether the claims give admin access. The admin claim can be a string or a list, e.g. of groups.
+// Nobody is an admin if no admin value is set.
+func (p *OIDCProvider) isAdmin(claims jwt.MapClaims) bool {
+	if p.adminValue == "" {
+		return false
+	}
+	switch v := claims[p.adminClaim].(type) {
+	case string:
+		return v == p.adminValue
+	case bool:
+		return v && p.adminValue == "true"
+	case []interface{}:
+		for _, item := range v {
+			if s, _ := item.(string); s == p.adminValue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcRedirectURL returns the callback URL registered with the provider, guessing from the request if it isn't set.
+func (app *appContext) oidcRedirectURL(gc *gin.Context) string {
+	if app.oidc.redirectURL != "" {
+		return app.oidc.redirectURL
+	}
+	scheme := "http"
+	if gc.Request.TLS != nil || gc.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + gc.Request.Host + app.URLBase + "/oidc/callback"
+}
+
+// @Summary Redirects to the OpenID Connect provider to log in.
+// @Success 302
+// @Failure 500 {object} stringResponse
+// @Router /oidc/login [get]
+// @tags Auth
+func (app *appContext) OIDCLogin(gc *gin.Context) {
+	authURL, err := app.oidc.authURL(app.oidcRedirectURL(gc))
+	if err != nil {
+		app.err.Printf("OIDC: Failed to contact provider: %v", err)
+		respond(500, "Couldn't contact provider", gc)
+		return
+	}
+	gc.Redirect(302, authURL)
+}
+
+// @Summary Callback for the OpenID Connect provider. If the user has admin access, sets the refresh cookie and redirects to the admin page, or if they have 2FA enabled, returns a challenge for /token/totp.
+// @Param code query string true "Authorization code."
+// @Param state query string true "State given in /oidc/login."
+// @Success 302
+// @Success 200 {object} getTokenDTO
+// @Failure 401 {object} stringResponse
+// @Router /oidc/callback [get]
+// @tags Auth
+func (app *appContext) OIDCCallback(gc *gin.Context) {
+	if errMsg := gc.Query("error"); errMsg != "" {
+		app.info.Printf("OIDC: Login failed at provider: %s %s", errMsg, gc.Query("error_description"))
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	claims, err := app.oidc.exchange(gc.Query("state"), gc.Query("code"), app.oidcRedirectURL(gc))
+	if err != nil {
+		app.info.Printf("OIDC: Auth denied: %v", err)
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		app.info.Println("OIDC: Auth denied: ID token has no subject")
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	username, _ := claims[app.oidc.usernameClaim].(string)
+	if username == "" {
+		username = subject
+	}
+	if !app.oidc.isAdmin(claims) {
+		app.info.Printf("OIDC: Auth denied: \"%s\" doesn't have %s \"%s\"", username, app.oidc.adminClaim, app.oidc.adminValue)
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	userID := shortuuid.New()
+	app.addUser(User{UserID: userID, Username: username, Subject: subject})
+	if app.totpEnabled(oidcOwnerPrefix + subject) {
+		challenge, err := createTOTPChallenge(userID, "")
+		if err != nil {
+			app.err.Printf("OIDC: Couldn't generate 2FA challenge (%s)", err)
+			respond(500, "Couldn't generate token", gc)
+			return
+		}
+		app.debug.Printf("OIDC: 2FA code required for \"%s\"", username)
+		gc.JSON(200, getTokenDTO{TOTPRequired: true, Challenge: challenge})
+		return
+	}
+	_, refresh, err := app.createSession(userID, "", gc)
+	if err != nil {
+		app.err.Printf("getToken failed: Couldn't generate token (%s)", err)
+		respond(500, "Couldn't generate token", gc)
+		return
+	}
+	app.info.Printf("OIDC: Logged in \"%s\"", username)
+	metrics.inc(metricLogins, "result", metricResultSuccess)
+	// The admin page logs in with the refresh cookie when loaded.
+	gc.SetCookie("refresh", refresh, int(sessionLength.Seconds()), "/", gc.Request.URL.Hostname(), true, true)
+	gc.Redirect(302, app.URLBase+"/")
+}
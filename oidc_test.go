@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/hrfee/jfa-go/logger"
+	"github.com/pquerna/otp/totp"
+	"gopkg.in/ini.v1"
+)
+
+// mockOIDC is an OpenID Connect provider which gives out ID tokens for the last login it was sent to.
+type mockOIDC struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	nonce     string
+	challenge string
+	groups    []string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &mockOIDC{key: key}
+	p.server = httptest.NewServer(p)
+	return p
+}
+
+func (p *mockOIDC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	case "/jwks":
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kid: "test",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	case "/token":
+		r.ParseForm()
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		tk := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                p.server.URL,
+			"aud":                "jfa-go",
+			"sub":                "1234",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              p.nonce,
+			"preferred_username": "oidcadmin",
+			"groups":             p.groups,
+		})
+		tk.Header["kid"] = "test"
+		idToken, _ := tk.SignedString(p.key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "x", "token_type": "Bearer"})
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func newOIDCTestApp(t *testing.T, issuer string) *appContext {
+	dir := t.TempDir()
+	app := &appContext{
+		config:   ini.Empty(),
+		dataPath: dir,
+		info:     logger.NewEmptyLogger(),
+		debug:    logger.NewEmptyLogger(),
+		err:      logger.NewEmptyLogger(),
+	}
+	var err error
+	app.storage.backend, err = newJSONBackend(dir, func(key string) string {
+		return filepath.Join(dir, key+".json")
+	})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	app.config.Section("oidc").Key("issuer").SetValue(issuer)
+	app.config.Section("oidc").Key("client_id").SetValue("jfa-go")
+	app.config.Section("oidc").Key("client_secret").SetValue("secret")
+	app.config.Section("oidc").Key("redirect_url").SetValue("http://jfa.example.com/oidc/callback")
+	app.config.Section("oidc").Key("admin_value").SetValue("admins")
+	app.oidc = newOIDCProvider(app)
+	return app
+}
+
+// oidcLogin goes through /oidc/login and /oidc/callback, returning the callback's response.
+func oidcLogin(t *testing.T, app *appContext, provider *mockOIDC, tamperState bool) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	gc, _ := gin.CreateTestContext(w)
+	gc.Request = httptest.NewRequest("GET", "/oidc/login", nil)
+	app.OIDCLogin(gc)
+	if w.Code != 302 {
+		t.Fatalf("Expected redirect from /oidc/login, got %d", w.Code)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect: %v", err)
+	}
+	query := loc.Query()
+	if query.Get("client_id") != "jfa-go" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Unexpected authorization request: %s", loc)
+	}
+	provider.nonce = query.Get("nonce")
+	provider.challenge = query.Get("code_challenge")
+	state := query.Get("state")
+	if tamperState {
+		state += "x"
+	}
+
+	w = httptest.NewRecorder()
+	gc, _ = gin.CreateTestContext(w)
+	gc.Request = httptest.NewRequest("GET", "/oidc/callback?"+url.Values{"code": {"code"}, "state": {state}}.Encode(), nil)
+	app.OIDCCallback(gc)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider := newMockOIDC(t)
+	defer provider.server.Close()
+
+	t.Run("admin", func(t *testing.T) {
+		app := newOIDCTestApp(t, provider.server.URL)
+		provider.groups = []string{"users", "admins"}
+		w := oidcLogin(t, app, provider, false)
+		if w.Code != 302 {
+			t.Fatalf("Expected redirect to admin page, got %d: %s", w.Code, w.Body.String())
+		}
+		var refresh string
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "refresh" {
+				refresh = cookie.Value
+			}
+		}
+		token, err := jwt.Parse(refresh, checkToken)
+		if err != nil || token.Claims.(jwt.MapClaims)["type"] != "refresh" {
+			t.Fatalf("Invalid refresh token: %v", err)
+		}
+		if len(app.users) != 1 || app.users[0].Username != "oidcadmin" || app.users[0].UserID != token.Claims.(jwt.MapClaims)["id"] {
+			t.Errorf("Admin wasn't added to users: %+v", app.users)
+		}
+		sessionID, _ := token.Claims.(jwt.MapClaims)["sid"].(string)
+		if session, ok := app.validSession(sessionID); !ok || session.Username != "oidcadmin" {
+			t.Errorf("Session wasn't stored for login: %+v", app.storage.GetSessions())
+		}
+	})
+	t.Run("separate from local admin", func(t *testing.T) {
+		app := newOIDCTestApp(t, provider.server.URL)
+		// A local admin with the same name shouldn't share sessions or 2FA with the provider's user.
+		app.users = append(app.users, User{UserID: "local-id", Username: "oidcadmin", Password: "password"})
+		provider.groups = []string{"admins"}
+		if w := oidcLogin(t, app, provider, false); w.Code != 302 {
+			t.Fatalf("Expected redirect to admin page, got %d: %s", w.Code, w.Body.String())
+		}
+		for _, session := range app.storage.GetSessions() {
+			if session.Owner != "oidc:1234" {
+				t.Errorf("Expected session owner \"oidc:1234\", got \"%s\"", session.Owner)
+			}
+		}
+		if key, _ := app.totpAccount("local-id", ""); key != "local:oidcadmin" {
+			t.Errorf("Expected local admin's account to be \"local:oidcadmin\", got \"%s\"", key)
+		}
+	})
+	t.Run("2FA", func(t *testing.T) {
+		app := newOIDCTestApp(t, provider.server.URL)
+		key, _ := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "oidcadmin"})
+		app.storage.SetTOTPKey("oidc:1234", TOTPAccount{Name: "oidcadmin", Secret: key.Secret(), Enabled: true})
+		provider.groups = []string{"admins"}
+		w := oidcLogin(t, app, provider, false)
+		var resp getTokenDTO
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != 200 || !resp.TOTPRequired || resp.Challenge == "" || len(w.Result().Cookies()) != 0 {
+			t.Fatalf("Expected a 2FA challenge and no cookie, got %d: %s", w.Code, w.Body.String())
+		}
+		if len(app.storage.GetSessions()) != 0 {
+			t.Errorf("Session was created before 2FA")
+		}
+		code, _ := totp.GenerateCode(key.Secret(), time.Now())
+		if status, token := totpCode(app, resp.Challenge, code); status != 200 || token == "" {
+			t.Errorf("Expected a token for a valid code, got %d", status)
+		}
+	})
+	t.Run("no admin value", func(t *testing.T) {
+		app := newOIDCTestApp(t, provider.server.URL)
+		app.config.Section("oidc").Key("admin_value").SetValue("")
+		app.oidc = newOIDCProvider(app)
+		provider.groups = []string{"admins"}
+		if w := oidcLogin(t, app, provider, false); w.Code != 401 {
+			t.Errorf("Expected 401 with no admin value set, got %d", w.Code)
+		}
+	})
+	t.Run("not admin", func(t *testing.T) {
+		app := newOIDCTestApp(t, provider.server.URL)
+		provider.groups = []string{"users"}
+		if w := oidcLogin(t, app, provider, false); w.Code != 401 {
+			t.Errorf("Expected 401 for non-admin, got %d", w.Code)
+		}
+		if len(app.users) != 0 {
+			t.Errorf("Non-admin was added to users")
+		}
+	})
+	t.Run("wrong state", func(t *testing.T) {
+		app := newOIDCTestApp(t, provider.server.URL)
+		provider.groups = []string{"admins"}
+		if w := oidcLogin(t, app, provider, true); w.Code != 401 {
+			t.Errorf("Expected 401 for wrong state, got %d", w.Code)
+		}
+	})
+}
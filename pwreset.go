@@ -12,7 +12,7 @@ import (
 // GenInternalReset generates a local password reset PIN, for use with the PWR option on the Admin page.
 func (app *appContext) GenInternalReset(userID string) (InternalPWR, error) {
 	pin := genAuthToken()
-	user, status, err := app.jf.UserByID(userID, false)
+	user, status, err := app.getJFUserByID(userID)
 	if err != nil || status != 200 {
 		return InternalPWR{}, err
 	}
@@ -58,7 +58,7 @@ type PasswordReset struct {
 }
 
 func pwrMonitor(app *appContext, watcher *fsnotify.Watcher) {
-	if !emailEnabled {
+	if !emailEnabled && !webhooksEnabled {
 		return
 	}
 	for {
@@ -81,7 +81,7 @@ func pwrMonitor(app *appContext, watcher *fsnotify.Watcher) {
 				}
 				app.info.Printf("New password reset for user \"%s\"", pwr.Username)
 				if currentTime := time.Now(); pwr.Expiry.After(currentTime) {
-					user, status, err := app.jf.UserByName(pwr.Username, false)
+					user, status, err := app.getJFUserByName(pwr.Username)
 					if !(status == 200 || status == 204) || err != nil {
 						app.err.Printf("Failed to get users from Jellyfin: Code %d", status)
 						app.debug.Printf("Error: %s", err)
@@ -93,6 +93,11 @@ func pwrMonitor(app *appContext, watcher *fsnotify.Watcher) {
 						app.err.Printf("Couldn't get user ID for user \"%s\"", pwr.Username)
 						return
 					}
+					app.triggerWebhook(webhookPasswordReset, map[string]interface{}{
+						"id":       uid,
+						"username": pwr.Username,
+						"expiry":   pwr.Expiry.Unix(),
+					})
 					name := app.getAddressOrName(uid)
 					if name != "" {
 						msg, err := app.email.constructReset(pwr, app, false)
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Buckets for rate limited routes. Each has its own per-IP request limit, while failed attempts and lockouts are shared.
+const (
+	limitLogin   = "login"
+	limitSignup  = "signup"
+	limitCaptcha = "captcha"
+	limitPIN     = "pin"
+	limitRenewal = "renewal"
+	// Polled by the form while waiting for a bot to verify a PIN, so it has its own, higher limit.
+	limitPINStatus = "pin_status"
+)
+
+// Prefixes of rateLimiter keys, for lockouts of an IP or a username.
+const (
+	banIP       = "ip"
+	banUsername = "user"
+)
+
+// attemptRecord tracks failed attempts for an IP or username, and any lockout they've caused.
+type attemptRecord struct {
+	Failures     int
+	FirstFailure time.Time // Start of the current failure window.
+	LastFailure  time.Time
+	Lockouts     int // Number of lockouts so far, each twice as long as the last.
+	Until        time.Time
+}
+
+type requestWindow struct {
+	Start time.Time
+	Count int
+}
+
+// rateLimiter keeps request counts and failed attempts in memory, so bans are cleared by a restart.
+// The zero value is ready to use.
+type rateLimiter struct {
+	lock      sync.Mutex
+	attempts  map[string]*attemptRecord // Keyed "<banIP|banUsername>:<value>".
+	requests  map[string]*requestWindow // Keyed "<bucket> <IP>".
+	lastPrune time.Time
+}
+
+func banKey(kind, value string) string {
+	if kind == banUsername {
+		value = strings.ToLower(value)
+	}
+	return kind + ":" + value
+}
+
+// lockedFor returns how long the key is still locked out for, or 0 if it isn't.
+func (rl *rateLimiter) lockedFor(key string) time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if record, ok := rl.attempts[key]; ok {
+		if wait := time.Until(record.Until); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// request counts a request in a one minute window, returning how long until another is allowed if it's over the limit.
+func (rl *rateLimiter) request(key string, perMinute int) time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := time.Now()
+	rl.prune(now)
+	if rl.requests == nil {
+		rl.requests = map[string]*requestWindow{}
+	}
+	window, ok := rl.requests[key]
+	if !ok || now.Sub(window.Start) >= time.Minute {
+		window = &requestWindow{Start: now}
+		rl.requests[key] = window
+	}
+	window.Count++
+	if window.Count > perMinute {
+		return window.Start.Add(time.Minute).Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt, locking the key out once maxFailures is reached within window.
+// Lockouts start at base and double each time, up to max. The length of a new lockout is returned.
+func (rl *rateLimiter) fail(key string, maxFailures int, window, base, max time.Duration) time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := time.Now()
+	rl.prune(now)
+	if rl.attempts == nil {
+		rl.attempts = map[string]*attemptRecord{}
+	}
+	record, ok := rl.attempts[key]
+	if !ok {
+		record = &attemptRecord{}
+		rl.attempts[key] = record
+	}
+	if now.Sub(record.FirstFailure) > window {
+		record.Failures = 0
+		record.FirstFailure = now
+	}
+	record.Failures++
+	record.LastFailure = now
+	if record.Failures < maxFailures {
+		return 0
+	}
+	length := base
+	for i := 0; i < record.Lockouts && length < max; i++ {
+		length *= 2
+	}
+	if length > max {
+		length = max
+	}
+	record.Lockouts++
+	record.Failures = 0
+	record.Until = now.Add(length)
+	return length
+}
+
+// succeed clears the failed attempts of a key, leaving its lockout count so repeat offenders are still locked out for longer.
+func (rl *rateLimiter) succeed(key string) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if record, ok := rl.attempts[key]; ok {
+		record.Failures = 0
+	}
+}
+
+// prune removes finished request windows, and records with no failures or lockouts for a day, at most once a minute.
+// Must be called with the lock held.
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < time.Minute {
+		return
+	}
+	rl.lastPrune = now
+	for key, window := range rl.requests {
+		if now.Sub(window.Start) >= time.Minute {
+			delete(rl.requests, key)
+		}
+	}
+	for key, record := range rl.attempts {
+		if now.Sub(record.LastFailure) > 24*time.Hour && record.Until.Before(now) {
+			delete(rl.attempts, key)
+		}
+	}
+}
+
+// bans returns the keys currently locked out, and their records.
+func (rl *rateLimiter) bans() map[string]attemptRecord {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := time.Now()
+	bans := map[string]attemptRecord{}
+	for key, record := range rl.attempts {
+		if record.Until.After(now) {
+			bans[key] = *record
+		}
+	}
+	return bans
+}
+
+// clear removes the given keys' records entirely, ending lockouts and resetting their length.
+func (rl *rateLimiter) clear(keys ...string) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	for _, key := range keys {
+		delete(rl.attempts, key)
+	}
+}
+
+func (app *appContext) rateLimitEnabled() bool {
+	return app.config.Section("rate_limiting").Key("enabled").MustBool(true)
+}
+
+func (app *appContext) limitUsernames() bool {
+	return app.config.Section("rate_limiting").Key("limit_usernames").MustBool(true)
+}
+
+// tooManyRequests responds with a 429 telling the client when to try again.
+func (app *appContext) tooManyRequests(gc *gin.Context, bucket, reason string, wait time.Duration) {
+	metrics.inc(metricRateLimited, "bucket", bucket, "reason", reason)
+	gc.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	respond(429, "Too many requests", gc)
+}
+
+// rateLimit returns middleware which rejects requests from locked out IPs (and for /token/login, usernames),
+// and those over the bucket's per-minute limit.
+func (app *appContext) rateLimit(bucket string) gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		if !app.rateLimitEnabled() {
+			return
+		}
+		ip := gc.ClientIP()
+		if wait := app.limiter.lockedFor(banKey(banIP, ip)); wait > 0 {
+			app.debug.Printf("Rate limit: %s is locked out", ip)
+			app.tooManyRequests(gc, bucket, "lockout", wait)
+			return
+		}
+		if username, _, ok := gc.Request.BasicAuth(); ok && username != "" && bucket == limitLogin && app.limitUsernames() {
+			if wait := app.limiter.lockedFor(banKey(banUsername, username)); wait > 0 {
+				app.debug.Printf("Rate limit: \"%s\" is locked out", username)
+				app.tooManyRequests(gc, bucket, "lockout", wait)
+				return
+			}
+		}
+		perMinute := app.config.Section("rate_limiting").Key("requests_per_minute").MustInt(30)
+		if bucket == limitPINStatus {
+			perMinute = app.config.Section("rate_limiting").Key("status_requests_per_minute").MustInt(120)
+		}
+		if wait := app.limiter.request(bucket+" "+ip, perMinute); wait > 0 {
+			app.debug.Printf("Rate limit: %s is over the limit for %s", ip, bucket)
+			app.tooManyRequests(gc, bucket, "rate", wait)
+			return
+		}
+	}
+}
+
+// failedAttempt records a failed login, code or PIN for the request's IP, and the username if given.
+func (app *appContext) failedAttempt(gc *gin.Context, username string) {
+	if !app.rateLimitEnabled() {
+		return
+	}
+	section := app.config.Section("rate_limiting")
+	maxFailures := section.Key("max_failures").MustInt(5)
+	window := time.Duration(section.Key("failure_window").MustInt(15)) * time.Minute
+	base := time.Duration(section.Key("lockout_seconds").MustInt(60)) * time.Second
+	max := time.Duration(section.Key("max_lockout").MustInt(1440)) * time.Minute
+	keys := []string{banKey(banIP, gc.ClientIP())}
+	if username != "" && app.limitUsernames() {
+		keys = append(keys, banKey(banUsername, username))
+	}
+	for _, key := range keys {
+		if length := app.limiter.fail(key, maxFailures, window, base, max); length != 0 {
+			app.info.Printf("Rate limit: Locked out %s for %s after too many failed attempts", key, length)
+		}
+	}
+}
+
+// succeededAttempt clears the failed attempts of the request's IP and the given username after a successful login.
+func (app *appContext) succeededAttempt(gc *gin.Context, username string) {
+	app.limiter.succeed(banKey(banIP, gc.ClientIP()))
+	if username != "" {
+		app.limiter.succeed(banKey(banUsername, username))
+	}
+}
+
+// @Summary Returns the IPs and usernames currently locked out after too many failed attempts.
+// @Produce json
+// @Success 200 {object} getBansDTO
+// @Router /bans [get]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) GetBans(gc *gin.Context) {
+	resp := getBansDTO{Bans: []BanDTO{}}
+	for key, record := range app.limiter.bans() {
+		parts := strings.SplitN(key, ":", 2)
+		resp.Bans = append(resp.Bans, BanDTO{
+			ID:       key,
+			Type:     parts[0],
+			Value:    parts[1],
+			Until:    record.Until.Unix(),
+			Lockouts: record.Lockouts,
+		})
+	}
+	sort.Slice(resp.Bans, func(i, j int) bool { return resp.Bans[i].Until > resp.Bans[j].Until })
+	gc.JSON(200, resp)
+}
+
+// @Summary Lifts lockouts, resetting how long the next one will be. If no IDs are given, all are lifted.
+// @Produce json
+// @Param deleteBansDTO body deleteBansDTO true "IDs of the bans to lift, as given by GET /bans."
+// @Success 200 {object} boolResponse
+// @Router /bans [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) DeleteBans(gc *gin.Context) {
+	var req deleteBansDTO
+	gc.BindJSON(&req)
+	if len(req.Bans) == 0 {
+		for key := range app.limiter.bans() {
+			req.Bans = append(req.Bans, key)
+		}
+	}
+	app.limiter.clear(req.Bans...)
+	app.info.Printf("Lifted %d lockout(s)", len(req.Bans))
+	app.adminActivity(gc, activityBansLifted, req.Bans, "")
+	respondBool(200, true, gc)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Referrer tracks the referral invites a user has created, which count towards their quota.
+type Referrer struct {
+	Invites     []string  `json:"invites"` // Codes of every invite created, including those since used or expired.
+	LastCreated time.Time `json:"last_created"`
+}
+
+// referralPolicy is the "referrals" config section.
+type referralPolicy struct {
+	enabled                 bool
+	users                   map[string]bool // Lower-case usernames.
+	profiles, labels        map[string]bool
+	profile, label          string
+	validDays, uses, expiry int
+	quota                   int
+	cooldown                time.Duration
+}
+
+func (app *appContext) loadReferralPolicy() referralPolicy {
+	section := app.config.Section("referrals")
+	policy := referralPolicy{
+		enabled:   section.Key("enabled").MustBool(false),
+		users:     map[string]bool{},
+		profiles:  configSet(section, "profiles"),
+		labels:    configSet(section, "labels"),
+		profile:   section.Key("profile").MustString(""),
+		label:     section.Key("label").MustString("Referral"),
+		validDays: section.Key("valid_days").MustInt(7),
+		uses:      section.Key("uses").MustInt(1),
+		expiry:    section.Key("user_expiry_days").MustInt(0),
+		quota:     section.Key("quota").MustInt(5),
+		cooldown:  time.Duration(section.Key("cooldown_hours").MustInt(24)) * time.Hour,
+	}
+	for name := range configSet(section, "users") {
+		policy.users[strings.ToLower(name)] = true
+	}
+	if policy.validDays < 1 {
+		policy.validDays = 1
+	}
+	if policy.uses < 1 {
+		policy.uses = 1
+	}
+	if policy.profile == "" {
+		policy.profile = app.storage.GetDefaultProfile()
+	}
+	return policy
+}
+
+// referralAllowed returns whether the user can create referral invites, by being named or having one of the profiles or labels.
+func (app *appContext) referralAllowed(policy referralPolicy, jfID, username string) bool {
+	if !policy.enabled {
+		return false
+	}
+	if policy.users[strings.ToLower(username)] {
+		return true
+	}
+	emailStore, _ := app.storage.GetEmailsKey(jfID)
+	return policy.profiles[emailStore.Profile] || policy.labels[emailStore.Label]
+}
+
+// remaining returns how many more invites the referrer can create, or -1 if there's no limit.
+func (policy referralPolicy) remaining(referrer Referrer) int {
+	if policy.quota == 0 {
+		return -1
+	}
+	if n := policy.quota - len(referrer.Invites); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// nextAllowed returns when the referrer can next create an invite, ignoring their quota.
+func (policy referralPolicy) nextAllowed(referrer Referrer) time.Time {
+	if referrer.LastCreated.IsZero() {
+		return time.Time{}
+	}
+	return referrer.LastCreated.Add(policy.cooldown)
+}
+
+// @Summary Returns whether the logged in user can create referral invites, how many they have left, and their invites which can still be used.
+// @Produce json
+// @Success 200 {object} myReferralsDTO
+// @Router /my/referrals [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) GetMyReferrals(gc *gin.Context) {
+	jfID := gc.GetString("jfId")
+	policy := app.loadReferralPolicy()
+	resp := myReferralsDTO{
+		Allowed: app.referralAllowed(policy, jfID, gc.GetString("username")),
+		Invites: []myReferralInviteDTO{},
+	}
+	if !resp.Allowed {
+		gc.JSON(200, resp)
+		return
+	}
+	app.storage.loadInvites()
+	referrer, _ := app.storage.GetReferrersKey(jfID)
+	resp.Remaining = policy.remaining(referrer)
+	if next := policy.nextAllowed(referrer); next.After(time.Now()) {
+		resp.NextAllowed = next.Unix()
+	}
+	for _, code := range referrer.Invites {
+		inv, ok := app.storage.GetInvitesKey(code)
+		if !ok || time.Now().After(inv.ValidTill) {
+			continue
+		}
+		resp.Invites = append(resp.Invites, myReferralInviteDTO{
+			Code:          code,
+			ValidTill:     inv.ValidTill.Unix(),
+			RemainingUses: inv.RemainingUses,
+			Used:          len(inv.UsedBy),
+		})
+	}
+	sort.Slice(resp.Invites, func(i, j int) bool { return resp.Invites[i].ValidTill < resp.Invites[j].ValidTill })
+	gc.JSON(200, resp)
+}
+
+// @Summary Creates a referral invite for the logged in user, from the template in the "referrals" settings.
+// @Produce json
+// @Success 200 {object} myReferralInviteDTO
+// @Failure 400 {object} stringResponse
+// @Failure 403 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/referrals [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) CreateMyReferral(gc *gin.Context) {
+	jfID := gc.GetString("jfId")
+	username := gc.GetString("username")
+	policy := app.loadReferralPolicy()
+	if !app.referralAllowed(policy, jfID, username) {
+		respond(403, "errorUnknown", gc)
+		return
+	}
+	// Held throughout so simultaneous requests can't both take the last of a quota.
+	app.referralLock.Lock()
+	defer app.referralLock.Unlock()
+	referrer, _ := app.storage.GetReferrersKey(jfID)
+	if policy.remaining(referrer) == 0 {
+		respond(400, "errorReferralQuota", gc)
+		return
+	} else if policy.nextAllowed(referrer).After(time.Now()) {
+		respond(400, "errorReferralCooldown", gc)
+		return
+	}
+	app.storage.loadInvites()
+	code, invite := app.newInvite(generateInviteDTO{
+		Days:          policy.validDays,
+		UserExpiry:    policy.expiry != 0,
+		UserDays:      policy.expiry,
+		MultipleUses:  policy.uses != 1,
+		RemainingUses: policy.uses,
+		Profile:       policy.profile,
+		Label:         policy.label,
+	})
+	invite.CreatedBy = username
+	invite.ReferrerID = jfID
+	app.storage.SetInvitesKey(code, invite)
+	referrer.Invites = append(referrer.Invites, code)
+	referrer.LastCreated = invite.Created
+	app.storage.SetReferrersKey(jfID, referrer)
+	if err := app.storage.storeTogether("invites", "referrers"); err != nil {
+		app.err.Printf("Failed to store referral invite for \"%s\": %v", username, err)
+		respond(500, "errorUnknown", gc)
+		return
+	}
+	app.info.Printf("%s: \"%s\" created a referral invite", code, username)
+	app.userActivity(jfID, username, activityReferralCreated, code)
+	gc.JSON(200, myReferralInviteDTO{
+		Code:          code,
+		ValidTill:     invite.ValidTill.Unix(),
+		RemainingUses: invite.RemainingUses,
+	})
+}
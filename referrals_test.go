@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestCreateMyReferral checks referral invites are limited to allowed users, by the cooldown between them, and by the quota.
+func TestCreateMyReferral(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newTestApp(t, "http://localhost")
+	section := app.config.Section("referrals")
+	section.Key("enabled").SetValue("true")
+	section.Key("users").SetValue("Alice")
+	section.Key("quota").SetValue("2")
+	section.Key("cooldown_hours").SetValue("1")
+
+	create := func(jfID, username string) (int, string) {
+		w := httptest.NewRecorder()
+		gc, _ := gin.CreateTestContext(w)
+		gc.Request = httptest.NewRequest("POST", "/my/referrals", nil)
+		gc.Set("jfId", jfID)
+		gc.Set("username", username)
+		app.CreateMyReferral(gc)
+		var resp stringResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Error
+	}
+	// Lets the cooldown pass, as if the last invite was made earlier.
+	wait := func() {
+		referrer, _ := app.storage.GetReferrersKey("alice-id")
+		referrer.LastCreated = referrer.LastCreated.Add(-2 * time.Hour)
+		app.storage.SetReferrersKey("alice-id", referrer)
+	}
+
+	if status, _ := create("bob-id", "bob"); status != 403 {
+		t.Errorf("Expected 403 for a user not allowed referrals, got %d", status)
+	}
+	if status, _ := create("alice-id", "alice"); status != 200 {
+		t.Fatalf("Expected the first referral to be created, got %d", status)
+	}
+	if status, msg := create("alice-id", "alice"); status != 400 || msg != "errorReferralCooldown" {
+		t.Errorf("Expected 400 errorReferralCooldown during the cooldown, got %d %s", status, msg)
+	}
+	wait()
+	if status, _ := create("alice-id", "alice"); status != 200 {
+		t.Fatalf("Expected a referral to be created after the cooldown, got %d", status)
+	}
+	wait()
+	if status, msg := create("alice-id", "alice"); status != 400 || msg != "errorReferralQuota" {
+		t.Errorf("Expected 400 errorReferralQuota once the quota is used, got %d %s", status, msg)
+	}
+	referrer, _ := app.storage.GetReferrersKey("alice-id")
+	if len(referrer.Invites) != 2 {
+		t.Fatalf("Expected 2 referral invites, got %d", len(referrer.Invites))
+	}
+	for _, code := range referrer.Invites {
+		if inv, ok := app.storage.GetInvitesKey(code); !ok || inv.ReferrerID != "alice-id" {
+			t.Errorf("Expected invite %s to be stored with its referrer, got %+v", code, inv)
+		}
+	}
+}
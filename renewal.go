@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// errRenewalNotAllowed is returned by renewUser for accounts that don't expire, or were disabled for some other reason.
+var errRenewalNotAllowed = errors.New("account can't be renewed")
+
+// RenewalCode is redeemed on the /renew page to extend the expiry of the user it was made for.
+type RenewalCode struct {
+	Code      string    `json:"code"`
+	Label     string    `json:"label"`
+	UserID    string    `json:"user_id"` // Jellyfin ID of the only user who can redeem the code.
+	Months    int       `json:"months"`
+	Days      int       `json:"days"`
+	Hours     int       `json:"hours"`
+	Minutes   int       `json:"minutes"`
+	MaxUses   int       `json:"max_uses"` // Times the user can redeem the code, 0 for unlimited.
+	UsedBy    []string  `json:"used_by"`  // The user's Jellyfin ID, once for each time they've redeemed the code.
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by"`
+	ValidTill time.Time `json:"valid_till"` // Zero if the code doesn't expire.
+}
+
+// valid returns whether the code hasn't expired and has uses left.
+func (code RenewalCode) valid() bool {
+	if !code.ValidTill.IsZero() && code.ValidTill.Before(time.Now()) {
+		return false
+	}
+	return code.MaxUses == 0 || len(code.UsedBy) < code.MaxUses
+}
+
+// extend returns the time the code's duration after t.
+func (code RenewalCode) extend(t time.Time) time.Time {
+	return t.AddDate(0, code.Months, code.Days).Add(time.Duration((60*code.Hours)+code.Minutes) * time.Minute)
+}
+
+// renewUser extends the user's expiry by the code's duration, from their current expiry if it hasn't passed, otherwise from now.
+// Users disabled on expiry are re-enabled. Returns errRenewalNotAllowed for users without an expiry, or who were disabled by an admin.
+func (app *appContext) renewUser(user mediabrowser.User, code RenewalCode) (time.Time, error) {
+	expiry, hasExpiry := app.storage.GetUsersKey(user.ID)
+	_, expired := app.storage.GetExpiredUsersKey(user.ID)
+	if (user.Policy.IsDisabled && !expired) || (!hasExpiry && !expired) {
+		return time.Time{}, errRenewalNotAllowed
+	}
+	now := time.Now()
+	if !hasExpiry || expiry.Before(now) {
+		expiry = now
+	}
+	expiry = code.extend(expiry)
+	if user.Policy.IsDisabled {
+		user.Policy.IsDisabled = false
+		status, err := app.jf.SetPolicy(user.ID, user.Policy)
+		if !(status == 200 || status == 204) || err != nil {
+			return time.Time{}, fmt.Errorf("failed to enable user (%d): %v", status, err)
+		}
+		app.expireJFCache()
+	}
+	app.storage.SetUsersKey(user.ID, expiry)
+	if err := app.storage.storeUsers(); err != nil {
+		return time.Time{}, err
+	}
+	if expired {
+		app.storage.DeleteExpiredUsersKey(user.ID)
+		if err := app.storage.storeExpiredUsers(); err != nil {
+			app.err.Printf("Failed to store expired users: %v", err)
+		}
+	}
+	return expiry, nil
+}
+
+// redeemRenewalCode renews the named user with the code, returning their new expiry, or the form language string describing why it failed.
+// A missing or used up code, one made for someone else and an unknown username all give the same error, so the form can't be used to check usernames.
+func (app *appContext) redeemRenewalCode(gc *gin.Context, username, code string) (time.Time, string) {
+	app.renewalLock.Lock()
+	defer app.renewalLock.Unlock()
+	renewal, ok := app.storage.GetRenewalCodesKey(code)
+	if !ok || !renewal.valid() || renewal.UserID == "" {
+		app.debug.Printf("Renewal: Invalid code \"%s\"", code)
+		app.failedAttempt(gc, "")
+		return time.Time{}, "renewalInvalidCode"
+	}
+	user, status, err := app.getJFUserByName(username)
+	if status != 200 || err != nil || user.ID != renewal.UserID {
+		app.debug.Printf("Renewal: Code \"%s\" can't be used by \"%s\" (%d): %v", code, username, status, err)
+		app.failedAttempt(gc, "")
+		return time.Time{}, "renewalInvalidCode"
+	}
+	expiry, err := app.renewUser(user, renewal)
+	if err == errRenewalNotAllowed {
+		app.info.Printf("Renewal: \"%s\" can't be renewed, as they don't expire or were disabled by an admin", user.Name)
+		return time.Time{}, "renewalNotAllowed"
+	} else if err != nil {
+		app.err.Printf("Failed to renew \"%s\": %v", user.Name, err)
+		return time.Time{}, "renewalFailed"
+	}
+	renewal.UsedBy = append(renewal.UsedBy, user.ID)
+	app.storage.SetRenewalCodesKey(code, renewal)
+	if err := app.storage.storeRenewalCodes(); err != nil {
+		app.err.Printf("Failed to store renewal codes: %v", err)
+	}
+	app.info.Printf("Renewed \"%s\" until %s with code \"%s\"", user.Name, app.formatDatetime(expiry), code)
+	app.userActivity(user.ID, user.Name, activityAccountRenewed, code)
+	return expiry, ""
+}
+
+func (app *appContext) renewPageData(gc *gin.Context, lang string) gin.H {
+	return gin.H{
+		"urlBase":        app.getURLBase(gc),
+		"cssClass":       app.cssClass,
+		"cssVersion":     cssVersion,
+		"contactMessage": app.config.Section("ui").Key("contact_message").String(),
+		"strings":        app.storage.lang.Form[lang].Strings,
+		"code":           gc.Query("code"),
+	}
+}
+
+// RenewPage serves the form for redeeming a renewal code, filled in if given one as "?code=".
+func (app *appContext) RenewPage(gc *gin.Context) {
+	app.pushResources(gc, false)
+	lang := app.getLang(gc, FormPage, app.storage.lang.chosenFormLang)
+	gcHTML(gc, http.StatusOK, "renew.html", app.renewPageData(gc, lang))
+}
+
+// RenewAccount redeems the renewal code posted from the /renew page, showing the result on the same page.
+func (app *appContext) RenewAccount(gc *gin.Context) {
+	lang := app.getLang(gc, FormPage, app.storage.lang.chosenFormLang)
+	formStrings := app.storage.lang.Form[lang].Strings
+	username := strings.TrimSpace(gc.PostForm("username"))
+	code := strings.TrimSpace(gc.PostForm("code"))
+	data := app.renewPageData(gc, lang)
+	data["username"] = username
+	data["code"] = code
+	if username == "" || code == "" {
+		data["error"] = formStrings.get("renewalInvalidCode")
+		gcHTML(gc, http.StatusBadRequest, "renew.html", data)
+		return
+	}
+	expiry, errKey := app.redeemRenewalCode(gc, username, code)
+	if errKey != "" {
+		data["error"] = formStrings.get(errKey)
+		gcHTML(gc, http.StatusBadRequest, "renew.html", data)
+		return
+	}
+	data["success"] = formStrings.template("yourAccountIsValidUntil", tmpl{"date": app.formatDatetime(expiry)})
+	gcHTML(gc, http.StatusOK, "renew.html", data)
+}
+
+// @Summary Returns the renewal codes which haven't been deleted.
+// @Produce json
+// @Success 200 {object} getRenewalCodesDTO
+// @Router /renewal-codes [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetRenewalCodes(gc *gin.Context) {
+	codes := app.storage.GetRenewalCodes()
+	resp := getRenewalCodesDTO{Codes: make([]RenewalCodeDTO, 0, len(codes))}
+	for _, code := range codes {
+		dto := RenewalCodeDTO{
+			Code:      code.Code,
+			Label:     code.Label,
+			UserID:    code.UserID,
+			Months:    code.Months,
+			Days:      code.Days,
+			Hours:     code.Hours,
+			Minutes:   code.Minutes,
+			MaxUses:   code.MaxUses,
+			UsedBy:    code.UsedBy,
+			Valid:     code.valid(),
+			Created:   code.Created.Unix(),
+			CreatedBy: code.CreatedBy,
+		}
+		if dto.UsedBy == nil {
+			dto.UsedBy = []string{}
+		}
+		if !code.ValidTill.IsZero() {
+			dto.ValidTill = code.ValidTill.Unix()
+		}
+		resp.Codes = append(resp.Codes, dto)
+	}
+	sort.Slice(resp.Codes, func(i, j int) bool { return resp.Codes[i].Created < resp.Codes[j].Created })
+	gc.JSON(200, resp)
+}
+
+// @Summary Creates a renewal code for a user, which they can redeem at /renew?code=<code> to extend their expiry by its duration.
+// @Produce json
+// @Param newRenewalCodeDTO body newRenewalCodeDTO true "User, duration and limits of the code."
+// @Success 200 {object} stringResponse
+// @Failure 400 {object} stringResponse
+// @Failure 404 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /renewal-codes [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) CreateRenewalCode(gc *gin.Context) {
+	var req newRenewalCodeDTO
+	gc.BindJSON(&req)
+	if req.Months < 0 || req.Days < 0 || req.Hours < 0 || req.Minutes < 0 || req.Months+req.Days+req.Hours+req.Minutes == 0 {
+		respond(400, "Duration required", gc)
+		return
+	}
+	if req.MaxUses < 0 {
+		respond(400, "Invalid number of uses", gc)
+		return
+	}
+	if req.UserID == "" {
+		respond(400, "User required", gc)
+		return
+	}
+	if _, status, err := app.getJFUserByID(req.UserID); status != 200 || err != nil {
+		app.err.Printf("Failed to get user \"%s\" (%d): %v", req.UserID, status, err)
+		respond(404, "User not found", gc)
+		return
+	}
+	code := RenewalCode{
+		Code:    shortuuid.New(),
+		Label:   req.Label,
+		UserID:  req.UserID,
+		Months:  req.Months,
+		Days:    req.Days,
+		Hours:   req.Hours,
+		Minutes: req.Minutes,
+		MaxUses: req.MaxUses,
+		UsedBy:  []string{},
+		Created: time.Now(),
+	}
+	if req.ValidTill != 0 {
+		code.ValidTill = time.Unix(req.ValidTill, 0)
+		if code.ValidTill.Before(code.Created) {
+			respond(400, "Expiry is in the past", gc)
+			return
+		}
+	}
+	_, code.CreatedBy = app.requestActor(gc)
+	app.storage.SetRenewalCodesKey(code.Code, code)
+	if err := app.storage.storeRenewalCodes(); err != nil {
+		app.err.Printf("Failed to store renewal codes: %v", err)
+		respond(500, "Couldn't store code", gc)
+		return
+	}
+	app.info.Printf("Created renewal code \"%s\"", code.Code)
+	app.adminActivity(gc, activityRenewalCodeCreated, []string{code.Code}, code.Label)
+	respond(200, code.Code, gc)
+}
+
+// @Summary Deletes a renewal code, so it can't be redeemed.
+// @Produce json
+// @Param code path string true "The renewal code."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /renewal-codes/{code} [delete]
+// @Security Bearer
+// @tags Users
+func (app *appContext) DeleteRenewalCode(gc *gin.Context) {
+	code := gc.Param("code")
+	if _, ok := app.storage.GetRenewalCodesKey(code); !ok {
+		respondBool(404, false, gc)
+		return
+	}
+	app.storage.DeleteRenewalCodesKey(code)
+	if err := app.storage.storeRenewalCodes(); err != nil {
+		app.err.Printf("Failed to store renewal codes: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Printf("Deleted renewal code \"%s\"", code)
+	app.adminActivity(gc, activityRenewalCodeDeleted, []string{code}, "")
+	respondBool(200, true, gc)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+)
+
+// TestRedeemRenewalCode checks a code only renews the user it was made for, as many times as it allows, and every other failure looks the same.
+func TestRedeemRenewalCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jf := &mockJellyfin{}
+	for i, name := range []string{"alice", "bob"} {
+		jf.users = append(jf.users, mediabrowser.User{Name: name, ID: fmt.Sprintf("%032x", i+1)})
+	}
+	server := httptest.NewServer(jf)
+	defer server.Close()
+	app := newTestApp(t, server.URL)
+	app.config.Section("rate_limiting").Key("enabled").SetValue("false")
+	alice, bob := jf.users[0].ID, jf.users[1].ID
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	app.storage.SetUsersKey(alice, expiry)
+	app.storage.SetUsersKey(bob, expiry)
+	app.storage.SetRenewalCodesKey("code", RenewalCode{Code: "code", UserID: alice, Days: 7, MaxUses: 1})
+	app.storage.SetRenewalCodesKey("unbound", RenewalCode{Code: "unbound", Days: 7})
+
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Request = httptest.NewRequest("POST", "/renew", nil)
+	for _, c := range []struct{ username, code string }{
+		{"alice", "unknown"},
+		{"alice", "unbound"},
+		{"bob", "code"},
+		{"nobody", "code"},
+	} {
+		if _, errKey := app.redeemRenewalCode(gc, c.username, c.code); errKey != "renewalInvalidCode" {
+			t.Errorf("Redeeming %s as %s: expected renewalInvalidCode, got %q", c.code, c.username, errKey)
+		}
+	}
+	if got, _ := app.storage.GetUsersKey(bob); !got.Equal(expiry) {
+		t.Errorf("Expected bob's expiry to be unchanged, got %s", got)
+	}
+
+	renewed, errKey := app.redeemRenewalCode(gc, "alice", "code")
+	if errKey != "" {
+		t.Fatalf("Expected alice to be renewed, got %q", errKey)
+	}
+	if want := expiry.AddDate(0, 0, 7); !renewed.Equal(want) {
+		t.Errorf("Expected expiry %s, got %s", want, renewed)
+	}
+	if got, _ := app.storage.GetUsersKey(alice); !got.Equal(renewed) {
+		t.Errorf("Expected stored expiry %s, got %s", renewed, got)
+	}
+	if _, errKey := app.redeemRenewalCode(gc, "alice", "code"); errKey != "renewalInvalidCode" {
+		t.Errorf("Expected a used code to be invalid, got %q", errKey)
+	}
+
+	// A multi-use code can be redeemed by its user until its uses run out.
+	app.storage.SetRenewalCodesKey("multi", RenewalCode{Code: "multi", UserID: bob, Days: 7, MaxUses: 2})
+	for i := 1; i <= 2; i++ {
+		renewed, errKey := app.redeemRenewalCode(gc, "bob", "multi")
+		if want := expiry.AddDate(0, 0, 7*i); errKey != "" || !renewed.Equal(want) {
+			t.Errorf("Expected use %d to renew bob until %s, got %s %q", i, want, renewed, errKey)
+		}
+	}
+	if _, errKey := app.redeemRenewalCode(gc, "bob", "multi"); errKey != "renewalInvalidCode" {
+		t.Errorf("Expected a used up code to be invalid, got %q", errKey)
+	}
+}
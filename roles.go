@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+)
+
+// Permissions roles can grant. Each API route requires one of these, see routePermissions.
+const (
+	permUsersRead     = "users:read"
+	permUsersCreate   = "users:create"
+	permUsersDelete   = "users:delete"
+	permUsersEnable   = "users:enable"
+	permUsersExtend   = "users:extend"
+	permUsersModify   = "users:modify"
+	permUsersAnnounce = "users:announce"
+	permInvitesRead   = "invites:read"
+	permInvitesWrite  = "invites:write"
+	permProfilesRead  = "profiles:read"
+	permProfilesWrite = "profiles:write"
+	permConfigRead    = "config:read"
+	permConfigWrite   = "config:write"
+	permUpdates       = "updates"
+	permLogsRead      = "logs:read"
+	permBackupsRead   = "backups:read"
+	permBackupsWrite  = "backups:write"
+	// Given to every logged in admin, for routes which only affect themselves.
+	permAny = "any"
+)
+
+var permissions = []string{
+	permUsersRead, permUsersCreate, permUsersDelete, permUsersEnable, permUsersExtend, permUsersModify, permUsersAnnounce,
+	permInvitesRead, permInvitesWrite,
+	permProfilesRead, permProfilesWrite,
+	permConfigRead, permConfigWrite, permUpdates,
+	permLogsRead,
+	permBackupsRead, permBackupsWrite,
+}
+
+// routePermissions maps "<method> <route>" (without the URL base) to the permission needed to use it.
+// Routes not listed, like those for managing roles, API keys and admins, are only available to full admins.
+var routePermissions = map[string]string{
+	"GET /users":                           permUsersRead,
+	"POST /users":                          permUsersCreate,
+	"POST /users/bulk":                     permUsersCreate,
+	"DELETE /users":                        permUsersDelete,
+	"POST /users/enable":                   permUsersEnable,
+	"POST /users/extend":                   permUsersExtend,
+	"GET /users/inactivity":                permUsersRead,
+	"GET /users/export":                    permUsersRead,
+	"GET /users/scheduled":                 permUsersRead,
+	"POST /users/scheduled":                permAny, // Also needs the permission for the action, see scheduleAllowed.
+	"DELETE /users/scheduled/:id":          permAny, // Also needs the permission for the action, see scheduleAllowed.
+	"GET /renewal-codes":                   permUsersExtend,
+	"POST /renewal-codes":                  permUsersExtend,
+	"DELETE /renewal-codes/:code":          permUsersExtend,
+	"POST /users/emails":                   permUsersModify,
+	"POST /users/labels":                   permUsersModify,
+	"POST /users/settings":                 permUsersModify,
+	"POST /users/import":                   permUsersModify,
+	"POST /users/password-reset":           permUsersModify,
+	"POST /users/telegram":                 permUsersModify,
+	"POST /users/discord":                  permUsersModify,
+	"POST /users/contact":                  permUsersModify,
+	"GET /telegram/pin":                    permUsersModify,
+	"GET /telegram/verified/:pin":          permUsersModify,
+	"GET /users/discord/:username":         permUsersModify,
+	"GET /ombi/users":                      permUsersRead,
+	"POST /users/announce":                 permUsersAnnounce,
+	"GET /users/announce":                  permUsersAnnounce,
+	"POST /users/announce/template":        permUsersAnnounce,
+	"GET /users/announce/:name":            permUsersAnnounce,
+	"DELETE /users/announce/:name":         permUsersAnnounce,
+	"GET /invites":                         permInvitesRead,
+	"GET /invites/export":                  permInvitesRead,
+	"POST /invites":                        permInvitesWrite,
+	"DELETE /invites":                      permInvitesWrite,
+	"POST /invites/profile":                permInvitesWrite,
+	"POST /invites/notify":                 permInvitesWrite,
+	"GET /profiles":                        permProfilesRead,
+	"POST /profiles":                       permProfilesWrite,
+	"DELETE /profiles":                     permProfilesWrite,
+	"POST /profiles/default":               permProfilesWrite,
+	"POST /profiles/ombi/:profile":         permProfilesWrite,
+	"DELETE /profiles/ombi/:profile":       permProfilesWrite,
+	"GET /config":                          permConfigRead,
+	"GET /config/emails":                   permConfigRead,
+	"GET /config/emails/:id":               permConfigRead,
+	"POST /config":                         permConfigWrite,
+	"POST /config/emails/:id":              permConfigWrite,
+	"POST /config/emails/:id/state/:state": permConfigWrite,
+	"POST /matrix/login":                   permConfigWrite,
+	"POST /restart":                        permConfigWrite,
+	"GET /config/update":                   permUpdates,
+	"POST /config/update":                  permUpdates,
+	"GET /logs":                            permLogsRead,
+	"GET /activity":                        permLogsRead,
+	"GET /webhooks/deliveries":             permLogsRead,
+	"GET /backups":                         permBackupsRead,
+	"GET /backups/:fname":                  permBackupsRead,
+	"POST /backups":                        permBackupsWrite,
+	"POST /backups/upload":                 permBackupsWrite,
+	"POST /backups/:fname/restore":         permBackupsWrite,
+	"GET /totp":                            permAny,
+	"POST /totp/enroll":                    permAny,
+	"POST /totp/confirm":                   permAny,
+	"POST /totp/disable":                   permAny,
+	"GET /sessions":                        permAny,
+	"DELETE /sessions":                     permAny,
+	"DELETE /sessions/:id":                 permAny,
+}
+
+// Role is a named set of permissions, given to Jellyfin users to limit what they can do.
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+func validPermission(perm string) bool {
+	for _, p := range permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// accountsAdmin returns whether the Jellyfin user can log in as a full admin, by being made one in jfa-go, being a Jellyfin admin with ui.admin_only set, or through ui.allow_all.
+func (app *appContext) accountsAdmin(user mediabrowser.User) bool {
+	if app.config.Section("ui").Key("allow_all").MustBool(false) {
+		return true
+	}
+	emailStore, _ := app.storage.GetEmailsKey(user.ID)
+	return emailStore.Admin || (app.config.Section("ui").Key("admin_only").MustBool(true) && user.Policy.IsAdministrator)
+}
+
+// userPermissions returns whether the Jellyfin user is a full admin, or otherwise the permissions their roles give them.
+// Admins without roles keep the access they had before roles existed. Anyone else without roles has no access, and perms is nil.
+func (app *appContext) userPermissions(jfID string) (all bool, perms map[string]bool) {
+	emailStore, _ := app.storage.GetEmailsKey(jfID)
+	if emailStore.Admin {
+		return true, nil
+	}
+	if len(emailStore.Roles) == 0 {
+		user, status, err := app.getJFUserByID(jfID)
+		if status != 200 || err != nil {
+			app.debug.Printf("Failed to get user \"%s\" (%d): %v", jfID, status, err)
+			return false, nil
+		}
+		return app.accountsAdmin(user), nil
+	}
+	perms = map[string]bool{}
+	for _, name := range emailStore.Roles {
+		role, ok := app.storage.GetRolesKey(name)
+		if !ok {
+			continue
+		}
+		for _, perm := range role.Permissions {
+			perms[perm] = true
+		}
+	}
+	return false, perms
+}
+
+// requestFullAdmin returns whether the request in gc was made by a full admin, rather than with an API key or by someone limited by roles.
+func (app *appContext) requestFullAdmin(gc *gin.Context) bool {
+	if gc.GetString("apiKeyId") != "" {
+		return false
+	}
+	jfID := gc.GetString("jfId")
+	if jfID == "" {
+		return true
+	}
+	all, _ := app.userPermissions(jfID)
+	return all
+}
+
+// routeAllowed returns whether the Jellyfin user has the permission required for the route gc matched.
+func (app *appContext) routeAllowed(jfID string, gc *gin.Context) bool {
+	all, perms := app.userPermissions(jfID)
+	if all {
+		return true
+	} else if perms == nil {
+		return false
+	}
+	route := gc.FullPath()
+	if app.URLBase != "" && strings.HasPrefix(route, app.URLBase+"/") {
+		route = strings.TrimPrefix(route, app.URLBase)
+	}
+	perm, ok := routePermissions[gc.Request.Method+" "+route]
+	return ok && (perm == permAny || perms[perm])
+}
+
+// hasRoles returns whether the Jellyfin user has been given any roles, which lets them log in even if they aren't an admin.
+func (app *appContext) hasRoles(jfID string) bool {
+	emailStore, ok := app.storage.GetEmailsKey(jfID)
+	return ok && len(emailStore.Roles) != 0
+}
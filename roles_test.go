@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+)
+
+// newRolesTestApp returns an app with a full admin, a Jellyfin admin and a normal user without roles, one limited to reading users, and one whose only role was deleted.
+func newRolesTestApp(t *testing.T) *appContext {
+	gin.SetMode(gin.TestMode)
+	jf := httptest.NewServer(&mockJellyfin{users: []mediabrowser.User{
+		{ID: "jellyfinadmin", Policy: mediabrowser.Policy{IsAdministrator: true}},
+		{ID: "norolesuser"},
+	}})
+	t.Cleanup(jf.Close)
+	app := newTestApp(t, jf.URL)
+	app.storage.SetRolesKey("viewer", Role{Name: "viewer", Permissions: []string{permUsersRead}})
+	app.storage.SetEmailsKey("full", EmailAddress{Admin: true, Roles: []string{"viewer"}})
+	app.storage.SetEmailsKey("norolesuser", EmailAddress{})
+	app.storage.SetEmailsKey("viewer", EmailAddress{Roles: []string{"viewer"}})
+	app.storage.SetEmailsKey("deleted", EmailAddress{Roles: []string{"gone"}})
+	return app
+}
+
+func TestRouteAllowed(t *testing.T) {
+	for _, base := range []string{"", "/jfa"} {
+		app := newRolesTestApp(t)
+		app.URLBase = base
+		router := gin.New()
+		allowed := map[string]bool{}
+		handler := func(gc *gin.Context) {
+			allowed[gc.Request.Method+" "+gc.Request.URL.Path+" "+gc.Query("as")] = app.routeAllowed(gc.Query("as"), gc)
+		}
+		router.GET(base+"/users", handler)
+		router.DELETE(base+"/users", handler)
+		router.GET(base+"/sessions", handler)
+		router.GET(base+"/api-keys", handler)
+		router.GET(base+"/users/announce/:name", handler)
+		for _, c := range []struct {
+			method, path, as string
+			want             bool
+		}{
+			{"GET", "/users", "full", true},
+			{"DELETE", "/users", "full", true},
+			{"GET", "/api-keys", "full", true},
+			{"DELETE", "/users", "jellyfinadmin", true},
+			{"GET", "/api-keys", "jellyfinadmin", true},
+			{"GET", "/users", "norolesuser", false},
+			{"GET", "/sessions", "norolesuser", false},
+			{"GET", "/users", "viewer", true},
+			{"GET", "/sessions", "viewer", true},
+			{"DELETE", "/users", "viewer", false},
+			{"GET", "/users/announce/x", "viewer", false},
+			{"GET", "/api-keys", "viewer", false},
+			{"GET", "/users", "deleted", false},
+			{"GET", "/sessions", "deleted", true},
+		} {
+			path := base + c.path
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(c.method, path+"?as="+c.as, nil))
+			if got := allowed[c.method+" "+path+" "+c.as]; got != c.want {
+				t.Errorf("%s %s as %s: allowed = %t, expected %t", c.method, path, c.as, got, c.want)
+			}
+		}
+	}
+}
+
+// TestRoleDenied checks a logged in admin limited by a role gets a 403 from routes their role doesn't cover.
+func TestRoleDenied(t *testing.T) {
+	app := newRolesTestApp(t)
+	router := gin.New()
+	ok := func(gc *gin.Context) { gc.Status(200) }
+	router.GET("/users", app.webAuth("users"), ok)
+	router.DELETE("/users", app.webAuth("users"), ok)
+	router.GET("/roles", app.webAuth(""), ok)
+
+	app.users = append(app.users, User{UserID: "viewer-session"})
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Request = httptest.NewRequest("GET", "/token/login", nil)
+	token, _, err := app.createSession("viewer-session", "viewer", gc)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	for _, c := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/users", 200},
+		{"DELETE", "/users", 403},
+		{"GET", "/roles", 403},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s %s returned %d, expected %d", c.method, c.path, w.Code, c.want)
+		}
+	}
+
+	// Taking away their roles should log them out, rather than leave them with more access.
+	w := httptest.NewRecorder()
+	gc, _ = gin.CreateTestContext(w)
+	gc.Request = httptest.NewRequest("POST", "/users/roles", bytes.NewBufferString(`{"viewer":[]}`))
+	app.SetUserRoles(gc)
+	if w.Code != 200 {
+		t.Fatalf("Failed to set roles: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(w, req)
+	if w.Code != 401 {
+		t.Errorf("Expected 401 once roles were removed, got %d", w.Code)
+	}
+}
+
+// TestImportAdminDenied checks only full admins can import rows which make someone an admin.
+func TestImportAdminDenied(t *testing.T) {
+	app := newRolesTestApp(t)
+	for _, c := range []struct {
+		key, value string
+		want       bool
+	}{
+		{"jfId", "viewer", false},
+		{"apiKeyId", "key", false},
+		{"jfId", "full", true},
+		{"userId", "local", true},
+	} {
+		w := httptest.NewRecorder()
+		gc, _ := gin.CreateTestContext(w)
+		gc.Request = httptest.NewRequest("POST", "/users/import?dry_run=true", bytes.NewBufferString(`[{"user":"viewer","admin":true}]`))
+		gc.Set(c.key, c.value)
+		app.ImportUsers(gc)
+		if allowed := w.Code != 403; allowed != c.want {
+			t.Errorf("Importing an admin as %s %s returned %d", c.key, c.value, w.Code)
+		}
+	}
+}
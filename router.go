@@ -78,6 +78,11 @@ func (app *appContext) loadRouter(address string, debug bool) *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.New()
+	// Only trust X-Forwarded-For/X-Real-IP from listed proxies, so clients can't pick their own IP for rate limiting.
+	proxies := app.config.Section("advanced").Key("trusted_proxies").Strings(",")
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		app.err.Fatalf("Invalid trusted proxies: %v", err)
+	}
 
 	setGinLogger(router, debug)
 
@@ -97,6 +102,8 @@ func (app *appContext) loadRouter(address string, debug bool) *gin.Engine {
 }
 
 func (app *appContext) loadRoutes(router *gin.Engine) {
+	userPageEnabled := app.config.Section("user_page").Key("enabled").MustBool(false)
+	renewalEnabled := app.config.Section("user_expiry").Key("renewal_page").MustBool(false)
 	routePrefixes := []string{app.URLBase}
 	if app.URLBase != "" {
 		routePrefixes = append(routePrefixes, "")
@@ -116,30 +123,49 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 		router.GET(p+"/accounts", app.AdminPage)
 		router.GET(p+"/settings", app.AdminPage)
 		router.GET(p+"/lang/:page/:file", app.ServeLang)
-		router.GET(p+"/token/login", app.getTokenLogin)
+		router.GET(p+"/token/login", app.rateLimit(limitLogin), app.getTokenLogin)
 		router.GET(p+"/token/refresh", app.getTokenRefresh)
-		router.POST(p+"/newUser", app.NewUser)
+		router.POST(p+"/token/totp", app.rateLimit(limitLogin), app.getTokenTOTP)
+		if app.oidc != nil {
+			router.GET(p+"/oidc/login", app.OIDCLogin)
+			router.GET(p+"/oidc/callback", app.OIDCCallback)
+		}
+		if app.config.Section("metrics").Key("enabled").MustBool(false) {
+			router.GET(p+"/metrics", app.GetMetrics)
+		}
+		router.POST(p+"/newUser", app.rateLimit(limitSignup), app.NewUser)
 		router.Use(static.Serve(p+"/invite/", app.webFS))
 		router.GET(p+"/invite/:invCode", app.InviteProxy)
 		if app.config.Section("captcha").Key("enabled").MustBool(false) {
 			router.GET(p+"/captcha/gen/:invCode", app.GenCaptcha)
 			router.GET(p+"/captcha/img/:invCode/:captchaID", app.GetCaptcha)
-			router.POST(p+"/captcha/verify/:invCode/:captchaID/:text", app.VerifyCaptcha)
+			router.POST(p+"/captcha/verify/:invCode/:captchaID/:text", app.rateLimit(limitCaptcha), app.VerifyCaptcha)
 		}
 		if telegramEnabled {
-			router.GET(p+"/invite/:invCode/telegram/verified/:pin", app.TelegramVerifiedInvite)
+			router.GET(p+"/invite/:invCode/telegram/verified/:pin", app.rateLimit(limitPINStatus), app.TelegramVerifiedInvite)
 		}
 		if discordEnabled {
-			router.GET(p+"/invite/:invCode/discord/verified/:pin", app.DiscordVerifiedInvite)
+			router.GET(p+"/invite/:invCode/discord/verified/:pin", app.rateLimit(limitPINStatus), app.DiscordVerifiedInvite)
 			if app.config.Section("discord").Key("provide_invite").MustBool(false) {
 				router.GET(p+"/invite/:invCode/discord/invite", app.DiscordServerInvite)
 			}
 		}
 		if matrixEnabled {
-			router.GET(p+"/invite/:invCode/matrix/verified/:userID/:pin", app.MatrixCheckPIN)
-			router.POST(p+"/invite/:invCode/matrix/user", app.MatrixSendPIN)
+			router.GET(p+"/invite/:invCode/matrix/verified/:userID/:pin", app.rateLimit(limitPIN), app.MatrixCheckPIN)
+			router.POST(p+"/invite/:invCode/matrix/user", app.rateLimit(limitPIN), app.MatrixSendPIN)
 			router.POST(p+"/users/matrix", app.MatrixConnect)
 		}
+		if renewalEnabled {
+			router.GET(p+"/renew", app.RenewPage)
+			router.POST(p+"/renew", app.rateLimit(limitRenewal), app.RenewAccount)
+		}
+		if userPageEnabled {
+			router.GET(p+"/my/account", app.MyAccountPage)
+			router.GET(p+"/my/token/login", app.rateLimit(limitLogin), app.getMyTokenLogin)
+			router.GET(p+"/my/token/refresh", app.getMyTokenRefresh)
+			router.POST(p+"/my/logout", app.MyLogout)
+			router.GET(p+"/my/confirm/:key", app.ConfirmMyEmail)
+		}
 	}
 	if *SWAGGER {
 		app.info.Print(warning("\n\nWARNING: Swagger should not be used on a public instance.\n\n"))
@@ -147,64 +173,128 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 			router.GET(p+"/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 		}
 	}
-	api := router.Group("/", app.webAuth())
+	// Each group matches a resource API keys can be given scopes for, see apiKeyResources.
+	// api is only accessible to logged in admins.
+	api := router.Group("/", app.webAuth(""))
+	users := router.Group("/", app.webAuth("users"))
+	invites := router.Group("/", app.webAuth("invites"))
+	profiles := router.Group("/", app.webAuth("profiles"))
+	config := router.Group("/", app.webAuth("config"))
+	backups := router.Group("/", app.webAuth("backups"))
+	logs := router.Group("/", app.webAuth("logs"))
+	// my is only accessible with a token for the My Account page, see userpage.go.
+	my := router.Group("/", app.userAuth())
 	for _, p := range routePrefixes {
 		router.POST(p+"/logout", app.Logout)
-		api.DELETE(p+"/users", app.DeleteUsers)
-		api.GET(p+"/users", app.GetUsers)
-		api.POST(p+"/users", app.NewUserAdmin)
-		api.POST(p+"/users/extend", app.ExtendExpiry)
-		api.POST(p+"/users/enable", app.EnableDisableUsers)
-		api.POST(p+"/invites", app.GenerateInvite)
-		api.GET(p+"/invites", app.GetInvites)
-		api.DELETE(p+"/invites", app.DeleteInvite)
-		api.POST(p+"/invites/profile", app.SetProfile)
-		api.GET(p+"/profiles", app.GetProfiles)
-		api.POST(p+"/profiles/default", app.SetDefaultProfile)
-		api.POST(p+"/profiles", app.CreateProfile)
-		api.DELETE(p+"/profiles", app.DeleteProfile)
-		api.POST(p+"/invites/notify", app.SetNotify)
-		api.POST(p+"/users/emails", app.ModifyEmails)
-		api.POST(p+"/users/labels", app.ModifyLabels)
+		users.DELETE(p+"/users", app.DeleteUsers)
+		users.GET(p+"/users", app.GetUsers)
+		users.POST(p+"/users", app.NewUserAdmin)
+		users.POST(p+"/users/extend", app.ExtendExpiry)
+		users.POST(p+"/users/enable", app.EnableDisableUsers)
+		users.GET(p+"/users/inactivity", app.GetInactivityPreview)
+		users.GET(p+"/users/scheduled", app.GetScheduledActions)
+		users.POST(p+"/users/scheduled", app.ScheduleAction)
+		users.DELETE(p+"/users/scheduled/:id", app.CancelScheduledAction)
+		users.POST(p+"/users/import", app.ImportUsers)
+		users.POST(p+"/users/bulk", app.NewUsersAdmin)
+		users.GET(p+"/users/export", app.ExportUsers)
+		users.GET(p+"/renewal-codes", app.GetRenewalCodes)
+		users.POST(p+"/renewal-codes", app.CreateRenewalCode)
+		users.DELETE(p+"/renewal-codes/:code", app.DeleteRenewalCode)
+		invites.POST(p+"/invites", app.GenerateInvite)
+		invites.GET(p+"/invites", app.GetInvites)
+		invites.GET(p+"/invites/export", app.ExportInvites)
+		invites.DELETE(p+"/invites", app.DeleteInvite)
+		invites.POST(p+"/invites/profile", app.SetProfile)
+		profiles.GET(p+"/profiles", app.GetProfiles)
+		profiles.POST(p+"/profiles/default", app.SetDefaultProfile)
+		profiles.POST(p+"/profiles", app.CreateProfile)
+		profiles.DELETE(p+"/profiles", app.DeleteProfile)
+		invites.POST(p+"/invites/notify", app.SetNotify)
+		users.POST(p+"/users/emails", app.ModifyEmails)
+		users.POST(p+"/users/labels", app.ModifyLabels)
 		api.POST(p+"/users/accounts-admin", app.SetAccountsAdmin)
 		// api.POST(p + "/setDefaults", app.SetDefaults)
-		api.POST(p+"/users/settings", app.ApplySettings)
-		api.POST(p+"/users/announce", app.Announce)
+		users.POST(p+"/users/settings", app.ApplySettings)
+		users.POST(p+"/users/announce", app.Announce)
 
-		api.GET(p+"/users/announce", app.GetAnnounceTemplates)
-		api.POST(p+"/users/announce/template", app.SaveAnnounceTemplate)
-		api.GET(p+"/users/announce/:name", app.GetAnnounceTemplate)
-		api.DELETE(p+"/users/announce/:name", app.DeleteAnnounceTemplate)
+		users.GET(p+"/users/announce", app.GetAnnounceTemplates)
+		users.POST(p+"/users/announce/template", app.SaveAnnounceTemplate)
+		users.GET(p+"/users/announce/:name", app.GetAnnounceTemplate)
+		users.DELETE(p+"/users/announce/:name", app.DeleteAnnounceTemplate)
 
-		api.POST(p+"/users/password-reset", app.AdminPasswordReset)
+		users.POST(p+"/users/password-reset", app.AdminPasswordReset)
 
-		api.GET(p+"/config/update", app.CheckUpdate)
-		api.POST(p+"/config/update", app.ApplyUpdate)
-		api.GET(p+"/config/emails", app.GetCustomEmails)
-		api.GET(p+"/config/emails/:id", app.GetCustomEmailTemplate)
-		api.POST(p+"/config/emails/:id", app.SetCustomEmail)
-		api.POST(p+"/config/emails/:id/state/:state", app.SetCustomEmailState)
-		api.GET(p+"/config", app.GetConfig)
-		api.POST(p+"/config", app.ModifyConfig)
-		api.POST(p+"/restart", app.restart)
-		api.GET(p+"/logs", app.GetLog)
+		config.GET(p+"/config/update", app.CheckUpdate)
+		config.POST(p+"/config/update", app.ApplyUpdate)
+		config.GET(p+"/config/emails", app.GetCustomEmails)
+		config.GET(p+"/config/emails/:id", app.GetCustomEmailTemplate)
+		config.POST(p+"/config/emails/:id", app.SetCustomEmail)
+		config.POST(p+"/config/emails/:id/state/:state", app.SetCustomEmailState)
+		config.GET(p+"/config", app.GetConfig)
+		config.POST(p+"/config", app.ModifyConfig)
+		config.POST(p+"/restart", app.restart)
+		logs.GET(p+"/logs", app.GetLog)
+		backups.GET(p+"/backups", app.GetBackups)
+		backups.POST(p+"/backups", app.CreateBackup)
+		backups.POST(p+"/backups/upload", app.UploadBackup)
+		backups.GET(p+"/backups/:fname", app.GetBackup)
+		backups.POST(p+"/backups/:fname/restore", app.RestoreBackup)
+		logs.GET(p+"/webhooks/deliveries", app.GetWebhookDeliveries)
+		logs.GET(p+"/activity", app.GetActivity)
+		api.GET(p+"/api-keys", app.GetAPIKeys)
+		api.POST(p+"/api-keys", app.CreateAPIKey)
+		api.DELETE(p+"/api-keys/:id", app.DeleteAPIKey)
+		api.GET(p+"/roles", app.GetRoles)
+		api.POST(p+"/roles", app.SetRole)
+		api.DELETE(p+"/roles/:name", app.DeleteRole)
+		api.POST(p+"/users/roles", app.SetUserRoles)
+		api.GET(p+"/totp", app.GetTOTP)
+		api.POST(p+"/totp/enroll", app.EnrollTOTP)
+		api.POST(p+"/totp/confirm", app.ConfirmTOTP)
+		api.POST(p+"/totp/disable", app.DisableTOTP)
+		api.DELETE(p+"/totp/:id", app.ResetTOTP)
+		api.GET(p+"/sessions", app.GetSessions)
+		api.DELETE(p+"/sessions", app.RevokeAllSessions)
+		api.DELETE(p+"/sessions/:id", app.RevokeSession)
+		api.POST(p+"/sessions/rotate-secret", app.RotateSecret)
+		api.GET(p+"/bans", app.GetBans)
+		api.DELETE(p+"/bans", app.DeleteBans)
 		if telegramEnabled || discordEnabled || matrixEnabled {
-			api.GET(p+"/telegram/pin", app.TelegramGetPin)
-			api.GET(p+"/telegram/verified/:pin", app.TelegramVerified)
-			api.POST(p+"/users/telegram", app.TelegramAddUser)
-			api.POST(p+"/users/contact", app.SetContactMethods)
+			users.GET(p+"/telegram/pin", app.TelegramGetPin)
+			users.GET(p+"/telegram/verified/:pin", app.TelegramVerified)
+			users.POST(p+"/users/telegram", app.TelegramAddUser)
+			users.POST(p+"/users/contact", app.SetContactMethods)
 		}
 		if discordEnabled {
-			api.GET(p+"/users/discord/:username", app.DiscordGetUsers)
-			api.POST(p+"/users/discord", app.DiscordConnect)
+			users.GET(p+"/users/discord/:username", app.DiscordGetUsers)
+			users.POST(p+"/users/discord", app.DiscordConnect)
 		}
 		if app.config.Section("ombi").Key("enabled").MustBool(false) {
-			api.GET(p+"/ombi/users", app.OmbiUsers)
-			api.POST(p+"/profiles/ombi/:profile", app.SetOmbiProfile)
-			api.DELETE(p+"/profiles/ombi/:profile", app.DeleteOmbiProfile)
+			users.GET(p+"/ombi/users", app.OmbiUsers)
+			profiles.POST(p+"/profiles/ombi/:profile", app.SetOmbiProfile)
+			profiles.DELETE(p+"/profiles/ombi/:profile", app.DeleteOmbiProfile)
 		}
-		api.POST(p+"/matrix/login", app.MatrixLogin)
+		config.POST(p+"/matrix/login", app.MatrixLogin)
 
+		if userPageEnabled {
+			my.GET(p+"/my/details", app.MyDetails)
+			my.POST(p+"/my/email", app.SetMyEmail)
+			my.POST(p+"/my/contact", app.SetMyContactMethods)
+			my.POST(p+"/my/password", app.rateLimit(limitLogin), app.ChangeMyPassword)
+			my.POST(p+"/my/link/:method", app.rateLimit(limitPIN), app.LinkMyContactMethod)
+			my.DELETE(p+"/my/link/:method", app.UnlinkMyContactMethod)
+			my.GET(p+"/my/referrals", app.GetMyReferrals)
+			my.POST(p+"/my/referrals", app.CreateMyReferral)
+			if telegramEnabled || discordEnabled {
+				my.GET(p+"/my/pin/:method", app.GetMyPIN)
+				my.GET(p+"/my/pin/:method/verified/:pin", app.rateLimit(limitPINStatus), app.MyPINVerified)
+			}
+			if matrixEnabled {
+				my.POST(p+"/my/matrix/user", app.rateLimit(limitPIN), app.MySendMatrixPIN)
+				my.GET(p+"/my/matrix/verified/:userID/:pin", app.rateLimit(limitPIN), app.MyMatrixCheckPIN)
+			}
+		}
 	}
 }
 
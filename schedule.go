@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// scheduledActionRoutes maps the actions which can be scheduled to the route they run, which also decides the permission needed to schedule them.
+var scheduledActionRoutes = map[string]string{
+	"enable_disable": "POST /users/enable",
+	"delete":         "DELETE /users",
+	"extend":         "POST /users/extend",
+	"announce":       "POST /users/announce",
+}
+
+// ScheduledAction is a request to one of scheduledActionRoutes, stored until it's due.
+type ScheduledAction struct {
+	ID        string            `json:"id"`
+	Action    string            `json:"action"`
+	Request   json.RawMessage   `json:"request"` // Body the route is called with.
+	RunAt     time.Time         `json:"run_at"`
+	Created   time.Time         `json:"created"`
+	CreatedBy string            `json:"created_by"`
+	Auth      map[string]string `json:"auth"` // Authentication context of whoever scheduled it (see requestAuth), so the action is checked against and logged as theirs.
+}
+
+// targets returns the user IDs the action applies to.
+func (action ScheduledAction) targets() []string {
+	var req struct {
+		Users []string `json:"users"`
+	}
+	json.Unmarshal(action.Request, &req)
+	return req.Users
+}
+
+// runScheduledAction does what the action's route would with its stored request, if whoever scheduled it is still allowed to.
+func (app *appContext) runScheduledAction(action ScheduledAction) {
+	if !app.scheduleAllowed(action.Auth, action.Action) {
+		app.err.Printf("Dropped scheduled action \"%s\" (%s), as whoever scheduled it can no longer do so", action.ID, action.Action)
+		return
+	}
+	app.info.Printf("Running scheduled action \"%s\" (%s)", action.ID, action.Action)
+	var err error
+	switch action.Action {
+	case "enable_disable":
+		var req enableDisableUserDTO
+		if err = json.Unmarshal(action.Request, &req); err == nil {
+			if failed := app.enableDisableUsers(req, action.Auth); len(failed["GetUser"]) != 0 || len(failed["SetPolicy"]) != 0 {
+				err = fmt.Errorf("%v", failed)
+			}
+		}
+	case "delete":
+		var req deleteUserDTO
+		if err = json.Unmarshal(action.Request, &req); err == nil {
+			if failed := app.deleteUsers(req, action.Auth); len(failed) != 0 {
+				err = fmt.Errorf("%v", failed)
+			}
+		}
+	case "extend":
+		var req extendExpiryDTO
+		if err = json.Unmarshal(action.Request, &req); err == nil {
+			err = app.extendExpiry(req, action.Auth)
+		}
+	case "announce":
+		var req announcementDTO
+		if err = json.Unmarshal(action.Request, &req); err == nil {
+			err = app.announce(req)
+		}
+	}
+	if err != nil {
+		app.err.Printf("Scheduled action \"%s\" (%s) failed: %v", action.ID, action.Action, err)
+	}
+}
+
+// scheduleDaemon runs scheduled actions once they're due, including any which came due while jfa-go wasn't running.
+type scheduleDaemon struct {
+	Stopped         bool
+	ShutdownChannel chan string
+	Interval        time.Duration
+	period          time.Duration
+	app             *appContext
+}
+
+func newScheduleDaemon(interval time.Duration, app *appContext) *scheduleDaemon {
+	return &scheduleDaemon{
+		Stopped:         false,
+		ShutdownChannel: make(chan string),
+		Interval:        interval,
+		period:          interval,
+		app:             app,
+	}
+}
+
+func (rt *scheduleDaemon) run() {
+	rt.app.info.Println("Schedule daemon started")
+	for {
+		select {
+		case <-rt.ShutdownChannel:
+			rt.ShutdownChannel <- "Down"
+			return
+		case <-time.After(rt.period):
+			break
+		}
+		started := time.Now()
+		due := []ScheduledAction{}
+		for _, action := range rt.app.storage.GetScheduledActions() {
+			if !action.RunAt.After(started) {
+				due = append(due, action)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+		for _, action := range due {
+			// Removed first, so a failure or crash doesn't run it again.
+			rt.app.storage.DeleteScheduledActionsKey(action.ID)
+			if err := rt.app.storage.storeScheduledActions(); err != nil {
+				rt.app.err.Printf("Failed to store scheduled actions: %v", err)
+			}
+			rt.app.runScheduledAction(action)
+		}
+		finished := time.Now()
+		duration := finished.Sub(started)
+		rt.period = rt.Interval - duration
+	}
+}
+
+func (rt *scheduleDaemon) shutdown() {
+	rt.Stopped = true
+	rt.ShutdownChannel <- "Down"
+	<-rt.ShutdownChannel
+	close(rt.ShutdownChannel)
+}
+
+// scheduleAllowed returns whether whoever auth identifies (see requestAuth) could call the action's route directly.
+// Also checked when the action runs, in case their API key was deleted, or they've lost admin or their roles changed since.
+func (app *appContext) scheduleAllowed(auth map[string]string, action string) bool {
+	if keyID := auth["apiKeyId"]; keyID != "" {
+		key, ok := app.storage.GetAPIKeysKey(keyID)
+		if !ok || (!key.Expiry.IsZero() && key.Expiry.Before(time.Now())) {
+			return false
+		}
+		// Every action's route is in the "users" group, see router.go.
+		return key.allows("users", strings.SplitN(scheduledActionRoutes[action], " ", 2)[0])
+	}
+	jfID := auth["jfId"]
+	if jfID == "" {
+		return true
+	}
+	// The same check as logging in, see getTokenLogin.
+	user, status, err := app.getJFUserByID(jfID)
+	if status != 200 || err != nil || !(app.accountsAdmin(user) || app.hasRoles(jfID)) {
+		return false
+	}
+	all, perms := app.userPermissions(jfID)
+	return all || perms[routePermissions[scheduledActionRoutes[action]]]
+}
+
+// @Summary Returns the actions scheduled to run later, soonest first.
+// @Produce json
+// @Success 200 {object} getScheduledActionsDTO
+// @Router /users/scheduled [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetScheduledActions(gc *gin.Context) {
+	actions := app.storage.GetScheduledActions()
+	resp := getScheduledActionsDTO{Actions: make([]ScheduledActionDTO, 0, len(actions))}
+	for _, action := range actions {
+		resp.Actions = append(resp.Actions, ScheduledActionDTO{
+			ID:        action.ID,
+			Action:    action.Action,
+			Request:   action.Request,
+			RunAt:     action.RunAt.Unix(),
+			Created:   action.Created.Unix(),
+			CreatedBy: action.CreatedBy,
+		})
+	}
+	sort.Slice(resp.Actions, func(i, j int) bool { return resp.Actions[i].RunAt < resp.Actions[j].RunAt })
+	gc.JSON(200, resp)
+}
+
+// @Summary Schedules an enable/disable, deletion, expiry extension or announcement to run later. The request is the same as the action's own route takes.
+// @Produce json
+// @Param scheduleActionDTO body scheduleActionDTO true "Action, time and request."
+// @Success 200 {object} stringResponse
+// @Failure 400 {object} stringResponse
+// @Failure 403 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /users/scheduled [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) ScheduleAction(gc *gin.Context) {
+	var req scheduleActionDTO
+	gc.BindJSON(&req)
+	if _, ok := scheduledActionRoutes[req.Action]; !ok {
+		respond(400, "Unknown action", gc)
+		return
+	}
+	if !app.scheduleAllowed(requestAuth(gc), req.Action) {
+		respond(403, "Forbidden", gc)
+		return
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(req.Request, &body); err != nil {
+		respond(400, "Invalid request", gc)
+		return
+	}
+	action := ScheduledAction{
+		ID:      shortuuid.New(),
+		Action:  req.Action,
+		Request: req.Request,
+		RunAt:   time.Unix(req.RunAt, 0),
+		Created: time.Now(),
+		Auth:    requestAuth(gc),
+	}
+	if !action.RunAt.After(action.Created) {
+		respond(400, "Time is in the past", gc)
+		return
+	}
+	if len(action.targets()) == 0 {
+		respond(400, "No users given", gc)
+		return
+	}
+	_, action.CreatedBy = app.requestActor(gc)
+	app.storage.SetScheduledActionsKey(action.ID, action)
+	if err := app.storage.storeScheduledActions(); err != nil {
+		app.err.Printf("Failed to store scheduled actions: %v", err)
+		respond(500, "Couldn't store action", gc)
+		return
+	}
+	app.info.Printf("Scheduled action \"%s\" (%s) for %s", action.ID, action.Action, app.formatDatetime(action.RunAt))
+	app.adminActivity(gc, activityActionScheduled, action.targets(), action.Action+" at "+app.formatDatetime(action.RunAt))
+	respond(200, action.ID, gc)
+}
+
+// @Summary Cancels a scheduled action which hasn't run yet.
+// @Produce json
+// @Param id path string true "ID of the scheduled action."
+// @Success 200 {object} boolResponse
+// @Failure 403 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /users/scheduled/{id} [delete]
+// @Security Bearer
+// @tags Users
+func (app *appContext) CancelScheduledAction(gc *gin.Context) {
+	id := gc.Param("id")
+	action, ok := app.storage.GetScheduledActionsKey(id)
+	if !ok {
+		respondBool(404, false, gc)
+		return
+	}
+	if !app.scheduleAllowed(requestAuth(gc), action.Action) {
+		respondBool(403, false, gc)
+		return
+	}
+	app.storage.DeleteScheduledActionsKey(id)
+	if err := app.storage.storeScheduledActions(); err != nil {
+		app.err.Printf("Failed to store scheduled actions: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Printf("Cancelled scheduled action \"%s\" (%s)", id, action.Action)
+	app.adminActivity(gc, activityScheduledActionCancelled, action.targets(), action.Action)
+	respondBool(200, true, gc)
+}
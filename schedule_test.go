@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+// TestRunScheduledAction checks actions run as whoever scheduled them, and are dropped if they've since lost access.
+func TestRunScheduledAction(t *testing.T) {
+	mock := &mockJellyfin{users: []mediabrowser.User{
+		{ID: "admin"},
+		{ID: "jellyfinadmin", Policy: mediabrowser.Policy{IsAdministrator: true}},
+	}}
+	jf := httptest.NewServer(mock)
+	defer jf.Close()
+	app := newTestApp(t, jf.URL)
+	key, _, _ := newAPIKey("script", []string{"users:write"}, time.Time{})
+	app.storage.SetAPIKeysKey(key.ID, key)
+	app.storage.SetRolesKey("extender", Role{Name: "extender", Permissions: []string{permUsersExtend}})
+	app.storage.SetEmailsKey("admin", EmailAddress{Roles: []string{"extender"}})
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	extend := func(user string, auth map[string]string) bool {
+		t.Helper()
+		app.storage.SetUsersKey(user, expiry)
+		app.runScheduledAction(ScheduledAction{
+			ID:      user,
+			Action:  "extend",
+			Request: []byte(`{"users":["` + user + `"],"days":1}`),
+			Auth:    auth,
+		})
+		got, _ := app.storage.GetUsersKey(user)
+		return got.Equal(expiry.AddDate(0, 0, 1))
+	}
+	if !extend("a", map[string]string{"apiKeyId": key.ID}) {
+		t.Error("Expected an action scheduled with a valid key to run")
+	}
+	if log := app.getActivity(); len(log) != 1 || log[0].ActorType != actorAPIKey || log[0].ActorID != key.ID {
+		t.Errorf("Expected the action to be logged as the key's, got %+v", log)
+	}
+	if !extend("b", map[string]string{"jfId": "admin"}) {
+		t.Error("Expected an action scheduled by an admin with the permission to run")
+	}
+	if !extend("e", map[string]string{"jfId": "jellyfinadmin"}) {
+		t.Error("Expected an action scheduled by a Jellyfin admin to run")
+	}
+
+	app.storage.DeleteAPIKeysKey(key.ID)
+	if extend("c", map[string]string{"apiKeyId": key.ID}) {
+		t.Error("Expected an action scheduled with a deleted key to be dropped")
+	}
+	app.storage.SetRolesKey("extender", Role{Name: "extender", Permissions: []string{permUsersRead}})
+	if extend("d", map[string]string{"jfId": "admin"}) {
+		t.Error("Expected an action scheduled by an admin who lost the permission to be dropped")
+	}
+	mock.lock.Lock()
+	mock.users[1].Policy.IsAdministrator = false
+	mock.lock.Unlock()
+	if extend("f", map[string]string{"jfId": "jellyfinadmin"}) {
+		t.Error("Expected an action scheduled by someone who's no longer a Jellyfin admin to be dropped")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+const (
+	// File in the data directory the token signing secret is kept in, so sessions survive restarts.
+	secretFile = "jwt_secret"
+	// Sessions last as long as their refresh token, and are extended each time it's used.
+	sessionLength = 24 * time.Hour
+)
+
+// Session is a login, identified by the "sid" claim of its tokens. Deleting it logs it out.
+type Session struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"` // Account key, as given by totpAccount.
+	UserID    string    `json:"user_id"`
+	JFID      string    `json:"jf_id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"`
+	Expiry    time.Time `json:"expiry"`
+	Secret    string    `json:"secret"` // Fingerprint of the signing secret the session's tokens were made with.
+}
+
+func secretFingerprint(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:8])
+}
+
+// loadSecret reads the token signing secret from the data directory, generating it if it doesn't exist.
+func (app *appContext) loadSecret() (string, error) {
+	path := filepath.Join(app.dataPath, secretFile)
+	if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) != 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	return app.rotateSecret()
+}
+
+// rotateSecret generates and saves a new signing secret, invalidating every token made with the old one.
+func (app *appContext) rotateSecret() (string, error) {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(app.dataPath, secretFile), []byte(secret), 0600); err != nil {
+		return "", err
+	}
+	os.Setenv("JFA_SECRET", secret)
+	app.pruneSessions()
+	return secret, nil
+}
+
+// pruneSessions removes expired sessions, and those made with a different signing secret.
+func (app *appContext) pruneSessions() {
+	fingerprint := secretFingerprint(os.Getenv("JFA_SECRET"))
+	now := time.Now()
+	changed := false
+	for id, session := range app.storage.GetSessions() {
+		if session.Expiry.Before(now) || session.Secret != fingerprint {
+			app.storage.DeleteSessionsKey(id)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := app.storage.storeSessions(); err != nil {
+		app.err.Printf("Failed to store sessions: %v", err)
+	}
+}
+
+// createSession starts a session for a user whose credentials have been checked, and returns its tokens.
+func (app *appContext) createSession(userID, jfID string, gc *gin.Context) (token, refresh string, err error) {
+	owner, username := app.totpAccount(userID, jfID)
+	now := time.Now()
+	session := Session{
+		ID:        shortuuid.New(),
+		Owner:     owner,
+		UserID:    userID,
+		JFID:      jfID,
+		Username:  username,
+		IP:        gc.ClientIP(),
+		UserAgent: gc.Request.UserAgent(),
+		Created:   now,
+		LastUsed:  now,
+		Expiry:    now.Add(sessionLength),
+		Secret:    secretFingerprint(os.Getenv("JFA_SECRET")),
+	}
+	token, refresh, err = CreateToken(userID, jfID, session.ID)
+	if err != nil {
+		return
+	}
+	app.storage.SetSessionsKey(session.ID, session)
+	app.pruneSessions()
+	err = app.storage.storeSessions()
+	return
+}
+
+// refreshSession extends a session when its refresh token is used, and returns new tokens.
+func (app *appContext) refreshSession(session Session, gc *gin.Context) (token, refresh string, err error) {
+	token, refresh, err = CreateToken(session.UserID, session.JFID, session.ID)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	session.IP = gc.ClientIP()
+	session.UserAgent = gc.Request.UserAgent()
+	session.LastUsed = now
+	session.Expiry = now.Add(sessionLength)
+	app.storage.SetSessionsKey(session.ID, session)
+	// User IDs are only kept in memory, so those from before a restart are added back.
+	user := User{UserID: session.UserID, Username: session.Username}
+	if strings.HasPrefix(session.Owner, oidcOwnerPrefix) {
+		user.Subject = strings.TrimPrefix(session.Owner, oidcOwnerPrefix)
+	}
+	app.addUser(user)
+	err = app.storage.storeSessions()
+	return
+}
+
+// validSession returns the session with the given ID, if it exists and hasn't expired.
+func (app *appContext) validSession(id string) (Session, bool) {
+	session, ok := app.storage.GetSessionsKey(id)
+	if !ok || session.Expiry.Before(time.Now()) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+func (app *appContext) revokeSessions(ids ...string) error {
+	for _, id := range ids {
+		app.storage.DeleteSessionsKey(id)
+	}
+	return app.storage.storeSessions()
+}
+
+// revokeUserSessions logs out every session of the given Jellyfin users, e.g. once their roles change.
+func (app *appContext) revokeUserSessions(jfIDs ...string) error {
+	users := map[string]bool{}
+	for _, id := range jfIDs {
+		users[id] = true
+	}
+	ids := []string{}
+	for id, session := range app.storage.GetSessions() {
+		if session.JFID != "" && users[session.JFID] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return app.revokeSessions(ids...)
+}
+
+// ownSessions returns the sessions belonging to the same account as the current request, newest first.
+func (app *appContext) ownSessions(gc *gin.Context) []Session {
+	current, _ := app.storage.GetSessionsKey(gc.GetString("sessionId"))
+	sessions := []Session{}
+	if current.Owner == "" {
+		return sessions
+	}
+	for _, session := range app.storage.GetSessions() {
+		if session.Owner == current.Owner && session.Expiry.After(time.Now()) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Created.After(sessions[j].Created) })
+	return sessions
+}
+
+// @Summary Returns the logged in admin's active sessions.
+// @Produce json
+// @Success 200 {object} getSessionsDTO
+// @Router /sessions [get]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) GetSessions(gc *gin.Context) {
+	sessions := app.ownSessions(gc)
+	resp := getSessionsDTO{Sessions: make([]SessionDTO, len(sessions))}
+	for i, session := range sessions {
+		resp.Sessions[i] = SessionDTO{
+			ID:        session.ID,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			Created:   session.Created.Unix(),
+			LastUsed:  session.LastUsed.Unix(),
+			Expiry:    session.Expiry.Unix(),
+			Current:   session.ID == gc.GetString("sessionId"),
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Logs out one of the logged in admin's sessions.
+// @Produce json
+// @Param id path string true "ID of the session."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /sessions/{id} [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) RevokeSession(gc *gin.Context) {
+	id := gc.Param("id")
+	for _, session := range app.ownSessions(gc) {
+		if session.ID != id {
+			continue
+		}
+		if err := app.revokeSessions(id); err != nil {
+			app.err.Printf("Failed to store sessions: %v", err)
+			respondBool(500, false, gc)
+			return
+		}
+		app.info.Printf("Session revoked for \"%s\"", session.Username)
+		respondBool(200, true, gc)
+		return
+	}
+	respondBool(404, false, gc)
+}
+
+// @Summary Logs out all of the logged in admin's sessions, including the current one.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /sessions [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) RevokeAllSessions(gc *gin.Context) {
+	sessions := app.ownSessions(gc)
+	ids := make([]string, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+	if err := app.revokeSessions(ids...); err != nil {
+		app.err.Printf("Failed to store sessions: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Printf("Revoked %d session(s)", len(ids))
+	gc.SetCookie("refresh", "invalid", -1, "/", gc.Request.URL.Hostname(), true, true)
+	respondBool(200, true, gc)
+}
+
+// @Summary Generates a new token signing secret, logging out every admin, including the current one.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /sessions/rotate-secret [post]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) RotateSecret(gc *gin.Context) {
+	if _, err := app.rotateSecret(); err != nil {
+		app.err.Printf("Failed to rotate secret: %v", err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Println("Token signing secret rotated, all sessions logged out")
+	app.adminActivity(gc, activitySecretRotated, nil, "")
+	gc.SetCookie("refresh", "invalid", -1, "/", gc.Request.URL.Hostname(), true, true)
+	respondBool(200, true, gc)
+}
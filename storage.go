@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,23 +18,39 @@ import (
 )
 
 type Storage struct {
-	timePattern                                                                                                                                                                                                          string
-	invite_path, emails_path, policy_path, configuration_path, displayprefs_path, ombi_path, profiles_path, customEmails_path, users_path, telegram_path, discord_path, matrix_path, announcements_path, matrix_sql_path string
-	users                                                                                                                                                                                                                map[string]time.Time
-	invites                                                                                                                                                                                                              Invites
-	profiles                                                                                                                                                                                                             map[string]Profile
-	defaultProfile                                                                                                                                                                                                       string
-	displayprefs, ombi_template                                                                                                                                                                                          map[string]interface{}
-	emails                                                                                                                                                                                                               map[string]EmailAddress
-	telegram                                                                                                                                                                                                             map[string]TelegramUser // Map of Jellyfin User IDs to telegram users.
-	discord                                                                                                                                                                                                              map[string]DiscordUser  // Map of Jellyfin user IDs to discord users.
-	matrix                                                                                                                                                                                                               map[string]MatrixUser   // Map of Jellyfin user IDs to Matrix users.
-	customEmails                                                                                                                                                                                                         customEmails
-	policy                                                                                                                                                                                                               mediabrowser.Policy
-	configuration                                                                                                                                                                                                        mediabrowser.Configuration
-	lang                                                                                                                                                                                                                 Lang
-	announcements                                                                                                                                                                                                        map[string]announcementTemplate
-	invitesLock, usersLock                                                                                                                                                                                               sync.Mutex
+	timePattern                                                                                                                                                                                                                                                                                                                       string
+	invite_path, emails_path, policy_path, configuration_path, displayprefs_path, ombi_path, profiles_path, customEmails_path, users_path, telegram_path, discord_path, matrix_path, announcements_path, matrix_sql_path                                                                                                              string
+	users                                                                                                                                                                                                                                                                                                                             map[string]time.Time
+	invites                                                                                                                                                                                                                                                                                                                           Invites
+	profiles                                                                                                                                                                                                                                                                                                                          map[string]Profile
+	defaultProfile                                                                                                                                                                                                                                                                                                                    string
+	displayprefs, ombi_template                                                                                                                                                                                                                                                                                                       map[string]interface{}
+	emails                                                                                                                                                                                                                                                                                                                            map[string]EmailAddress
+	telegram                                                                                                                                                                                                                                                                                                                          map[string]TelegramUser // Map of Jellyfin User IDs to telegram users.
+	discord                                                                                                                                                                                                                                                                                                                           map[string]DiscordUser  // Map of Jellyfin user IDs to discord users.
+	matrix                                                                                                                                                                                                                                                                                                                            map[string]MatrixUser   // Map of Jellyfin user IDs to Matrix users.
+	customEmails                                                                                                                                                                                                                                                                                                                      customEmails
+	policy                                                                                                                                                                                                                                                                                                                            mediabrowser.Policy
+	configuration                                                                                                                                                                                                                                                                                                                     mediabrowser.Configuration
+	lang                                                                                                                                                                                                                                                                                                                              Lang
+	announcements                                                                                                                                                                                                                                                                                                                     map[string]announcementTemplate
+	webhookDeliveries                                                                                                                                                                                                                                                                                                                 map[string]WebhookDelivery
+	activity                                                                                                                                                                                                                                                                                                                          map[string]Activity
+	apiKeys                                                                                                                                                                                                                                                                                                                           map[string]APIKey
+	roles                                                                                                                                                                                                                                                                                                                             map[string]Role
+	totp                                                                                                                                                                                                                                                                                                                              map[string]TOTPAccount
+	sessions                                                                                                                                                                                                                                                                                                                          map[string]Session
+	renewalCodes                                                                                                                                                                                                                                                                                                                      map[string]RenewalCode
+	expiredUsers                                                                                                                                                                                                                                                                                                                      map[string]ExpiredUser     // Map of Jellyfin user IDs to users disabled on expiry.
+	expiryReminders                                                                                                                                                                                                                                                                                                                   map[string]SentReminders   // Map of Jellyfin user IDs to the reminders sent before their current expiry.
+	inactiveUsers                                                                                                                                                                                                                                                                                                                     map[string]InactiveUser    // Map of Jellyfin user IDs to their progress through the inactivity policy.
+	scheduledActions                                                                                                                                                                                                                                                                                                                  map[string]ScheduledAction // Map of IDs to actions waiting to run.
+	userInvites                                                                                                                                                                                                                                                                                                                       map[string]UserInvite      // Map of Jellyfin user IDs to the invite they signed up with.
+	referrers                                                                                                                                                                                                                                                                                                                         map[string]Referrer        // Map of Jellyfin user IDs to the referral invites they\'ve created.
+	invitesLock, usersLock, emailsLock, telegramLock, discordLock, matrixLock, profilesLock, announcementsLock, webhookDeliveriesLock, activityLock, apiKeysLock, rolesLock, totpLock, sessionsLock, renewalCodesLock, expiredUsersLock, expiryRemindersLock, inactiveUsersLock, scheduledActionsLock, userInvitesLock, referrersLock sync.RWMutex
+	storeLock                                                                                                                                                                                                                                                                                                                         sync.Mutex // Held while writing to the backend, so a store can't be overwritten by an older copy.
+	activityAppended                                                                                                                                                                                                                                                                                                                  int        // Number of activity log entries appended since the whole log was last stored.
+	backend                                                                                                                                                                                                                                                                                                                           StorageBackend
 }
 
 type TelegramUser struct {
@@ -55,7 +73,9 @@ type EmailAddress struct {
 	Addr    string
 	Label   string // User Label.
 	Contact bool
-	Admin   bool // Whether or not user is jfa-go admin.
+	Admin   bool     // Whether or not user is jfa-go admin.
+	Roles   []string `json:",omitempty"` // Names of roles limiting what the user can do, if not Admin.
+	Profile string   `json:",omitempty"` // Profile last applied to the user, when created from an invite or by applying settings.
 }
 
 type customEmails struct {
@@ -69,6 +89,9 @@ type customEmails struct {
 	WelcomeEmail      customEmail `json:"welcomeEmail"`
 	EmailConfirmation customEmail `json:"emailConfirmation"`
 	UserExpired       customEmail `json:"userExpired"`
+	ExpiryReminder    customEmail `json:"expiryReminder"`
+	DeletionNotice    customEmail `json:"deletionNotice"`
+	InactivityWarning customEmail `json:"inactivityWarning"`
 }
 
 type customEmail struct {
@@ -103,12 +126,24 @@ type Invite struct {
 	UserMinutes   int       `json:"user-minutes,omitempty"`
 	SendTo        string    `json:"email"`
 	// Used to be stored as formatted time, now as Unix.
-	UsedBy   [][]string                 `json:"used-by"`
-	Notify   map[string]map[string]bool `json:"notify"`
-	Profile  string                     `json:"profile"`
-	Label    string                     `json:"label,omitempty"`
-	Keys     []string                   `json:"keys,omitempty"`
-	Captchas map[string]*captcha.Data   // Map of Captcha IDs to answers
+	UsedBy     [][]string                 `json:"used-by"`
+	Notify     map[string]map[string]bool `json:"notify"`
+	Profile    string                     `json:"profile"`
+	Label      string                     `json:"label,omitempty"`
+	Keys       []string                   `json:"keys,omitempty"`
+	Captchas   map[string]*captcha.Data   // Map of Captcha IDs to answers
+	CreatedBy  string                     `json:"created_by,omitempty"`  // Name of the admin, API key or referring user which created it.
+	ReferrerID string                     `json:"referrer_id,omitempty"` // Jellyfin ID of the user who created it, if it's a referral.
+}
+
+// UserInvite records the invite a user signed up with, as it was when they used it.
+type UserInvite struct {
+	Code     string    `json:"code"`
+	Label    string    `json:"label,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	Inviter  string    `json:"inviter,omitempty"`  // CreatedBy of the invite.
+	Referrer string    `json:"referrer,omitempty"` // ReferrerID of the invite.
+	Created  time.Time `json:"created"`            // When the user was created.
 }
 
 type Lang struct {
@@ -603,6 +638,9 @@ func (st *Storage) loadLangEmail(filesystems ...fs.FS) error {
 					patchLang(&lang.WelcomeEmail, &fallback.WelcomeEmail, &english.WelcomeEmail)
 					patchLang(&lang.EmailConfirmation, &fallback.EmailConfirmation, &english.EmailConfirmation)
 					patchLang(&lang.UserExpired, &fallback.UserExpired, &english.UserExpired)
+					patchLang(&lang.ExpiryReminder, &fallback.ExpiryReminder, &english.ExpiryReminder)
+					patchLang(&lang.DeletionNotice, &fallback.DeletionNotice, &english.DeletionNotice)
+					patchLang(&lang.InactivityWarning, &fallback.InactivityWarning, &english.InactivityWarning)
 					patchLang(&lang.Strings, &fallback.Strings, &english.Strings)
 				}
 			}
@@ -617,6 +655,9 @@ func (st *Storage) loadLangEmail(filesystems ...fs.FS) error {
 				patchLang(&lang.WelcomeEmail, &english.WelcomeEmail)
 				patchLang(&lang.EmailConfirmation, &english.EmailConfirmation)
 				patchLang(&lang.UserExpired, &english.UserExpired)
+				patchLang(&lang.ExpiryReminder, &english.ExpiryReminder)
+				patchLang(&lang.DeletionNotice, &english.DeletionNotice)
+				patchLang(&lang.InactivityWarning, &english.InactivityWarning)
 				patchLang(&lang.Strings, &english.Strings)
 			}
 		}
@@ -738,16 +779,890 @@ func (st *Storage) loadLangTelegram(filesystems ...fs.FS) error {
 
 type Invites map[string]Invite
 
-func (st *Storage) loadInvites() error {
+// object returns the in-memory store saved under the given key.
+// For those with a lock (see objectLock), it should be held while using the result.
+func (st *Storage) object(key string) interface{} {
+	switch key {
+	case "invites":
+		return st.invites
+	case "emails":
+		return st.emails
+	case "users":
+		return st.users
+	case "telegram_users":
+		return st.telegram
+	case "discord_users":
+		return st.discord
+	case "matrix_users":
+		return st.matrix
+	case "announcements":
+		return st.announcements
+	case "webhook_deliveries":
+		return st.webhookDeliveries
+	case "activity":
+		return st.activity
+	case "api_keys":
+		return st.apiKeys
+	case "roles":
+		return st.roles
+	case "totp":
+		return st.totp
+	case "sessions":
+		return st.sessions
+	case "renewal_codes":
+		return st.renewalCodes
+	case "expired_users":
+		return st.expiredUsers
+	case "expiry_reminders":
+		return st.expiryReminders
+	case "inactive_users":
+		return st.inactiveUsers
+	case "scheduled_actions":
+		return st.scheduledActions
+	case "user_invites":
+		return st.userInvites
+	case "referrers":
+		return st.referrers
+	case "user_profiles":
+		return st.profiles
+	case "custom_emails":
+		return st.customEmails
+	case "ombi_template":
+		return st.ombi_template
+	case "user_template":
+		return st.policy
+	case "user_configuration":
+		return st.configuration
+	case "user_displayprefs":
+		return st.displayprefs
+	}
+	return nil
+}
+
+// objectLock returns the lock guarding the store saved under the given key, or nil if it isn't guarded.
+func (st *Storage) objectLock(key string) *sync.RWMutex {
+	switch key {
+	case "invites":
+		return &st.invitesLock
+	case "emails":
+		return &st.emailsLock
+	case "users":
+		return &st.usersLock
+	case "telegram_users":
+		return &st.telegramLock
+	case "discord_users":
+		return &st.discordLock
+	case "matrix_users":
+		return &st.matrixLock
+	case "announcements":
+		return &st.announcementsLock
+	case "referrers":
+		return &st.referrersLock
+	case "user_invites":
+		return &st.userInvitesLock
+	case "scheduled_actions":
+		return &st.scheduledActionsLock
+	case "inactive_users":
+		return &st.inactiveUsersLock
+	case "expiry_reminders":
+		return &st.expiryRemindersLock
+	case "expired_users":
+		return &st.expiredUsersLock
+	case "renewal_codes":
+		return &st.renewalCodesLock
+	case "sessions":
+		return &st.sessionsLock
+	case "totp":
+		return &st.totpLock
+	case "roles":
+		return &st.rolesLock
+	case "api_keys":
+		return &st.apiKeysLock
+	case "activity":
+		return &st.activityLock
+	case "webhook_deliveries":
+		return &st.webhookDeliveriesLock
+	case "user_profiles":
+		return &st.profilesLock
+	}
+	return nil
+}
+
+// The following accessors guard each store with its lock. Get* returns a copy, so is safe to iterate over.
+
+// GetInvites returns a copy of the stored invites.
+func (st *Storage) GetInvites() Invites {
+	st.invitesLock.RLock()
+	defer st.invitesLock.RUnlock()
+	m := make(Invites, len(st.invites))
+	for k, v := range st.invites {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetInvitesKey(k string) (Invite, bool) {
+	st.invitesLock.RLock()
+	defer st.invitesLock.RUnlock()
+	v, ok := st.invites[k]
+	return v, ok
+}
+
+func (st *Storage) SetInvitesKey(k string, v Invite) {
 	st.invitesLock.Lock()
 	defer st.invitesLock.Unlock()
-	return loadJSON(st.invite_path, &st.invites)
+	if st.invites == nil {
+		st.invites = Invites{}
+	}
+	st.invites[k] = v
 }
 
-func (st *Storage) storeInvites() error {
+func (st *Storage) DeleteInvitesKey(k string) {
 	st.invitesLock.Lock()
 	defer st.invitesLock.Unlock()
-	return storeJSON(st.invite_path, st.invites)
+	delete(st.invites, k)
+}
+
+// GetUsers returns a copy of the stored user expiry times.
+func (st *Storage) GetUsers() map[string]time.Time {
+	st.usersLock.RLock()
+	defer st.usersLock.RUnlock()
+	m := make(map[string]time.Time, len(st.users))
+	for k, v := range st.users {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetUsersKey(k string) (time.Time, bool) {
+	st.usersLock.RLock()
+	defer st.usersLock.RUnlock()
+	v, ok := st.users[k]
+	return v, ok
+}
+
+func (st *Storage) SetUsersKey(k string, v time.Time) {
+	st.usersLock.Lock()
+	defer st.usersLock.Unlock()
+	if st.users == nil {
+		st.users = map[string]time.Time{}
+	}
+	st.users[k] = v
+}
+
+func (st *Storage) DeleteUsersKey(k string) {
+	st.usersLock.Lock()
+	defer st.usersLock.Unlock()
+	delete(st.users, k)
+}
+
+// GetEmails returns a copy of the stored email addresses.
+func (st *Storage) GetEmails() map[string]EmailAddress {
+	st.emailsLock.RLock()
+	defer st.emailsLock.RUnlock()
+	m := make(map[string]EmailAddress, len(st.emails))
+	for k, v := range st.emails {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetEmailsKey(k string) (EmailAddress, bool) {
+	st.emailsLock.RLock()
+	defer st.emailsLock.RUnlock()
+	v, ok := st.emails[k]
+	return v, ok
+}
+
+func (st *Storage) SetEmailsKey(k string, v EmailAddress) {
+	st.emailsLock.Lock()
+	defer st.emailsLock.Unlock()
+	if st.emails == nil {
+		st.emails = map[string]EmailAddress{}
+	}
+	st.emails[k] = v
+}
+
+func (st *Storage) DeleteEmailsKey(k string) {
+	st.emailsLock.Lock()
+	defer st.emailsLock.Unlock()
+	delete(st.emails, k)
+}
+
+// GetTelegram returns a copy of the stored Telegram users.
+func (st *Storage) GetTelegram() map[string]TelegramUser {
+	st.telegramLock.RLock()
+	defer st.telegramLock.RUnlock()
+	m := make(map[string]TelegramUser, len(st.telegram))
+	for k, v := range st.telegram {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetTelegramKey(k string) (TelegramUser, bool) {
+	st.telegramLock.RLock()
+	defer st.telegramLock.RUnlock()
+	v, ok := st.telegram[k]
+	return v, ok
+}
+
+func (st *Storage) SetTelegramKey(k string, v TelegramUser) {
+	st.telegramLock.Lock()
+	defer st.telegramLock.Unlock()
+	if st.telegram == nil {
+		st.telegram = map[string]TelegramUser{}
+	}
+	st.telegram[k] = v
+}
+
+func (st *Storage) DeleteTelegramKey(k string) {
+	st.telegramLock.Lock()
+	defer st.telegramLock.Unlock()
+	delete(st.telegram, k)
+}
+
+// GetDiscord returns a copy of the stored Discord users.
+func (st *Storage) GetDiscord() map[string]DiscordUser {
+	st.discordLock.RLock()
+	defer st.discordLock.RUnlock()
+	m := make(map[string]DiscordUser, len(st.discord))
+	for k, v := range st.discord {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetDiscordKey(k string) (DiscordUser, bool) {
+	st.discordLock.RLock()
+	defer st.discordLock.RUnlock()
+	v, ok := st.discord[k]
+	return v, ok
+}
+
+func (st *Storage) SetDiscordKey(k string, v DiscordUser) {
+	st.discordLock.Lock()
+	defer st.discordLock.Unlock()
+	if st.discord == nil {
+		st.discord = map[string]DiscordUser{}
+	}
+	st.discord[k] = v
+}
+
+func (st *Storage) DeleteDiscordKey(k string) {
+	st.discordLock.Lock()
+	defer st.discordLock.Unlock()
+	delete(st.discord, k)
+}
+
+// GetMatrix returns a copy of the stored Matrix users.
+func (st *Storage) GetMatrix() map[string]MatrixUser {
+	st.matrixLock.RLock()
+	defer st.matrixLock.RUnlock()
+	m := make(map[string]MatrixUser, len(st.matrix))
+	for k, v := range st.matrix {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetMatrixKey(k string) (MatrixUser, bool) {
+	st.matrixLock.RLock()
+	defer st.matrixLock.RUnlock()
+	v, ok := st.matrix[k]
+	return v, ok
+}
+
+func (st *Storage) SetMatrixKey(k string, v MatrixUser) {
+	st.matrixLock.Lock()
+	defer st.matrixLock.Unlock()
+	if st.matrix == nil {
+		st.matrix = map[string]MatrixUser{}
+	}
+	st.matrix[k] = v
+}
+
+func (st *Storage) DeleteMatrixKey(k string) {
+	st.matrixLock.Lock()
+	defer st.matrixLock.Unlock()
+	delete(st.matrix, k)
+}
+
+// GetProfiles returns a copy of the stored profiles.
+func (st *Storage) GetProfiles() map[string]Profile {
+	st.profilesLock.RLock()
+	defer st.profilesLock.RUnlock()
+	m := make(map[string]Profile, len(st.profiles))
+	for k, v := range st.profiles {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetProfilesKey(k string) (Profile, bool) {
+	st.profilesLock.RLock()
+	defer st.profilesLock.RUnlock()
+	v, ok := st.profiles[k]
+	return v, ok
+}
+
+func (st *Storage) SetProfilesKey(k string, v Profile) {
+	st.profilesLock.Lock()
+	defer st.profilesLock.Unlock()
+	if st.profiles == nil {
+		st.profiles = map[string]Profile{}
+	}
+	st.profiles[k] = v
+}
+
+func (st *Storage) DeleteProfilesKey(k string) {
+	st.profilesLock.Lock()
+	defer st.profilesLock.Unlock()
+	delete(st.profiles, k)
+}
+
+// GetAnnouncements returns a copy of the stored announcement templates.
+func (st *Storage) GetAnnouncements() map[string]announcementTemplate {
+	st.announcementsLock.RLock()
+	defer st.announcementsLock.RUnlock()
+	m := make(map[string]announcementTemplate, len(st.announcements))
+	for k, v := range st.announcements {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetAnnouncementsKey(k string) (announcementTemplate, bool) {
+	st.announcementsLock.RLock()
+	defer st.announcementsLock.RUnlock()
+	v, ok := st.announcements[k]
+	return v, ok
+}
+
+func (st *Storage) SetAnnouncementsKey(k string, v announcementTemplate) {
+	st.announcementsLock.Lock()
+	defer st.announcementsLock.Unlock()
+	if st.announcements == nil {
+		st.announcements = map[string]announcementTemplate{}
+	}
+	st.announcements[k] = v
+}
+
+func (st *Storage) DeleteAnnouncementsKey(k string) {
+	st.announcementsLock.Lock()
+	defer st.announcementsLock.Unlock()
+	delete(st.announcements, k)
+}
+
+// GetWebhookDeliveries returns a copy of the stored webhook deliveries.
+func (st *Storage) GetWebhookDeliveries() map[string]WebhookDelivery {
+	st.webhookDeliveriesLock.RLock()
+	defer st.webhookDeliveriesLock.RUnlock()
+	m := make(map[string]WebhookDelivery, len(st.webhookDeliveries))
+	for k, v := range st.webhookDeliveries {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetWebhookDeliveriesKey(k string) (WebhookDelivery, bool) {
+	st.webhookDeliveriesLock.RLock()
+	defer st.webhookDeliveriesLock.RUnlock()
+	v, ok := st.webhookDeliveries[k]
+	return v, ok
+}
+
+func (st *Storage) SetWebhookDeliveriesKey(k string, v WebhookDelivery) {
+	st.webhookDeliveriesLock.Lock()
+	defer st.webhookDeliveriesLock.Unlock()
+	if st.webhookDeliveries == nil {
+		st.webhookDeliveries = map[string]WebhookDelivery{}
+	}
+	st.webhookDeliveries[k] = v
+}
+
+func (st *Storage) DeleteWebhookDeliveriesKey(k string) {
+	st.webhookDeliveriesLock.Lock()
+	defer st.webhookDeliveriesLock.Unlock()
+	delete(st.webhookDeliveries, k)
+}
+
+// GetActivity returns a copy of the stored activity log.
+func (st *Storage) GetActivity() map[string]Activity {
+	st.activityLock.RLock()
+	defer st.activityLock.RUnlock()
+	m := make(map[string]Activity, len(st.activity))
+	for k, v := range st.activity {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetActivityKey(k string) (Activity, bool) {
+	st.activityLock.RLock()
+	defer st.activityLock.RUnlock()
+	v, ok := st.activity[k]
+	return v, ok
+}
+
+func (st *Storage) SetActivityKey(k string, v Activity) {
+	st.activityLock.Lock()
+	defer st.activityLock.Unlock()
+	if st.activity == nil {
+		st.activity = map[string]Activity{}
+	}
+	st.activity[k] = v
+}
+
+func (st *Storage) DeleteActivityKey(k string) {
+	st.activityLock.Lock()
+	defer st.activityLock.Unlock()
+	delete(st.activity, k)
+}
+
+// GetAPIKeys returns a copy of the stored API keys.
+func (st *Storage) GetAPIKeys() map[string]APIKey {
+	st.apiKeysLock.RLock()
+	defer st.apiKeysLock.RUnlock()
+	m := make(map[string]APIKey, len(st.apiKeys))
+	for k, v := range st.apiKeys {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetAPIKeysKey(k string) (APIKey, bool) {
+	st.apiKeysLock.RLock()
+	defer st.apiKeysLock.RUnlock()
+	v, ok := st.apiKeys[k]
+	return v, ok
+}
+
+func (st *Storage) SetAPIKeysKey(k string, v APIKey) {
+	st.apiKeysLock.Lock()
+	defer st.apiKeysLock.Unlock()
+	if st.apiKeys == nil {
+		st.apiKeys = map[string]APIKey{}
+	}
+	st.apiKeys[k] = v
+}
+
+func (st *Storage) DeleteAPIKeysKey(k string) {
+	st.apiKeysLock.Lock()
+	defer st.apiKeysLock.Unlock()
+	delete(st.apiKeys, k)
+}
+
+// SetAPIKeyLastUsed sets the key's last used time, returning the previous one, unless it's been deleted.
+func (st *Storage) SetAPIKeyLastUsed(k string, t time.Time) (previous time.Time, ok bool) {
+	st.apiKeysLock.Lock()
+	defer st.apiKeysLock.Unlock()
+	key, ok := st.apiKeys[k]
+	if !ok {
+		return
+	}
+	previous = key.LastUsed
+	key.LastUsed = t
+	st.apiKeys[k] = key
+	return
+}
+
+// GetRoles returns a copy of the stored roles.
+func (st *Storage) GetRoles() map[string]Role {
+	st.rolesLock.RLock()
+	defer st.rolesLock.RUnlock()
+	m := make(map[string]Role, len(st.roles))
+	for k, v := range st.roles {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetRolesKey(k string) (Role, bool) {
+	st.rolesLock.RLock()
+	defer st.rolesLock.RUnlock()
+	v, ok := st.roles[k]
+	return v, ok
+}
+
+func (st *Storage) SetRolesKey(k string, v Role) {
+	st.rolesLock.Lock()
+	defer st.rolesLock.Unlock()
+	if st.roles == nil {
+		st.roles = map[string]Role{}
+	}
+	st.roles[k] = v
+}
+
+func (st *Storage) DeleteRolesKey(k string) {
+	st.rolesLock.Lock()
+	defer st.rolesLock.Unlock()
+	delete(st.roles, k)
+}
+
+// GetTOTP returns a copy of the stored two-factor authentication secrets.
+func (st *Storage) GetTOTP() map[string]TOTPAccount {
+	st.totpLock.RLock()
+	defer st.totpLock.RUnlock()
+	m := make(map[string]TOTPAccount, len(st.totp))
+	for k, v := range st.totp {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetTOTPKey(k string) (TOTPAccount, bool) {
+	st.totpLock.RLock()
+	defer st.totpLock.RUnlock()
+	v, ok := st.totp[k]
+	return v, ok
+}
+
+func (st *Storage) SetTOTPKey(k string, v TOTPAccount) {
+	st.totpLock.Lock()
+	defer st.totpLock.Unlock()
+	if st.totp == nil {
+		st.totp = map[string]TOTPAccount{}
+	}
+	st.totp[k] = v
+}
+
+func (st *Storage) DeleteTOTPKey(k string) {
+	st.totpLock.Lock()
+	defer st.totpLock.Unlock()
+	delete(st.totp, k)
+}
+
+// GetSessions returns a copy of the stored login sessions.
+func (st *Storage) GetSessions() map[string]Session {
+	st.sessionsLock.RLock()
+	defer st.sessionsLock.RUnlock()
+	m := make(map[string]Session, len(st.sessions))
+	for k, v := range st.sessions {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetSessionsKey(k string) (Session, bool) {
+	st.sessionsLock.RLock()
+	defer st.sessionsLock.RUnlock()
+	v, ok := st.sessions[k]
+	return v, ok
+}
+
+func (st *Storage) SetSessionsKey(k string, v Session) {
+	st.sessionsLock.Lock()
+	defer st.sessionsLock.Unlock()
+	if st.sessions == nil {
+		st.sessions = map[string]Session{}
+	}
+	st.sessions[k] = v
+}
+
+func (st *Storage) DeleteSessionsKey(k string) {
+	st.sessionsLock.Lock()
+	defer st.sessionsLock.Unlock()
+	delete(st.sessions, k)
+}
+
+// GetRenewalCodes returns a copy of the stored renewal codes.
+func (st *Storage) GetRenewalCodes() map[string]RenewalCode {
+	st.renewalCodesLock.RLock()
+	defer st.renewalCodesLock.RUnlock()
+	m := make(map[string]RenewalCode, len(st.renewalCodes))
+	for k, v := range st.renewalCodes {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetRenewalCodesKey(k string) (RenewalCode, bool) {
+	st.renewalCodesLock.RLock()
+	defer st.renewalCodesLock.RUnlock()
+	v, ok := st.renewalCodes[k]
+	return v, ok
+}
+
+func (st *Storage) SetRenewalCodesKey(k string, v RenewalCode) {
+	st.renewalCodesLock.Lock()
+	defer st.renewalCodesLock.Unlock()
+	if st.renewalCodes == nil {
+		st.renewalCodes = map[string]RenewalCode{}
+	}
+	st.renewalCodes[k] = v
+}
+
+func (st *Storage) DeleteRenewalCodesKey(k string) {
+	st.renewalCodesLock.Lock()
+	defer st.renewalCodesLock.Unlock()
+	delete(st.renewalCodes, k)
+}
+
+// GetExpiredUsers returns a copy of the stored users disabled on expiry.
+func (st *Storage) GetExpiredUsers() map[string]ExpiredUser {
+	st.expiredUsersLock.RLock()
+	defer st.expiredUsersLock.RUnlock()
+	m := make(map[string]ExpiredUser, len(st.expiredUsers))
+	for k, v := range st.expiredUsers {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetExpiredUsersKey(k string) (ExpiredUser, bool) {
+	st.expiredUsersLock.RLock()
+	defer st.expiredUsersLock.RUnlock()
+	v, ok := st.expiredUsers[k]
+	return v, ok
+}
+
+func (st *Storage) SetExpiredUsersKey(k string, v ExpiredUser) {
+	st.expiredUsersLock.Lock()
+	defer st.expiredUsersLock.Unlock()
+	if st.expiredUsers == nil {
+		st.expiredUsers = map[string]ExpiredUser{}
+	}
+	st.expiredUsers[k] = v
+}
+
+func (st *Storage) DeleteExpiredUsersKey(k string) {
+	st.expiredUsersLock.Lock()
+	defer st.expiredUsersLock.Unlock()
+	delete(st.expiredUsers, k)
+}
+
+// GetExpiryReminders returns a copy of the stored sent expiry reminders.
+func (st *Storage) GetExpiryReminders() map[string]SentReminders {
+	st.expiryRemindersLock.RLock()
+	defer st.expiryRemindersLock.RUnlock()
+	m := make(map[string]SentReminders, len(st.expiryReminders))
+	for k, v := range st.expiryReminders {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetExpiryRemindersKey(k string) (SentReminders, bool) {
+	st.expiryRemindersLock.RLock()
+	defer st.expiryRemindersLock.RUnlock()
+	v, ok := st.expiryReminders[k]
+	return v, ok
+}
+
+func (st *Storage) SetExpiryRemindersKey(k string, v SentReminders) {
+	st.expiryRemindersLock.Lock()
+	defer st.expiryRemindersLock.Unlock()
+	if st.expiryReminders == nil {
+		st.expiryReminders = map[string]SentReminders{}
+	}
+	st.expiryReminders[k] = v
+}
+
+func (st *Storage) DeleteExpiryRemindersKey(k string) {
+	st.expiryRemindersLock.Lock()
+	defer st.expiryRemindersLock.Unlock()
+	delete(st.expiryReminders, k)
+}
+
+// GetInactiveUsers returns a copy of the stored inactivity records.
+func (st *Storage) GetInactiveUsers() map[string]InactiveUser {
+	st.inactiveUsersLock.RLock()
+	defer st.inactiveUsersLock.RUnlock()
+	m := make(map[string]InactiveUser, len(st.inactiveUsers))
+	for k, v := range st.inactiveUsers {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetInactiveUsersKey(k string) (InactiveUser, bool) {
+	st.inactiveUsersLock.RLock()
+	defer st.inactiveUsersLock.RUnlock()
+	v, ok := st.inactiveUsers[k]
+	return v, ok
+}
+
+func (st *Storage) SetInactiveUsersKey(k string, v InactiveUser) {
+	st.inactiveUsersLock.Lock()
+	defer st.inactiveUsersLock.Unlock()
+	if st.inactiveUsers == nil {
+		st.inactiveUsers = map[string]InactiveUser{}
+	}
+	st.inactiveUsers[k] = v
+}
+
+func (st *Storage) DeleteInactiveUsersKey(k string) {
+	st.inactiveUsersLock.Lock()
+	defer st.inactiveUsersLock.Unlock()
+	delete(st.inactiveUsers, k)
+}
+
+// GetScheduledActions returns a copy of the stored scheduled actions.
+func (st *Storage) GetScheduledActions() map[string]ScheduledAction {
+	st.scheduledActionsLock.RLock()
+	defer st.scheduledActionsLock.RUnlock()
+	m := make(map[string]ScheduledAction, len(st.scheduledActions))
+	for k, v := range st.scheduledActions {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetScheduledActionsKey(k string) (ScheduledAction, bool) {
+	st.scheduledActionsLock.RLock()
+	defer st.scheduledActionsLock.RUnlock()
+	v, ok := st.scheduledActions[k]
+	return v, ok
+}
+
+func (st *Storage) SetScheduledActionsKey(k string, v ScheduledAction) {
+	st.scheduledActionsLock.Lock()
+	defer st.scheduledActionsLock.Unlock()
+	if st.scheduledActions == nil {
+		st.scheduledActions = map[string]ScheduledAction{}
+	}
+	st.scheduledActions[k] = v
+}
+
+func (st *Storage) DeleteScheduledActionsKey(k string) {
+	st.scheduledActionsLock.Lock()
+	defer st.scheduledActionsLock.Unlock()
+	delete(st.scheduledActions, k)
+}
+
+// GetUserInvites returns a copy of the stored invite records.
+func (st *Storage) GetUserInvites() map[string]UserInvite {
+	st.userInvitesLock.RLock()
+	defer st.userInvitesLock.RUnlock()
+	m := make(map[string]UserInvite, len(st.userInvites))
+	for k, v := range st.userInvites {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetUserInvitesKey(k string) (UserInvite, bool) {
+	st.userInvitesLock.RLock()
+	defer st.userInvitesLock.RUnlock()
+	v, ok := st.userInvites[k]
+	return v, ok
+}
+
+func (st *Storage) SetUserInvitesKey(k string, v UserInvite) {
+	st.userInvitesLock.Lock()
+	defer st.userInvitesLock.Unlock()
+	if st.userInvites == nil {
+		st.userInvites = map[string]UserInvite{}
+	}
+	st.userInvites[k] = v
+}
+
+func (st *Storage) DeleteUserInvitesKey(k string) {
+	st.userInvitesLock.Lock()
+	defer st.userInvitesLock.Unlock()
+	delete(st.userInvites, k)
+}
+
+// GetReferrers returns a copy of the stored referrers.
+func (st *Storage) GetReferrers() map[string]Referrer {
+	st.referrersLock.RLock()
+	defer st.referrersLock.RUnlock()
+	m := make(map[string]Referrer, len(st.referrers))
+	for k, v := range st.referrers {
+		m[k] = v
+	}
+	return m
+}
+
+func (st *Storage) GetReferrersKey(k string) (Referrer, bool) {
+	st.referrersLock.RLock()
+	defer st.referrersLock.RUnlock()
+	v, ok := st.referrers[k]
+	return v, ok
+}
+
+func (st *Storage) SetReferrersKey(k string, v Referrer) {
+	st.referrersLock.Lock()
+	defer st.referrersLock.Unlock()
+	if st.referrers == nil {
+		st.referrers = map[string]Referrer{}
+	}
+	st.referrers[k] = v
+}
+
+func (st *Storage) DeleteReferrersKey(k string) {
+	st.referrersLock.Lock()
+	defer st.referrersLock.Unlock()
+	delete(st.referrers, k)
+}
+
+func (st *Storage) GetDefaultProfile() string {
+	st.profilesLock.RLock()
+	defer st.profilesLock.RUnlock()
+	return st.defaultProfile
+}
+
+func (st *Storage) SetDefaultProfile(name string) {
+	st.profilesLock.Lock()
+	defer st.profilesLock.Unlock()
+	st.defaultProfile = name
+}
+
+func (st *Storage) load(key string, obj interface{}) error {
+	data, err := st.backend.Get(key)
+	if err != nil {
+		log.Printf("ERROR: Failed to read \"%s\": %s", key, err)
+		return err
+	}
+	if data == nil {
+		data = []byte("{}")
+	}
+	err = json.Unmarshal(data, obj)
+	if err != nil {
+		log.Printf("ERROR: Failed to read \"%s\": %s", key, err)
+	}
+	return err
+}
+
+func (st *Storage) store(key string) error {
+	return st.storeTogether(key)
+}
+
+// storeTogether writes the given stores in a single transaction, so a failure can't leave them out of sync.
+func (st *Storage) storeTogether(keys ...string) error {
+	st.storeLock.Lock()
+	defer st.storeLock.Unlock()
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		lock := st.objectLock(key)
+		if lock != nil {
+			lock.RLock()
+		}
+		data, err := json.Marshal(st.object(key))
+		if lock != nil {
+			lock.RUnlock()
+		}
+		if err != nil {
+			return err
+		}
+		values[key] = data
+	}
+	err := st.backend.PutAll(values)
+	if err != nil {
+		log.Printf("ERROR: Failed to write %v: %s", keys, err)
+	}
+	return err
+}
+
+func (st *Storage) loadInvites() error {
+	st.invitesLock.Lock()
+	defer st.invitesLock.Unlock()
+	return st.load("invites", &st.invites)
+}
+
+func (st *Storage) storeInvites() error {
+	return st.store("invites")
 }
 
 func (st *Storage) loadUsers() error {
@@ -757,7 +1672,7 @@ func (st *Storage) loadUsers() error {
 		st.users = map[string]time.Time{}
 	}
 	temp := map[string]time.Time{}
-	err := loadJSON(st.users_path, &temp)
+	err := st.load("users", &temp)
 	if err != nil {
 		return err
 	}
@@ -770,91 +1685,103 @@ func (st *Storage) loadUsers() error {
 }
 
 func (st *Storage) storeUsers() error {
-	return storeJSON(st.users_path, st.users)
+	return st.store("users")
 }
 
 func (st *Storage) loadEmails() error {
-	return loadJSON(st.emails_path, &st.emails)
+	st.emailsLock.Lock()
+	defer st.emailsLock.Unlock()
+	return st.load("emails", &st.emails)
 }
 
 func (st *Storage) storeEmails() error {
-	return storeJSON(st.emails_path, st.emails)
+	return st.store("emails")
 }
 
 func (st *Storage) loadTelegramUsers() error {
-	return loadJSON(st.telegram_path, &st.telegram)
+	st.telegramLock.Lock()
+	defer st.telegramLock.Unlock()
+	return st.load("telegram_users", &st.telegram)
 }
 
 func (st *Storage) storeTelegramUsers() error {
-	return storeJSON(st.telegram_path, st.telegram)
+	return st.store("telegram_users")
 }
 
 func (st *Storage) loadDiscordUsers() error {
-	return loadJSON(st.discord_path, &st.discord)
+	st.discordLock.Lock()
+	defer st.discordLock.Unlock()
+	return st.load("discord_users", &st.discord)
 }
 
 func (st *Storage) storeDiscordUsers() error {
-	return storeJSON(st.discord_path, st.discord)
+	return st.store("discord_users")
 }
 
 func (st *Storage) loadMatrixUsers() error {
-	return loadJSON(st.matrix_path, &st.matrix)
+	st.matrixLock.Lock()
+	defer st.matrixLock.Unlock()
+	return st.load("matrix_users", &st.matrix)
 }
 
 func (st *Storage) storeMatrixUsers() error {
-	return storeJSON(st.matrix_path, st.matrix)
+	return st.store("matrix_users")
 }
 
 func (st *Storage) loadCustomEmails() error {
-	return loadJSON(st.customEmails_path, &st.customEmails)
+	return st.load("custom_emails", &st.customEmails)
 }
 
 func (st *Storage) storeCustomEmails() error {
-	return storeJSON(st.customEmails_path, st.customEmails)
+	return st.store("custom_emails")
 }
 
 func (st *Storage) loadPolicy() error {
-	return loadJSON(st.policy_path, &st.policy)
+	return st.load("user_template", &st.policy)
 }
 
 func (st *Storage) storePolicy() error {
-	return storeJSON(st.policy_path, st.policy)
+	return st.store("user_template")
 }
 
 func (st *Storage) loadConfiguration() error {
-	return loadJSON(st.configuration_path, &st.configuration)
+	return st.load("user_configuration", &st.configuration)
 }
 
 func (st *Storage) storeConfiguration() error {
-	return storeJSON(st.configuration_path, st.configuration)
+	return st.store("user_configuration")
 }
 
 func (st *Storage) loadDisplayprefs() error {
-	return loadJSON(st.displayprefs_path, &st.displayprefs)
+	return st.load("user_displayprefs", &st.displayprefs)
 }
 
 func (st *Storage) storeDisplayprefs() error {
-	return storeJSON(st.displayprefs_path, st.displayprefs)
+	return st.store("user_displayprefs")
 }
 
 func (st *Storage) loadOmbiTemplate() error {
-	return loadJSON(st.ombi_path, &st.ombi_template)
+	return st.load("ombi_template", &st.ombi_template)
 }
 
 func (st *Storage) storeOmbiTemplate() error {
-	return storeJSON(st.ombi_path, st.ombi_template)
+	return st.store("ombi_template")
 }
 
 func (st *Storage) loadAnnouncements() error {
-	return loadJSON(st.announcements_path, &st.announcements)
+	st.announcementsLock.Lock()
+	defer st.announcementsLock.Unlock()
+	return st.load("announcements", &st.announcements)
 }
 
 func (st *Storage) storeAnnouncements() error {
-	return storeJSON(st.announcements_path, st.announcements)
+	return st.store("announcements")
 }
 
 func (st *Storage) loadProfiles() error {
-	err := loadJSON(st.profiles_path, &st.profiles)
+	st.profilesLock.Lock()
+	defer st.profilesLock.Unlock()
+	err := st.load("user_profiles", &st.profiles)
 	for name, profile := range st.profiles {
 		if profile.Default {
 			st.defaultProfile = name
@@ -890,7 +1817,297 @@ func (st *Storage) loadProfiles() error {
 }
 
 func (st *Storage) storeProfiles() error {
-	return storeJSON(st.profiles_path, st.profiles)
+	return st.store("user_profiles")
+}
+
+func (st *Storage) loadWebhookDeliveries() error {
+	st.webhookDeliveriesLock.Lock()
+	defer st.webhookDeliveriesLock.Unlock()
+	return st.load("webhook_deliveries", &st.webhookDeliveries)
+}
+
+func (st *Storage) storeWebhookDeliveries() error {
+	return st.store("webhook_deliveries")
+}
+
+func (st *Storage) loadActivity() error {
+	st.activityLock.Lock()
+	defer st.activityLock.Unlock()
+	if err := st.load("activity", &st.activity); err != nil {
+		return err
+	}
+	// Entries logged since the whole log was last stored, see appendActivity.
+	records, err := st.backend.Appended("activity")
+	if err != nil {
+		log.Printf("ERROR: Failed to read appended \"activity\": %s", err)
+		return err
+	}
+	if st.activity == nil {
+		st.activity = map[string]Activity{}
+	}
+	for _, record := range records {
+		var activity Activity
+		if json.Unmarshal(record, &activity) == nil {
+			st.activity[activity.ID] = activity
+		}
+	}
+	st.activityAppended = len(records)
+	return nil
+}
+
+// storeActivity writes the whole activity log, folding in and clearing the entries appended to it.
+func (st *Storage) storeActivity() error {
+	st.activityLock.Lock()
+	appended := st.activityAppended
+	st.activityLock.Unlock()
+	err := st.store("activity")
+	if err == nil {
+		st.activityLock.Lock()
+		// Anything appended while storing will be stored next time.
+		st.activityAppended -= appended
+		st.activityLock.Unlock()
+	}
+	return err
+}
+
+// appendActivity adds an entry to the activity log, appending it to what's stored rather than rewriting the whole log.
+func (st *Storage) appendActivity(activity Activity) error {
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	// Held so storeActivity can't store the log without this entry, then clear it from what's appended.
+	st.storeLock.Lock()
+	defer st.storeLock.Unlock()
+	st.activityLock.Lock()
+	if st.activity == nil {
+		st.activity = map[string]Activity{}
+	}
+	st.activity[activity.ID] = activity
+	st.activityAppended++
+	st.activityLock.Unlock()
+	return st.backend.Append("activity", data)
+}
+
+// ActivityAppended returns the number of entries appended to the activity log since it was last stored whole.
+func (st *Storage) ActivityAppended() int {
+	st.activityLock.RLock()
+	defer st.activityLock.RUnlock()
+	return st.activityAppended
+}
+
+func (st *Storage) loadAPIKeys() error {
+	st.apiKeysLock.Lock()
+	defer st.apiKeysLock.Unlock()
+	return st.load("api_keys", &st.apiKeys)
+}
+
+func (st *Storage) storeAPIKeys() error {
+	return st.store("api_keys")
+}
+
+func (st *Storage) loadRoles() error {
+	st.rolesLock.Lock()
+	defer st.rolesLock.Unlock()
+	return st.load("roles", &st.roles)
+}
+
+func (st *Storage) storeRoles() error {
+	return st.store("roles")
+}
+
+func (st *Storage) loadTOTP() error {
+	st.totpLock.Lock()
+	defer st.totpLock.Unlock()
+	return st.load("totp", &st.totp)
+}
+
+func (st *Storage) storeTOTP() error {
+	return st.store("totp")
+}
+
+func (st *Storage) loadSessions() error {
+	st.sessionsLock.Lock()
+	defer st.sessionsLock.Unlock()
+	return st.load("sessions", &st.sessions)
+}
+
+func (st *Storage) storeSessions() error {
+	return st.store("sessions")
+}
+
+func (st *Storage) loadRenewalCodes() error {
+	st.renewalCodesLock.Lock()
+	defer st.renewalCodesLock.Unlock()
+	return st.load("renewal_codes", &st.renewalCodes)
+}
+
+func (st *Storage) storeRenewalCodes() error {
+	return st.store("renewal_codes")
+}
+
+func (st *Storage) loadExpiredUsers() error {
+	st.expiredUsersLock.Lock()
+	defer st.expiredUsersLock.Unlock()
+	return st.load("expired_users", &st.expiredUsers)
+}
+
+func (st *Storage) storeExpiredUsers() error {
+	return st.store("expired_users")
+}
+
+func (st *Storage) loadExpiryReminders() error {
+	st.expiryRemindersLock.Lock()
+	defer st.expiryRemindersLock.Unlock()
+	return st.load("expiry_reminders", &st.expiryReminders)
+}
+
+func (st *Storage) storeExpiryReminders() error {
+	return st.store("expiry_reminders")
+}
+
+func (st *Storage) loadInactiveUsers() error {
+	st.inactiveUsersLock.Lock()
+	defer st.inactiveUsersLock.Unlock()
+	return st.load("inactive_users", &st.inactiveUsers)
+}
+
+func (st *Storage) storeInactiveUsers() error {
+	return st.store("inactive_users")
+}
+
+func (st *Storage) loadScheduledActions() error {
+	st.scheduledActionsLock.Lock()
+	defer st.scheduledActionsLock.Unlock()
+	return st.load("scheduled_actions", &st.scheduledActions)
+}
+
+func (st *Storage) storeScheduledActions() error {
+	return st.store("scheduled_actions")
+}
+
+func (st *Storage) loadUserInvites() error {
+	st.userInvitesLock.Lock()
+	defer st.userInvitesLock.Unlock()
+	return st.load("user_invites", &st.userInvites)
+}
+
+func (st *Storage) storeUserInvites() error {
+	return st.store("user_invites")
+}
+
+func (st *Storage) loadReferrers() error {
+	st.referrersLock.Lock()
+	defer st.referrersLock.Unlock()
+	return st.load("referrers", &st.referrers)
+}
+
+func (st *Storage) storeReferrers() error {
+	return st.store("referrers")
+}
+
+// reload replaces every in-memory store with what's currently in the backend, e.g. after a restore.
+func (st *Storage) reload() error {
+	// Unmarshaling into an existing map only adds to it, so start from scratch.
+	for key, clear := range map[string]func(){
+		"invites":            func() { st.invites = nil },
+		"users":              func() { st.users = nil },
+		"emails":             func() { st.emails = nil },
+		"telegram_users":     func() { st.telegram = nil },
+		"discord_users":      func() { st.discord = nil },
+		"matrix_users":       func() { st.matrix = nil },
+		"announcements":      func() { st.announcements = nil },
+		"user_profiles":      func() { st.profiles, st.defaultProfile = nil, "" },
+		"referrers":          func() { st.referrers = nil },
+		"user_invites":       func() { st.userInvites = nil },
+		"scheduled_actions":  func() { st.scheduledActions = nil },
+		"inactive_users":     func() { st.inactiveUsers = nil },
+		"expiry_reminders":   func() { st.expiryReminders = nil },
+		"expired_users":      func() { st.expiredUsers = nil },
+		"renewal_codes":      func() { st.renewalCodes = nil },
+		"sessions":           func() { st.sessions = nil },
+		"totp":               func() { st.totp = nil },
+		"roles":              func() { st.roles = nil },
+		"api_keys":           func() { st.apiKeys = nil },
+		"activity":           func() { st.activity = nil },
+		"webhook_deliveries": func() { st.webhookDeliveries = nil },
+	} {
+		lock := st.objectLock(key)
+		lock.Lock()
+		clear()
+		lock.Unlock()
+	}
+	st.ombi_template = nil
+	st.displayprefs = nil
+	st.customEmails = customEmails{}
+	st.policy = mediabrowser.Policy{}
+	st.configuration = mediabrowser.Configuration{}
+	loaders := st.loaders()
+	for _, key := range storageKeys {
+		load, ok := loaders[key]
+		if !ok {
+			return fmt.Errorf("no loader for \"%s\"", key)
+		}
+		if err := load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStores makes sure each of the given raw stores can be loaded, by unmarshaling them into the type they're loaded as.
+// Nothing in memory is changed.
+func (st *Storage) checkStores(stores map[string][]byte) error {
+	for _, key := range storageKeys {
+		data, ok := stores[key]
+		if !ok {
+			continue
+		}
+		lock := st.objectLock(key)
+		if lock != nil {
+			lock.RLock()
+		}
+		obj := reflect.New(reflect.TypeOf(st.object(key))).Interface()
+		if lock != nil {
+			lock.RUnlock()
+		}
+		if err := json.Unmarshal(data, obj); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// loaders returns the load function for each of storageKeys.
+func (st *Storage) loaders() map[string]func() error {
+	return map[string]func() error{
+		"invites":            st.loadInvites,
+		"emails":             st.loadEmails,
+		"users":              st.loadUsers,
+		"telegram_users":     st.loadTelegramUsers,
+		"discord_users":      st.loadDiscordUsers,
+		"matrix_users":       st.loadMatrixUsers,
+		"announcements":      st.loadAnnouncements,
+		"user_profiles":      st.loadProfiles,
+		"custom_emails":      st.loadCustomEmails,
+		"ombi_template":      st.loadOmbiTemplate,
+		"user_template":      st.loadPolicy,
+		"user_configuration": st.loadConfiguration,
+		"user_displayprefs":  st.loadDisplayprefs,
+		"webhook_deliveries": st.loadWebhookDeliveries,
+		"activity":           st.loadActivity,
+		"api_keys":           st.loadAPIKeys,
+		"roles":              st.loadRoles,
+		"totp":               st.loadTOTP,
+		"sessions":           st.loadSessions,
+		"renewal_codes":      st.loadRenewalCodes,
+		"expired_users":      st.loadExpiredUsers,
+		"expiry_reminders":   st.loadExpiryReminders,
+		"inactive_users":     st.loadInactiveUsers,
+		"scheduled_actions":  st.loadScheduledActions,
+		"user_invites":       st.loadUserInvites,
+		"referrers":          st.loadReferrers,
+	}
 }
 
 func (st *Storage) migrateToProfile() error {
@@ -898,19 +2115,22 @@ func (st *Storage) migrateToProfile() error {
 	st.loadConfiguration()
 	st.loadDisplayprefs()
 	st.loadProfiles()
-	st.profiles["Default"] = Profile{
+	st.SetProfilesKey("Default", Profile{
 		Policy:        st.policy,
 		Configuration: st.configuration,
 		Displayprefs:  st.displayprefs,
-	}
+	})
 	return st.storeProfiles()
 }
 
+// Number of previous versions kept of each JSON file, as <file>.1, <file>.2, etc.
+var jsonGenerations = 2
+
 func loadJSON(path string, obj interface{}) error {
 	var file []byte
 	var err error
-	file, err = os.ReadFile(path)
-	if err != nil {
+	file, err = readJSONFile(path)
+	if err != nil || file == nil {
 		file = []byte("{}")
 	}
 	err = json.Unmarshal(file, &obj)
@@ -925,9 +2145,95 @@ func storeJSON(path string, obj interface{}) error {
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(path, data, 0644)
+	err = writeFileAtomic(path, data, jsonGenerations)
 	if err != nil {
 		log.Printf("ERROR: Failed to write to \"%s\": %s", path, err)
 	}
 	return err
 }
+
+// readJSONFile reads path, falling back to the newest previous generation if it's missing or corrupt.
+// If neither exist, nil is returned with no error.
+func readJSONFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && json.Valid(data) {
+		return data, nil
+	}
+	for i := 1; i <= jsonGenerations; i++ {
+		old, oldErr := os.ReadFile(fmt.Sprintf("%s.%d", path, i))
+		if oldErr == nil && json.Valid(old) {
+			log.Printf("WARN: \"%s\" was missing or corrupt, using previous version \"%s.%d\"", path, path, i)
+			return old, nil
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it and renames it over path,
+// so a crash can never leave path half-written. Up to the given number of previous versions are kept.
+func writeFileAtomic(path string, data []byte, generations int) error {
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if generations > 0 {
+		if err := rotateGenerations(path, generations); err != nil {
+			log.Printf("WARN: Failed to keep previous version of \"%s\": %s", path, err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Make sure the rename itself is on disk. Directories can't be synced on Windows, so errors are ignored.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// rotateGenerations shifts <path>.1 to <path>.2 and so on, then copies path to <path>.1.
+// path itself is left in place so there's never a moment without it.
+func rotateGenerations(path string, generations int) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	for i := generations; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", path, i-1), fmt.Sprintf("%s.%d", path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	prev := path + ".1"
+	os.Remove(prev)
+	if os.Link(path, prev) == nil {
+		return nil
+	}
+	// Not all filesystems support hard links.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeSynced(prev, data)
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
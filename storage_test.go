@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/jfa-go/logger"
+	"github.com/hrfee/mediabrowser"
+	"gopkg.in/ini.v1"
+)
+
+// mockJellyfin implements just enough of the Jellyfin API for newUser, checkUsers and looking up admins.
+type mockJellyfin struct {
+	lock  sync.Mutex
+	users []mediabrowser.User
+}
+
+func (jf *mockJellyfin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	jf.lock.Lock()
+	defer jf.lock.Unlock()
+	path := strings.ToLower(r.URL.Path)
+	switch {
+	case path == "/system/info/public":
+		json.NewEncoder(w).Encode(mediabrowser.ServerInfo{Name: "test", Version: "10.7.0"})
+	case path == "/users/new" && r.Method == "POST":
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		user := mediabrowser.User{Name: req["Name"], ID: fmt.Sprintf("%032x", len(jf.users)+1)}
+		jf.users = append(jf.users, user)
+		json.NewEncoder(w).Encode(user)
+	case path == "/users" && r.Method == "GET":
+		json.NewEncoder(w).Encode(jf.users)
+	case strings.HasPrefix(path, "/users/") && r.Method == "GET":
+		for _, user := range jf.users {
+			if user.ID == strings.TrimPrefix(path, "/users/") {
+				json.NewEncoder(w).Encode(user)
+				return
+			}
+		}
+		w.WriteHeader(404)
+	case strings.HasSuffix(path, "/policy") && r.Method == "POST":
+		id := strings.Split(path, "/")[2]
+		var policy mediabrowser.Policy
+		json.NewDecoder(r.Body).Decode(&policy)
+		for i := range jf.users {
+			if jf.users[i].ID == id {
+				jf.users[i].Policy = policy
+				return
+			}
+		}
+		w.WriteHeader(404)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func newTestApp(t *testing.T, jfURL string) *appContext {
+	dir := t.TempDir()
+	app := &appContext{
+		config:   ini.Empty(),
+		dataPath: dir,
+		info:     logger.NewEmptyLogger(),
+		debug:    logger.NewEmptyLogger(),
+		err:      logger.NewEmptyLogger(),
+	}
+	var err error
+	app.storage.backend, err = newJSONBackend(dir, func(key string) string {
+		return filepath.Join(dir, key+".json")
+	})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	app.jf, err = mediabrowser.NewServer(mediabrowser.JellyfinServer, jfURL, "jfa-go", "test", "test", "test", mediabrowser.NewNamedTimeoutHandler("Jellyfin", jfURL, true), 30)
+	if err != nil {
+		t.Fatalf("Failed to connect to mock Jellyfin: %v", err)
+	}
+	return app
+}
+
+// TestStorageRace creates users, changes contact methods and checks expiries all at once.
+// Run with -race, as it won't necessarily fail without.
+func TestStorageRace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jf := &mockJellyfin{}
+	// GetUsers assumes there's at least one user.
+	jf.users = append(jf.users, mediabrowser.User{Name: "admin", ID: fmt.Sprintf("%032x", 0)})
+	server := httptest.NewServer(jf)
+	defer server.Close()
+	app := newTestApp(t, server.URL)
+	app.registerContactMethod(&TelegramDaemon{app: app})
+	app.registerContactMethod(&DiscordDaemon{app: app})
+	app.registerContactMethod(&MatrixDaemon{app: app})
+	app.registerContactMethod(emailContactMethod{app})
+
+	const n = 20
+	// Expiry in the past, so checkUsers acts on users as soon as they're created.
+	app.storage.SetInvitesKey("test", Invite{NoLimit: true, UserExpiry: true, UserMinutes: -1})
+	if err := app.storage.storeInvites(); err != nil {
+		t.Fatalf("Failed to store invite: %v", err)
+	}
+	// Existing users with every contact method, for SetContactMethods to change.
+	contactIDs := make([]string, n)
+	for i := range contactIDs {
+		id := fmt.Sprintf("contact%d", i)
+		contactIDs[i] = id
+		app.storage.SetEmailsKey(id, EmailAddress{Addr: id + "@example.com", Contact: true})
+		app.storage.SetTelegramKey(id, TelegramUser{ChatID: int64(i), Username: id, Contact: true})
+		app.storage.SetDiscordKey(id, DiscordUser{ID: id, Username: id, Contact: true})
+		app.storage.SetMatrixKey(id, MatrixUser{UserID: "@" + id + ":example.com", Contact: true})
+	}
+
+	var wg sync.WaitGroup
+	failures := make(chan string, 3*n)
+	for i := 0; i < n; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			_, success := app.newUser(newUserDTO{
+				Username: fmt.Sprintf("user%d", i),
+				Password: "password",
+				Email:    fmt.Sprintf("user%d@example.com", i),
+				Code:     "test",
+			}, true)
+			if !success {
+				failures <- fmt.Sprintf("newUser failed for user%d", i)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]interface{}{"id": contactIDs[i], "email": i%2 == 0, "telegram": i%3 == 0})
+			w := httptest.NewRecorder()
+			gc, _ := gin.CreateTestContext(w)
+			gc.Request = httptest.NewRequest("POST", "/users/contact", bytes.NewReader(body))
+			app.SetContactMethods(gc)
+			if w.Code != 200 {
+				failures <- fmt.Sprintf("SetContactMethods for %s returned %d", contactIDs[i], w.Code)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			app.checkUsers()
+		}()
+	}
+	wg.Wait()
+	close(failures)
+	for f := range failures {
+		t.Error(f)
+	}
+
+	if len(jf.users) != n+1 {
+		t.Errorf("Expected %d Jellyfin users, got %d", n+1, len(jf.users))
+	}
+	for i, id := range contactIDs {
+		email, _ := app.storage.GetEmailsKey(id)
+		tgUser, _ := app.storage.GetTelegramKey(id)
+		if email.Contact != (i%2 == 0) || tgUser.Contact != (i%3 == 0) {
+			t.Errorf("Contact methods for %s weren't set", id)
+		}
+	}
+	// Whatever was written last should match what's in memory.
+	stored := Storage{backend: app.storage.backend}
+	for key, loaded := range map[string]func() error{
+		"emails":         stored.loadEmails,
+		"telegram_users": stored.loadTelegramUsers,
+		"discord_users":  stored.loadDiscordUsers,
+		"matrix_users":   stored.loadMatrixUsers,
+	} {
+		if err := loaded(); err != nil {
+			t.Fatalf("Failed to load %s: %v", key, err)
+		}
+	}
+	if !reflect.DeepEqual(stored.GetEmails(), app.storage.GetEmails()) {
+		t.Error("Stored emails don't match those in memory")
+	}
+	if !reflect.DeepEqual(stored.GetTelegram(), app.storage.GetTelegram()) {
+		t.Error("Stored Telegram users don't match those in memory")
+	}
+	if !reflect.DeepEqual(stored.GetDiscord(), app.storage.GetDiscord()) {
+		t.Error("Stored Discord users don't match those in memory")
+	}
+	if !reflect.DeepEqual(stored.GetMatrix(), app.storage.GetMatrix()) {
+		t.Error("Stored Matrix users don't match those in memory")
+	}
+}
+
+// TestActivityAppend checks logging activity only appends to what's stored, and that entries survive a reload before and after the log is pruned.
+func TestActivityAppend(t *testing.T) {
+	app := newTestApp(t, "http://localhost")
+	for i := 0; i < 3; i++ {
+		app.daemonActivity("test", activityUserExpired, []string{fmt.Sprint(i)}, "")
+	}
+	if value, _ := app.storage.backend.Get("activity"); value != nil {
+		t.Errorf("Expected the stored log not to be rewritten, got %s", value)
+	}
+	if records, _ := app.storage.backend.Appended("activity"); len(records) != 3 {
+		t.Errorf("Expected 3 appended entries, got %d", len(records))
+	}
+	reload := func() {
+		app.storage.activity = nil
+		if err := app.storage.loadActivity(); err != nil {
+			t.Fatalf("Failed to load activity: %v", err)
+		}
+	}
+	reload()
+	if n := len(app.storage.GetActivity()); n != 3 {
+		t.Errorf("Expected 3 entries after reloading, got %d", n)
+	}
+
+	app.config.Section("activity_log").Key("keep_n_records").SetValue("2")
+	app.pruneActivity()
+	if records, _ := app.storage.backend.Appended("activity"); len(records) != 0 || app.storage.ActivityAppended() != 0 {
+		t.Errorf("Expected pruning to fold in appended entries, %d left", len(records))
+	}
+	reload()
+	if n := len(app.storage.GetActivity()); n != 2 {
+		t.Errorf("Expected 2 entries after pruning, got %d", n)
+	}
+}
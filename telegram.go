@@ -46,7 +46,7 @@ func newTelegramDaemon(app *appContext) (*TelegramDaemon, error) {
 		link:            "https://t.me/" + bot.Self.UserName,
 		app:             app,
 	}
-	for _, user := range app.storage.telegram {
+	for _, user := range app.storage.GetTelegram() {
 		if user.Lang != "" {
 			td.languages[user.ChatID] = user.Lang
 		}
@@ -161,6 +161,7 @@ func (t *TelegramDaemon) Send(message *Message, ID ...int64) error {
 			msg.ParseMode = "MarkdownV2"
 		}
 		_, err := t.bot.Send(msg)
+		metrics.result(metricMessages, err, "daemon", "telegram")
 		if err != nil {
 			return err
 		}
@@ -182,6 +183,28 @@ func (t *TelegramDaemon) commandStart(upd *tg.Update, sects []string, lang strin
 	if err != nil {
 		t.app.err.Printf("Telegram: Failed to send message to \"%s\": %v", upd.Message.From.UserName, err)
 	}
+	t.linkImported(upd)
+}
+
+// linkImported fills in the chat ID of a user imported with only their Telegram username, once they've started a chat with the bot.
+func (t *TelegramDaemon) linkImported(upd *tg.Update) {
+	username := upd.Message.Chat.UserName
+	if username == "" {
+		return
+	}
+	for jfID, user := range t.app.storage.GetTelegram() {
+		if user.ChatID != 0 || !strings.EqualFold(user.Username, username) {
+			continue
+		}
+		user.ChatID = upd.Message.Chat.ID
+		user.Username = username
+		t.app.storage.SetTelegramKey(jfID, user)
+		if err := t.app.storage.storeTelegramUsers(); err != nil {
+			t.app.err.Printf("Failed to store Telegram users: %v", err)
+		}
+		t.app.info.Printf("Telegram: Linked imported user \"@%s\"", username)
+		return
+	}
 }
 
 func (t *TelegramDaemon) commandLang(upd *tg.Update, sects []string, lang string) {
@@ -198,10 +221,10 @@ func (t *TelegramDaemon) commandLang(upd *tg.Update, sects []string, lang string
 	}
 	if _, ok := t.app.storage.lang.Telegram[sects[1]]; ok {
 		t.languages[upd.Message.Chat.ID] = sects[1]
-		for jfID, user := range t.app.storage.telegram {
+		for jfID, user := range t.app.storage.GetTelegram() {
 			if user.ChatID == upd.Message.Chat.ID {
 				user.Lang = sects[1]
-				t.app.storage.telegram[jfID] = user
+				t.app.storage.SetTelegramKey(jfID, user)
 				if err := t.app.storage.storeTelegramUsers(); err != nil {
 					t.app.err.Printf("Failed to store Telegram users: %v", err)
 				}
@@ -238,3 +261,91 @@ func (t *TelegramDaemon) commandPIN(upd *tg.Update, sects []string, lang string)
 	t.tokens[len(t.tokens)-1], t.tokens[tokenIndex] = t.tokens[tokenIndex], t.tokens[len(t.tokens)-1]
 	t.tokens = t.tokens[:len(t.tokens)-1]
 }
+
+// ContactMethod implementation, registered in main once the daemon is running.
+
+func (t *TelegramDaemon) Name() string       { return "Telegram" }
+func (t *TelegramDaemon) Enabled() bool      { return telegramEnabled }
+func (t *TelegramDaemon) StorageKey() string { return "telegram_users" }
+
+func (t *TelegramDaemon) Linked(jfID string) bool {
+	_, ok := t.app.storage.GetTelegramKey(jfID)
+	return ok
+}
+
+func (t *TelegramDaemon) DisplayName(jfID string) string {
+	user, _ := t.app.storage.GetTelegramKey(jfID)
+	return "@" + user.Username
+}
+
+func (t *TelegramDaemon) Contact(jfID string) bool {
+	user, ok := t.app.storage.GetTelegramKey(jfID)
+	return ok && user.Contact
+}
+
+func (t *TelegramDaemon) SetContact(jfID string, contact bool) {
+	if user, ok := t.app.storage.GetTelegramKey(jfID); ok {
+		user.Contact = contact
+		t.app.storage.SetTelegramKey(jfID, user)
+	}
+}
+
+func (t *TelegramDaemon) SendByID(msg *Message, jfID string) error {
+	user, ok := t.app.storage.GetTelegramKey(jfID)
+	// Imported users can't be messaged until they've started a chat with the bot, see linkImported.
+	if !ok || user.ChatID == 0 {
+		return nil
+	}
+	return t.Send(msg, user.ChatID)
+}
+
+func (t *TelegramDaemon) Account(jfID string) contactAccountDTO {
+	tgUser, _ := t.app.storage.GetTelegramKey(jfID)
+	return contactAccountDTO{Name: tgUser.Username, Contact: tgUser.Contact}
+}
+
+func (t *TelegramDaemon) Unlink(jfID string) { t.app.storage.DeleteTelegramKey(jfID) }
+
+func (t *TelegramDaemon) Required() bool {
+	return t.app.config.Section("telegram").Key("required").MustBool(false)
+}
+
+func (t *TelegramDaemon) verifiedTokenIndex(pin string) int {
+	for i, v := range t.verifiedTokens {
+		if v.Token == pin {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *TelegramDaemon) Verified(pin string) bool { return t.verifiedTokenIndex(pin) != -1 }
+
+func (t *TelegramDaemon) Issued(pin string) bool {
+	for _, token := range t.tokens {
+		if token == pin {
+			return true
+		}
+	}
+	return t.Verified(pin)
+}
+
+func (t *TelegramDaemon) Link(jfID, pin string, contact bool) error {
+	i := t.verifiedTokenIndex(pin)
+	if i == -1 {
+		return fmt.Errorf("PIN not verified")
+	}
+	token := t.verifiedTokens[i]
+	user := TelegramUser{
+		ChatID:   token.ChatID,
+		Username: token.Username,
+		Contact:  contact,
+	}
+	if lang, ok := t.languages[token.ChatID]; ok {
+		user.Lang = lang
+	}
+	t.app.storage.SetTelegramKey(jfID, user)
+	t.verifiedTokens[len(t.verifiedTokens)-1], t.verifiedTokens[i] = t.verifiedTokens[i], t.verifiedTokens[len(t.verifiedTokens)-1]
+	t.verifiedTokens = t.verifiedTokens[:len(t.verifiedTokens)-1]
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer = "jfa-go"
+	// Time given to enter a code after the password is accepted.
+	totpChallengeLength = 5 * time.Minute
+	totpRecoveryCodes   = 10
+	totpQRSize          = 256
+	// Codes are valid for one period either side of the current one, so a used code is kept for three.
+	totpReuseWindow = 90 * time.Second
+)
+
+// TOTPAccount is an admin's TOTP secret and recovery codes. It's kept under the key given by totpAccount.
+type TOTPAccount struct {
+	Name          string    `json:"name"`
+	Secret        string    `json:"secret"`
+	Enabled       bool      `json:"enabled"`        // False until enrollment is confirmed with a code.
+	RecoveryCodes []string  `json:"recovery_codes"` // SHA-256 hashes, removed once used.
+	Created       time.Time `json:"created"`
+	LastCode      string    `json:"last_code"` // Stops a code being used twice.
+	LastUsed      time.Time `json:"last_used"`
+}
+
+// totpAccount returns the key an admin's TOTP is stored under, and their username.
+// Jellyfin users are identified by their Jellyfin ID, OpenID Connect admins by their subject and the local admin by their username, as user IDs change every login or restart.
+func (app *appContext) totpAccount(userID, jfID string) (key, name string) {
+	if jfID != "" {
+		name = jfID
+		if user, status, err := app.getJFUserByID(jfID); status == 200 && err == nil {
+			name = user.Name
+		}
+		return jfID, name
+	}
+	user, ok := app.findUser(func(user User) bool { return user.UserID == userID })
+	if !ok {
+		return "", ""
+	} else if user.Subject != "" {
+		return oidcOwnerPrefix + user.Subject, user.Username
+	}
+	return "local:" + user.Username, user.Username
+}
+
+// totpEnabled returns whether the admin needs to give a TOTP code to log in.
+func (app *appContext) totpEnabled(key string) bool {
+	account, ok := app.storage.GetTOTPKey(key)
+	return ok && account.Enabled
+}
+
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(hash[:])
+}
+
+// newRecoveryCodes returns a set of codes to show the user, and their hashes to store.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < totpRecoveryCodes; i++ {
+		raw := make([]byte, 5)
+		if _, err = rand.Read(raw); err != nil {
+			return
+		}
+		code := base32.StdEncoding.EncodeToString(raw)
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return
+}
+
+// checkTOTP validates a TOTP or recovery code for the account, consuming recovery codes and recording used TOTP codes, and stores the change.
+func (app *appContext) checkTOTP(key, code string) bool {
+	app.totpLock.Lock()
+	defer app.totpLock.Unlock()
+	account, ok := app.storage.GetTOTPKey(key)
+	if !ok || code == "" {
+		return false
+	}
+	now := time.Now()
+	if totp.Validate(code, account.Secret) {
+		if code == account.LastCode && now.Sub(account.LastUsed) < totpReuseWindow {
+			return false
+		}
+		account.LastCode = code
+		account.LastUsed = now
+		app.storage.SetTOTPKey(key, account)
+		app.storeTOTPUse()
+		return true
+	}
+	if !account.Enabled {
+		return false
+	}
+	hash := hashRecoveryCode(code)
+	for i, recovery := range account.RecoveryCodes {
+		if recovery == hash {
+			account.RecoveryCodes = append(account.RecoveryCodes[:i], account.RecoveryCodes[i+1:]...)
+			app.storage.SetTOTPKey(key, account)
+			app.storeTOTPUse()
+			app.info.Printf("Recovery code used for \"%s\", %d left", account.Name, len(account.RecoveryCodes))
+			return true
+		}
+	}
+	return false
+}
+
+// storeTOTPUse stores a used code. A failure is only logged, as the code was still valid.
+func (app *appContext) storeTOTPUse() {
+	if err := app.storage.storeTOTP(); err != nil {
+		app.err.Printf("Failed to store 2FA secrets: %v", err)
+	}
+}
+
+// createTOTPChallenge returns a token standing in for a correct username and password until a TOTP code is given.
+func createTOTPChallenge(userID, jfID string) (string, error) {
+	claims := jwt.MapClaims{
+		"valid": true,
+		"id":    userID,
+		"exp":   time.Now().Add(totpChallengeLength).Unix(),
+		"jfid":  jfID,
+		"type":  "totp",
+	}
+	tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return tk.SignedString([]byte(os.Getenv("JFA_SECRET")))
+}
+
+// @Summary Completes a login for admins with 2FA enabled, exchanging the challenge from /token/login and a TOTP or recovery code for an API token.
+// @Produce json
+// @Param totpLoginDTO body totpLoginDTO true "Challenge and code."
+// @Success 200 {object} getTokenDTO
+// @Failure 401 {object} stringResponse
+// @Router /token/totp [post]
+// @tags Auth
+func (app *appContext) getTokenTOTP(gc *gin.Context) {
+	var req totpLoginDTO
+	gc.BindJSON(&req)
+	token, err := jwt.Parse(req.Challenge, checkToken)
+	if err != nil {
+		app.debug.Printf("Auth denied: %s", err)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !(ok && token.Valid && claims["type"] == "totp") {
+		app.debug.Println("Auth denied: Invalid 2FA challenge")
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	userID, _ := claims["id"].(string)
+	jfID, _ := claims["jfid"].(string)
+	key, name := app.totpAccount(userID, jfID)
+	if key == "" || !app.checkTOTP(key, req.Code) {
+		app.info.Printf("Auth denied: Invalid 2FA code for \"%s\"", name)
+		metrics.inc(metricLogins, "result", metricResultFailure)
+		app.failedAttempt(gc, name)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	app.succeededAttempt(gc, name)
+	app.issueTokens(userID, jfID, gc)
+}
+
+// @Summary Returns whether 2FA is enabled for the logged in admin, and how many recovery codes they have left.
+// @Produce json
+// @Success 200 {object} totpStatusDTO
+// @Router /totp [get]
+// @Security Bearer
+// @tags 2FA
+func (app *appContext) GetTOTP(gc *gin.Context) {
+	key, _ := app.totpAccount(gc.GetString("userId"), gc.GetString("jfId"))
+	account, _ := app.storage.GetTOTPKey(key)
+	gc.JSON(200, totpStatusDTO{Enabled: account.Enabled, RecoveryCodes: len(account.RecoveryCodes)})
+}
+
+// @Summary Starts 2FA enrollment for the logged in admin, returning a secret and QR code for their authenticator app. Enrollment is finished with /totp/confirm.
+// @Produce json
+// @Success 200 {object} totpEnrollDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /totp/enroll [post]
+// @Security Bearer
+// @tags 2FA
+func (app *appContext) EnrollTOTP(gc *gin.Context) {
+	key, name := app.totpAccount(gc.GetString("userId"), gc.GetString("jfId"))
+	if key == "" {
+		respond(400, "Unknown user", gc)
+		return
+	}
+	if app.totpEnabled(key) {
+		respond(400, "2FA already enabled", gc)
+		return
+	}
+	otpKey, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: name})
+	if err != nil {
+		app.err.Printf("Failed to generate 2FA secret: %v", err)
+		respond(500, "Couldn't generate secret", gc)
+		return
+	}
+	img, err := otpKey.Image(totpQRSize, totpQRSize)
+	var qr bytes.Buffer
+	if err == nil {
+		err = png.Encode(&qr, img)
+	}
+	if err != nil {
+		app.err.Printf("Failed to generate 2FA QR code: %v", err)
+		respond(500, "Couldn't generate QR code", gc)
+		return
+	}
+	app.storage.SetTOTPKey(key, TOTPAccount{
+		Name:    name,
+		Secret:  otpKey.Secret(),
+		Created: time.Now(),
+	})
+	if err := app.storage.storeTOTP(); err != nil {
+		app.err.Printf("Failed to store 2FA secrets: %v", err)
+		respond(500, "Couldn't store secret", gc)
+		return
+	}
+	gc.JSON(200, totpEnrollDTO{
+		Secret: otpKey.Secret(),
+		URL:    otpKey.URL(),
+		QR:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+	})
+}
+
+// @Summary Finishes 2FA enrollment with a code from the authenticator app, returning recovery codes which should be noted down.
+// @Produce json
+// @Param totpCodeDTO body totpCodeDTO true "Code from the authenticator app."
+// @Success 200 {object} totpRecoveryCodesDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /totp/confirm [post]
+// @Security Bearer
+// @tags 2FA
+func (app *appContext) ConfirmTOTP(gc *gin.Context) {
+	var req totpCodeDTO
+	gc.BindJSON(&req)
+	key, _ := app.totpAccount(gc.GetString("userId"), gc.GetString("jfId"))
+	account, ok := app.storage.GetTOTPKey(key)
+	if !ok || account.Enabled {
+		respond(400, "Enrollment not started", gc)
+		return
+	}
+	if !app.checkTOTP(key, req.Code) {
+		respond(400, "Invalid code", gc)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.err.Printf("Failed to generate recovery codes: %v", err)
+		respond(500, "Couldn't generate recovery codes", gc)
+		return
+	}
+	account, _ = app.storage.GetTOTPKey(key)
+	account.Enabled = true
+	account.RecoveryCodes = hashes
+	app.storage.SetTOTPKey(key, account)
+	if err := app.storage.storeTOTP(); err != nil {
+		app.err.Printf("Failed to store 2FA secrets: %v", err)
+		respond(500, "Couldn't store secret", gc)
+		return
+	}
+	app.info.Printf("2FA enabled for \"%s\"", account.Name)
+	app.adminActivity(gc, activityTOTPEnabled, []string{key}, "")
+	gc.JSON(200, totpRecoveryCodesDTO{RecoveryCodes: codes})
+}
+
+// @Summary Disables 2FA for the logged in admin. Requires a current TOTP or recovery code.
+// @Produce json
+// @Param totpCodeDTO body totpCodeDTO true "TOTP or recovery code."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /totp/disable [post]
+// @Security Bearer
+// @tags 2FA
+func (app *appContext) DisableTOTP(gc *gin.Context) {
+	var req totpCodeDTO
+	gc.BindJSON(&req)
+	key, _ := app.totpAccount(gc.GetString("userId"), gc.GetString("jfId"))
+	if !app.totpEnabled(key) {
+		respond(400, "2FA not enabled", gc)
+		return
+	}
+	if !app.checkTOTP(key, req.Code) {
+		respond(400, "Invalid code", gc)
+		return
+	}
+	app.removeTOTP(key, gc)
+}
+
+// @Summary Resets another admin's 2FA, e.g. if they've lost their authenticator and recovery codes.
+// @Produce json
+// @Param id path string true "Jellyfin ID of the admin, or \"local:<username>\" for the admin set in Settings."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /totp/{id} [delete]
+// @Security Bearer
+// @tags 2FA
+func (app *appContext) ResetTOTP(gc *gin.Context) {
+	key := gc.Param("id")
+	if _, ok := app.storage.GetTOTPKey(key); !ok {
+		respond(404, "2FA not set up", gc)
+		return
+	}
+	app.removeTOTP(key, gc)
+}
+
+func (app *appContext) removeTOTP(key string, gc *gin.Context) {
+	account, _ := app.storage.GetTOTPKey(key)
+	app.storage.DeleteTOTPKey(key)
+	if err := app.storage.storeTOTP(); err != nil {
+		app.err.Printf("Failed to store 2FA secrets: %v", err)
+		respond(500, "Couldn't store secrets", gc)
+		return
+	}
+	app.info.Printf("2FA disabled for \"%s\"", account.Name)
+	app.adminActivity(gc, activityTOTPDisabled, []string{key}, fmt.Sprintf("Disabled for \"%s\"", account.Name))
+	respondBool(200, true, gc)
+}
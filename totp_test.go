@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+)
+
+// newTOTPTestApp returns an app with a local admin "admin", who has 2FA enabled, and their recovery codes.
+func newTOTPTestApp(t *testing.T) (*appContext, string, []string) {
+	gin.SetMode(gin.TestMode)
+	app := newTestApp(t, "http://localhost")
+	app.users = append(app.users, User{UserID: "admin-id", Username: "admin", Password: "password"})
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "admin"})
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("Failed to generate recovery codes: %v", err)
+	}
+	app.storage.SetTOTPKey("local:admin", TOTPAccount{Name: "admin", Secret: key.Secret(), Enabled: true, RecoveryCodes: hashes})
+	return app, key.Secret(), codes
+}
+
+// totpLogin logs in as admin, returning the 2FA challenge.
+func totpLogin(t *testing.T, app *appContext) string {
+	w := httptest.NewRecorder()
+	gc, _ := gin.CreateTestContext(w)
+	gc.Request = httptest.NewRequest("GET", "/token/login", nil)
+	gc.Request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password")))
+	app.getTokenLogin(gc)
+	var resp getTokenDTO
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || !resp.TOTPRequired || resp.Challenge == "" || resp.Token != "" {
+		t.Fatalf("Expected a 2FA challenge and no token, got %d: %s", w.Code, w.Body.String())
+	}
+	return resp.Challenge
+}
+
+// totpCode sends a challenge and code to /token/totp, returning the status and token.
+func totpCode(app *appContext, challenge, code string) (int, string) {
+	w := httptest.NewRecorder()
+	gc, _ := gin.CreateTestContext(w)
+	body, _ := json.Marshal(totpLoginDTO{Challenge: challenge, Code: code})
+	gc.Request = httptest.NewRequest("POST", "/token/totp", bytes.NewReader(body))
+	app.getTokenTOTP(gc)
+	var resp getTokenDTO
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Token
+}
+
+func TestTOTPLogin(t *testing.T) {
+	app, secret, recovery := newTOTPTestApp(t)
+	challenge := totpLogin(t, app)
+	if status, _ := totpCode(app, challenge, "000000x"); status != 401 {
+		t.Errorf("Expected 401 for a wrong code, got %d", status)
+	}
+	code, _ := totp.GenerateCode(secret, time.Now())
+	if status, token := totpCode(app, challenge, code); status != 200 || token == "" {
+		t.Fatalf("Expected a token for a valid code, got %d", status)
+	}
+	// The same code can't be used again, even with a new challenge.
+	if status, _ := totpCode(app, totpLogin(t, app), code); status != 401 {
+		t.Errorf("Expected 401 for a replayed code, got %d", status)
+	}
+	// Recovery codes work once each, in either case and without the dash.
+	if status, _ := totpCode(app, challenge, recovery[0]); status != 200 {
+		t.Errorf("Expected a recovery code to work, got %d", status)
+	}
+	if status, _ := totpCode(app, challenge, recovery[0]); status != 401 {
+		t.Errorf("Expected 401 for a used recovery code, got %d", status)
+	}
+	if status, _ := totpCode(app, challenge, strings.ToLower(recovery[1][:4]+recovery[1][5:])); status != 200 {
+		t.Errorf("Expected a recovery code in lower case without the dash to work, got %d", status)
+	}
+	if account, _ := app.storage.GetTOTPKey("local:admin"); len(account.RecoveryCodes) != totpRecoveryCodes-2 {
+		t.Errorf("Expected %d recovery codes left, got %d", totpRecoveryCodes-2, len(account.RecoveryCodes))
+	}
+}
+
+func TestTOTPChallenge(t *testing.T) {
+	app, secret, _ := newTOTPTestApp(t)
+	code, _ := totp.GenerateCode(secret, time.Now())
+	// A refresh token, or a challenge for someone else, isn't a challenge for admin.
+	_, refresh, _ := CreateToken("admin-id", "", "session")
+	if status, _ := totpCode(app, refresh, code); status != 401 {
+		t.Errorf("Expected 401 for a refresh token as the challenge, got %d", status)
+	}
+	other, _ := createTOTPChallenge("someone-else", "")
+	if status, _ := totpCode(app, other, code); status != 401 {
+		t.Errorf("Expected 401 for another user's challenge, got %d", status)
+	}
+	if status, _ := totpCode(app, "", code); status != 401 {
+		t.Errorf("Expected 401 for no challenge, got %d", status)
+	}
+	// Rejected challenges shouldn't have used up the code.
+	if status, _ := totpCode(app, totpLogin(t, app), code); status != 200 {
+		t.Errorf("Expected the code to still work, got %d", status)
+	}
+}
+
+// TestTOTPConcurrent checks a code sent in several requests at once is only accepted by one of them.
+func TestTOTPConcurrent(t *testing.T) {
+	app, secret, recovery := newTOTPTestApp(t)
+	code, _ := totp.GenerateCode(secret, time.Now())
+	for _, c := range []string{code, recovery[0]} {
+		var wg sync.WaitGroup
+		var accepted int32
+		start := make(chan bool)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if app.checkTOTP("local:admin", c) {
+					atomic.AddInt32(&accepted, 1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if accepted != 1 {
+			t.Errorf("Expected %s to be accepted once, was accepted %d times", c, accepted)
+		}
+	}
+}
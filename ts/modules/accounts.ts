@@ -22,6 +22,15 @@ interface User {
     notify_matrix: boolean;
     label: string;
     accounts_admin: boolean;
+    invite?: UserInvite;
+}
+
+interface UserInvite {
+    code: string;
+    label?: string;
+    profile?: string;
+    inviter?: string;
+    created: number;
 }
 
 interface getPinResponse {
@@ -398,6 +407,26 @@ class user implements User {
         }
     }
 
+    private _invite: UserInvite;
+    get invite(): UserInvite { return this._invite; }
+    set invite(inv: UserInvite) {
+        this._invite = inv;
+        if (!inv) {
+            this._username.title = "";
+            return;
+        }
+        let name = inv.code;
+        if (inv.label) { name += ` (${inv.label})`; }
+        if (inv.inviter) { name += ", " + window.lang.var("strings", "inviteCreatedBy", inv.inviter); }
+        this._username.title = window.lang.var("strings", "signedUpWithInvite", name);
+    }
+
+    // invitedWith returns whether the user signed up with the invite with the given code or label, in lower case.
+    invitedWith = (inv: string): boolean => {
+        if (!this._invite) { return false; }
+        return this._invite.code.toLowerCase() == inv || (this._invite.label || "").toLowerCase() == inv;
+    }
+
     get label(): string { return this._userLabel; }
     set label(l: string) {
         this._userLabel = l ? l : "";
@@ -649,6 +678,7 @@ class user implements User {
         this.discord_id = user.discord_id;
         this.label = user.label;
         this.accounts_admin = user.accounts_admin;
+        this.invite = user.invite;
     }
 
     asElement = (): HTMLTableRowElement => { return this._row; }
@@ -723,14 +753,14 @@ export class accountsList {
     search = (query: string): string[] => {
         query = query.toLowerCase()
         let result: string[] = [];
-        if (query.includes(":")) {  // Support admin:<true/false> and disabled:<true/false>
-            const words = query.split(" ");
-            query = "";
+        if (query.includes(":")) {  // Support admin:<true/false>, disabled:<true/false>, invite:<code/label> and inviter:<name>
+            const words = query.split(" ").filter((word: string) => word != "");
+            let text: string[] = [];
             for (let word of words) {
                 if (word.includes(":")) {
                     const querySplit = word.split(":")
                     let state = false;
-                    if (querySplit[1] == "true" || querySplit[1] == "yes") {
+                    if (querySplit[1] == "true" || querySplit[1] == "yes" || querySplit[0] == "invite" || querySplit[0] == "inviter") {
                         state = true;
                     }
                     for (let id in this._users) {
@@ -738,10 +768,13 @@ export class accountsList {
                         let attrib: boolean;
                         if (querySplit[0] == "admin") { attrib = user.admin; }
                         else if (querySplit[0] == "disabled") { attrib = user.disabled; }
+                        else if (querySplit[0] == "invite") { attrib = user.invitedWith(querySplit[1]); }
+                        else if (querySplit[0] == "inviter") { attrib = user.invite != undefined && (user.invite.inviter || "").toLowerCase() == querySplit[1]; }
                         if (attrib == state) { result.push(id); }
                     }
-                } else { query += word + " "; }
+                } else { text.push(word); }
             }
+            query = text.join(" ");
         }
         if (query == "") { return result; }
         for (let id in this._users) {
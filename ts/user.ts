@@ -0,0 +1,393 @@
+import { Modal } from "./modules/modal.js";
+import { lang, LangFile, loadLangSelector } from "./modules/lang.js";
+import { notificationBox, whichAnimationEvent, toDateString } from "./modules/common.js";
+import { _get, _post, _delete, toggleLoader, addLoader, removeLoader } from "./modules/common.js";
+import { initValidator } from "./modules/validator.js";
+
+interface userWindow extends Window {
+    messages: { [key: string]: string };
+    userExpiryMessage: string;
+    referralStrings: { remaining: string; next: string; validUntil: string };
+    loginModal: Modal;
+    telegramModal: Modal;
+    discordModal: Modal;
+    matrixModal: Modal;
+}
+
+declare var window: userWindow;
+
+interface ContactMethod {
+    method: string;
+    linked: boolean;
+    value: string;
+    contact: boolean;
+}
+
+interface Details {
+    id: string;
+    username: string;
+    expiry: number;
+    email: string;
+    contact_methods: ContactMethod[];
+}
+
+interface ReferralInvite {
+    code: string;
+    valid_till: number;
+    remaining_uses: number;
+    used: number;
+}
+
+interface Referrals {
+    allowed: boolean;
+    remaining: number;
+    next_allowed: number;
+    invites: ReferralInvite[];
+}
+
+window.lang = new lang(window.langFile as LangFile);
+loadLangSelector("form");
+
+window.notifications = new notificationBox(document.getElementById("notification-box") as HTMLDivElement);
+
+window.animationEvent = whichAnimationEvent();
+
+window.token = "";
+
+window.loginModal = new Modal(document.getElementById("modal-login"), true);
+
+const logoutButton = document.getElementById("logout-button") as HTMLSpanElement;
+
+const showError = (type: string, req: XMLHttpRequest) => {
+    let msg = window.messages["errorUnknown"];
+    if (req.status == 429) {
+        msg = window.messages["errorTooManyRequests"];
+    } else if (req.response && req.response["error"] && window.messages[req.response["error"]]) {
+        msg = window.messages[req.response["error"]];
+    }
+    window.notifications.customError(type, msg);
+};
+
+const loadDetails = () => _get("/my/details", null, (req: XMLHttpRequest) => {
+    if (req.readyState != 4) { return; }
+    if (req.status != 200) {
+        if (req.status == 401) { window.loginModal.show(); }
+        return;
+    }
+    const details = req.response as Details;
+    document.getElementById("user-username").textContent = details.username;
+    const expiryMessage = document.getElementById("user-expiry-message") as HTMLElement;
+    if (details.expiry) {
+        expiryMessage.textContent = window.userExpiryMessage.replace("{date}", toDateString(new Date(details.expiry * 1000)));
+        expiryMessage.classList.remove("unfocused");
+    } else {
+        expiryMessage.classList.add("unfocused");
+    }
+    const email = document.getElementById("user-email") as HTMLInputElement;
+    if (email) { email.value = details.email; }
+    for (let method of details.contact_methods) {
+        const contact = document.getElementById("contact-via-" + method.method) as HTMLInputElement;
+        if (contact) {
+            contact.checked = method.contact;
+            contact.parentElement.classList.toggle("unfocused", !method.linked);
+        }
+        const row = document.getElementById("method-" + method.method);
+        if (!row) { continue; }
+        row.querySelector(".method-value").textContent = method.linked ? method.value : "";
+        row.querySelector(".method-unlink").classList.toggle("unfocused", !method.linked);
+    }
+    loadReferrals();
+});
+
+const referralsCard = document.getElementById("referrals") as HTMLDivElement;
+const referralsCreate = document.getElementById("referrals-create") as HTMLSpanElement;
+
+// loadReferrals shows the user's referral invites, if they're allowed to create them.
+const loadReferrals = () => _get("/my/referrals", null, (req: XMLHttpRequest) => {
+    if (req.readyState != 4) { return; }
+    if (req.status != 200) {
+        referralsCard.classList.add("unfocused");
+        return;
+    }
+    const referrals = req.response as Referrals;
+    referralsCard.classList.toggle("unfocused", !referrals.allowed);
+    if (!referrals.allowed) { return; }
+    const limit = document.getElementById("referrals-limit") as HTMLParagraphElement;
+    if (referrals.next_allowed) {
+        limit.textContent = window.referralStrings.next.replace("{date}", toDateString(new Date(referrals.next_allowed * 1000)));
+    } else if (referrals.remaining != -1) {
+        limit.textContent = window.referralStrings.remaining.replace("{n}", "" + referrals.remaining);
+    } else {
+        limit.textContent = "";
+    }
+    referralsCreate.classList.toggle("unfocused", referrals.remaining == 0 || referrals.next_allowed != 0);
+    const list = document.getElementById("referrals-list") as HTMLDivElement;
+    list.textContent = "";
+    for (let i = 0; i < referrals.invites.length; i++) {
+        const invite = referrals.invites[i];
+        const link = window.location.origin + window.URLBase + "/invite/" + invite.code;
+        const row = document.createElement("div") as HTMLDivElement;
+        row.innerHTML = `
+        <a class="font-mono break-all" target="_blank"></a>
+        <p class="support"></p>
+        `;
+        const a = row.querySelector("a") as HTMLAnchorElement;
+        a.href = link;
+        a.textContent = link;
+        row.querySelector("p").textContent = window.referralStrings.validUntil.replace("{date}", toDateString(new Date(invite.valid_till * 1000))).replace("{n}", "" + invite.remaining_uses);
+        list.appendChild(row);
+    }
+});
+
+referralsCreate.onclick = () => {
+    addLoader(referralsCreate);
+    _post("/my/referrals", null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) { return; }
+        removeLoader(referralsCreate);
+        if (req.status != 200) {
+            showError("referralError", req);
+            return;
+        }
+        window.notifications.customPositive("referralCreated", "", window.messages["inviteCreated"]);
+        loadReferrals();
+    }, true);
+};
+
+function login(username: string, password: string, run?: (state?: number) => void) {
+    const req = new XMLHttpRequest();
+    req.responseType = 'json';
+    const refresh = (username == "" && password == "");
+    req.open("GET", window.URLBase + (refresh ? "/my/token/refresh" : "/my/token/login"), true);
+    if (!refresh) {
+        req.setRequestHeader("Authorization", "Basic " + btoa(username + ":" + password));
+    }
+    req.onreadystatechange = function (): void {
+        if (this.readyState != 4) { return; }
+        if (this.status != 200) {
+            if (refresh) {
+                window.loginModal.show();
+            } else if (this.status == 401) {
+                window.notifications.customError("loginError", window.messages["errorLogin"]);
+            } else {
+                showError("loginError", this);
+            }
+        } else {
+            window.token = this.response["token"];
+            window.loginModal.close();
+            logoutButton.classList.remove("unfocused");
+            loadDetails();
+        }
+        if (run) { run(+this.status); }
+    };
+    req.send();
+}
+
+(document.getElementById("form-login") as HTMLFormElement).onsubmit = (event: SubmitEvent) => {
+    event.preventDefault();
+    const button = (event.target as HTMLElement).querySelector(".submit") as HTMLSpanElement;
+    const username = (document.getElementById("login-user") as HTMLInputElement).value;
+    const password = (document.getElementById("login-password") as HTMLInputElement).value;
+    if (!username || !password) { return; }
+    toggleLoader(button);
+    login(username, password, () => toggleLoader(button));
+};
+
+login("", "");
+
+logoutButton.onclick = () => _post("/my/logout", null, (req: XMLHttpRequest) => {
+    if (req.readyState == 4 && req.status == 200) {
+        window.token = "";
+        location.reload();
+    }
+});
+
+const emailForm = document.getElementById("form-email") as HTMLFormElement;
+if (emailForm) {
+    emailForm.onsubmit = (event: SubmitEvent) => {
+        event.preventDefault();
+        const button = emailForm.querySelector(".submit") as HTMLSpanElement;
+        addLoader(button);
+        const send = { email: (document.getElementById("user-email") as HTMLInputElement).value };
+        _post("/my/email", send, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) { return; }
+            removeLoader(button);
+            if (req.status != 200) {
+                showError("emailError", req);
+                return;
+            }
+            if (req.response && req.response["response"] == "confirmEmailSent") {
+                window.notifications.customPositive("emailChanged", "", window.messages["confirmEmailSent"]);
+                return;
+            }
+            window.notifications.customPositive("emailChanged", "", window.messages["changesSaved"]);
+            loadDetails();
+        }, true);
+    };
+}
+
+const contactBoxes = document.querySelectorAll("#contact-via input") as NodeListOf<HTMLInputElement>;
+for (let i = 0; i < contactBoxes.length; i++) {
+    contactBoxes[i].onchange = () => {
+        let send = {};
+        for (let j = 0; j < contactBoxes.length; j++) { send[contactBoxes[j].value] = contactBoxes[j].checked; }
+        _post("/my/contact", send, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) { return; }
+            if (req.status != 200) {
+                showError("contactError", req);
+                return;
+            }
+            window.notifications.customPositive("contactChanged", "", window.messages["changesSaved"]);
+        });
+    };
+}
+
+// linkMethod links the account which verified the PIN, then reloads the page's details.
+const linkMethod = (method: string, pin: string, modal: Modal) => _post("/my/link/" + method, { pin: pin }, (req: XMLHttpRequest) => {
+    if (req.readyState != 4) { return; }
+    modal.close();
+    if (req.status != 200) {
+        showError(method + "Error", req);
+        return;
+    }
+    window.notifications.customPositive(method + "Verified", "", window.messages["verified"]);
+    loadDetails();
+}, true);
+
+// Telegram and Discord accounts are linked by sending a PIN to the bot, which is polled for until it's verified.
+const setupBotLink = (method: string, modal: Modal) => {
+    const row = document.getElementById("method-" + method);
+    const waiting = document.getElementById(method + "-waiting") as HTMLSpanElement;
+    let polling = false;
+    modal.onclose = () => {
+        if (polling) { toggleLoader(waiting); }
+        polling = false;
+    };
+    (row.querySelector(".method-link") as HTMLSpanElement).onclick = () => _get("/my/pin/" + method, null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) { return; }
+        if (req.status != 200) {
+            showError(method + "Error", req);
+            return;
+        }
+        const pin = req.response["token"] as string;
+        document.getElementById(method + "-pin").textContent = pin;
+        toggleLoader(waiting);
+        polling = true;
+        modal.show();
+        const checkVerified = () => _get("/my/pin/" + method + "/verified/" + pin, null, (req: XMLHttpRequest) => {
+            if (req.readyState != 4 || !polling) { return; }
+            if (req.status == 200 && req.response["success"] as boolean) {
+                linkMethod(method, pin, modal);
+            } else if (req.status == 200) {
+                setTimeout(checkVerified, 1500);
+            }
+        });
+        checkVerified();
+    });
+};
+
+if (document.getElementById("modal-telegram")) {
+    window.telegramModal = new Modal(document.getElementById("modal-telegram"));
+    setupBotLink("telegram", window.telegramModal);
+}
+if (document.getElementById("modal-discord")) {
+    window.discordModal = new Modal(document.getElementById("modal-discord"));
+    setupBotLink("discord", window.discordModal);
+}
+
+// Matrix accounts are linked by entering a user ID, then the PIN the bot sends to it.
+if (document.getElementById("modal-matrix")) {
+    window.matrixModal = new Modal(document.getElementById("modal-matrix"));
+    const row = document.getElementById("method-matrix");
+    const submitButton = document.getElementById("matrix-send") as HTMLSpanElement;
+    const input = document.getElementById("matrix-userid") as HTMLInputElement;
+    let userID = "";
+    (row.querySelector(".method-link") as HTMLSpanElement).onclick = () => {
+        userID = "";
+        input.value = "";
+        input.placeholder = "@user:riot.im";
+        window.matrixModal.show();
+    };
+    submitButton.onclick = () => {
+        addLoader(submitButton);
+        if (userID == "") {
+            _post("/my/matrix/user", { user_id: input.value }, (req: XMLHttpRequest) => {
+                if (req.readyState != 4) { return; }
+                removeLoader(submitButton);
+                if (req.status != 200) {
+                    showError("matrixError", req);
+                    window.matrixModal.close();
+                    return;
+                }
+                userID = input.value;
+                input.placeholder = "PIN";
+                input.value = "";
+            }, true);
+        } else {
+            const pin = input.value;
+            _get("/my/matrix/verified/" + userID + "/" + pin, null, (req: XMLHttpRequest) => {
+                if (req.readyState != 4) { return; }
+                removeLoader(submitButton);
+                if (req.status == 200 && req.response["success"] as boolean) {
+                    linkMethod("matrix", pin, window.matrixModal);
+                } else if (req.status == 200) {
+                    window.notifications.customError("matrixError", window.messages["errorInvalidPIN"]);
+                } else {
+                    showError("matrixError", req);
+                }
+            });
+        }
+    };
+}
+
+for (let method of ["telegram", "discord", "matrix"]) {
+    const row = document.getElementById("method-" + method);
+    if (!row) { continue; }
+    (row.querySelector(".method-unlink") as HTMLSpanElement).onclick = () => _delete("/my/link/" + method, null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) { return; }
+        if (req.status != 200) {
+            showError(method + "Error", req);
+            return;
+        }
+        loadDetails();
+    });
+}
+
+const passwordForm = document.getElementById("form-password") as HTMLFormElement;
+const passwordSubmit = passwordForm.querySelector("input[type=submit]") as HTMLInputElement;
+const passwordSpan = passwordForm.querySelector("span.submit") as HTMLSpanElement;
+const oldPasswordField = document.getElementById("password-old") as HTMLInputElement;
+const newPasswordField = document.getElementById("password-new") as HTMLInputElement;
+const rePasswordField = document.getElementById("password-reenter") as HTMLInputElement;
+
+let requirements: any;
+const validatorFunc = (oncomplete: (valid: boolean) => void) => {
+    let valid = true;
+    for (let category in requirements) {
+        if (!requirements[category].valid) { valid = false; }
+    }
+    oncomplete(valid);
+};
+requirements = initValidator(newPasswordField, rePasswordField, passwordSubmit, passwordSpan, validatorFunc)[0];
+
+passwordForm.onsubmit = (event: SubmitEvent) => {
+    event.preventDefault();
+    addLoader(passwordSpan);
+    const send = { old: oldPasswordField.value, new: newPasswordField.value };
+    _post("/my/password", send, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) { return; }
+        removeLoader(passwordSpan);
+        if (req.status == 400) {
+            for (let type in req.response) {
+                if (requirements[type]) { requirements[type].valid = req.response[type] as boolean; }
+            }
+            return;
+        } else if (req.status != 200) {
+            showError("passwordError", req);
+            return;
+        }
+        oldPasswordField.value = "";
+        newPasswordField.value = "";
+        rePasswordField.value = "";
+        window.notifications.customPositive("passwordChanged", "", window.messages["passwordChanged"]);
+    }, true);
+};
@@ -1,11 +1,27 @@
 package main
 
 import (
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hrfee/mediabrowser"
 )
 
+// ExpiredUser is a user who was disabled on expiry. With user_expiry/behaviour "disable_then_delete", they're deleted after a grace period.
+// Re-enabling them, including by renewing, removes this and so cancels the deletion.
+type ExpiredUser struct {
+	Disabled   time.Time `json:"disabled"`
+	NoticeSent bool      `json:"notice_sent"` // Whether the final notice before deletion has been sent.
+}
+
+// SentReminders records the reminders sent before a user's expiry, so restarts don't cause them to be sent again.
+type SentReminders struct {
+	Expiry time.Time `json:"expiry"` // If the user's expiry changes, reminders are sent again for the new one.
+	Sent   []int64   `json:"sent"`   // Minutes before expiry of each reminder sent.
+}
+
 type userDaemon struct {
 	Stopped         bool
 	ShutdownChannel chan string
@@ -36,6 +52,8 @@ func (rt *userDaemon) run() {
 		}
 		started := time.Now()
 		rt.app.checkUsers()
+		rt.app.checkInactivity()
+		rt.app.pruneActivity()
 		finished := time.Now()
 		duration := finished.Sub(started)
 		rt.period = rt.Interval - duration
@@ -54,36 +72,44 @@ func (app *appContext) checkUsers() {
 		app.err.Printf("Failed to load user expiries: %v", err)
 		return
 	}
-	app.storage.usersLock.Lock()
-	defer app.storage.usersLock.Unlock()
-	if len(app.storage.users) == 0 {
+	if len(app.storage.GetUsers()) == 0 && len(app.storage.GetExpiredUsers()) == 0 {
 		return
 	}
 	app.info.Println("Daemon: Checking for user expiry")
-	users, status, err := app.jf.GetUsers(false)
+	users, status, err := app.getJFUsers()
 	if err != nil || status != 200 {
 		app.err.Printf("Failed to get users (%d): %s", status, err)
 		return
 	}
 	mode := "disable"
 	termPlural := "Disabling"
-	if app.config.Section("user_expiry").Key("behaviour").MustString("disable_user") == "delete_user" {
+	behaviour := app.config.Section("user_expiry").Key("behaviour").MustString("disable_user")
+	if behaviour == "delete_user" {
 		mode = "delete"
 		termPlural = "Deleting"
 	}
+	// Users are disabled on expiry, then deleted once the grace period's over.
+	staged := behaviour == "disable_then_delete"
 	contact := false
 	if messagesEnabled && app.config.Section("user_expiry").Key("send_email").MustBool(true) {
 		contact = true
 	}
+	remind := messagesEnabled && app.config.Section("expiry_reminders").Key("enabled").MustBool(false)
+	var offsets []time.Duration
+	if remind {
+		offsets = app.reminderOffsets()
+	}
 	// Use a map to speed up checking for deleted users later
 	userExists := map[string]bool{}
+	jfUsers := map[string]mediabrowser.User{}
 	for _, user := range users {
 		userExists[user.ID] = true
+		jfUsers[user.ID] = user
 	}
-	for id, expiry := range app.storage.users {
+	for id, expiry := range app.storage.GetUsers() {
 		if _, ok := userExists[id]; !ok {
 			app.info.Printf("Deleting expiry for non-existent user \"%s\"", id)
-			delete(app.storage.users, id)
+			app.storage.DeleteUsersKey(id)
 		} else if time.Now().After(expiry) {
 			found := false
 			var user mediabrowser.User
@@ -96,7 +122,7 @@ func (app *appContext) checkUsers() {
 			}
 			if !found {
 				app.info.Printf("Expired user already deleted, ignoring.")
-				delete(app.storage.users, id)
+				app.storage.DeleteUsersKey(id)
 				continue
 			}
 			app.info.Printf("%s expired user \"%s\"", termPlural, user.Name)
@@ -110,10 +136,23 @@ func (app *appContext) checkUsers() {
 			}
 			if !(status == 200 || status == 204) || err != nil {
 				app.err.Printf("Failed to %s \"%s\" (%d): %s", mode, user.Name, status, err)
+				metrics.inc(metricExpiriesProcessed, "action", mode, "result", metricResultFailure)
 				continue
 			}
-			delete(app.storage.users, id)
-			app.jf.CacheExpiry = time.Now()
+			metrics.inc(metricExpiriesProcessed, "action", mode, "result", metricResultSuccess)
+			app.storage.DeleteUsersKey(id)
+			if mode == "disable" {
+				// Remembered so renewing can re-enable them, see renewUser, and for deletion after the grace period.
+				app.storage.SetExpiredUsersKey(id, ExpiredUser{Disabled: time.Now()})
+			}
+			app.expireJFCache()
+			app.triggerWebhook(webhookUserExpired, map[string]interface{}{
+				"id":       id,
+				"username": user.Name,
+				"expiry":   expiry.Unix(),
+				"action":   mode,
+			})
+			app.daemonActivity("user_expiry", activityUserExpired, []string{id}, mode)
 			if contact {
 				if !ok {
 					continue
@@ -128,10 +167,148 @@ func (app *appContext) checkUsers() {
 					app.info.Printf("Sent expiry notification to \"%s\"", name)
 				}
 			}
+			if mode == "delete" {
+				if err := app.unlinkContactMethods(id); err != nil {
+					app.err.Printf("Failed to remove contact methods of \"%s\": %v", user.Name, err)
+				}
+			}
+		} else if remind {
+			app.remindUser(id, jfUsers[id].Name, expiry, offsets)
+		}
+	}
+	for id, record := range app.storage.GetExpiredUsers() {
+		user, ok := jfUsers[id]
+		if !ok {
+			app.storage.DeleteExpiredUsersKey(id)
+		} else if !user.Policy.IsDisabled {
+			// Re-enabled by an admin or through Jellyfin, which cancels any pending deletion.
+			app.info.Printf("Expired user \"%s\" has been re-enabled", user.Name)
+			app.storage.DeleteExpiredUsersKey(id)
+		} else if staged {
+			app.checkGracePeriod(user, record)
+		}
+	}
+	// Reminders for expiries which have passed or changed are no longer needed.
+	for id, record := range app.storage.GetExpiryReminders() {
+		if expiry, ok := app.storage.GetUsersKey(id); !ok || !expiry.Equal(record.Expiry) {
+			app.storage.DeleteExpiryRemindersKey(id)
 		}
 	}
 	err = app.storage.storeUsers()
 	if err != nil {
 		app.err.Printf("Failed to store user expiries: %s", err)
 	}
+	if err := app.storage.storeExpiryReminders(); err != nil {
+		app.err.Printf("Failed to store sent expiry reminders: %v", err)
+	}
+	if err := app.storage.storeExpiredUsers(); err != nil {
+		app.err.Printf("Failed to store expired users: %v", err)
+	}
+}
+
+// checkGracePeriod deletes a user disabled on expiry once user_expiry/delete_after_days have passed,
+// sending them a final notice user_expiry/final_notice_days beforehand.
+func (app *appContext) checkGracePeriod(user mediabrowser.User, record ExpiredUser) {
+	section := app.config.Section("user_expiry")
+	deletion := record.Disabled.AddDate(0, 0, section.Key("delete_after_days").MustInt(30))
+	if time.Now().After(deletion) {
+		app.info.Printf("Deleting expired user \"%s\" after their grace period", user.Name)
+		status, err := app.jf.DeleteUser(user.ID)
+		if !(status == 200 || status == 204) || err != nil {
+			app.err.Printf("Failed to delete \"%s\" (%d): %s", user.Name, status, err)
+			metrics.inc(metricExpiriesProcessed, "action", "delete", "result", metricResultFailure)
+			return
+		}
+		metrics.inc(metricExpiriesProcessed, "action", "delete", "result", metricResultSuccess)
+		app.storage.DeleteExpiredUsersKey(user.ID)
+		app.expireJFCache()
+		app.triggerWebhook(webhookUserExpired, map[string]interface{}{
+			"id":       user.ID,
+			"username": user.Name,
+			"expiry":   record.Disabled.Unix(),
+			"action":   "delete",
+		})
+		app.daemonActivity("user_expiry", activityUserExpired, []string{user.ID}, "delete")
+		if err := app.unlinkContactMethods(user.ID); err != nil {
+			app.err.Printf("Failed to remove contact methods of \"%s\": %v", user.Name, err)
+		}
+		return
+	}
+	noticeDays := section.Key("final_notice_days").MustInt(3)
+	if record.NoticeSent || noticeDays <= 0 || !messagesEnabled || time.Now().Before(deletion.AddDate(0, 0, -noticeDays)) {
+		return
+	}
+	name := app.getAddressOrName(user.ID)
+	msg, err := app.email.constructDeletionNotice(deletion, app, false)
+	if err != nil {
+		app.err.Printf("Failed to construct deletion notice for \"%s\": %v", user.Name, err)
+		return
+	} else if err := app.sendByID(msg, user.ID); err != nil {
+		app.err.Printf("Failed to send deletion notice to \"%s\": %v", name, err)
+		return
+	}
+	app.info.Printf("Sent deletion notice to \"%s\"", name)
+	record.NoticeSent = true
+	app.storage.SetExpiredUsersKey(user.ID, record)
+}
+
+// reminderOffsets parses expiry_reminders/reminders into the times before expiry to send reminders at, longest first.
+// Each is a number of days ("7d") or hours ("12h"), and plain numbers are taken as days.
+func (app *appContext) reminderOffsets() []time.Duration {
+	offsets := []time.Duration{}
+	for _, value := range strings.Split(app.config.Section("expiry_reminders").Key("reminders").MustString("7d, 1d"), ",") {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		unit := 24 * time.Hour
+		if strings.HasSuffix(value, "h") {
+			unit = time.Hour
+		}
+		n, err := strconv.Atoi(strings.TrimRight(value, "dh"))
+		if err != nil || n <= 0 {
+			app.err.Printf("Ignoring invalid expiry reminder \"%s\"", value)
+			continue
+		}
+		offsets = append(offsets, time.Duration(n)*unit)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	return offsets
+}
+
+// remindUser sends the closest reminder to the user's expiry which is due, unless it or a closer one has already been sent.
+// Reminders passed over, like those due before the expiry was set, aren't sent.
+func (app *appContext) remindUser(id, username string, expiry time.Time, offsets []time.Duration) {
+	until := time.Until(expiry)
+	due := time.Duration(-1)
+	for _, offset := range offsets {
+		if until <= offset {
+			due = offset
+		}
+	}
+	if due < 0 {
+		return
+	}
+	record, ok := app.storage.GetExpiryRemindersKey(id)
+	if !ok || !record.Expiry.Equal(expiry) {
+		record = SentReminders{Expiry: expiry}
+	}
+	minutes := int64(due / time.Minute)
+	for _, sent := range record.Sent {
+		if sent <= minutes {
+			return
+		}
+	}
+	msg, err := app.email.constructExpiryReminder(username, expiry, app, false)
+	if err != nil {
+		app.err.Printf("Failed to construct expiry reminder for \"%s\": %v", username, err)
+		return
+	}
+	if err := app.sendByID(msg, id); err != nil {
+		app.err.Printf("Failed to send expiry reminder to \"%s\": %v", app.getAddressOrName(id), err)
+		return
+	}
+	app.info.Printf("Sent expiry reminder to \"%s\"", app.getAddressOrName(id))
+	record.Sent = append(record.Sent, minutes)
+	app.storage.SetExpiryRemindersKey(id, record)
 }
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// Tokens for the My Account page have their own types, so they're rejected by the admin API, and admin tokens by the user API.
+const (
+	userTokenType     = "user"
+	userRefreshType   = "user_refresh"
+	userRefreshCookie = "user-refresh"
+	// Sent in the link to confirm a change of email address, see SetMyEmail.
+	emailChangeType = "email_change"
+)
+
+// createUserToken returns a token and refresh token for a Jellyfin user to use the My Account page with.
+func createUserToken(jfID, username string) (token, refresh string, err error) {
+	claims := jwt.MapClaims{
+		"valid":    true,
+		"jfid":     jfID,
+		"username": username,
+		"exp":      time.Now().Add(time.Minute * 20).Unix(),
+		"type":     userTokenType,
+	}
+	tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token, err = tk.SignedString([]byte(os.Getenv("JFA_SECRET")))
+	if err != nil {
+		return
+	}
+	claims["exp"] = time.Now().Add(sessionLength).Unix()
+	claims["type"] = userRefreshType
+	tk = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	refresh, err = tk.SignedString([]byte(os.Getenv("JFA_SECRET")))
+	return
+}
+
+// parseUserToken returns the claims of a valid, unexpired token of the given type.
+func parseUserToken(raw, tokenType string) (jwt.MapClaims, bool) {
+	token, err := jwt.Parse(raw, checkToken)
+	if err != nil {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !(ok && token.Valid && claims["type"] == tokenType) {
+		return nil, false
+	}
+	if _, ok := claims["jfid"].(string); !ok {
+		return nil, false
+	}
+	return claims, true
+}
+
+// userAuth returns middleware which only lets through requests with a My Account page token, and sets "jfId" and "username".
+func (app *appContext) userAuth() gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		header := strings.SplitN(gc.Request.Header.Get("Authorization"), " ", 2)
+		if len(header) != 2 || header[0] != "Bearer" {
+			respond(401, "Unauthorized", gc)
+			return
+		}
+		claims, ok := parseUserToken(header[1], userTokenType)
+		if !ok {
+			app.debug.Println("User auth denied: Invalid token")
+			respond(401, "Unauthorized", gc)
+			return
+		}
+		gc.Set("jfId", claims["jfid"].(string))
+		gc.Set("username", claims["username"])
+		gc.Next()
+	}
+}
+
+func (app *appContext) issueUserTokens(jfID, username string, gc *gin.Context) {
+	token, refresh, err := createUserToken(jfID, username)
+	if err != nil {
+		app.err.Printf("getMyToken failed: Couldn't generate token (%s)", err)
+		respond(500, "Couldn't generate token", gc)
+		return
+	}
+	gc.SetCookie(userRefreshCookie, refresh, int(sessionLength.Seconds()), "/", gc.Request.URL.Hostname(), true, true)
+	gc.JSON(200, getTokenDTO{Token: token})
+}
+
+// @Summary Grabs a token for the My Account page using a Jellyfin username & password.
+// @Produce json
+// @Success 200 {object} getTokenDTO
+// @Failure 401 {object} stringResponse
+// @Router /my/token/login [get]
+// @tags User Page
+// @Security getTokenAuth
+func (app *appContext) getMyTokenLogin(gc *gin.Context) {
+	username, password, ok := gc.Request.BasicAuth()
+	if !ok || username == "" || password == "" {
+		app.debug.Println("User auth denied: blank username/password")
+		app.failedAttempt(gc, "")
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	user, status, err := app.authJf.Authenticate(username, password)
+	if status != 200 || err != nil {
+		if status == 401 || status == 400 {
+			app.info.Printf("User auth denied: Invalid username/password for \"%s\"", username)
+			app.failedAttempt(gc, username)
+			respond(401, "Unauthorized", gc)
+			return
+		}
+		app.err.Printf("User auth failed: Couldn't authenticate with Jellyfin (%d/%s)", status, err)
+		respond(500, "Jellyfin error", gc)
+		return
+	}
+	app.succeededAttempt(gc, username)
+	app.debug.Printf("User \"%s\" logged in to the My Account page", user.Name)
+	app.issueUserTokens(user.ID, user.Name, gc)
+}
+
+// @Summary Grabs a token for the My Account page using the refresh token from cookies.
+// @Produce json
+// @Success 200 {object} getTokenDTO
+// @Failure 401 {object} stringResponse
+// @Router /my/token/refresh [get]
+// @tags User Page
+func (app *appContext) getMyTokenRefresh(gc *gin.Context) {
+	cookie, err := gc.Cookie(userRefreshCookie)
+	if err != nil || cookie == "" {
+		respond(400, "Couldn't get token", gc)
+		return
+	}
+	claims, ok := parseUserToken(cookie, userRefreshType)
+	if !ok {
+		respond(401, "Invalid token", gc)
+		return
+	}
+	// Deleted or disabled users shouldn't be able to keep using a refresh token.
+	user, status, err := app.getJFUserByID(claims["jfid"].(string))
+	if status != 200 || err != nil || user.Policy.IsDisabled {
+		app.debug.Printf("getMyTokenRefresh: User \"%s\" no longer exists or is disabled", claims["jfid"])
+		respond(401, "Invalid token", gc)
+		return
+	}
+	app.issueUserTokens(user.ID, user.Name, gc)
+}
+
+// @Summary Logs out of the My Account page by deleting the refresh token from cookies.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Router /my/logout [post]
+// @tags User Page
+func (app *appContext) MyLogout(gc *gin.Context) {
+	gc.SetCookie(userRefreshCookie, "invalid", -1, "/", gc.Request.URL.Hostname(), true, true)
+	respondBool(200, true, gc)
+}
+
+// @Summary Returns the logged in user's details, expiry and contact methods.
+// @Produce json
+// @Success 200 {object} myDetailsDTO
+// @Router /my/details [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) MyDetails(gc *gin.Context) {
+	jfID := gc.GetString("jfId")
+	resp := myDetailsDTO{
+		ID:             jfID,
+		Username:       gc.GetString("username"),
+		ContactMethods: []myContactMethodDTO{},
+	}
+	if expiry, ok := app.storage.GetUsersKey(jfID); ok {
+		resp.Expiry = expiry.Unix()
+	}
+	if email, ok := app.storage.GetEmailsKey(jfID); ok {
+		resp.Email = email.Addr
+	}
+	for _, method := range app.contactMethods {
+		if !method.Enabled() {
+			continue
+		}
+		dto := myContactMethodDTO{
+			Method:  contactKey(method),
+			Linked:  method.Linked(jfID),
+			Contact: method.Contact(jfID),
+		}
+		if dto.Linked {
+			dto.Value = method.DisplayName(jfID)
+		}
+		resp.ContactMethods = append(resp.ContactMethods, dto)
+	}
+	gc.JSON(200, resp)
+}
+
+// setUserEmail stores a user's new email address, and sets it in Ombi if enabled.
+func (app *appContext) setUserEmail(jfID, address string) error {
+	emailStore, ok := app.storage.GetEmailsKey(jfID)
+	if !ok {
+		emailStore.Contact = true
+	}
+	emailStore.Addr = address
+	app.storage.SetEmailsKey(jfID, emailStore)
+	if err := app.storage.storeEmails(); err != nil {
+		return err
+	}
+	if app.config.Section("ombi").Key("enabled").MustBool(false) {
+		app.setOmbiEmail(jfID, address)
+	}
+	return nil
+}
+
+// @Summary Changes the logged in user's email address. If email confirmation is enabled, it's only changed once the link sent to the new address is followed, and the response is "confirmEmailSent".
+// @Produce json
+// @Param myEmailDTO body myEmailDTO true "New email address."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/email [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) SetMyEmail(gc *gin.Context) {
+	var req myEmailDTO
+	gc.BindJSON(&req)
+	jfID := gc.GetString("jfId")
+	username := gc.GetString("username")
+	if !strings.Contains(req.Email, "@") {
+		respond(400, "errorNoEmail", gc)
+		return
+	}
+	if emailEnabled && app.config.Section("email_confirmation").Key("enabled").MustBool(false) {
+		claims := jwt.MapClaims{
+			"valid": true,
+			"jfid":  jfID,
+			"email": req.Email,
+			"exp":   time.Now().Add(time.Hour * 12).Unix(),
+			"type":  emailChangeType,
+		}
+		key, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JFA_SECRET")))
+		if err != nil {
+			app.err.Printf("Failed to generate email confirmation token: %v", err)
+			respond(500, "errorUnknown", gc)
+			return
+		}
+		link, err := app.GenEmailChangeLink(key)
+		if err != nil {
+			app.err.Printf("Failed to generate email confirmation link: %v", err)
+			respond(500, "errorUnknown", gc)
+			return
+		}
+		msg, err := app.email.constructConfirmation(username, link, app, false)
+		if err != nil {
+			app.err.Printf("Failed to construct email confirmation email: %v", err)
+			respond(500, "errorUnknown", gc)
+			return
+		} else if err := app.email.send(msg, req.Email); err != nil {
+			app.err.Printf("Failed to send email confirmation email: %v", err)
+			respond(500, "errorUnknown", gc)
+			return
+		}
+		app.info.Printf("Sent email change confirmation for \"%s\" to \"%s\"", username, req.Email)
+		respond(200, "confirmEmailSent", gc)
+		return
+	}
+	if err := app.setUserEmail(jfID, req.Email); err != nil {
+		app.err.Printf("Failed to store emails: %v", err)
+		respond(500, "errorUnknown", gc)
+		return
+	}
+	app.info.Printf("\"%s\" changed their email address", username)
+	respondBool(200, true, gc)
+}
+
+// @Summary Finishes changing a user's email address, from the link sent to the new address by /my/email. Redirects to the My Account page.
+// @Param key path string true "Key from the confirmation email."
+// @Success 302
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/confirm/{key} [get]
+// @tags User Page
+func (app *appContext) ConfirmMyEmail(gc *gin.Context) {
+	token, err := jwt.Parse(gc.Param("key"), checkToken)
+	if err != nil {
+		app.debug.Printf("Email confirmation denied: %v", err)
+		respond(400, "Invalid key", gc)
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	jfID, _ := claims["jfid"].(string)
+	address, _ := claims["email"].(string)
+	if !(ok && token.Valid && claims["type"] == emailChangeType && jfID != "" && address != "") {
+		app.debug.Println("Email confirmation denied: Invalid key")
+		respond(400, "Invalid key", gc)
+		return
+	}
+	if err := app.setUserEmail(jfID, address); err != nil {
+		app.err.Printf("Failed to store emails: %v", err)
+		respond(500, "Couldn't store email", gc)
+		return
+	}
+	app.info.Printf("\"%s\" confirmed their new email address", jfID)
+	gc.Redirect(302, app.URLBase+"/my/account")
+}
+
+// @Summary Sets which of the logged in user's linked contact methods they want to be messaged through. The ID field is ignored.
+// @Produce json
+// @Param SetContactMethodsDTO body SetContactMethodsDTO true "Methods to contact the user through."
+// @Success 200 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /my/contact [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) SetMyContactMethods(gc *gin.Context) {
+	var req SetContactMethodsDTO
+	gc.BindJSON(&req)
+	jfID := gc.GetString("jfId")
+	for _, method := range app.contactMethods {
+		if !method.Linked(jfID) {
+			continue
+		}
+		method.SetContact(jfID, req.Contact[contactKey(method)])
+		if err := app.storage.store(method.StorageKey()); err != nil {
+			app.err.Printf("%s: Failed to store users: %v", method.Name(), err)
+			respondBool(500, false, gc)
+			return
+		}
+	}
+	respondBool(200, true, gc)
+}
+
+// pinVerifier returns the enabled contact method with the given lower-case name, if it's linked through a PIN.
+func (app *appContext) pinVerifier(name string) (PINVerifier, bool) {
+	for _, method := range app.contactMethods {
+		if verifier, ok := method.(PINVerifier); ok && method.Enabled() && contactKey(method) == name {
+			return verifier, true
+		}
+	}
+	return nil, false
+}
+
+// @Summary Returns a new PIN to send to the Telegram or Discord bot, and the bot's username.
+// @Produce json
+// @Param method path string true "\"telegram\" or \"discord\"."
+// @Success 200 {object} telegramPinDTO
+// @Failure 404 {object} boolResponse
+// @Router /my/pin/{method} [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) GetMyPIN(gc *gin.Context) {
+	switch gc.Param("method") {
+	case "telegram":
+		if telegramEnabled {
+			gc.JSON(200, telegramPinDTO{Token: app.telegram.NewAuthToken(), Username: app.telegram.username})
+			return
+		}
+	case "discord":
+		if discordEnabled {
+			gc.JSON(200, telegramPinDTO{Token: app.discord.NewAuthToken(), Username: app.discord.username})
+			return
+		}
+	}
+	respondBool(404, false, gc)
+}
+
+// @Summary Returns whether a PIN from /my/pin/{method} has been verified by the bot.
+// @Produce json
+// @Param method path string true "\"telegram\" or \"discord\"."
+// @Param pin path string true "PIN to check."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Router /my/pin/{method}/verified/{pin} [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) MyPINVerified(gc *gin.Context) {
+	verifier, ok := app.pinVerifier(gc.Param("method"))
+	if !ok {
+		respondBool(404, false, gc)
+		return
+	}
+	app.pinStatus(verifier, gc)
+}
+
+// @Summary Sends a PIN to the given Matrix user, to be checked with /my/matrix/verified.
+// @Produce json
+// @Param MatrixSendPINDTO body MatrixSendPINDTO true "User's Matrix ID."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /my/matrix/user [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) MySendMatrixPIN(gc *gin.Context) {
+	var req MatrixSendPINDTO
+	gc.BindJSON(&req)
+	if req.UserID == "" {
+		respondBool(400, false, gc)
+		return
+	}
+	if !app.matrix.SendStart(req.UserID) {
+		respondBool(500, false, gc)
+		return
+	}
+	respondBool(200, true, gc)
+}
+
+// @Summary Checks a PIN sent to a Matrix user, marking it verified if correct.
+// @Produce json
+// @Param userID path string true "Matrix User ID"
+// @Param pin path string true "PIN sent to the user."
+// @Success 200 {object} boolResponse
+// @Router /my/matrix/verified/{userID}/{pin} [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) MyMatrixCheckPIN(gc *gin.Context) {
+	if !app.matrix.checkPIN(gc.Param("userID"), gc.Param("pin")) {
+		app.failedAttempt(gc, "")
+		respondBool(200, false, gc)
+		return
+	}
+	respondBool(200, true, gc)
+}
+
+// @Summary Links the account which verified the PIN to the logged in user, replacing any already linked.
+// @Produce json
+// @Param method path string true "\"telegram\", \"discord\" or \"matrix\"."
+// @Param myLinkDTO body myLinkDTO true "Verified PIN."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/link/{method} [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) LinkMyContactMethod(gc *gin.Context) {
+	var req myLinkDTO
+	gc.BindJSON(&req)
+	verifier, ok := app.pinVerifier(gc.Param("method"))
+	if !ok {
+		respondBool(404, false, gc)
+		return
+	}
+	jfID := gc.GetString("jfId")
+	if !verifier.Verified(req.PIN) {
+		app.failedAttempt(gc, "")
+		respond(400, "errorInvalidPIN", gc)
+		return
+	}
+	if err := verifier.Link(jfID, req.PIN, true); err != nil {
+		app.err.Printf("%s: Failed to link user \"%s\": %v", verifier.Name(), gc.GetString("username"), err)
+		respond(500, "errorUnknown", gc)
+		return
+	}
+	if err := app.storage.store(verifier.StorageKey()); err != nil {
+		app.err.Printf("%s: Failed to store users: %v", verifier.Name(), err)
+		respond(500, "errorUnknown", gc)
+		return
+	}
+	app.info.Printf("%s: \"%s\" linked %s", verifier.Name(), gc.GetString("username"), verifier.DisplayName(jfID))
+	respondBool(200, true, gc)
+}
+
+// @Summary Unlinks the logged in user's Telegram, Discord or Matrix account.
+// @Produce json
+// @Param method path string true "\"telegram\", \"discord\" or \"matrix\"."
+// @Success 200 {object} boolResponse
+// @Failure 404 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /my/link/{method} [delete]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) UnlinkMyContactMethod(gc *gin.Context) {
+	verifier, ok := app.pinVerifier(gc.Param("method"))
+	jfID := gc.GetString("jfId")
+	if !ok || !verifier.Linked(jfID) {
+		respondBool(404, false, gc)
+		return
+	}
+	verifier.Unlink(jfID)
+	if err := app.storage.store(verifier.StorageKey()); err != nil {
+		app.err.Printf("%s: Failed to store users: %v", verifier.Name(), err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Printf("%s: \"%s\" unlinked their account", verifier.Name(), gc.GetString("username"))
+	respondBool(200, true, gc)
+}
+
+// @Summary Changes the logged in user's Jellyfin password, which must meet the password requirements.
+// @Produce json
+// @Param myPasswordDTO body myPasswordDTO true "Current and new password."
+// @Success 200 {object} PasswordValidation
+// @Failure 400 {object} PasswordValidation
+// @Failure 401 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/password [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) ChangeMyPassword(gc *gin.Context) {
+	var req myPasswordDTO
+	gc.BindJSON(&req)
+	validation := app.validator.validate(req.New)
+	for _, val := range validation {
+		if !val {
+			gc.JSON(400, validation)
+			return
+		}
+	}
+	username := gc.GetString("username")
+	user, status, err := app.authJf.Authenticate(username, req.Old)
+	if status != 200 || err != nil || user.ID != gc.GetString("jfId") {
+		app.info.Printf("\"%s\" failed to change their password: Current password incorrect", username)
+		app.failedAttempt(gc, username)
+		respond(401, "errorOldPassword", gc)
+		return
+	}
+	status, err = app.jf.SetPassword(user.ID, req.Old, req.New)
+	if !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to change password for \"%s\" (%d): %v", username, status, err)
+		respond(500, "errorUnknown", gc)
+		return
+	}
+	app.info.Printf("\"%s\" changed their password", username)
+	gc.JSON(200, validation)
+}
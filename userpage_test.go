@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// TestConfirmMyEmail checks a new address is only stored once a valid email change key is followed.
+func TestConfirmMyEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newTestApp(t, "http://localhost")
+	app.storage.SetEmailsKey("alice-id", EmailAddress{Addr: "old@example.com", Contact: true})
+
+	sign := func(claims jwt.MapClaims) string {
+		key, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JFA_SECRET")))
+		if err != nil {
+			t.Fatalf("Failed to sign key: %v", err)
+		}
+		return key
+	}
+	confirm := func(key string) int {
+		w := httptest.NewRecorder()
+		gc, _ := gin.CreateTestContext(w)
+		gc.Request = httptest.NewRequest("GET", "/my/confirm/"+key, nil)
+		gc.Params = gin.Params{{Key: "key", Value: key}}
+		app.ConfirmMyEmail(gc)
+		return w.Code
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	for name, key := range map[string]string{
+		"garbage":             "notakey",
+		"invite confirmation": sign(jwt.MapClaims{"valid": true, "jfid": "alice-id", "email": "new@example.com", "exp": exp, "type": "confirmation"}),
+		"expired":             sign(jwt.MapClaims{"valid": true, "jfid": "alice-id", "email": "new@example.com", "exp": time.Now().Add(-time.Hour).Unix(), "type": emailChangeType}),
+	} {
+		if status := confirm(key); status != 400 {
+			t.Errorf("Expected 400 for a %s key, got %d", name, status)
+		}
+	}
+	if email, _ := app.storage.GetEmailsKey("alice-id"); email.Addr != "old@example.com" {
+		t.Fatalf("Expected the address to be unchanged, got %s", email.Addr)
+	}
+
+	key := sign(jwt.MapClaims{"valid": true, "jfid": "alice-id", "email": "new@example.com", "exp": exp, "type": emailChangeType})
+	if status := confirm(key); status != 302 {
+		t.Fatalf("Expected a redirect for a valid key, got %d", status)
+	}
+	if email, _ := app.storage.GetEmailsKey("alice-id"); email.Addr != "new@example.com" || !email.Contact {
+		t.Errorf("Expected the new address to be stored, got %+v", email)
+	}
+}
@@ -234,7 +234,7 @@ func (app *appContext) ResetPassword(gc *gin.Context) {
 		app.err.Printf("Password Reset failed (%d): %v", status, err)
 	}
 	if app.config.Section("ombi").Key("enabled").MustBool(false) {
-		jfUser, status, err := app.jf.UserByName(username, false)
+		jfUser, status, err := app.getJFUserByName(username)
 		if status != 200 || err != nil {
 			app.err.Printf("Failed to get user \"%s\" from jellyfin/emby (%d): %v", username, status, err)
 			return
@@ -262,7 +262,7 @@ func (app *appContext) ResetPassword(gc *gin.Context) {
 func (app *appContext) GetCaptcha(gc *gin.Context) {
 	code := gc.Param("invCode")
 	captchaID := gc.Param("captchaID")
-	inv, ok := app.storage.invites[code]
+	inv, ok := app.storage.GetInvitesKey(code)
 	if !ok {
 		gcHTML(gc, 404, "invalidCode.html", gin.H{
 			"cssClass":       app.cssClass,
@@ -296,7 +296,7 @@ func (app *appContext) GetCaptcha(gc *gin.Context) {
 // @tags Users
 func (app *appContext) GenCaptcha(gc *gin.Context) {
 	code := gc.Param("invCode")
-	inv, ok := app.storage.invites[code]
+	inv, ok := app.storage.GetInvitesKey(code)
 	if !ok {
 		gcHTML(gc, 404, "invalidCode.html", gin.H{
 			"cssClass":       app.cssClass,
@@ -315,14 +315,14 @@ func (app *appContext) GenCaptcha(gc *gin.Context) {
 	}
 	captchaID := genAuthToken()
 	inv.Captchas[captchaID] = capt
-	app.storage.invites[code] = inv
+	app.storage.SetInvitesKey(code, inv)
 	app.storage.storeInvites()
 	gc.JSON(200, genCaptchaDTO{captchaID})
 	return
 }
 
 func (app *appContext) verifyCaptcha(code, id, text string) bool {
-	inv, ok := app.storage.invites[code]
+	inv, ok := app.storage.GetInvitesKey(code)
 	if !ok || inv.Captchas == nil {
 		app.debug.Printf("Couldn't find invite \"%s\"", code)
 		return false
@@ -346,8 +346,9 @@ func (app *appContext) VerifyCaptcha(gc *gin.Context) {
 	code := gc.Param("invCode")
 	captchaID := gc.Param("captchaID")
 	text := gc.Param("text")
-	inv, ok := app.storage.invites[code]
+	inv, ok := app.storage.GetInvitesKey(code)
 	if !ok {
+		app.failedAttempt(gc, "")
 		gcHTML(gc, 404, "invalidCode.html", gin.H{
 			"cssClass":       app.cssClass,
 			"cssVersion":     cssVersion,
@@ -364,6 +365,7 @@ func (app *appContext) VerifyCaptcha(gc *gin.Context) {
 		return
 	}
 	if strings.ToLower(capt.Text) != strings.ToLower(text) {
+		app.failedAttempt(gc, "")
 		respondBool(400, false, gc)
 		return
 	}
@@ -377,7 +379,7 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 	lang := app.getLang(gc, FormPage, app.storage.lang.chosenFormLang)
 	/* Don't actually check if the invite is valid, just if it exists, just so the page loads quicker. Invite is actually checked on submit anyway. */
 	// if app.checkInvite(code, false, "") {
-	inv, ok := app.storage.invites[code]
+	inv, ok := app.storage.GetInvitesKey(code)
 	if !ok {
 		gcHTML(gc, 404, "invalidCode.html", gin.H{
 			"cssClass":       app.cssClass,
@@ -443,15 +445,15 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 			"contactMessage": app.config.Section("ui").Key("contact_message").String(),
 			"jfLink":         app.config.Section("ui").Key("redirect_url").String(),
 		})
-		inv, ok := app.storage.invites[code]
+		inv, ok := app.storage.GetInvitesKey(code)
 		if ok {
 			l := len(inv.Keys)
 			inv.Keys[l-1], inv.Keys[keyIndex] = inv.Keys[keyIndex], inv.Keys[l-1]
-			app.storage.invites[code] = inv
+			app.storage.SetInvitesKey(code, inv)
 		}
 		return
 	}
-	email := app.storage.invites[code].SendTo
+	email := inv.SendTo
 	if strings.Contains(email, "Failed") || !strings.Contains(email, "@") {
 		email = ""
 	}
@@ -519,6 +521,42 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 	gcHTML(gc, http.StatusOK, "form-loader.html", data)
 }
 
+// MyAccountPage serves the page where users log in to manage their own contact details and password.
+func (app *appContext) MyAccountPage(gc *gin.Context) {
+	app.pushResources(gc, false)
+	lang := app.getLang(gc, FormPage, app.storage.lang.chosenFormLang)
+	data := gin.H{
+		"urlBase":           app.getURLBase(gc),
+		"cssClass":          app.cssClass,
+		"cssVersion":        cssVersion,
+		"contactMessage":    app.config.Section("ui").Key("contact_message").String(),
+		"requirements":      app.validator.getCriteria(),
+		"strings":           app.storage.lang.Form[lang].Strings,
+		"validationStrings": app.storage.lang.Form[lang].validationStringsJSON,
+		"notifications":     app.storage.lang.Form[lang].notificationsJSON,
+		"userExpiryMessage": app.storage.lang.Form[lang].Strings.get("yourAccountIsValidUntil"),
+		"langName":          lang,
+		"emailEnabled":      emailEnabled,
+		"telegramEnabled":   telegramEnabled,
+		"discordEnabled":    discordEnabled,
+		"matrixEnabled":     matrixEnabled,
+	}
+	if telegramEnabled {
+		data["telegramUsername"] = app.telegram.username
+		data["telegramURL"] = app.telegram.link
+	}
+	if matrixEnabled {
+		data["matrixUser"] = app.matrix.userID
+	}
+	if discordEnabled {
+		data["discordSendPINMessage"] = template.HTML(app.storage.lang.Form[lang].Strings.template("sendPINDiscord", tmpl{
+			"command":        `<span class="text-black dark:text-white font-mono">/` + app.config.Section("discord").Key("start_command").MustString("start") + `</span>`,
+			"server_channel": app.discord.serverChannelName,
+		}))
+	}
+	gcHTML(gc, http.StatusOK, "user.html", data)
+}
+
 func (app *appContext) NoRouteHandler(gc *gin.Context) {
 	app.pushResources(gc, false)
 	gcHTML(gc, 404, "404.html", gin.H{
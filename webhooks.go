@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// Events sent to webhooks.
+const (
+	webhookUserCreated         = "user_created"
+	webhookInviteExpired       = "invite_expired"
+	webhookUserExpired         = "user_expired"
+	webhookUserDisabled        = "user_disabled"
+	webhookUserDeleted         = "user_deleted"
+	webhookPasswordReset       = "password_reset_requested"
+	webhookContactMethodLinked = "contact_method_linked"
+)
+
+const (
+	webhookSignatureHeader = "X-Jfa-Go-Signature"
+	webhookEventHeader     = "X-Jfa-Go-Event"
+	webhookRetryInterval   = 30 * time.Second
+	webhookTimeout         = 10 * time.Second
+	// Caps the wait between retries at 64 * webhookRetryInterval.
+	webhookMaxBackoff = 64
+)
+
+// WebhookDelivery is an event being sent to a webhook URL, kept in the delivery log.
+type WebhookDelivery struct {
+	ID          string    `json:"id"`
+	Event       string    `json:"event"`
+	URL         string    `json:"url"`
+	Payload     string    `json:"payload"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	NextAttempt time.Time `json:"next_attempt"` // Zero once delivered or given up on.
+	Status      int       `json:"status"`       // HTTP status of the last attempt, 0 if the request failed.
+	Error       string    `json:"error,omitempty"`
+	Delivered   bool      `json:"delivered"`
+}
+
+type webhookPayload struct {
+	ID    string                 `json:"id"`
+	Event string                 `json:"event"`
+	Time  int64                  `json:"time"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+func (app *appContext) webhookURLs() []string {
+	urls := []string{}
+	for _, url := range strings.Split(app.config.Section("webhooks").Key("urls").String(), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+func signWebhook(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// triggerWebhook sends an event to every webhook URL in the background.
+func (app *appContext) triggerWebhook(event string, data map[string]interface{}) {
+	if !webhooksEnabled {
+		return
+	}
+	payload, err := json.Marshal(webhookPayload{
+		ID:    shortuuid.New(),
+		Event: event,
+		Time:  time.Now().Unix(),
+		Data:  data,
+	})
+	if err != nil {
+		app.err.Printf("Webhooks: Failed to encode \"%s\" event: %v", event, err)
+		return
+	}
+	now := time.Now()
+	for _, url := range app.webhookURLs() {
+		delivery := WebhookDelivery{
+			ID:      shortuuid.New(),
+			Event:   event,
+			URL:     url,
+			Payload: string(payload),
+			Created: now,
+			// Leaves time for the first attempt before webhookDaemon would pick it up.
+			NextAttempt: now.Add(webhookRetryInterval),
+		}
+		app.storage.SetWebhookDeliveriesKey(delivery.ID, delivery)
+		go app.deliverWebhook(delivery)
+	}
+	if err := app.storage.storeWebhookDeliveries(); err != nil {
+		app.err.Printf("Webhooks: Failed to store deliveries: %v", err)
+	}
+}
+
+func (app *appContext) contactLinkedWebhook(method ContactMethod, jfID string) {
+	app.triggerWebhook(webhookContactMethodLinked, map[string]interface{}{
+		"id":      jfID,
+		"method":  contactKey(method),
+		"account": method.DisplayName(jfID),
+	})
+}
+
+// deliverWebhook makes one attempt at a delivery, and schedules a retry with exponential backoff if it fails.
+func (app *appContext) deliverWebhook(delivery WebhookDelivery) {
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+	delivery.Status = 0
+	delivery.Error = ""
+	err := func() error {
+		req, err := http.NewRequest("POST", delivery.URL, bytes.NewBufferString(delivery.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "jfa-go/"+app.version)
+		req.Header.Set(webhookEventHeader, delivery.Event)
+		req.Header.Set(webhookSignatureHeader, signWebhook([]byte(app.config.Section("webhooks").Key("secret").String()), []byte(delivery.Payload)))
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		delivery.Status = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("got status %d", resp.StatusCode)
+		}
+		return nil
+	}()
+	if err == nil {
+		delivery.Delivered = true
+		delivery.NextAttempt = time.Time{}
+		app.debug.Printf("Webhooks: Delivered \"%s\" to \"%s\"", delivery.Event, delivery.URL)
+	} else {
+		delivery.Error = err.Error()
+		if delivery.Attempts > app.config.Section("webhooks").Key("max_retries").MustInt(5) {
+			delivery.NextAttempt = time.Time{}
+			app.err.Printf("Webhooks: Giving up on delivering \"%s\" to \"%s\" after %d attempts: %v", delivery.Event, delivery.URL, delivery.Attempts, err)
+		} else {
+			multiplier := 1 << uint(delivery.Attempts-1)
+			if multiplier > webhookMaxBackoff {
+				multiplier = webhookMaxBackoff
+			}
+			delivery.NextAttempt = time.Now().Add(time.Duration(multiplier) * webhookRetryInterval)
+			app.info.Printf("Webhooks: Failed to deliver \"%s\" to \"%s\", retrying at %s: %v", delivery.Event, delivery.URL, delivery.NextAttempt.Format(time.RFC3339), err)
+		}
+	}
+	app.storage.SetWebhookDeliveriesKey(delivery.ID, delivery)
+	app.pruneWebhookDeliveries()
+	if err := app.storage.storeWebhookDeliveries(); err != nil {
+		app.err.Printf("Webhooks: Failed to store deliveries: %v", err)
+	}
+}
+
+// pruneWebhookDeliveries removes the oldest finished deliveries beyond the configured log length.
+func (app *appContext) pruneWebhookDeliveries() {
+	length := app.config.Section("webhooks").Key("log_length").MustInt(200)
+	deliveries := app.getWebhookDeliveries()
+	for i := len(deliveries) - 1; i >= length; i-- {
+		if deliveries[i].NextAttempt.IsZero() {
+			app.storage.DeleteWebhookDeliveriesKey(deliveries[i].ID)
+		}
+	}
+}
+
+// getWebhookDeliveries returns the delivery log, newest first.
+func (app *appContext) getWebhookDeliveries() []WebhookDelivery {
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range app.storage.GetWebhookDeliveries() {
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Created.After(deliveries[j].Created) })
+	return deliveries
+}
+
+// webhookDaemon retries failed deliveries once they're due, including any left over from before a restart.
+type webhookDaemon struct {
+	Stopped         bool
+	ShutdownChannel chan string
+	Interval        time.Duration
+	period          time.Duration
+	app             *appContext
+}
+
+func newWebhookDaemon(interval time.Duration, app *appContext) *webhookDaemon {
+	return &webhookDaemon{
+		Stopped:         false,
+		ShutdownChannel: make(chan string),
+		Interval:        interval,
+		period:          interval,
+		app:             app,
+	}
+}
+
+func (rt *webhookDaemon) run() {
+	rt.app.info.Println("Webhook daemon started")
+	for {
+		select {
+		case <-rt.ShutdownChannel:
+			rt.ShutdownChannel <- "Down"
+			return
+		case <-time.After(rt.period):
+			break
+		}
+		started := time.Now()
+		for _, delivery := range rt.app.storage.GetWebhookDeliveries() {
+			if !delivery.NextAttempt.IsZero() && started.After(delivery.NextAttempt) {
+				rt.app.deliverWebhook(delivery)
+			}
+		}
+		finished := time.Now()
+		duration := finished.Sub(started)
+		rt.period = rt.Interval - duration
+	}
+}
+
+func (rt *webhookDaemon) shutdown() {
+	rt.Stopped = true
+	rt.ShutdownChannel <- "Down"
+	<-rt.ShutdownChannel
+	close(rt.ShutdownChannel)
+}